@@ -5,165 +5,284 @@ package beta
 import (
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
-	"sync"
+	"log"
+	config "tg/config"
 	db "tg/db"
+	errors "tg/errors"
+	locks "tg/locks"
+	mail "tg/mail"
+	random "tg/random"
+	router "tg/router"
+	sender "tg/sender"
+	validate "tg/validate"
+	wizard "tg/wizard"
+	"time"
 )
 
-var (
-	bot         *tgbotapi.BotAPI          // Declare the bot variable at the package level
-	betaInfoMap = make(map[int64]db.Beta) // Map to store BetaInfo for each user
-	mu          sync.Mutex                // Mutex to prevent data race
-)
-
-func SetBot(b *tgbotapi.BotAPI) {
-	bot = b // Set the bot variable
+// Handler runs the beta signup and the review of the submitted applications.
+//
+// Each application is posted to the review chat, where staff approve, reject
+// or waitlist it with the buttons under it. The applicant is messaged about
+// every decision, and every status change is kept on the application.
+//
+// Once submitted, a code is mailed to the applicant's email address, and the
+// application is marked verified when they send it back in chat.
+type Handler struct {
+	store       db.Store       // Store the applications and wizard progress are kept in
+	out         *sender.Sender // Sends the review posts and the decisions
+	mailer      mail.Mailer    // Sends the email verification codes
+	reviewChat  int64          // Chat staff review applications in, 0 when they are not reviewed
+	codeTTL     time.Duration  // How long a verification code is accepted
+	codeResend  time.Duration  // How long an applicant waits before another code is sent
+	maxCodes    int            // Codes sent per application
+	maxAttempts int            // Wrong guesses before a code is discarded
+	flow        *wizard.Wizard // Signup wizard
+	commands    *router.Router // Set by Register; bot admins may list applications anywhere
+
+	locks locks.Keyed[string] // Serializes changes to each application, keyed by its ID
 }
 
-func Handle(userID int64, groupID int64, userName string) (tgbotapi.MessageConfig, db.Beta) {
-	mu.Lock()                       // Lock the mutex
-	betaInfo := betaInfoMap[userID] // Retrieve the betaInfo for the user
-	mu.Unlock()                     // Unlock the mutex
-
-	betaInfo.Username = userName
-	betaInfo.UserID = userID
-	betaInfo.GroupID = groupID
-
-	row := []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("Yes", "yes"),
-		tgbotapi.NewInlineKeyboardButtonData("No", "no"),
-	}
-
-	var keyboard [][]tgbotapi.InlineKeyboardButton
-	keyboard = append(keyboard, row)
-
-	markup := tgbotapi.InlineKeyboardMarkup{
-		InlineKeyboard: keyboard,
+// steps declares the beta signup wizard, in the order the questions are asked.
+// Email addresses at blocked domains are refused.
+func steps(blocked validate.Domains) []wizard.Step {
+	return []wizard.Step{
+		{
+			Name:    "api_key",
+			Prompt:  wizard.Text("Do you have an API Key?"),
+			Choices: []wizard.Choice{{Label: "Yes", Value: "yes"}, {Label: "No", Value: "no"}},
+			Next: func(state *db.WizardState) string {
+				if state.Answers["api_key"] == "no" {
+					return "no_api_key"
+				}
+				return "provider"
+			},
+		},
+		{
+			Name:   "no_api_key",
+			Prompt: wizard.Text("Please obtain an API key, then send /beta again."),
+			Final:  true,
+		},
+		{
+			Name:    "provider",
+			Prompt:  wizard.Text("Do you have Azure or OpenAI API key?"),
+			Choices: []wizard.Choice{{Label: "Azure", Value: "azure"}, {Label: "OpenAI", Value: "openai"}},
+		},
+		{
+			Name:    "model",
+			Prompt:  wizard.Text("What model do you have access to?"),
+			Choices: []wizard.Choice{{Label: "GPT3.5", Value: "gpt3.5"}, {Label: "GPT4", Value: "gpt4"}, {Label: "GPT4-32k", Value: "gpt4-32k"}},
+		},
+		{
+			Name:   "email",
+			Prompt: wizard.Text("Please enter your email:"),
+			Validate: func(answer string) (string, error) {
+				return validate.Email(answer, blocked)
+			},
+		},
+		{
+			Name:     "name",
+			Prompt:   wizard.Text("What is your name?"),
+			Validate: validate.Name,
+		},
+		{
+			Name:   "contact_method",
+			Prompt: wizard.Text("How should we contact you?"),
+			Choices: []wizard.Choice{
+				{Label: "Telegram", Value: db.ContactTelegram},
+				{Label: "Email", Value: db.ContactEmail},
+				{Label: "Phone", Value: db.ContactPhone},
+			},
+			Next: func(state *db.WizardState) string {
+				if state.Answers["contact_method"] == db.ContactPhone {
+					return "phone"
+				}
+				return "contact_time"
+			},
+		},
+		{
+			Name:     "phone",
+			Prompt:   wizard.Text("What is your phone number, with the country code (e.g. +44 20 7946 0958)?"),
+			Validate: validate.Phone,
+		},
+		{
+			Name:   "contact_time",
+			Prompt: wizard.Text("When is the best time to contact you? Send the days, hours and your time zone, e.g. \"weekdays 9:00-17:00 Europe/Berlin\" or \"18:00-20:00 UTC+2\"."),
+			Validate: func(answer string) (string, error) {
+				window, err := validate.ContactTime(answer)
+				if err != nil {
+					return "", err
+				}
+				return validate.FormatWindow(window), nil
+			},
+		},
+		{
+			Name:    "confirm",
+			Prompt:  summary,
+			Choices: []wizard.Choice{{Label: "Submit", Value: "submit"}, {Label: "Reset", Value: "reset"}},
+			Next: func(state *db.WizardState) string {
+				if state.Answers["confirm"] == "reset" {
+					return wizard.Restart
+				}
+				return wizard.Done
+			},
+		},
 	}
-
-	msg := tgbotapi.NewMessage(userID, "Do you have an API Key?")
-	msg.ReplyMarkup = markup
-
-	mu.Lock()                      // Lock the mutex
-	betaInfoMap[userID] = betaInfo // Update the betaInfo for the user
-	mu.Unlock()                    // Unlock the mutex
-
-	return msg, betaInfo
 }
 
-func HandleProvider(update *tgbotapi.Update, betaInfo db.Beta) (tgbotapi.EditMessageTextConfig, db.Beta) {
-	betaInfo.APIKey = true
-
-	row := []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("Azure", "azure"),
-		tgbotapi.NewInlineKeyboardButtonData("OpenAI", "openai"),
+// New creates the beta signup handler on top of the store, posting
+// applications to the review chat of cfg and mailing verification codes with mailer.
+func New(store db.Store, out *sender.Sender, mailer mail.Mailer, cfg config.BetaConfig) *Handler {
+	h := &Handler{
+		store:       store,
+		out:         out,
+		mailer:      mailer,
+		reviewChat:  cfg.ReviewChatID,
+		codeTTL:     cfg.CodeTTL,
+		codeResend:  cfg.CodeResend,
+		maxCodes:    cfg.MaxCodes,
+		maxAttempts: cfg.MaxAttempts,
 	}
-
-	var keyboard [][]tgbotapi.InlineKeyboardButton
-	keyboard = append(keyboard, row)
-
-	markup := tgbotapi.InlineKeyboardMarkup{
-		InlineKeyboard: keyboard,
+	blocked, err := validate.Disposable(cfg.Disposable)
+	if err != nil {
+		log.Printf("Failed to load the disposable email domains from %s, using the built-in ones: %v", cfg.Disposable, err)
 	}
-
-	msg := tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, "Do you have Azure or OpenAI API key?")
-	msg.ReplyMarkup = &markup
-
-	return msg, betaInfo
+	h.flow = wizard.New("beta", store, steps(blocked), h.complete)
+	return h
 }
 
-func HandleModel(update *tgbotapi.Update, betaInfo db.Beta) (tgbotapi.EditMessageTextConfig, db.Beta) {
-	betaInfo.Provider = update.CallbackQuery.Data
-
-	row := []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("GPT3.5", "gpt3.5"),
-		tgbotapi.NewInlineKeyboardButtonData("GPT4", "gpt4"),
-		tgbotapi.NewInlineKeyboardButtonData("GPT4-32k", "gpt4-32k"),
-	}
+// Register adds the /beta and /verify commands, and the /applications command of the review chat, to the router.
+func (h *Handler) Register(r *router.Router) {
+	h.commands = r
+	r.MustRegister(router.Command{
+		Name:        "beta",
+		Aliases:     []string{"signup"},
+		Description: "Participate in the beta testing of the bot",
+		Handler: func(ctx *router.Context) (tgbotapi.Chattable, error) {
+			return h.Handle(int64(ctx.Message.From.ID), ctx.Message.Chat.ID, ctx.Message.From.UserName)
+		},
+	})
+	r.MustRegister(router.Command{
+		Name:        "verify",
+		Description: "Verify the email address of your beta application",
+		Usage:       "[code|resend]",
+		MaxArgs:     -1,
+		ChatTypes:   []string{router.Private},
+		Handler:     h.handleVerify,
+	})
+	r.MustRegister(router.Command{
+		Name:        "applications",
+		Description: "Review beta applications",
+		Usage:       "[pending|approved|rejected|waitlisted|id]",
+		MaxArgs:     1,
+		Hidden:      true,
+		Handler:     h.handleApplications,
+	})
+}
 
-	var keyboard [][]tgbotapi.InlineKeyboardButton
-	keyboard = append(keyboard, row)
+// Handle starts the signup wizard in the user's private chat.
+// groupID is the chat /beta was sent from and is stored with the application.
+func (h *Handler) Handle(userID int64, groupID int64, userName string) (tgbotapi.Chattable, error) {
+	return h.flow.Start(userID, userID, groupID, userName)
+}
 
-	markup := tgbotapi.InlineKeyboardMarkup{
-		InlineKeyboard: keyboard,
+// HandleUpdate feeds an answer or button press to the user's running signup.
+// The boolean result reports whether the update belonged to the signup.
+// Button presses it claims are answered, whatever the outcome.
+func (h *Handler) HandleUpdate(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	response, ok, err := h.flow.Handle(update)
+	if ok && update.CallbackQuery != nil {
+		h.out.Answer(update.CallbackQuery.ID, "")
 	}
-
-	msg := tgbotapi.NewEditMessageText(update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.Message.MessageID, "What model do you have access to?")
-	msg.ReplyMarkup = &markup
-
-	return msg, betaInfo
+	return response, ok, err
 }
 
-func HandleEmail(update *tgbotapi.Update, betaInfo db.Beta) (tgbotapi.Chattable, db.Beta) {
-	var msg tgbotapi.Chattable
-
-	mu.Lock()                                             // Lock the mutex
-	betaInfo = betaInfoMap[int64(update.Message.From.ID)] // Retrieve the betaInfo for the user
-	mu.Unlock()                                           // Unlock the mutex
+// summary renders the answers for review before submitting.
+func summary(state *db.WizardState) string {
+	betaInfo := fromState(state)
+	return fmt.Sprintf("Please review your information:\n\nAPI Key: %v\nProvider: %s\nModel: %s\nEmail: %s\nName: %s\nContact: %s\n",
+		betaInfo.APIKey, betaInfo.Provider, betaInfo.Model, betaInfo.Email, betaInfo.Name, contact(&betaInfo))
+}
 
-	if update.Message != nil && update.Message.Text != "" {
-		// Save the input as email
-		betaInfo.Email = update.Message.Text
-		msg = tgbotapi.NewMessage(update.Message.Chat.ID, "What is your name?")
-	} else {
-		// If update.Message is nil or text is empty, return a default message
-		msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Please enter your email:")
+// contact describes how and when an applicant would like to be contacted.
+func contact(betaInfo *db.Beta) string {
+	how := betaInfo.ContactMethod // Free text on older applications
+	switch betaInfo.ContactMethod {
+	case db.ContactTelegram:
+		how = "Telegram"
+	case db.ContactEmail:
+		how = "email, " + betaInfo.Email
+	case db.ContactPhone:
+		how = "phone, " + betaInfo.Phone
 	}
-
-	mu.Lock()                                             // Lock the mutex
-	betaInfoMap[int64(update.Message.From.ID)] = betaInfo // Update the betaInfo for the user
-	mu.Unlock()                                           // Unlock the mutex
-
-	return msg, betaInfo
+	if betaInfo.ContactTime != "" {
+		how += ", " + betaInfo.ContactTime
+	}
+	return how
 }
 
-func HandleName(update *tgbotapi.Update) (tgbotapi.Chattable, db.Beta) {
-	var msg tgbotapi.Chattable
-
-	mu.Lock()                                              // Lock the mutex
-	betaInfo := betaInfoMap[int64(update.Message.From.ID)] // Retrieve the betaInfo for the user
-	mu.Unlock()                                            // Unlock the mutex
-
-	if update.Message != nil {
-		betaInfo.Name = update.Message.Text
-		msg = tgbotapi.NewMessage(update.Message.Chat.ID, "What is the best time and method of contacting you?")
+// complete saves the finished application with the code verifying its email
+// address, then mails the code and posts the application for review.
+func (h *Handler) complete(state *db.WizardState) (string, error) {
+	betaInfo := fromState(state)
+	betaInfo.ID = random.ID()
+	betaInfo.Status = db.BetaPending
+	betaInfo.Created = time.Now()
+	betaInfo.History = []db.BetaTransition{{To: db.BetaPending, ByID: betaInfo.UserID, ByName: betaInfo.Name, At: betaInfo.Created}}
+
+	// The application stands without a code; /verify resend tries again
+	code, codeErr := h.issue(&betaInfo, betaInfo.Created)
+	if err := h.store.SaveBeta(betaInfo); err != nil {
+		return "", err
 	}
 
-	mu.Lock()                                             // Lock the mutex
-	betaInfoMap[int64(update.Message.From.ID)] = betaInfo // Update the betaInfo for the user
-	mu.Unlock()                                           // Unlock the mutex
+	closing := fmt.Sprintf("We've emailed a %d-digit code to %s. Send it here within %s to verify your address.", codeDigits, betaInfo.Email, duration(h.codeTTL))
+	if codeErr == nil {
+		codeErr = h.mailCode(&betaInfo, code)
+	}
+	if codeErr != nil {
+		log.Printf("Failed to send a verification code for beta application %s: %v", betaInfo.ID, errors.HandleError(codeErr))
+		closing = fmt.Sprintf("We couldn't email a verification code to %s just now. Send /verify resend in a few minutes to get one.", betaInfo.Email)
+	}
 
-	return msg, betaInfo
+	h.announce(&betaInfo)
+	return "Thanks! Your beta application has been submitted. We'll message you here once it has been reviewed.\n\n" + closing, nil
 }
 
-func HandleContact(update *tgbotapi.Update, betaInfo db.Beta) (tgbotapi.Chattable, db.Beta) {
-	var msg tgbotapi.Chattable
+// update applies change to the stored application and saves it if change
+// returns true, holding the application's lock so that changes made at the
+// same time are not lost. Nothing may be sent or mailed from change.
+func (h *Handler) update(id string, change func(betaInfo *db.Beta) bool) (*db.Beta, error) {
+	unlock := h.locks.Lock(id)
+	defer unlock()
 
-	if update.Message != nil {
-		betaInfo.ContactMethod = update.Message.Text
-		msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Please review your information:")
+	betaInfo, err := h.store.GetBetaByID(id)
+	if err != nil {
+		return nil, err
 	}
-
-	return msg, betaInfo
+	if !change(betaInfo) {
+		return betaInfo, nil
+	}
+	return betaInfo, h.store.UpdateBeta(*betaInfo)
 }
 
-func HandleSummary(update *tgbotapi.Update, betaInfo db.Beta) tgbotapi.Chattable {
-	summary := fmt.Sprintf("API Key: %v\nProvider: %s\nModel: %s\nEmail: %s\nName: %s\nContact: %s\n",
-		betaInfo.APIKey, betaInfo.Provider, betaInfo.Model, betaInfo.Email, betaInfo.Name, betaInfo.ContactMethod)
-
-	row := []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("Submit", "submit"),
-		tgbotapi.NewInlineKeyboardButtonData("Reset", "reset"),
+// fromState builds a Beta record from the wizard answers.
+func fromState(state *db.WizardState) db.Beta {
+	betaInfo := db.Beta{
+		Username:      state.Username,
+		UserID:        state.UserID,
+		GroupID:       state.OriginChatID,
+		APIKey:        state.Answers["api_key"] == "yes",
+		Provider:      state.Answers["provider"],
+		Model:         state.Answers["model"],
+		Email:         state.Answers["email"],
+		Name:          state.Answers["name"],
+		ContactMethod: state.Answers["contact_method"],
+		Phone:         state.Answers["phone"],
+		ContactTime:   state.Answers["contact_time"],
 	}
-
-	var keyboard [][]tgbotapi.InlineKeyboardButton
-	keyboard = append(keyboard, row)
-
-	markup := tgbotapi.InlineKeyboardMarkup{
-		InlineKeyboard: keyboard,
+	// The answer was validated, so it parses again
+	if window, err := validate.ContactTime(betaInfo.ContactTime); err == nil {
+		betaInfo.ContactWindow = &window
 	}
-
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, summary)
-	msg.ReplyMarkup = &markup
-
-	return msg
+	return betaInfo
 }
@@ -0,0 +1,316 @@
+//beta/review.go
+
+package beta
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"strings"
+	db "tg/db"
+	errors "tg/errors"
+	router "tg/router"
+	"time"
+)
+
+// callbackPrefix starts the data of the decision buttons in the review chat.
+const callbackPrefix = "review"
+
+// listSize is the number of applications listed by /applications.
+const listSize = 20
+
+// decisions maps the button actions to the statuses they set, in button order.
+var decisions = []struct {
+	action string
+	label  string
+	status string
+}{
+	{"approve", "Approve", db.BetaApproved},
+	{"reject", "Reject", db.BetaRejected},
+	{"waitlist", "Waitlist", db.BetaWaitlisted},
+}
+
+// verdicts are the messages applicants get when their application reaches a status.
+var verdicts = map[string]string{
+	db.BetaApproved:   "Good news: your beta application has been approved! Welcome aboard, we'll send you everything you need to get started shortly.",
+	db.BetaRejected:   "Thank you for applying to the beta. Unfortunately we can't offer you a place this time.",
+	db.BetaWaitlisted: "Thank you for applying to the beta. You're on the waitlist, and we'll message you here as soon as a place opens up.",
+}
+
+// announce posts a new application to the review chat with the decision buttons.
+// It is best effort: the application is saved whether or not staff can be told.
+func (h *Handler) announce(betaInfo *db.Beta) {
+	if h.reviewChat == 0 {
+		return
+	}
+
+	msg := tgbotapi.NewMessage(h.reviewChat, describe(betaInfo))
+	msg.ReplyMarkup = buttons(betaInfo)
+	sent, err := h.out.Send(msg)
+	if err != nil {
+		log.Printf("Failed to post beta application %s for review: %v", betaInfo.ID, err)
+		return
+	}
+
+	_, err = h.update(betaInfo.ID, func(current *db.Beta) bool {
+		current.StaffMessageID = sent.MessageID
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to remember the review message of beta application %s: %v", betaInfo.ID, errors.HandleError(err))
+	}
+}
+
+// refresh updates the post of an application in the review chat after a
+// change the applicant made. It is best effort, like announce.
+func (h *Handler) refresh(betaInfo *db.Beta) {
+	if h.reviewChat == 0 || betaInfo.StaffMessageID == 0 {
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(h.reviewChat, betaInfo.StaffMessageID, describe(betaInfo))
+	markup := buttons(betaInfo)
+	edit.ReplyMarkup = &markup
+	if _, err := h.out.Send(edit); err != nil {
+		log.Printf("Failed to update the review post of beta application %s: %v", betaInfo.ID, err)
+	}
+}
+
+// HandleReview handles the decision buttons under an application in the review chat.
+// The boolean result reports whether the button belonged to a review; those
+// presses are answered, with the reason when nothing was decided.
+func (h *Handler) HandleReview(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	query := update.CallbackQuery
+	if query == nil || query.Message == nil {
+		return nil, false, nil
+	}
+
+	parts := strings.SplitN(query.Data, "|", 3)
+	if len(parts) != 3 || parts[0] != callbackPrefix {
+		return nil, false, nil
+	}
+
+	var toast string
+	defer func() { h.out.Answer(query.ID, toast) }()
+
+	if query.Message.Chat.ID != h.reviewChat {
+		toast = "Applications can only be reviewed in the review chat."
+		return nil, true, nil
+	}
+
+	status := ""
+	for _, d := range decisions {
+		if d.action == parts[1] {
+			status = d.status
+		}
+	}
+	if status == "" {
+		toast = "This button no longer works."
+		return nil, true, nil
+	}
+
+	betaInfo, err := h.decide(parts[2], status, query.From)
+	if err != nil {
+		return nil, true, err
+	}
+	if betaInfo == nil {
+		toast = "The application no longer exists or already has that status."
+		return nil, true, nil
+	}
+	log.Printf("%d set beta application %s to %s", query.From.ID, betaInfo.ID, betaInfo.Status)
+
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, describe(betaInfo))
+	markup := buttons(betaInfo)
+	edit.ReplyMarkup = &markup
+	return edit, true, nil
+}
+
+// decide moves an application to a status, records who did it, and tells the
+// applicant once the decision is stored. The application is nil when it does
+// not exist or already has the status.
+func (h *Handler) decide(id string, status string, reviewer *tgbotapi.User) (*db.Beta, error) {
+	decided := -1 // Index of the transition in the history
+	betaInfo, err := h.update(id, func(betaInfo *db.Beta) bool {
+		if statusOf(betaInfo) == status {
+			return false
+		}
+
+		now := time.Now()
+		decided = len(betaInfo.History)
+		betaInfo.History = append(betaInfo.History, db.BetaTransition{
+			From:   statusOf(betaInfo),
+			To:     status,
+			ByID:   int64(reviewer.ID),
+			ByName: reviewer.FirstName,
+			At:     now,
+		})
+		betaInfo.Status = status
+		betaInfo.ReviewerID = int64(reviewer.ID)
+		betaInfo.ReviewerName = reviewer.FirstName
+		betaInfo.Reviewed = now
+		return true
+	})
+	if errors.IsNotFound(err) || (err == nil && decided < 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := h.out.Send(tgbotapi.NewMessage(betaInfo.UserID, verdicts[status])); err != nil {
+		log.Printf("Failed to tell %d about beta application %s: %v", betaInfo.UserID, betaInfo.ID, err)
+		note := "the applicant could not be messaged"
+		if errors.IsForbidden(err) {
+			note = "the applicant could not be messaged: they blocked the bot or never started it"
+		}
+		noted, err := h.update(id, func(betaInfo *db.Beta) bool {
+			if len(betaInfo.History) <= decided || betaInfo.History[decided].To != status {
+				return false
+			}
+			betaInfo.History[decided].Note = note
+			return true
+		})
+		if err != nil {
+			log.Printf("Failed to note that %d was not told about beta application %s: %v", betaInfo.UserID, betaInfo.ID, errors.HandleError(err))
+		} else {
+			betaInfo = noted
+		}
+	}
+	return betaInfo, nil
+}
+
+// handleApplications lists the applications with a status, pending by default,
+// or shows one application with its history. It is for the review chat and bot admins.
+func (h *Handler) handleApplications(ctx *router.Context) (tgbotapi.Chattable, error) {
+	chatID := ctx.Message.Chat.ID
+	if chatID != h.reviewChat && !h.commands.IsAdmin(int64(ctx.Message.From.ID)) {
+		return ctx.Reply("/applications only works in the review chat."), nil
+	}
+
+	arg := db.BetaPending
+	if len(ctx.Args) > 0 {
+		arg = strings.ToLower(ctx.Args[0])
+	}
+
+	switch arg {
+	case db.BetaPending, db.BetaApproved, db.BetaRejected, db.BetaWaitlisted:
+		betas, err := h.store.ListBetas(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(betas) == 0 {
+			return ctx.Reply(fmt.Sprintf("There are no %s applications.", arg)), nil
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s applications (%d):\n", strings.ToUpper(arg[:1])+arg[1:], len(betas))
+		for i, betaInfo := range betas {
+			if i == listSize {
+				fmt.Fprintf(&b, "\n…and %d more.", len(betas)-listSize)
+				break
+			}
+			fmt.Fprintf(&b, "\n%s %s, %s", betaInfo.ID, applicant(&betaInfo), betaInfo.Created.Format("2 Jan 2006"))
+		}
+		b.WriteString("\n\nSend /applications <id> for the details of one.")
+		return ctx.Reply(b.String()), nil
+	}
+
+	betaInfo, err := h.store.GetBetaByID(ctx.Args[0])
+	if errors.IsNotFound(err) {
+		return ctx.Reply(fmt.Sprintf("There is no application %s.", ctx.Args[0])), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg := ctx.Reply(describe(betaInfo) + "\n\n" + history(betaInfo))
+	if chatID == h.reviewChat {
+		msg.ReplyMarkup = buttons(betaInfo)
+	}
+	return msg, nil
+}
+
+// describe renders an application and its current decision for staff.
+func describe(betaInfo *db.Beta) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Beta application %s\n\n", betaInfo.ID)
+	fmt.Fprintf(&b, "Applicant: %s\n", applicant(betaInfo))
+	if betaInfo.EmailVerified {
+		fmt.Fprintf(&b, "Email: %s (verified)\n", betaInfo.Email)
+	} else {
+		fmt.Fprintf(&b, "Email: %s (not verified)\n", betaInfo.Email)
+	}
+	if betaInfo.APIKey {
+		fmt.Fprintf(&b, "API key: yes, %s, %s\n", betaInfo.Provider, betaInfo.Model)
+	} else {
+		b.WriteString("API key: no\n")
+	}
+	fmt.Fprintf(&b, "Contact: %s\n", contact(betaInfo))
+	if betaInfo.GroupID != betaInfo.UserID {
+		fmt.Fprintf(&b, "Applied from: group %d\n", betaInfo.GroupID)
+	}
+	fmt.Fprintf(&b, "Submitted: %s\n\n", betaInfo.Created.Format(time.RFC1123))
+
+	status := statusOf(betaInfo)
+	if status == db.BetaPending || betaInfo.ReviewerName == "" {
+		fmt.Fprintf(&b, "Status: %s", status)
+	} else {
+		fmt.Fprintf(&b, "Status: %s by %s on %s", status, betaInfo.ReviewerName, betaInfo.Reviewed.Format(time.RFC1123))
+	}
+	if n := len(betaInfo.History); n > 0 && betaInfo.History[n-1].Note != "" {
+		fmt.Fprintf(&b, " (%s)", betaInfo.History[n-1].Note)
+	}
+	if betaInfo.Cohort != "" {
+		fmt.Fprintf(&b, "\nCohort: %s, joined %s", betaInfo.Cohort, betaInfo.Joined.Format(time.RFC1123))
+	}
+	return b.String()
+}
+
+// history renders the status changes of an application, oldest first.
+func history(betaInfo *db.Beta) string {
+	if len(betaInfo.History) == 0 {
+		return "No recorded history."
+	}
+
+	var b strings.Builder
+	b.WriteString("History:")
+	for _, t := range betaInfo.History {
+		fmt.Fprintf(&b, "\n%s: ", t.At.Format("2 Jan 2006 15:04 MST"))
+		if t.From == "" {
+			fmt.Fprintf(&b, "submitted as %s", t.To)
+		} else {
+			fmt.Fprintf(&b, "%s → %s by %s", t.From, t.To, t.ByName)
+		}
+		if t.Note != "" {
+			fmt.Fprintf(&b, " (%s)", t.Note)
+		}
+	}
+	return b.String()
+}
+
+// buttons offers every decision other than the current one, so decisions can be revised.
+func buttons(betaInfo *db.Beta) tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	for _, d := range decisions {
+		if d.status != statusOf(betaInfo) {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(d.label, callbackPrefix+"|"+d.action+"|"+betaInfo.ID))
+		}
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// applicant names the user behind an application.
+func applicant(betaInfo *db.Beta) string {
+	if betaInfo.Username != "" {
+		return fmt.Sprintf("%s (@%s, %d)", betaInfo.Name, betaInfo.Username, betaInfo.UserID)
+	}
+	return fmt.Sprintf("%s (%d)", betaInfo.Name, betaInfo.UserID)
+}
+
+// statusOf returns the status of an application, counting a missing one as pending.
+func statusOf(betaInfo *db.Beta) string {
+	if betaInfo.Status == "" {
+		return db.BetaPending
+	}
+	return betaInfo.Status
+}
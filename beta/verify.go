@@ -0,0 +1,266 @@
+//beta/verify.go
+
+package beta
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"strings"
+	db "tg/db"
+	errors "tg/errors"
+	mail "tg/mail"
+	random "tg/random"
+	router "tg/router"
+	"time"
+)
+
+// codeDigits is the length of an email verification code.
+const codeDigits = 6
+
+// mailTimeout bounds how long sending a verification code may take.
+const mailTimeout = 30 * time.Second
+
+// codeMail is the email carrying a verification code, filled with the code and how long it is valid.
+const codeMail = `Hi,
+
+your verification code for the beta is %s.
+
+Send it to the bot in Telegram within %s to confirm this email address.
+If you didn't apply to the beta, you can ignore this email.
+`
+
+// issue draws a fresh verification code for the application, replacing any
+// earlier one, and returns it. The caller saves the application before the
+// code is mailed, so every code that arrives is one that is accepted.
+func (h *Handler) issue(betaInfo *db.Beta, now time.Time) (string, error) {
+	code, err := random.Code(random.Digits, codeDigits)
+	if err != nil {
+		return "", err
+	}
+	betaInfo.CodeHash = hashCode(betaInfo.ID, code)
+	betaInfo.CodeExpires = now.Add(h.codeTTL)
+	betaInfo.CodeSent = now
+	betaInfo.CodesSent++
+	betaInfo.CodeAttempts = 0
+	return code, nil
+}
+
+// mailCode mails a code issued for the application. A code that cannot be
+// mailed is withdrawn, so it counts against neither the codes an application
+// gets nor the wait for the next one.
+func (h *Handler) mailCode(betaInfo *db.Beta, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+	defer cancel()
+	msg := mail.Message{
+		To:      betaInfo.Email,
+		Subject: "Your beta verification code",
+		Body:    fmt.Sprintf(codeMail, code, duration(h.codeTTL)),
+	}
+	err := h.mailer.Send(ctx, msg)
+	if err == nil {
+		return nil
+	}
+
+	hash := hashCode(betaInfo.ID, code)
+	if _, withdrawErr := h.update(betaInfo.ID, func(betaInfo *db.Beta) bool {
+		if betaInfo.CodeHash != hash {
+			return false // Replaced or used meanwhile
+		}
+		betaInfo.CodeHash = ""
+		betaInfo.CodeSent = time.Time{}
+		betaInfo.CodesSent--
+		return true
+	}); withdrawErr != nil {
+		log.Printf("Failed to withdraw the unsent code of beta application %s: %v", betaInfo.ID, errors.HandleError(withdrawErr))
+	}
+	return fmt.Errorf("mailing a verification code with %s: %w", h.mailer.Name(), err)
+}
+
+// handleVerify shows where the verification of the user's email address
+// stands, checks a code, or sends a new one.
+func (h *Handler) handleVerify(ctx *router.Context) (tgbotapi.Chattable, error) {
+	userID := int64(ctx.Message.From.ID)
+	arg := strings.ToLower(strings.TrimSpace(ctx.RawArgs))
+
+	betaInfo, err := h.store.GetBeta(userID)
+	if errors.IsNotFound(err) || (err == nil && betaInfo.ID == "") {
+		return ctx.Reply("You have no beta application to verify. Send /beta to apply."), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case betaInfo.EmailVerified:
+		return ctx.Reply(fmt.Sprintf("Your email address %s is verified.", betaInfo.Email)), nil
+	case arg == "":
+		if betaInfo.CodeHash == "" {
+			return ctx.Reply(fmt.Sprintf("Your email address %s is not verified yet. Send /verify resend to get a code.", betaInfo.Email)), nil
+		}
+		return ctx.Reply(fmt.Sprintf("Send the %d-digit code we emailed to %s, or /verify resend for a new one.", codeDigits, betaInfo.Email)), nil
+	case arg == "resend":
+		return ctx.Reply(h.resend(betaInfo.ID, time.Now())), nil
+	}
+	return ctx.Reply(h.check(betaInfo.ID, arg, time.Now())), nil
+}
+
+// HandleMessage takes verification codes sent as plain messages in a private
+// chat. The boolean result reports whether the message was a code the user
+// was asked for; other messages are left to the rest of the bot.
+func (h *Handler) HandleMessage(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	message := update.Message
+	if message == nil || message.From == nil || !message.Chat.IsPrivate() {
+		return nil, false, nil
+	}
+	code := strings.Join(strings.Fields(message.Text), "")
+	if !isCode(code) {
+		return nil, false, nil
+	}
+
+	betaInfo, err := h.store.GetBeta(int64(message.From.ID))
+	if errors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if betaInfo.ID == "" || betaInfo.EmailVerified || betaInfo.CodeHash == "" {
+		return nil, false, nil
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, h.check(betaInfo.ID, code, time.Now()))
+	msg.ReplyToMessageID = message.MessageID
+	return msg, true, nil
+}
+
+// check compares a code with the outstanding one of the application, marks
+// the application verified when they match, and returns the answer for the
+// applicant. Wrong guesses are counted, and too many discard the code.
+func (h *Handler) check(id string, code string, now time.Time) string {
+	code = strings.Join(strings.Fields(code), "")
+
+	var answer string
+	verified := false
+	betaInfo, err := h.update(id, func(betaInfo *db.Beta) bool {
+		switch {
+		case betaInfo.EmailVerified:
+			answer = fmt.Sprintf("Your email address %s is verified.", betaInfo.Email)
+			return false
+		case betaInfo.CodeHash == "":
+			answer = "There is no code waiting to be checked. Send /verify resend to get one."
+			return false
+		case !now.Before(betaInfo.CodeExpires):
+			betaInfo.CodeHash = ""
+			answer = "That code has expired. Send /verify resend to get a new one."
+			return true
+		}
+
+		if !isCode(code) || subtle.ConstantTimeCompare([]byte(hashCode(betaInfo.ID, code)), []byte(betaInfo.CodeHash)) != 1 {
+			betaInfo.CodeAttempts++
+			answer = fmt.Sprintf("That code is wrong. You can try %d more times.", h.maxAttempts-betaInfo.CodeAttempts)
+			if h.maxAttempts-betaInfo.CodeAttempts == 1 {
+				answer = "That code is wrong. You can try once more."
+			}
+			if betaInfo.CodeAttempts >= h.maxAttempts {
+				betaInfo.CodeHash = ""
+				answer = "That code is wrong, and there have been too many wrong codes. Send /verify resend to get a new one."
+			}
+			return true
+		}
+
+		betaInfo.EmailVerified = true
+		betaInfo.Verified = now
+		betaInfo.CodeHash = ""
+		betaInfo.CodeAttempts = 0
+		verified = true
+		answer = fmt.Sprintf("Thanks, your email address %s is verified!", betaInfo.Email)
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to check a code for beta application %s: %v", id, errors.HandleError(err))
+		return "Something went wrong while checking your code. Please try again."
+	}
+
+	if verified {
+		log.Printf("%d verified the email address of beta application %s", betaInfo.UserID, betaInfo.ID)
+		h.refresh(betaInfo)
+	}
+	return answer
+}
+
+// resend mails a new code unless the application has had all its codes or
+// the latest one was sent too recently, and returns the answer for the
+// applicant.
+func (h *Handler) resend(id string, now time.Time) string {
+	const unsent = "We couldn't send a code right now. Please try /verify resend again in a few minutes."
+
+	var code, answer string
+	betaInfo, err := h.update(id, func(betaInfo *db.Beta) bool {
+		switch {
+		case betaInfo.EmailVerified:
+			answer = fmt.Sprintf("Your email address %s is verified.", betaInfo.Email)
+			return false
+		case betaInfo.CodesSent >= h.maxCodes:
+			answer = fmt.Sprintf("We have already sent %d codes to %s. If none of them arrived, send /support and we'll sort it out.", betaInfo.CodesSent, betaInfo.Email)
+			return false
+		}
+		if wait := betaInfo.CodeSent.Add(h.codeResend).Sub(now); wait > 0 {
+			answer = fmt.Sprintf("Please wait %s before asking for another code.", duration(wait))
+			return false
+		}
+
+		var err error
+		if code, err = h.issue(betaInfo, now); err != nil {
+			log.Printf("Failed to draw a verification code for beta application %s: %v", betaInfo.ID, err)
+			answer = unsent
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to record a verification code for beta application %s: %v", id, errors.HandleError(err))
+		return unsent
+	}
+	if code == "" {
+		return answer
+	}
+
+	if err := h.mailCode(betaInfo, code); err != nil {
+		log.Printf("Failed to send a verification code for beta application %s: %v", id, errors.HandleError(err))
+		return unsent
+	}
+	return fmt.Sprintf("We've emailed a new %d-digit code to %s. Send it here within %s.", codeDigits, betaInfo.Email, duration(h.codeTTL))
+}
+
+// hashCode hashes a code with the application it was sent for, so codes are not stored in the clear.
+func hashCode(id string, code string) string {
+	sum := sha256.Sum256([]byte(id + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// isCode reports whether s has the form of a verification code.
+func isCode(s string) bool {
+	if len(s) != codeDigits {
+		return false
+	}
+	return strings.Trim(s, "0123456789") == ""
+}
+
+// duration renders a wait for applicants, rounded up to whole minutes, e.g. "15 minutes" or "2 hours".
+func duration(d time.Duration) string {
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	switch {
+	case minutes <= 1:
+		return "a minute"
+	case minutes == 60:
+		return "an hour"
+	case minutes%60 == 0:
+		return fmt.Sprintf("%d hours", minutes/60)
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
@@ -0,0 +1,160 @@
+//beta/verify_test.go
+
+package beta
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	mail "tg/mail"
+	sender "tg/sender"
+	"time"
+)
+
+// fakeAPI records what is sent and numbers the messages.
+type fakeAPI struct {
+	mu   sync.Mutex
+	sent []tgbotapi.Chattable
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, c)
+	return tgbotapi.Message{MessageID: 1000 + len(f.sent)}, nil
+}
+
+func (f *fakeAPI) messages() []tgbotapi.Chattable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]tgbotapi.Chattable(nil), f.sent...)
+}
+
+// fakeMailer keeps the mailed codes, or fails while failing is set. It
+// checks that every code it is asked to mail is already stored.
+type fakeMailer struct {
+	t       *testing.T
+	store   db.Store
+	failing bool
+	codes   []string
+}
+
+var codePattern = regexp.MustCompile(`code for the beta is (\d+)`)
+
+func (f *fakeMailer) Name() string { return "fake" }
+
+func (f *fakeMailer) Send(ctx context.Context, msg mail.Message) error {
+	code := codePattern.FindStringSubmatch(msg.Body)[1]
+	betaInfo, err := f.store.GetBetaByID(testID)
+	if err != nil || betaInfo.CodeHash != hashCode(testID, code) {
+		f.t.Errorf("code %s was mailed before it was stored", code)
+	}
+	if f.failing {
+		return fmt.Errorf("mail server down")
+	}
+	f.codes = append(f.codes, code)
+	return nil
+}
+
+const (
+	testID      = "a1b2c3d4"
+	applicantID = int64(7)
+)
+
+var testConfig = config.BetaConfig{ReviewChatID: -300, CodeTTL: time.Hour, CodeResend: time.Minute, MaxCodes: 3, MaxAttempts: 2}
+
+func newHandler(t *testing.T) (*Handler, *fakeAPI, *fakeMailer, *db.MemoryStore) {
+	store := db.NewMemoryStore()
+	api := &fakeAPI{}
+	mailer := &fakeMailer{t: t, store: store}
+	h := New(store, sender.New(api, store, nil, sender.Options{}), mailer, testConfig)
+	return h, api, mailer, store
+}
+
+func saveApplication(t *testing.T, store db.Store) {
+	betaInfo := db.Beta{ID: testID, UserID: applicantID, Name: "Ann", Email: "ann@example.com", Status: db.BetaPending, Created: time.Now()}
+	if err := store.SaveBeta(betaInfo); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResend(t *testing.T) {
+	h, _, mailer, store := newHandler(t)
+	saveApplication(t, store)
+	now := time.Now()
+
+	// A code that cannot be mailed is withdrawn and can be asked for again right away
+	mailer.failing = true
+	if answer := h.resend(testID, now); !strings.Contains(answer, "couldn't send") {
+		t.Errorf("answer = %q, want a failure", answer)
+	}
+	betaInfo, _ := store.GetBetaByID(testID)
+	if betaInfo.CodeHash != "" || betaInfo.CodesSent != 0 {
+		t.Errorf("unsent code kept: hash %q, %d sent", betaInfo.CodeHash, betaInfo.CodesSent)
+	}
+
+	mailer.failing = false
+	if answer := h.resend(testID, now); !strings.Contains(answer, "emailed a new") {
+		t.Errorf("answer = %q, want the code to be sent", answer)
+	}
+	if answer := h.resend(testID, now.Add(time.Second)); !strings.Contains(answer, "Please wait") {
+		t.Errorf("answer = %q, want to wait", answer)
+	}
+	h.resend(testID, now.Add(2*time.Minute))
+	h.resend(testID, now.Add(4*time.Minute))
+	if answer := h.resend(testID, now.Add(6*time.Minute)); !strings.Contains(answer, "already sent 3 codes") {
+		t.Errorf("answer = %q, want the limit", answer)
+	}
+	if len(mailer.codes) != 3 {
+		t.Errorf("mailed %d codes, want 3", len(mailer.codes))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		guesses  []string // "code" stands for the mailed code
+		after    time.Duration
+		want     string
+		verified bool
+	}{
+		{"right code", []string{"code"}, 0, "is verified", true},
+		{"spaces in the code", []string{" code "}, 0, "is verified", true},
+		{"wrong code", []string{"000000"}, 0, "try once more", false},
+		{"too many wrong codes", []string{"000000", "000001", "code"}, 0, "no code waiting", false},
+		{"expired", []string{"code"}, 2 * time.Hour, "expired", false},
+		{"after verifying", []string{"code", "code"}, 0, "is verified", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api, mailer, store := newHandler(t)
+			saveApplication(t, store)
+			now := time.Now()
+			h.resend(testID, now)
+
+			var answer string
+			for _, guess := range tt.guesses {
+				if strings.Contains(guess, "code") {
+					guess = strings.Replace(guess, "code", mailer.codes[0], 1)
+				}
+				answer = h.check(testID, guess, now.Add(tt.after))
+			}
+			if !strings.Contains(answer, tt.want) {
+				t.Errorf("answer = %q, want %q", answer, tt.want)
+			}
+			betaInfo, _ := store.GetBetaByID(testID)
+			if betaInfo.EmailVerified != tt.verified {
+				t.Errorf("verified = %v, want %v", betaInfo.EmailVerified, tt.verified)
+			}
+			if len(api.messages()) != 0 {
+				t.Errorf("sent %d messages, want none without a review post", len(api.messages()))
+			}
+		})
+	}
+}
@@ -0,0 +1,634 @@
+//broadcast/broadcast.go
+
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"strings"
+	"sync"
+	db "tg/db"
+	errors "tg/errors"
+	mute "tg/mute"
+	random "tg/random"
+	ratelimit "tg/ratelimit"
+	router "tg/router"
+	sender "tg/sender"
+	"time"
+)
+
+// pollInterval is how often the service looks for scheduled broadcasts that became due.
+const pollInterval = 30 * time.Second
+
+// callbackPrefix starts the data of the buttons under a preview.
+const callbackPrefix = "broadcast"
+
+// usage explains the command; the message to send goes on the lines after it.
+const usage = `<users|groups> [ingroup] [active] [lang=xx] [beta=applied|none] [at=2006-01-02T15:04|in=2h]
+<message on the following lines>
+or /broadcast list | status <id> | cancel <id>`
+
+// Service drafts, schedules and delivers broadcasts.
+//
+// Every recipient's delivery is stored before and after it is sent, so a
+// broadcast interrupted by a crash resumes where it stopped. A delivery
+// whose outcome is unknown is skipped rather than sent twice. Chats that
+// muted broadcasts are skipped, and those in their quiet hours get the
+// message without a sound.
+type Service struct {
+	store    db.Store
+	out      *sender.Sender
+	commands *router.Router // Set by Register; used for the admin check on buttons
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc // Broadcasts being delivered, by ID
+	wake    chan struct{}
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New creates a broadcast service delivering through out.
+func New(store db.Store, out *sender.Sender) *Service {
+	return &Service{
+		store:   store,
+		out:     out,
+		running: make(map[string]context.CancelFunc),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Register adds the admin-only /broadcast command to the router.
+func (s *Service) Register(r *router.Router) {
+	s.commands = r
+	r.MustRegister(router.Command{
+		Name:        "broadcast",
+		Description: "Broadcast a message to users or groups",
+		Usage:       usage,
+		MinArgs:     1,
+		ChatTypes:   []string{router.Private},
+		Admin:       true,
+		Handler:     s.handleCommand,
+	})
+}
+
+// Start begins delivering due broadcasts, including those interrupted by a previous run.
+func (s *Service) Start(ctx context.Context) error {
+	runCtx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.loop(runCtx)
+	}()
+	return nil
+}
+
+// Stop interrupts the running deliveries and waits for them to record their progress.
+func (s *Service) Stop(ctx context.Context) error {
+	if s.stop == nil {
+		return nil
+	}
+	s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HandleCallback handles the Send and Cancel buttons under a preview.
+// The boolean result reports whether the button belonged to a broadcast;
+// those presses are answered, with the reason when they are refused.
+func (s *Service) HandleCallback(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	query := update.CallbackQuery
+	if query == nil || query.Message == nil {
+		return nil, false, nil
+	}
+
+	parts := strings.SplitN(query.Data, "|", 3)
+	if len(parts) != 3 || parts[0] != callbackPrefix {
+		return nil, false, nil
+	}
+	action, id := parts[1], parts[2]
+
+	var toast string
+	defer func() { s.out.Answer(query.ID, toast) }()
+
+	chatID := query.Message.Chat.ID
+	edit := func(text string) tgbotapi.Chattable {
+		return tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, text)
+	}
+
+	// The preview is left as it is for the administrators who can still use it
+	if s.commands == nil || !s.commands.IsAdmin(int64(query.From.ID)) {
+		toast = "Only bot administrators can send broadcasts."
+		return nil, true, nil
+	}
+
+	switch action {
+	case "confirm":
+		b, err := s.confirm(id)
+		if err != nil {
+			return nil, true, err
+		}
+		if b.Status != db.BroadcastScheduled {
+			return edit(fmt.Sprintf("Broadcast %s is %s and can no longer be sent.", b.ID, b.Status)), true, nil
+		}
+		if b.ScheduledAt.After(time.Now()) {
+			return edit(fmt.Sprintf("Broadcast %s is scheduled for %s.", b.ID, b.ScheduledAt.Format(time.RFC1123))), true, nil
+		}
+		return edit(fmt.Sprintf("Broadcast %s is being sent. Use /broadcast status %s to follow it.", b.ID, b.ID)), true, nil
+	case "cancel":
+		b, err := s.Cancel(id)
+		if err != nil {
+			return nil, true, err
+		}
+		return edit(fmt.Sprintf("Broadcast %s is %s.", b.ID, b.Status)), true, nil
+	}
+	toast = "This button no longer works."
+	return nil, true, nil
+}
+
+// handleCommand drafts a broadcast or runs one of the list, status and cancel subcommands.
+func (s *Service) handleCommand(ctx *router.Context) (tgbotapi.Chattable, error) {
+	switch strings.ToLower(ctx.Args[0]) {
+	case "list":
+		return s.list(ctx)
+	case "status":
+		if len(ctx.Args) != 2 {
+			return ctx.Reply("Usage: /broadcast status <id>"), nil
+		}
+		b, err := s.get(ctx.Args[1])
+		if err != nil {
+			return ctx.Reply(err.Error()), nil
+		}
+		if b.Status == db.BroadcastSending {
+			// The stored counts are only updated when sending stops
+			if err := s.tally(b); err != nil {
+				return nil, err
+			}
+		}
+		return ctx.Reply(describe(b)), nil
+	case "cancel":
+		if len(ctx.Args) != 2 {
+			return ctx.Reply("Usage: /broadcast cancel <id>"), nil
+		}
+		if _, err := s.get(ctx.Args[1]); err != nil {
+			return ctx.Reply(err.Error()), nil
+		}
+		b, err := s.Cancel(ctx.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Reply(fmt.Sprintf("Broadcast %s is %s.", b.ID, b.Status)), nil
+	}
+	return s.draft(ctx)
+}
+
+// draft stores a new broadcast and replies with its preview.
+func (s *Service) draft(ctx *router.Context) (tgbotapi.Chattable, error) {
+	head, text, _ := strings.Cut(ctx.RawArgs, "\n")
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ctx.Reply("Put the message to broadcast on the lines after the command.\n\nUsage: /broadcast " + usage), nil
+	}
+
+	audience, at, err := parseOptions(router.SplitArgs(head), time.Now())
+	if err != nil {
+		return ctx.Reply(err.Error()), nil
+	}
+
+	recipients, err := s.store.Recipients(audience)
+	if err != nil {
+		return nil, err
+	}
+
+	b := db.Broadcast{
+		ID:          random.ID(),
+		CreatedBy:   int64(ctx.Message.From.ID),
+		Text:        text,
+		Audience:    audience,
+		Status:      db.BroadcastDraft,
+		ScheduledAt: at,
+		Created:     time.Now(),
+	}
+	if err := s.store.SaveBroadcast(b); err != nil {
+		return nil, err
+	}
+
+	when := "right away"
+	confirm := "Send now"
+	if !at.IsZero() {
+		when = at.Format(time.RFC1123)
+		confirm = "Schedule"
+	}
+
+	preview := ctx.Reply(fmt.Sprintf("Broadcast %s to %d %s (%s), sent %s:\n\n%s",
+		b.ID, len(recipients), audience.Target, filters(audience), when, text))
+	preview.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(confirm, callbackPrefix+"|confirm|"+b.ID),
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", callbackPrefix+"|cancel|"+b.ID),
+	))
+	return preview, nil
+}
+
+// list replies with the most recent broadcasts.
+func (s *Service) list(ctx *router.Context) (tgbotapi.Chattable, error) {
+	broadcasts, err := s.store.ListBroadcasts()
+	if err != nil {
+		return nil, err
+	}
+	if len(broadcasts) == 0 {
+		return ctx.Reply("There are no broadcasts yet."), nil
+	}
+
+	var b strings.Builder
+	b.WriteString("Recent broadcasts:\n")
+	for i, broadcast := range broadcasts {
+		if i == 10 {
+			break
+		}
+		fmt.Fprintf(&b, "\n%s - %s to %s, %d/%d sent", broadcast.ID, broadcast.Status, broadcast.Audience.Target, broadcast.Sent, broadcast.Total)
+	}
+	return ctx.Reply(b.String()), nil
+}
+
+// get loads a broadcast; the error is meant for the admin.
+func (s *Service) get(id string) (*db.Broadcast, error) {
+	b, err := s.store.GetBroadcast(id)
+	if errors.IsNotFound(err) {
+		return nil, fmt.Errorf("There is no broadcast %s.", id)
+	}
+	return b, err
+}
+
+// confirm moves a draft to scheduled and wakes the delivery loop.
+func (s *Service) confirm(id string) (*db.Broadcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.store.GetBroadcast(id)
+	if err != nil {
+		return nil, err
+	}
+	if b.Status != db.BroadcastDraft {
+		return b, nil
+	}
+
+	b.Status = db.BroadcastScheduled
+	if err := s.store.SaveBroadcast(*b); err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return b, nil
+}
+
+// Cancel stops a broadcast that has not finished. Deliveries already made stay made.
+func (s *Service) Cancel(id string) (*db.Broadcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.store.GetBroadcast(id)
+	if err != nil {
+		return nil, err
+	}
+	if b.Status == db.BroadcastDone || b.Status == db.BroadcastCancelled {
+		return b, nil
+	}
+
+	b.Status = db.BroadcastCancelled
+	b.Finished = time.Now()
+	if err := s.store.SaveBroadcast(*b); err != nil {
+		return nil, err
+	}
+
+	if cancel, ok := s.running[id]; ok {
+		cancel()
+	}
+	return b, nil
+}
+
+// loop starts due broadcasts on start, when woken and on every tick, until ctx is done.
+func (s *Service) loop(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.startDue(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// startDue starts delivering every broadcast that is due and not running yet.
+func (s *Service) startDue(ctx context.Context) {
+	broadcasts, err := s.store.ListBroadcasts(db.BroadcastScheduled, db.BroadcastSending)
+	if err != nil {
+		log.Printf("Failed to load due broadcasts: %v", errors.HandleError(err))
+		return
+	}
+
+	now := time.Now()
+	for _, b := range broadcasts {
+		if b.Status == db.BroadcastScheduled && b.ScheduledAt.After(now) {
+			continue
+		}
+
+		s.mu.Lock()
+		if _, ok := s.running[b.ID]; ok {
+			s.mu.Unlock()
+			continue
+		}
+		runCtx, cancel := context.WithCancel(ctx)
+		s.running[b.ID] = cancel
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func(b db.Broadcast) {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.running, b.ID)
+				s.mu.Unlock()
+				cancel()
+			}()
+
+			if err := s.deliver(runCtx, b); err != nil {
+				log.Printf("Broadcast %s stopped: %v", b.ID, errors.HandleError(err))
+			}
+		}(b)
+	}
+}
+
+// deliver sends a broadcast to every recipient that has not been attempted yet.
+func (s *Service) deliver(ctx context.Context, b db.Broadcast) error {
+	if b.Status == db.BroadcastScheduled {
+		b.Status = db.BroadcastSending
+		b.Started = time.Now()
+
+		recipients, err := s.store.Recipients(b.Audience)
+		if err != nil {
+			return err
+		}
+		if err := s.store.AddDeliveries(b.ID, recipients); err != nil {
+			return err
+		}
+		b.Total = len(recipients)
+		if err := s.setStatus(b, db.BroadcastScheduled); err != nil {
+			return err
+		}
+		log.Printf("Broadcast %s started for %d recipients", b.ID, b.Total)
+	}
+
+	// A delivery left in sending was interrupted after it reached Telegram, or just before
+	stale, err := s.store.ListDeliveries(b.ID, db.DeliverySending)
+	if err != nil {
+		return err
+	}
+	for _, d := range stale {
+		if err := s.finish(d, db.DeliverySkipped, 0, "outcome unknown after a restart"); err != nil {
+			return err
+		}
+	}
+
+	pending, err := s.store.ListDeliveries(b.ID, db.DeliveryPending)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		if !s.out.Reachable(d.ChatID) {
+			if err := s.finish(d, db.DeliverySkipped, 0, "chat is unreachable"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		decision, reason, err := mute.Check(s.store, d.ChatID, db.CategoryBroadcasts, time.Now())
+		if err != nil {
+			return err
+		}
+		if decision == mute.Suppress {
+			if err := s.finish(d, db.DeliverySkipped, 0, reason); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Persisted before sending so a crash cannot lead to a second copy
+		d.Status = db.DeliverySending
+		d.Updated = time.Now()
+		if err := s.store.SaveDelivery(d); err != nil {
+			return err
+		}
+
+		message := tgbotapi.NewMessage(d.ChatID, b.Text)
+		message.DisableNotification = decision == mute.Silent
+		msg, err := s.out.SendPriority(ctx, message, ratelimit.Broadcast)
+		switch {
+		case err == nil:
+			err = s.finish(d, db.DeliverySent, msg.MessageID, "")
+		case ctx.Err() != nil && errors.Is(err, ctx.Err()):
+			// Stopped while waiting for the rate limiter; nothing was sent
+			err = s.finish(d, db.DeliveryPending, 0, "")
+		default:
+			err = s.finish(d, db.DeliveryFailed, 0, err.Error())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.complete(ctx, b)
+}
+
+// finish records the outcome of a delivery.
+func (s *Service) finish(d db.Delivery, status string, messageID int, reason string) error {
+	d.Status = status
+	d.MessageID = messageID
+	d.Error = reason
+	d.Updated = time.Now()
+	return s.store.SaveDelivery(d)
+}
+
+// complete stores the final counts. A broadcast interrupted by shutdown stays
+// in sending so the next run resumes it; a finished one notifies its author.
+func (s *Service) complete(ctx context.Context, b db.Broadcast) error {
+	if err := s.tally(&b); err != nil {
+		return err
+	}
+
+	interrupted := ctx.Err() != nil
+	if !interrupted {
+		b.Status = db.BroadcastDone
+		b.Finished = time.Now()
+	}
+	if err := s.setStatus(b, db.BroadcastSending); err != nil {
+		return err
+	}
+
+	if !interrupted {
+		log.Printf("Broadcast %s done: %d sent, %d failed, %d skipped", b.ID, b.Sent, b.Failed, b.Skipped)
+		report := tgbotapi.NewMessage(b.CreatedBy, describe(&b))
+		if _, err := s.out.Send(report); err != nil {
+			log.Printf("Failed to report broadcast %s: %v", b.ID, errors.HandleError(err))
+		}
+	}
+	return nil
+}
+
+// setStatus saves b unless an admin cancelled it meanwhile, in which case
+// only the counts are updated. from is the status b is expected to still have.
+func (s *Service) setStatus(b db.Broadcast, from string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.store.GetBroadcast(b.ID)
+	if err != nil {
+		return err
+	}
+	if current.Status != from && current.Status != b.Status {
+		current.Total, current.Sent, current.Failed, current.Skipped = b.Total, b.Sent, b.Failed, b.Skipped
+		return s.store.SaveBroadcast(*current)
+	}
+	return s.store.SaveBroadcast(b)
+}
+
+// tally recounts the outcomes of a broadcast from its deliveries.
+func (s *Service) tally(b *db.Broadcast) error {
+	deliveries, err := s.store.ListDeliveries(b.ID)
+	if err != nil {
+		return err
+	}
+
+	b.Total, b.Sent, b.Failed, b.Skipped = len(deliveries), 0, 0, 0
+	for _, d := range deliveries {
+		switch d.Status {
+		case db.DeliverySent:
+			b.Sent++
+		case db.DeliveryFailed:
+			b.Failed++
+		case db.DeliverySkipped:
+			b.Skipped++
+		}
+	}
+	return nil
+}
+
+// parseOptions reads the audience and the send time from the words after /broadcast.
+func parseOptions(args []string, now time.Time) (db.Audience, time.Time, error) {
+	var (
+		audience db.Audience
+		at       time.Time
+	)
+
+	if len(args) == 0 {
+		return audience, at, fmt.Errorf("Usage: /broadcast %s", usage)
+	}
+	switch target := strings.ToLower(args[0]); target {
+	case db.AudienceUsers, db.AudienceGroups:
+		audience.Target = target
+	default:
+		return audience, at, fmt.Errorf("Unknown audience %q; use users or groups.", args[0])
+	}
+
+	for _, arg := range args[1:] {
+		key, value, _ := strings.Cut(strings.ToLower(arg), "=")
+		users := audience.Target == db.AudienceUsers
+
+		switch {
+		case key == "ingroup" && users:
+			audience.InGroup = true
+		case key == "active" && !users:
+			audience.Active = true
+		case key == "lang" && users && value != "":
+			audience.Language = value
+		case key == "beta" && users && (value == db.BetaApplied || value == db.BetaNone):
+			audience.Beta = value
+		case key == "at":
+			t, err := parseTime(value)
+			if err != nil {
+				return audience, at, fmt.Errorf("Cannot read the time %q; use 2006-01-02T15:04 (UTC) or RFC 3339.", value)
+			}
+			at = t
+		case key == "in":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return audience, at, fmt.Errorf("Cannot read the delay %q; use e.g. in=30m or in=2h.", value)
+			}
+			at = now.Add(d)
+		default:
+			return audience, at, fmt.Errorf("Option %q does not apply to %s.", arg, audience.Target)
+		}
+	}
+
+	if !at.IsZero() && !at.After(now) {
+		return audience, at, fmt.Errorf("The send time %s is in the past.", at.Format(time.RFC1123))
+	}
+	return audience, at, nil
+}
+
+// parseTime accepts RFC 3339 or a UTC time without seconds.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, strings.ToUpper(s)); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02t15:04", s)
+}
+
+// filters describes the filters of an audience for the preview.
+func filters(a db.Audience) string {
+	var parts []string
+	if a.InGroup {
+		parts = append(parts, "seen in a group")
+	}
+	if a.Active {
+		parts = append(parts, "active only")
+	}
+	if a.Language != "" {
+		parts = append(parts, "language "+a.Language)
+	}
+	switch a.Beta {
+	case db.BetaApplied:
+		parts = append(parts, "beta applicants")
+	case db.BetaNone:
+		parts = append(parts, "not in the beta")
+	}
+	if len(parts) == 0 {
+		return "no filters"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describe summarizes a broadcast for /broadcast status and the final report.
+func describe(b *db.Broadcast) string {
+	text := fmt.Sprintf("Broadcast %s is %s.\nAudience: %s (%s)\nSent: %d, failed: %d, skipped: %d of %d",
+		b.ID, b.Status, b.Audience.Target, filters(b.Audience), b.Sent, b.Failed, b.Skipped, b.Total)
+	if b.Status == db.BroadcastScheduled && !b.ScheduledAt.IsZero() {
+		text += "\nScheduled for " + b.ScheduledAt.Format(time.RFC1123)
+	}
+	return text
+}
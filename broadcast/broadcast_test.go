@@ -0,0 +1,356 @@
+//broadcast/broadcast_test.go
+
+package broadcast
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strings"
+	"sync"
+	"testing"
+	db "tg/db"
+	router "tg/router"
+	sender "tg/sender"
+	"time"
+)
+
+const admin = 99
+
+// fakeAPI records the messages that are sent and the answers to button presses.
+type fakeAPI struct {
+	mu      sync.Mutex
+	sent    []tgbotapi.MessageConfig
+	answers []string
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := c.(tgbotapi.MessageConfig); ok {
+		f.sent = append(f.sent, m)
+	}
+	return tgbotapi.Message{MessageID: 100 + len(f.sent)}, nil
+}
+
+func (f *fakeAPI) AnswerCallbackQuery(c tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers = append(f.answers, c.Text)
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// to returns the messages sent to a chat.
+func (f *fakeAPI) to(chatID int64) []tgbotapi.MessageConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var messages []tgbotapi.MessageConfig
+	for _, m := range f.sent {
+		if m.ChatID == chatID {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+func newService(t *testing.T) (*Service, *fakeAPI, *db.MemoryStore) {
+	store := db.NewMemoryStore()
+	api := &fakeAPI{}
+	s := New(store, sender.New(api, store, nil, sender.Options{}))
+	r := router.New("testbot")
+	r.SetAdmins([]int64{admin})
+	s.Register(r)
+	return s, api, store
+}
+
+// command sends a /broadcast command from a user in their private chat.
+func command(t *testing.T, s *Service, userID int, text string) string {
+	update := &tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: userID},
+		Chat:      &tgbotapi.Chat{ID: int64(userID), Type: "private"},
+		Text:      text,
+	}}
+	response, _, err := s.commands.Dispatch(update)
+	if err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if m, ok := response.(tgbotapi.MessageConfig); ok {
+		return m.Text
+	}
+	return ""
+}
+
+// press presses a button under a preview.
+func press(t *testing.T, s *Service, userID int, data string) (string, bool) {
+	update := &tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "q",
+		From:    &tgbotapi.User{ID: userID},
+		Message: &tgbotapi.Message{MessageID: 5, Chat: &tgbotapi.Chat{ID: int64(userID), Type: "private"}},
+		Data:    data,
+	}}
+	response, ok, err := s.HandleCallback(update)
+	if err != nil {
+		t.Fatalf("HandleCallback: %v", err)
+	}
+	if m, ok := response.(tgbotapi.EditMessageTextConfig); ok {
+		return m.Text, true
+	}
+	return "", ok
+}
+
+// audience stores users and groups for the audience filters to pick from.
+func audience(t *testing.T, store *db.MemoryStore) {
+	users := []struct {
+		id       int
+		language string
+		inGroup  bool
+		bot      bool
+	}{
+		{1, "en", true, false},
+		{2, "en-GB", false, false},
+		{3, "de", true, false},
+		{4, "de", false, false},
+		{5, "en", true, true},
+	}
+	for _, u := range users {
+		if err := store.LogUserProfile(db.User{User: tgbotapi.User{ID: u.id, LanguageCode: u.language, IsBot: u.bot}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetUserInGroup(u.id, u.inGroup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveBeta(db.Beta{ID: "a1", UserID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []db.Group{{GroupID: -10, IsActive: true}, {GroupID: -20, IsActive: false}} {
+		if err := store.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDraft(t *testing.T) {
+	tests := []struct {
+		head string // First line of the command
+		want string // Part of the reply
+	}{
+		{"users", "to 4 users (no filters)"},
+		{"users ingroup", "to 2 users (seen in a group)"},
+		{"users lang=en", "to 2 users (language en)"},
+		{"users beta=applied", "to 1 users (beta applicants)"},
+		{"users beta=none lang=de", "to 1 users (language de, not in the beta)"},
+		{"groups", "to 2 groups"},
+		{"groups active", "to 1 groups (active only)"},
+		{"users in=2h", "Schedule"},
+		{"users active", `Option "active" does not apply to users.`},
+		{"everyone", `Unknown audience "everyone"`},
+		{"users at=2000-01-01T00:00", "is in the past"},
+		{"users in=soon", "Cannot read the delay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.head, func(t *testing.T) {
+			s, _, store := newService(t)
+			audience(t, store)
+
+			got := command(t, s, admin, "/broadcast "+tt.head+"\nHello")
+			if tt.want == "Schedule" {
+				broadcasts, _ := store.ListBroadcasts()
+				if len(broadcasts) != 1 || broadcasts[0].ScheduledAt.IsZero() {
+					t.Errorf("stored %+v, want one scheduled draft", broadcasts)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("reply = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestButtons(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID int
+		data   []string // Buttons pressed in turn
+		status string   // Of the broadcast afterwards
+		want   string   // Part of the last edit; empty when the preview is left alone
+	}{
+		{"send", admin, []string{"broadcast|confirm|b1"}, db.BroadcastScheduled, "is being sent"},
+		{"cancel", admin, []string{"broadcast|cancel|b1"}, db.BroadcastCancelled, "is cancelled"},
+		{"send after cancel", admin, []string{"broadcast|cancel|b1", "broadcast|confirm|b1"}, db.BroadcastCancelled, "can no longer be sent"},
+		{"send by a non-admin", 7, []string{"broadcast|confirm|b1"}, db.BroadcastDraft, ""},
+		{"cancel by a non-admin", 7, []string{"broadcast|cancel|b1"}, db.BroadcastDraft, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, api, store := newService(t)
+			if err := store.SaveBroadcast(db.Broadcast{ID: "b1", CreatedBy: admin, Text: "Hello", Status: db.BroadcastDraft}); err != nil {
+				t.Fatal(err)
+			}
+
+			var got string
+			for _, data := range tt.data {
+				text, ok := press(t, s, tt.userID, data)
+				if !ok {
+					t.Fatalf("%s was not handled", data)
+				}
+				got = text
+			}
+
+			b, _ := store.GetBroadcast("b1")
+			if b.Status != tt.status {
+				t.Errorf("status = %q, want %q", b.Status, tt.status)
+			}
+			if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("edit = %q, want %q", got, tt.want)
+			}
+			if len(api.answers) != len(tt.data) {
+				t.Errorf("%d presses answered, want %d", len(api.answers), len(tt.data))
+			}
+			if tt.userID != admin && !strings.Contains(api.answers[0], "Only bot administrators") {
+				t.Errorf("answer = %q, want the refusal", api.answers[0])
+			}
+		})
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	s, api, store := newService(t)
+	now := time.Now().UTC()
+	minute := now.Hour()*60 + now.Minute()
+
+	// 1 gets it, 2 muted broadcasts, 3 is in quiet hours, 4 blocked the bot, 5 muted only the news
+	prefs := []db.Preferences{
+		{ChatID: 2, Muted: []string{db.CategoryBroadcasts}},
+		{ChatID: 3, QuietStart: (minute + 1380) % 1440, QuietEnd: (minute + 60) % 1440},
+		{ChatID: 5, Muted: []string{db.CategoryNews}},
+	}
+	for _, p := range prefs {
+		if err := store.SavePreferences(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveChatStatus(db.ChatStatus{ChatID: 4, Reachable: false}); err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 5; id++ {
+		if err := store.LogUserProfile(db.User{User: tgbotapi.User{ID: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := db.Broadcast{ID: "b1", CreatedBy: admin, Text: "Hello", Audience: db.Audience{Target: db.AudienceUsers}, Status: db.BroadcastScheduled}
+	if err := store.SaveBroadcast(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.deliver(context.Background(), b); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+
+	for chatID, want := range map[int64]int{1: 1, 2: 0, 3: 1, 4: 0, 5: 1} {
+		if got := len(api.to(chatID)); got != want {
+			t.Errorf("chat %d got %d messages, want %d", chatID, got, want)
+		}
+	}
+	if quiet := api.to(3); len(quiet) == 1 && !quiet[0].DisableNotification {
+		t.Error("message in quiet hours was sent with a sound")
+	}
+	if loud := api.to(1); len(loud) == 1 && loud[0].DisableNotification {
+		t.Error("message outside quiet hours was sent without a sound")
+	}
+
+	done, _ := store.GetBroadcast("b1")
+	if done.Status != db.BroadcastDone || done.Total != 5 || done.Sent != 3 || done.Skipped != 2 || done.Failed != 0 {
+		t.Errorf("broadcast = %+v, want done with 3 sent and 2 skipped", done)
+	}
+	if report := api.to(admin); len(report) != 1 || !strings.Contains(report[0].Text, "Sent: 3, failed: 0, skipped: 2 of 5") {
+		t.Errorf("author got %+v, want the report", report)
+	}
+}
+
+func TestResume(t *testing.T) {
+	s, api, store := newService(t)
+
+	// A previous run sent to 1 and stopped while sending to 2; 3 is still pending
+	b := db.Broadcast{ID: "b1", CreatedBy: admin, Text: "Hello", Audience: db.Audience{Target: db.AudienceUsers}, Status: db.BroadcastSending, Total: 3}
+	if err := store.SaveBroadcast(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddDeliveries("b1", []int64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []db.Delivery{
+		{BroadcastID: "b1", ChatID: 1, Status: db.DeliverySent, MessageID: 7},
+		{BroadcastID: "b1", ChatID: 2, Status: db.DeliverySending},
+	} {
+		if err := store.SaveDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Start(context.Background())
+	defer s.Stop(context.Background())
+	finished := waitFor(t, store, "b1", db.BroadcastDone)
+
+	for chatID, want := range map[int64]int{1: 0, 2: 0, 3: 1} {
+		if got := len(api.to(chatID)); got != want {
+			t.Errorf("chat %d got %d messages, want %d", chatID, got, want)
+		}
+	}
+	if finished.Sent != 2 || finished.Skipped != 1 {
+		t.Errorf("broadcast = %+v, want 2 sent and the interrupted one skipped", finished)
+	}
+	stale, _ := store.ListDeliveries("b1", db.DeliverySkipped)
+	if len(stale) != 1 || stale[0].ChatID != 2 || !strings.Contains(stale[0].Error, "outcome unknown") {
+		t.Errorf("skipped = %+v, want the interrupted delivery", stale)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s, api, store := newService(t)
+	if err := store.LogUserProfile(db.User{User: tgbotapi.User{ID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []db.Broadcast{
+		{ID: "due", CreatedBy: admin, Text: "Now", Audience: db.Audience{Target: db.AudienceUsers}, Status: db.BroadcastScheduled, ScheduledAt: time.Now().Add(-time.Minute)},
+		{ID: "later", CreatedBy: admin, Text: "Later", Audience: db.Audience{Target: db.AudienceUsers}, Status: db.BroadcastScheduled, ScheduledAt: time.Now().Add(time.Hour)},
+		{ID: "draft", CreatedBy: admin, Text: "Draft", Audience: db.Audience{Target: db.AudienceUsers}, Status: db.BroadcastDraft},
+	} {
+		if err := store.SaveBroadcast(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Start(context.Background())
+	waitFor(t, store, "due", db.BroadcastDone)
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	if sent := api.to(1); len(sent) != 1 || sent[0].Text != "Now" {
+		t.Errorf("user got %+v, want only the due broadcast", sent)
+	}
+	for id, want := range map[string]string{"later": db.BroadcastScheduled, "draft": db.BroadcastDraft} {
+		if b, _ := store.GetBroadcast(id); b.Status != want {
+			t.Errorf("%s is %s, want %s", id, b.Status, want)
+		}
+	}
+}
+
+// waitFor waits until a broadcast has the status and returns it.
+func waitFor(t *testing.T, store *db.MemoryStore, id string, status string) *db.Broadcast {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		b, err := store.GetBroadcast(id)
+		if err == nil && b.Status == status {
+			return b
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broadcast %s is %+v, want %s", id, b, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
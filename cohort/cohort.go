@@ -0,0 +1,473 @@
+//cohort/cohort.go
+
+package cohort
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	config "tg/config"
+	db "tg/db"
+	errors "tg/errors"
+	random "tg/random"
+	router "tg/router"
+	sender "tg/sender"
+	start "tg/start"
+	"time"
+)
+
+// codeAlphabet leaves out the letters and digits that are easily confused.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// codeLength is the number of characters of an invite code.
+const codeLength = 8
+
+// maxName is the longest cohort name.
+const maxName = 32
+
+// usage lists every form of /cohort.
+const usage = "Usage: /cohort list, /cohort show <name>, /cohort create <name> <capacity>, /cohort capacity <name> <capacity>, /cohort invite <name> <application id|all> or /cohort revoke <code>"
+
+// Manager onboards approved beta applicants in cohorts.
+//
+// Staff create cohorts with a number of seats and invite approved applicants
+// to them. Each invite is a one-time code, sent to the applicant as a deep
+// link t.me/<bot>?start=<code>; opening it redeems the code for the user who
+// applied. Redeemed invites and those that can still be redeemed hold a
+// seat; revoked and expired ones free theirs.
+type Manager struct {
+	store      db.Store
+	out        *sender.Sender
+	botName    string
+	reviewChat int64         // Chat staff manage cohorts in
+	ttl        time.Duration // How long an invite can be redeemed
+	commands   *router.Router
+
+	mu sync.Mutex // Serializes seat allocation
+}
+
+// New creates the cohort manager of a bot. Invite links are issued for botName.
+func New(store db.Store, out *sender.Sender, botName string, cfg config.BetaConfig) *Manager {
+	return &Manager{
+		store:      store,
+		out:        out,
+		botName:    botName,
+		reviewChat: cfg.ReviewChatID,
+		ttl:        cfg.InviteTTL,
+	}
+}
+
+// Register adds the /cohort command of the review chat to the router and
+// takes the deep-link payloads no other feature claims as invite codes.
+func (m *Manager) Register(r *router.Router, s *start.Start) {
+	m.commands = r
+	r.MustRegister(router.Command{
+		Name:        "cohort",
+		Description: "Manage beta cohorts and invites",
+		Usage:       "list|show|create|capacity|invite|revoke ...",
+		MaxArgs:     3,
+		Hidden:      true,
+		Handler:     m.handleCommand,
+	})
+	s.HandleOther(m.redeem)
+}
+
+// handleCommand runs a cohort subcommand for staff.
+func (m *Manager) handleCommand(ctx *router.Context) (tgbotapi.Chattable, error) {
+	if ctx.Message.Chat.ID != m.reviewChat && !m.commands.IsAdmin(int64(ctx.Message.From.ID)) {
+		return ctx.Reply("/cohort only works in the review chat."), nil
+	}
+	if len(ctx.Args) == 0 {
+		return ctx.Reply(usage), nil
+	}
+
+	args := ctx.Args[1:]
+	switch action := strings.ToLower(ctx.Args[0]); {
+	case action == "list" && len(args) == 0:
+		return m.list(ctx)
+	case action == "show" && len(args) == 1:
+		return m.show(ctx, strings.ToLower(args[0]))
+	case (action == "create" || action == "capacity") && len(args) == 2:
+		capacity, err := strconv.Atoi(args[1])
+		if err != nil || capacity < 1 {
+			return ctx.Reply("The capacity must be a number of seats, 1 or more."), nil
+		}
+		if action == "create" {
+			return m.create(ctx, strings.ToLower(args[0]), capacity)
+		}
+		return m.resize(ctx, strings.ToLower(args[0]), capacity)
+	case action == "invite" && len(args) == 2:
+		return m.invite(ctx, strings.ToLower(args[0]), args[1])
+	case action == "revoke" && len(args) == 1:
+		return m.revoke(ctx, strings.ToUpper(args[0]))
+	}
+	return ctx.Reply(usage), nil
+}
+
+// create adds an empty cohort.
+func (m *Manager) create(ctx *router.Context, name string, capacity int) (tgbotapi.Chattable, error) {
+	if !validName(name) {
+		return ctx.Reply(fmt.Sprintf("Cohort names are 1-%d lowercase letters, digits, dashes or underscores, e.g. wave1.", maxName)), nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.store.GetCohort(name)
+	if err == nil {
+		return ctx.Reply(fmt.Sprintf("Cohort %s already exists.", name)), nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	now := time.Now()
+	cohort := db.Cohort{Name: name, Capacity: capacity, CreatedBy: int64(ctx.Message.From.ID), Created: now, Updated: now}
+	if err := m.store.SaveCohort(cohort); err != nil {
+		return nil, err
+	}
+	return ctx.Reply(fmt.Sprintf("Created cohort %s with %d seats. Invite approved applicants with /cohort invite %s <application id|all>.", name, capacity, name)), nil
+}
+
+// resize changes the number of seats of a cohort, never below the seats already held.
+func (m *Manager) resize(ctx *router.Context, name string, capacity int) (tgbotapi.Chattable, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cohort, invites, err := m.load(name)
+	if errors.IsNotFound(err) {
+		return ctx.Reply(fmt.Sprintf("There is no cohort %s.", name)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if held := held(invites, time.Now()); capacity < held {
+		return ctx.Reply(fmt.Sprintf("%d seats of %s are already taken; revoke invites first to go below that.", held, name)), nil
+	}
+
+	cohort.Capacity = capacity
+	cohort.Updated = time.Now()
+	if err := m.store.SaveCohort(*cohort); err != nil {
+		return nil, err
+	}
+	return ctx.Reply(fmt.Sprintf("Cohort %s now has %d seats.", name, capacity)), nil
+}
+
+// list shows every cohort with how its seats are used.
+func (m *Manager) list(ctx *router.Context) (tgbotapi.Chattable, error) {
+	cohorts, err := m.store.ListCohorts()
+	if err != nil {
+		return nil, err
+	}
+	if len(cohorts) == 0 {
+		return ctx.Reply("There are no cohorts yet. Create one with /cohort create <name> <capacity>."), nil
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	b.WriteString("Cohorts:")
+	for _, cohort := range cohorts {
+		invites, err := m.store.ListInvites(cohort.Name)
+		if err != nil {
+			return nil, err
+		}
+		joined, outstanding := 0, 0
+		for _, invite := range invites {
+			if invite.RedeemedBy != 0 {
+				joined++
+			} else if invite.Holds(now) {
+				outstanding++
+			}
+		}
+		fmt.Fprintf(&b, "\n%s: %d joined, %d invited, %d seats", cohort.Name, joined, outstanding, cohort.Capacity)
+	}
+	return ctx.Reply(b.String()), nil
+}
+
+// show lists the invites of a cohort and what became of them.
+func (m *Manager) show(ctx *router.Context, name string) (tgbotapi.Chattable, error) {
+	cohort, invites, err := m.load(name)
+	if errors.IsNotFound(err) {
+		return ctx.Reply(fmt.Sprintf("There is no cohort %s.", name)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Cohort %s: %d of %d seats taken", cohort.Name, held(invites, now), cohort.Capacity)
+	for _, invite := range invites {
+		fmt.Fprintf(&b, "\n%s for application %s (%d): ", invite.Code, invite.BetaID, invite.UserID)
+		switch {
+		case invite.RedeemedBy != 0:
+			fmt.Fprintf(&b, "redeemed %s", invite.Redeemed.Format("2 Jan 2006"))
+		case invite.Revoked:
+			b.WriteString("revoked")
+		case !now.Before(invite.Expires):
+			fmt.Fprintf(&b, "expired %s", invite.Expires.Format("2 Jan 2006"))
+		default:
+			fmt.Fprintf(&b, "valid until %s", invite.Expires.Format("2 Jan 2006"))
+		}
+	}
+	return ctx.Reply(b.String()), nil
+}
+
+// invite issues invites to a cohort for one approved application, or for
+// every approved applicant without a cohort or a valid invite, as long as seats last.
+func (m *Manager) invite(ctx *router.Context, name string, target string) (tgbotapi.Chattable, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cohort, invites, err := m.load(name)
+	if errors.IsNotFound(err) {
+		return ctx.Reply(fmt.Sprintf("There is no cohort %s.", name)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := m.store.ListInvites("")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	pending := make(map[string]db.Invite) // Valid invites by application
+	for _, invite := range all {
+		if invite.RedeemedBy == 0 && invite.Holds(now) {
+			pending[invite.BetaID] = invite
+		}
+	}
+
+	var candidates []db.Beta
+	if strings.EqualFold(target, "all") {
+		approved, err := m.store.ListBetas(db.BetaApproved)
+		if err != nil {
+			return nil, err
+		}
+		for _, betaInfo := range approved {
+			if _, ok := pending[betaInfo.ID]; !ok && betaInfo.Cohort == "" {
+				candidates = append(candidates, betaInfo)
+			}
+		}
+		if len(candidates) == 0 {
+			return ctx.Reply("Every approved applicant has joined a cohort or has a valid invite."), nil
+		}
+	} else {
+		betaInfo, err := m.store.GetBetaByID(target)
+		if errors.IsNotFound(err) {
+			return ctx.Reply(fmt.Sprintf("There is no application %s.", target)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch invite, ok := pending[betaInfo.ID]; {
+		case betaInfo.Status != db.BetaApproved:
+			return ctx.Reply(fmt.Sprintf("Application %s is %s; only approved applicants can be invited.", betaInfo.ID, betaInfo.Status)), nil
+		case betaInfo.Cohort != "":
+			return ctx.Reply(fmt.Sprintf("The applicant of %s already joined cohort %s.", betaInfo.ID, betaInfo.Cohort)), nil
+		case ok:
+			return ctx.Reply(fmt.Sprintf("The applicant of %s already has invite %s to %s, valid until %s.", betaInfo.ID, invite.Code, invite.Cohort, invite.Expires.Format("2 Jan 2006"))), nil
+		}
+		candidates = []db.Beta{*betaInfo}
+	}
+
+	free := cohort.Capacity - held(invites, now)
+	var b strings.Builder
+	issued := 0
+	for _, betaInfo := range candidates {
+		if issued >= free {
+			break
+		}
+		code, err := random.Code(codeAlphabet, codeLength)
+		if err != nil {
+			return nil, err
+		}
+		invite := db.Invite{
+			Code:      code,
+			Cohort:    cohort.Name,
+			BetaID:    betaInfo.ID,
+			UserID:    betaInfo.UserID,
+			CreatedBy: int64(ctx.Message.From.ID),
+			Created:   now,
+			Expires:   now.Add(m.ttl),
+		}
+		if err := m.store.SaveInvite(invite); err != nil {
+			return nil, err
+		}
+		issued++
+
+		fmt.Fprintf(&b, "\n%s for %s (%s)", invite.Code, betaInfo.Name, betaInfo.ID)
+		if err := m.send(invite); err != nil {
+			log.Printf("Failed to send invite %s to %d: %v", invite.Code, invite.UserID, err)
+			fmt.Fprintf(&b, ": could not be messaged, share the code with them")
+		}
+	}
+
+	summary := fmt.Sprintf("Invited %s to %s; %d of %d seats are taken.", applicants(issued), cohort.Name, cohort.Capacity-free+issued, cohort.Capacity)
+	if left := len(candidates) - issued; left > 0 {
+		summary += fmt.Sprintf(" %s did not get a seat.", applicants(left))
+	}
+	return ctx.Reply(summary + "\n" + b.String()), nil
+}
+
+// send messages an invite to its applicant.
+func (m *Manager) send(invite db.Invite) error {
+	link, err := start.Link(m.botName, "", invite.Code)
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("You're invited to the beta! Open %s to join, or send /start %s to me. The invite is for you only and can be used once until %s.",
+		link, invite.Code, invite.Expires.Format("2 Jan 2006"))
+	msg := tgbotapi.NewMessage(invite.UserID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL("Join the beta", link),
+	))
+	_, err = m.out.Send(msg)
+	return err
+}
+
+// revoke withdraws an invite that was not redeemed, freeing its seat.
+func (m *Manager) revoke(ctx *router.Context, code string) (tgbotapi.Chattable, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invite, err := m.store.GetInvite(code)
+	if errors.IsNotFound(err) {
+		return ctx.Reply(fmt.Sprintf("There is no invite %s.", code)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invite.RedeemedBy != 0 {
+		return ctx.Reply(fmt.Sprintf("Invite %s was already redeemed.", code)), nil
+	}
+
+	invite.Revoked = true
+	if err := m.store.SaveInvite(*invite); err != nil {
+		return nil, err
+	}
+	return ctx.Reply(fmt.Sprintf("Revoked invite %s; its seat in %s is free again.", code, invite.Cohort)), nil
+}
+
+// redeem binds an invite code opened through a deep link, or sent with
+// /start, to the user. Payloads that are not shaped like codes are left to /start.
+func (m *Manager) redeem(ctx *router.Context, payload string) (tgbotapi.Chattable, error) {
+	code := strings.ToUpper(payload)
+	if !validCode(code) {
+		return nil, nil
+	}
+	userID := int64(ctx.Message.From.ID)
+	now := time.Now()
+
+	invite, err := m.store.GetInvite(code)
+	if errors.IsNotFound(err) {
+		return ctx.Reply("That invite code is not valid. Check that you copied all of it."), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case invite.RedeemedBy == userID:
+		return ctx.Reply(fmt.Sprintf("You've already joined the beta with this invite, in cohort %s.", invite.Cohort)), nil
+	case invite.RedeemedBy != 0:
+		return ctx.Reply("This invite has already been used."), nil
+	case invite.Revoked:
+		return ctx.Reply("This invite was withdrawn. Send /support if you think that's a mistake."), nil
+	case !now.Before(invite.Expires):
+		return ctx.Reply(fmt.Sprintf("This invite expired on %s. Send /support to ask for a new one.", invite.Expires.Format("2 Jan 2006"))), nil
+	case invite.UserID != 0 && invite.UserID != userID:
+		return ctx.Reply("This invite was issued to another account. Open it from the Telegram account you applied with."), nil
+	}
+
+	invite, err = m.store.RedeemInvite(code, userID, now)
+	if errors.IsNotFound(err) {
+		return ctx.Reply("This invite has already been used."), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.join(invite)
+
+	return ctx.Reply(fmt.Sprintf("Welcome to the beta! You've joined cohort %s. Send /getstarted for a quick tour.", invite.Cohort)), nil
+}
+
+// join records the cohort on the redeemed application and tells staff.
+// Both are best effort: the invite is already bound to the user.
+func (m *Manager) join(invite *db.Invite) {
+	betaInfo, err := m.store.GetBetaByID(invite.BetaID)
+	if err == nil {
+		betaInfo.Cohort = invite.Cohort
+		betaInfo.Joined = invite.Redeemed
+		err = m.store.UpdateBeta(*betaInfo)
+	}
+	if err != nil {
+		log.Printf("Failed to record cohort %s on application %s: %v", invite.Cohort, invite.BetaID, errors.HandleError(err))
+	}
+
+	if m.reviewChat == 0 {
+		return
+	}
+	text := fmt.Sprintf("Invite %s was redeemed: the applicant of %s joined cohort %s.", invite.Code, invite.BetaID, invite.Cohort)
+	if _, err := m.out.Send(tgbotapi.NewMessage(m.reviewChat, text)); err != nil {
+		log.Printf("Failed to tell staff about invite %s: %v", invite.Code, err)
+	}
+}
+
+// load returns a cohort and its invites.
+func (m *Manager) load(name string) (*db.Cohort, []db.Invite, error) {
+	cohort, err := m.store.GetCohort(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	invites, err := m.store.ListInvites(name)
+	return cohort, invites, err
+}
+
+// held counts the seats the invites take up.
+func held(invites []db.Invite, now time.Time) int {
+	n := 0
+	for _, invite := range invites {
+		if invite.Holds(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// applicants counts applicants in words, e.g. "1 applicant".
+func applicants(n int) string {
+	if n == 1 {
+		return "1 applicant"
+	}
+	return fmt.Sprintf("%d applicants", n)
+}
+
+// validCode reports whether s is shaped like an invite code.
+func validCode(s string) bool {
+	if len(s) != codeLength {
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune(codeAlphabet, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validName reports whether a cohort name is usable in commands.
+func validName(name string) bool {
+	if name == "" || len(name) > maxName {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
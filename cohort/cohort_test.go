@@ -0,0 +1,245 @@
+//cohort/cohort_test.go
+
+package cohort
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strings"
+	"sync"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	router "tg/router"
+	sender "tg/sender"
+	start "tg/start"
+	"time"
+)
+
+const (
+	reviewChat = int64(-500)
+	staffID    = 5
+)
+
+// fakeAPI records the chats messages are sent to.
+type fakeAPI struct {
+	mu    sync.Mutex
+	chats []int64
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := c.(tgbotapi.MessageConfig); ok {
+		f.chats = append(f.chats, m.ChatID)
+	}
+	return tgbotapi.Message{MessageID: len(f.chats)}, nil
+}
+
+func (f *fakeAPI) sentTo(chatID int64) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, id := range f.chats {
+		if id == chatID {
+			n++
+		}
+	}
+	return n
+}
+
+// newManager creates a manager with approved applications a1, a2, ... of users 11, 12, ...
+func newManager(t *testing.T, approved int) (*router.Router, *fakeAPI, *db.MemoryStore) {
+	store := db.NewMemoryStore()
+	for i := 1; i <= approved; i++ {
+		betaInfo := db.Beta{ID: fmt.Sprintf("a%d", i), UserID: int64(10 + i), Name: "Ann", Status: db.BetaApproved, Created: time.Now()}
+		if err := store.SaveBeta(betaInfo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &fakeAPI{}
+	m := New(store, sender.New(api, store, nil, sender.Options{}), "testbot", config.BetaConfig{ReviewChatID: reviewChat, InviteTTL: 24 * time.Hour})
+	r := router.New("testbot")
+	s := start.New()
+	s.Register(r)
+	m.Register(r, s)
+	return r, api, store
+}
+
+// run sends text as the user in a chat and returns the reply.
+func run(t *testing.T, r *router.Router, chatID int64, userID int, text string) string {
+	t.Helper()
+	chatType := "private"
+	if chatID < 0 {
+		chatType = "group"
+	}
+	response, ok, err := r.Dispatch(&tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: userID},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType},
+		Text:      text,
+		Entities:  &[]tgbotapi.MessageEntity{{Type: "bot_command", Length: len(strings.Fields(text)[0])}},
+	}})
+	if !ok || err != nil {
+		t.Fatalf("Dispatch(%q) = %v, %v", text, ok, err)
+	}
+	reply, _ := response.(tgbotapi.MessageConfig)
+	return reply.Text
+}
+
+// staff runs a /cohort command in the review chat.
+func staff(t *testing.T, r *router.Router, args string) string {
+	t.Helper()
+	return run(t, r, reviewChat, staffID, "/cohort "+args)
+}
+
+// codes returns the invite codes of a cohort by application.
+func codes(t *testing.T, store *db.MemoryStore, cohort string) map[string]string {
+	t.Helper()
+	invites, err := store.ListInvites(cohort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byBeta := make(map[string]string)
+	for _, invite := range invites {
+		byBeta[invite.BetaID] = invite.Code
+	}
+	return byBeta
+}
+
+func TestCapacity(t *testing.T) {
+	r, api, store := newManager(t, 3)
+	staff(t, r, "create wave1 2")
+
+	reply := staff(t, r, "invite wave1 all")
+	if !strings.Contains(reply, "Invited 2 applicants") || !strings.Contains(reply, "1 applicant did not get a seat") {
+		t.Errorf("invite all = %q, want 2 invited and 1 left out", reply)
+	}
+	invited := codes(t, store, "wave1")
+	if len(invited) != 2 || api.sentTo(11) != 1 || api.sentTo(12) != 1 {
+		t.Fatalf("invites = %v, want a1 and a2 invited and messaged", invited)
+	}
+
+	if reply := staff(t, r, "invite wave1 a3"); !strings.Contains(reply, "Invited 0 applicants") {
+		t.Errorf("invite into a full cohort = %q, want no seat", reply)
+	}
+	if reply := staff(t, r, "capacity wave1 1"); !strings.Contains(reply, "2 seats of wave1 are already taken") {
+		t.Errorf("shrinking below the taken seats = %q, want it refused", reply)
+	}
+
+	// Revoking frees the seat for the applicant left out
+	if reply := staff(t, r, "revoke "+invited["a2"]); !strings.Contains(reply, "is free again") {
+		t.Errorf("revoke = %q", reply)
+	}
+	if reply := staff(t, r, "invite wave1 a3"); !strings.Contains(reply, "Invited 1 applicant to wave1; 2 of 2 seats") {
+		t.Errorf("invite after revoking = %q, want the freed seat", reply)
+	}
+	if reply := staff(t, r, "invite wave1 a1"); !strings.Contains(reply, "already has invite") {
+		t.Errorf("inviting twice = %q, want the pending invite", reply)
+	}
+}
+
+func TestExpiredInviteFreesSeat(t *testing.T) {
+	r, _, store := newManager(t, 2)
+	staff(t, r, "create wave1 1")
+
+	expired := db.Invite{Code: "EXPRDCDE", Cohort: "wave1", BetaID: "a1", UserID: 11, Expires: time.Now().Add(-time.Minute)}
+	if err := store.SaveInvite(expired); err != nil {
+		t.Fatal(err)
+	}
+	if reply := run(t, r, 11, 11, "/start "+expired.Code); !strings.Contains(reply, "expired") {
+		t.Errorf("redeeming an expired invite = %q", reply)
+	}
+	if reply := staff(t, r, "invite wave1 a2"); !strings.Contains(reply, "Invited 1 applicant") {
+		t.Errorf("invite = %q, want the seat of the expired invite", reply)
+	}
+	if reply := staff(t, r, "invite wave1 a1"); !strings.Contains(reply, "Invited 0 applicants") {
+		t.Errorf("reinviting a1 = %q, want the cohort full", reply)
+	}
+}
+
+func TestRedeem(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(invite *db.Invite)
+		userID int
+		reply  string
+		joined bool
+	}{
+		{"invitee", nil, 11, "You've joined cohort wave1", true},
+		{"lowercase", nil, 11, "You've joined cohort wave1", true},
+		{"other account", nil, 99, "issued to another account", false},
+		{"expired", func(i *db.Invite) { i.Expires = time.Now().Add(-time.Second) }, 11, "expired", false},
+		{"revoked", func(i *db.Invite) { i.Revoked = true }, 11, "withdrawn", false},
+		{"used", func(i *db.Invite) { i.RedeemedBy, i.Redeemed = 42, time.Now() }, 11, "already been used", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, api, store := newManager(t, 1)
+			staff(t, r, "create wave1 1")
+			staff(t, r, "invite wave1 a1")
+			code := codes(t, store, "wave1")["a1"]
+			if tt.setup != nil {
+				invite, err := store.GetInvite(code)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.setup(invite)
+				if err := store.SaveInvite(*invite); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.name == "lowercase" {
+				code = strings.ToLower(code)
+			}
+
+			if reply := run(t, r, int64(tt.userID), tt.userID, "/start "+code); !strings.Contains(reply, tt.reply) {
+				t.Errorf("reply = %q, want it to contain %q", reply, tt.reply)
+			}
+			betaInfo, err := store.GetBetaByID("a1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (betaInfo.Cohort == "wave1") != tt.joined {
+				t.Errorf("cohort = %q, want joined %v", betaInfo.Cohort, tt.joined)
+			}
+			if told := api.sentTo(reviewChat) == 1; told != tt.joined {
+				t.Errorf("staff told %v, want %v", told, tt.joined)
+			}
+		})
+	}
+}
+
+func TestRedeemOnce(t *testing.T) {
+	r, _, store := newManager(t, 1)
+	staff(t, r, "create wave1 1")
+	staff(t, r, "invite wave1 a1")
+	code := codes(t, store, "wave1")["a1"]
+
+	if reply := run(t, r, 11, 11, "/start "+code); !strings.Contains(reply, "Welcome to the beta") {
+		t.Fatalf("first redemption = %q", reply)
+	}
+	if reply := run(t, r, 11, 11, "/start "+code); !strings.Contains(reply, "already joined the beta with this invite") {
+		t.Errorf("second redemption = %q", reply)
+	}
+
+	// The store refuses a second redemption even when the checks before it raced
+	if _, err := store.RedeemInvite(code, 12, time.Now()); err == nil {
+		t.Error("RedeemInvite redeemed a used code again")
+	}
+	invite, err := store.GetInvite(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invite.RedeemedBy != 11 {
+		t.Errorf("redeemed by %d, want 11", invite.RedeemedBy)
+	}
+
+	// A redeemed invite keeps its seat, and cannot be revoked
+	if reply := staff(t, r, "revoke "+code); !strings.Contains(reply, "already redeemed") {
+		t.Errorf("revoke = %q", reply)
+	}
+	if reply := run(t, r, 11, 11, "/start ABCDEFGH"); !strings.Contains(reply, "not valid") {
+		t.Errorf("unknown code = %q", reply)
+	}
+}
@@ -0,0 +1,408 @@
+//config/config.go
+
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config holds every setting the bot needs at startup.
+//
+// Each field can be set from a YAML or TOML file, an environment variable
+// and a command-line flag. Later sources override earlier ones:
+// defaults < file < environment < flags.
+type Config struct {
+	Telegram TelegramConfig `yaml:"telegram" toml:"telegram"`
+	Mongo    MongoConfig    `yaml:"mongo" toml:"mongo"`
+	Workers  WorkerConfig   `yaml:"workers" toml:"workers"`
+	Webhook  WebhookConfig  `yaml:"webhook" toml:"webhook"`
+	Limits   LimitConfig    `yaml:"rate_limit" toml:"rate_limit"`
+	Welcome  WelcomeConfig  `yaml:"welcome" toml:"welcome"`
+	Beta     BetaConfig     `yaml:"beta" toml:"beta"`
+	Support  SupportConfig  `yaml:"support" toml:"support"`
+	News     NewsConfig     `yaml:"news" toml:"news"`
+	Tutorial TutorialConfig `yaml:"tutorial" toml:"tutorial"`
+	Demo     DemoConfig     `yaml:"demo" toml:"demo"`
+	Social   SocialConfig   `yaml:"social" toml:"social"`
+	Mail     MailConfig     `yaml:"mail" toml:"mail"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"TG_SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"How long to wait for in-flight updates on shutdown"`
+}
+
+// Update delivery modes.
+const (
+	ModePolling = "polling" // Fetch updates with getUpdates long polling
+	ModeWebhook = "webhook" // Receive updates pushed by Telegram over HTTP
+)
+
+// TelegramConfig holds the settings used to talk to the Telegram Bot API.
+type TelegramConfig struct {
+	Token         string  `yaml:"token" toml:"token" env:"TG_BOT_TOKEN" flag:"token" secret:"true" usage:"Telegram bot token"`
+	Debug         bool    `yaml:"debug" toml:"debug" env:"TG_DEBUG" flag:"debug" usage:"Log every Telegram API request and response"`
+	UpdateTimeout int     `yaml:"update_timeout" toml:"update_timeout" env:"TG_UPDATE_TIMEOUT" flag:"update-timeout" usage:"Long polling timeout in seconds"`
+	Mode          string  `yaml:"mode" toml:"mode" env:"TG_MODE" flag:"mode" usage:"How updates are received: polling or webhook"`
+	Admins        []int64 `yaml:"admins" toml:"admins" env:"TG_ADMINS" flag:"admins" usage:"Comma-separated user IDs allowed to run admin commands"`
+}
+
+// MongoConfig holds the MongoDB connection settings.
+type MongoConfig struct {
+	URI            string        `yaml:"uri" toml:"uri" env:"TG_MONGO_URI" flag:"mongo-uri" secret:"uri" usage:"MongoDB connection string"`
+	Database       string        `yaml:"database" toml:"database" env:"TG_MONGO_DB" flag:"mongo-db" usage:"MongoDB database name"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout" toml:"connect_timeout" env:"TG_MONGO_CONNECT_TIMEOUT" flag:"mongo-connect-timeout" usage:"Timeout for connecting to MongoDB"`
+	OpTimeout      time.Duration `yaml:"op_timeout" toml:"op_timeout" env:"TG_MONGO_OP_TIMEOUT" flag:"mongo-op-timeout" usage:"Timeout for each database read or write"`
+}
+
+// WorkerConfig holds the settings of the update worker pool.
+type WorkerConfig struct {
+	Count         int           `yaml:"count" toml:"count" env:"TG_WORKERS" flag:"workers" usage:"Number of workers handling updates in parallel"`
+	QueueSize     int           `yaml:"queue_size" toml:"queue_size" env:"TG_WORKER_QUEUE_SIZE" flag:"worker-queue-size" usage:"Updates each worker can queue before fetching blocks"`
+	StatsInterval time.Duration `yaml:"stats_interval" toml:"stats_interval" env:"TG_WORKER_STATS_INTERVAL" flag:"worker-stats-interval" usage:"How often to log worker pool metrics, 0 to disable"`
+}
+
+// LimitConfig holds the outbound message rates, kept under Telegram's limits.
+type LimitConfig struct {
+	Global         float64       `yaml:"global_per_second" toml:"global_per_second" env:"TG_RATE_GLOBAL" flag:"rate-global" usage:"Messages per second across all chats"`
+	PerChat        float64       `yaml:"chat_per_second" toml:"chat_per_second" env:"TG_RATE_CHAT" flag:"rate-chat" usage:"Messages per second to a single chat"`
+	PerGroupMinute float64       `yaml:"group_per_minute" toml:"group_per_minute" env:"TG_RATE_GROUP" flag:"rate-group" usage:"Messages per minute to a single group"`
+	ReplyTimeout   time.Duration `yaml:"reply_timeout" toml:"reply_timeout" env:"TG_RATE_REPLY_TIMEOUT" flag:"rate-reply-timeout" usage:"Longest a reply waits for the rate limits before it is dropped"`
+}
+
+// WelcomeConfig holds the settings shared by every group's welcome message.
+type WelcomeConfig struct {
+	Template    string        `yaml:"template" toml:"template" env:"TG_WELCOME_TEMPLATE" flag:"welcome-template" usage:"Welcome message used until a group sets its own"`
+	BatchWindow time.Duration `yaml:"batch_window" toml:"batch_window" env:"TG_WELCOME_BATCH_WINDOW" flag:"welcome-batch-window" usage:"Joins within this window after a welcome are greeted together"`
+}
+
+// BetaConfig holds the settings of the beta application reviews.
+type BetaConfig struct {
+	ReviewChatID int64         `yaml:"review_chat_id" toml:"review_chat_id" env:"TG_BETA_REVIEW_CHAT" flag:"beta-review-chat" usage:"Group where staff approve, reject or waitlist beta applications, 0 to keep them unreviewed"`
+	InviteTTL    time.Duration `yaml:"invite_ttl" toml:"invite_ttl" env:"TG_BETA_INVITE_TTL" flag:"beta-invite-ttl" usage:"How long a cohort invite code can be redeemed"`
+	Disposable   string        `yaml:"disposable_domains" toml:"disposable_domains" env:"TG_BETA_DISPOSABLE_DOMAINS" flag:"beta-disposable-domains" usage:"File of disposable email domains refused on top of the built-in ones, one per line"`
+	CodeTTL      time.Duration `yaml:"code_ttl" toml:"code_ttl" env:"TG_BETA_CODE_TTL" flag:"beta-code-ttl" usage:"How long an email verification code is accepted"`
+	CodeResend   time.Duration `yaml:"code_resend" toml:"code_resend" env:"TG_BETA_CODE_RESEND" flag:"beta-code-resend" usage:"How long an applicant waits before another verification code is sent"`
+	MaxCodes     int           `yaml:"max_codes" toml:"max_codes" env:"TG_BETA_MAX_CODES" flag:"beta-max-codes" usage:"Verification codes sent per application"`
+	MaxAttempts  int           `yaml:"max_code_attempts" toml:"max_code_attempts" env:"TG_BETA_MAX_CODE_ATTEMPTS" flag:"beta-max-code-attempts" usage:"Wrong guesses allowed before a verification code is discarded"`
+}
+
+// SupportConfig holds the settings of the support ticket system.
+type SupportConfig struct {
+	StaffChatID int64 `yaml:"staff_chat_id" toml:"staff_chat_id" env:"TG_SUPPORT_CHAT" flag:"support-chat" usage:"Group where the support team receives and answers tickets, 0 to disable /submit"`
+}
+
+// NewsConfig holds the sources of /news and how often they are read.
+type NewsConfig struct {
+	Feeds    []string      `yaml:"feeds" toml:"feeds" env:"TG_NEWS_FEEDS" flag:"news-feeds" usage:"Comma-separated RSS or Atom feed URLs"`
+	Dir      string        `yaml:"dir" toml:"dir" env:"TG_NEWS_DIR" flag:"news-dir" usage:"Directory of markdown files published as news"`
+	Interval time.Duration `yaml:"interval" toml:"interval" env:"TG_NEWS_INTERVAL" flag:"news-interval" usage:"How often the news sources are read"`
+	Push     bool          `yaml:"push" toml:"push" env:"TG_NEWS_PUSH" flag:"news-push" usage:"Send new items to subscribed chats"`
+}
+
+// TutorialConfig holds where the onboarding tutorials are loaded from.
+type TutorialConfig struct {
+	Dir string `yaml:"dir" toml:"dir" env:"TG_TUTORIAL_DIR" flag:"tutorial-dir" usage:"Directory of the tutorial content files, one YAML file per tutorial"`
+}
+
+// Demo providers.
+const (
+	ProviderStub   = "stub"   // Local and deterministic, no key needed
+	ProviderOpenAI = "openai" // OpenAI or a compatible API
+	ProviderAzure  = "azure"  // Azure OpenAI
+)
+
+// DemoConfig holds the model behind /demo and how much of it users get.
+type DemoConfig struct {
+	Provider     string `yaml:"provider" toml:"provider" env:"TG_DEMO_PROVIDER" flag:"demo-provider" usage:"Model provider of /demo: stub, openai or azure"`
+	APIKey       string `yaml:"api_key" toml:"api_key" env:"TG_DEMO_API_KEY" flag:"demo-api-key" secret:"true" usage:"API key of the demo provider"`
+	Model        string `yaml:"model" toml:"model" env:"TG_DEMO_MODEL" flag:"demo-model" usage:"Model used with the openai provider"`
+	Endpoint     string `yaml:"endpoint" toml:"endpoint" env:"TG_DEMO_ENDPOINT" flag:"demo-endpoint" usage:"Azure resource endpoint, or a base URL replacing OpenAI's"`
+	Deployment   string `yaml:"deployment" toml:"deployment" env:"TG_DEMO_DEPLOYMENT" flag:"demo-deployment" usage:"Azure model deployment name"`
+	APIVersion   string `yaml:"api_version" toml:"api_version" env:"TG_DEMO_API_VERSION" flag:"demo-api-version" usage:"Azure OpenAI API version"`
+	DailyQuota   int    `yaml:"daily_quota" toml:"daily_quota" env:"TG_DEMO_QUOTA" flag:"demo-quota" usage:"Demo messages each user may send per day (UTC)"`
+	ContextTurns int    `yaml:"context_turns" toml:"context_turns" env:"TG_DEMO_CONTEXT" flag:"demo-context" usage:"Earlier messages of a chat sent with each demo prompt"`
+}
+
+// SocialConfig holds how clicks on the /social links are counted. Without a
+// redirect URL the buttons open the bot, which counts the click and replies
+// with the link.
+type SocialConfig struct {
+	RedirectURL string `yaml:"redirect_url" toml:"redirect_url" env:"TG_SOCIAL_REDIRECT_URL" flag:"social-redirect-url" usage:"Public base URL of the click-counting redirects, e.g. https://bot.example.com/go"`
+	Listen      string `yaml:"listen" toml:"listen" env:"TG_SOCIAL_LISTEN" flag:"social-listen" usage:"Address the redirect server listens on"`
+}
+
+// Mailers.
+const (
+	MailerFile = "file" // Append to a local file, or log when no file is set
+	MailerSMTP = "smtp" // Deliver through an SMTP server
+)
+
+// MailConfig holds how the bot sends email, such as the verification codes of beta applicants.
+type MailConfig struct {
+	Mailer   string `yaml:"mailer" toml:"mailer" env:"TG_MAIL_MAILER" flag:"mail-mailer" usage:"How email is sent: file or smtp"`
+	File     string `yaml:"file" toml:"file" env:"TG_MAIL_FILE" flag:"mail-file" usage:"File the file mailer appends to; empty logs every email instead"`
+	Host     string `yaml:"host" toml:"host" env:"TG_MAIL_HOST" flag:"mail-host" usage:"SMTP server host"`
+	Port     int    `yaml:"port" toml:"port" env:"TG_MAIL_PORT" flag:"mail-port" usage:"SMTP server port; 465 uses implicit TLS, others STARTTLS when offered"`
+	Username string `yaml:"username" toml:"username" env:"TG_MAIL_USERNAME" flag:"mail-username" usage:"SMTP user name, empty to send without authentication"`
+	Password string `yaml:"password" toml:"password" env:"TG_MAIL_PASSWORD" flag:"mail-password" secret:"true" usage:"SMTP password"`
+	From     string `yaml:"from" toml:"from" env:"TG_MAIL_FROM" flag:"mail-from" usage:"Sender address, e.g. Beta Bot <beta@example.com>"`
+}
+
+// WebhookConfig holds the settings of the webhook server used in webhook mode.
+type WebhookConfig struct {
+	Listen         string `yaml:"listen" toml:"listen" env:"TG_WEBHOOK_LISTEN" flag:"webhook-listen" usage:"Address the webhook server listens on"`
+	Path           string `yaml:"path" toml:"path" env:"TG_WEBHOOK_PATH" flag:"webhook-path" usage:"HTTP path Telegram posts updates to"`
+	PublicURL      string `yaml:"public_url" toml:"public_url" env:"TG_WEBHOOK_URL" flag:"webhook-url" usage:"Public HTTPS URL registered with Telegram"`
+	Secret         string `yaml:"secret" toml:"secret" env:"TG_WEBHOOK_SECRET" flag:"webhook-secret" secret:"true" usage:"Secret token Telegram must send with every update"`
+	CertFile       string `yaml:"cert_file" toml:"cert_file" env:"TG_WEBHOOK_CERT" flag:"webhook-cert" usage:"TLS certificate; leave empty when behind a reverse proxy"`
+	KeyFile        string `yaml:"key_file" toml:"key_file" env:"TG_WEBHOOK_KEY" flag:"webhook-key" usage:"TLS private key"`
+	UploadCert     bool   `yaml:"upload_cert" toml:"upload_cert" env:"TG_WEBHOOK_UPLOAD_CERT" flag:"webhook-upload-cert" usage:"Upload the certificate to Telegram (self-signed certificates)"`
+	MaxConnections int    `yaml:"max_connections" toml:"max_connections" env:"TG_WEBHOOK_MAX_CONNECTIONS" flag:"webhook-max-connections" usage:"Maximum simultaneous connections Telegram opens, 0 for its default"`
+	Register       bool   `yaml:"register" toml:"register" env:"TG_WEBHOOK_REGISTER" flag:"webhook-register" usage:"Call setWebhook at startup; disable to test with local POSTs"`
+}
+
+// Default returns a Config populated with the built-in defaults.
+func Default() Config {
+	return Config{
+		Telegram: TelegramConfig{
+			UpdateTimeout: 60,
+			Mode:          ModePolling,
+		},
+		Mongo: MongoConfig{
+			URI:            "mongodb://127.0.0.1:27017",
+			Database:       "cntrlTGtest",
+			ConnectTimeout: 10 * time.Second,
+			OpTimeout:      5 * time.Second,
+		},
+		Workers: WorkerConfig{
+			Count:         8,
+			QueueSize:     100,
+			StatsInterval: time.Minute,
+		},
+		Webhook: WebhookConfig{
+			Listen:   ":8443",
+			Path:     "/telegram/webhook",
+			Register: true,
+		},
+		Welcome: WelcomeConfig{
+			Template:    "Welcome to {group}, {first_name}!",
+			BatchWindow: 30 * time.Second,
+		},
+		Beta: BetaConfig{
+			InviteTTL:   14 * 24 * time.Hour,
+			CodeTTL:     15 * time.Minute,
+			CodeResend:  time.Minute,
+			MaxCodes:    5,
+			MaxAttempts: 5,
+		},
+		Demo: DemoConfig{
+			Provider:     ProviderStub,
+			Model:        "gpt-3.5-turbo",
+			APIVersion:   "2024-02-01",
+			DailyQuota:   20,
+			ContextTurns: 10,
+		},
+		Tutorial: TutorialConfig{
+			Dir: "content/tutorials",
+		},
+		News: NewsConfig{
+			Interval: 15 * time.Minute,
+		},
+		Mail: MailConfig{
+			Mailer: MailerFile,
+			Port:   587,
+			From:   "beta@localhost",
+		},
+		Limits: LimitConfig{
+			Global:         30,
+			PerChat:        1,
+			PerGroupMinute: 20,
+			ReplyTimeout:   10 * time.Second,
+		},
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// Validate checks that the configuration is complete and consistent.
+func (c Config) Validate() error {
+	var problems []string
+
+	if c.Telegram.Token == "" {
+		problems = append(problems, "telegram token is required (set TG_BOT_TOKEN or -token)")
+	} else if !strings.Contains(c.Telegram.Token, ":") {
+		problems = append(problems, "telegram token is malformed")
+	}
+	if c.Telegram.UpdateTimeout < 0 {
+		problems = append(problems, "telegram update timeout must not be negative")
+	}
+
+	switch c.Telegram.Mode {
+	case ModePolling:
+	case ModeWebhook:
+		problems = append(problems, c.Webhook.validate()...)
+	default:
+		problems = append(problems, fmt.Sprintf("telegram mode must be %q or %q", ModePolling, ModeWebhook))
+	}
+
+	if c.Mongo.URI == "" {
+		problems = append(problems, "mongo uri is required")
+	} else if u, err := url.Parse(c.Mongo.URI); err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") {
+		problems = append(problems, "mongo uri must start with mongodb:// or mongodb+srv://")
+	}
+	if c.Mongo.Database == "" {
+		problems = append(problems, "mongo database is required")
+	}
+	if c.Mongo.ConnectTimeout <= 0 {
+		problems = append(problems, "mongo connect timeout must be positive")
+	}
+	if c.Mongo.OpTimeout <= 0 {
+		problems = append(problems, "mongo op timeout must be positive")
+	}
+
+	if c.Workers.Count < 1 {
+		problems = append(problems, "worker count must be at least 1")
+	}
+	if c.Workers.QueueSize < 0 {
+		problems = append(problems, "worker queue size must not be negative")
+	}
+	if c.Workers.StatsInterval < 0 {
+		problems = append(problems, "worker stats interval must not be negative")
+	}
+
+	if c.Limits.Global <= 0 || c.Limits.PerChat <= 0 || c.Limits.PerGroupMinute <= 0 {
+		problems = append(problems, "rate limits must be positive")
+	}
+	if c.Limits.ReplyTimeout <= 0 {
+		problems = append(problems, "reply timeout must be positive")
+	}
+
+	if c.Welcome.Template == "" {
+		problems = append(problems, "welcome template is required")
+	}
+	if c.Welcome.BatchWindow < 0 {
+		problems = append(problems, "welcome batch window must not be negative")
+	}
+	if c.Beta.InviteTTL <= 0 {
+		problems = append(problems, "beta invite ttl must be positive")
+	}
+	if c.Beta.CodeTTL <= 0 {
+		problems = append(problems, "beta code ttl must be positive")
+	}
+	if c.Beta.CodeResend < 0 {
+		problems = append(problems, "beta code resend interval must not be negative")
+	}
+	if c.Beta.MaxCodes < 1 || c.Beta.MaxAttempts < 1 {
+		problems = append(problems, "beta max codes and max code attempts must be at least 1")
+	}
+	if c.News.Interval <= 0 {
+		problems = append(problems, "news interval must be positive")
+	}
+	problems = append(problems, c.Demo.validate()...)
+	if c.Social.RedirectURL != "" {
+		if u, err := url.Parse(c.Social.RedirectURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems = append(problems, "social redirect url must be an http:// or https:// URL")
+		}
+		if c.Social.Listen == "" {
+			problems = append(problems, "social listen address is required with a redirect url")
+		}
+	}
+	problems = append(problems, c.Mail.validate()...)
+
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdown timeout must be positive")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validate checks the webhook settings; it is only called in webhook mode.
+func (w WebhookConfig) validate() []string {
+	var problems []string
+
+	if w.Listen == "" {
+		problems = append(problems, "webhook listen address is required")
+	}
+	if !strings.HasPrefix(w.Path, "/") {
+		problems = append(problems, "webhook path must start with /")
+	}
+	if w.Register {
+		if u, err := url.Parse(w.PublicURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			problems = append(problems, "webhook public url must be an https:// URL")
+		}
+	}
+	if len(w.Secret) == 0 || len(w.Secret) > 256 || strings.TrimFunc(w.Secret, isSecretRune) != "" {
+		problems = append(problems, "webhook secret must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if (w.CertFile == "") != (w.KeyFile == "") {
+		problems = append(problems, "webhook cert file and key file must be set together")
+	}
+	if w.UploadCert && w.CertFile == "" {
+		problems = append(problems, "webhook upload cert requires a cert file")
+	}
+	if w.MaxConnections < 0 || w.MaxConnections > 100 {
+		problems = append(problems, "webhook max connections must be between 0 and 100")
+	}
+	return problems
+}
+
+// validate checks the demo settings.
+func (d DemoConfig) validate() []string {
+	var problems []string
+	switch d.Provider {
+	case ProviderStub:
+	case ProviderOpenAI:
+		if d.APIKey == "" {
+			problems = append(problems, "demo api key is required for the openai provider")
+		}
+		if d.Model == "" {
+			problems = append(problems, "demo model is required for the openai provider")
+		}
+	case ProviderAzure:
+		if d.APIKey == "" || d.Endpoint == "" || d.Deployment == "" || d.APIVersion == "" {
+			problems = append(problems, "demo api key, endpoint, deployment and api version are required for the azure provider")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("demo provider must be %q, %q or %q", ProviderStub, ProviderOpenAI, ProviderAzure))
+	}
+	if d.DailyQuota < 0 {
+		problems = append(problems, "demo daily quota must not be negative")
+	}
+	if d.ContextTurns < 0 {
+		problems = append(problems, "demo context turns must not be negative")
+	}
+	return problems
+}
+
+// validate checks the mail settings.
+func (m MailConfig) validate() []string {
+	var problems []string
+	if _, err := mail.ParseAddress(m.From); err != nil {
+		problems = append(problems, "mail from must be an email address")
+	}
+	switch m.Mailer {
+	case MailerFile:
+	case MailerSMTP:
+		if m.Host == "" {
+			problems = append(problems, "mail host is required for the smtp mailer")
+		}
+		if m.Port < 1 || m.Port > 65535 {
+			problems = append(problems, "mail port must be between 1 and 65535")
+		}
+		if m.Password != "" && m.Username == "" {
+			problems = append(problems, "mail username is required with a password")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("mail mailer must be %q or %q", MailerFile, MailerSMTP))
+	}
+	return problems
+}
+
+// isSecretRune reports whether r is allowed in a webhook secret token.
+func isSecretRune(r rune) bool {
+	return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
@@ -0,0 +1,236 @@
+//config/load.go
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFileEnv names the environment variable holding the config file path.
+const ConfigFileEnv = "TG_CONFIG"
+
+// field is a single configurable leaf of Config discovered through its tags.
+type field struct {
+	key    string        // Dotted path built from the yaml tags, e.g. "mongo.uri"
+	env    string        // Environment variable name
+	flag   string        // Command-line flag name
+	usage  string        // Flag help text
+	secret string        // "true" to mask the whole value, "uri" to mask URI credentials
+	value  reflect.Value // Settable value inside the Config
+}
+
+// flagValue records a raw flag value so it can be applied after the file and environment.
+type flagValue struct {
+	raw    string
+	isBool bool
+	set    bool
+}
+
+func (f *flagValue) String() string   { return f.raw }
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+func (f *flagValue) Set(s string) error {
+	f.raw = s
+	f.set = true
+	return nil
+}
+
+// Load builds the configuration from the defaults, an optional config file,
+// the environment and the given command-line arguments, then validates it.
+//
+// The config file is taken from the -config flag or the TG_CONFIG variable;
+// files ending in .toml are parsed as TOML, anything else as YAML.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+	fields := collectFields(&cfg)
+
+	fs := flag.NewFlagSet("tg", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(ConfigFileEnv), "Path to a YAML or TOML config file")
+	flags := make(map[string]*flagValue, len(fields))
+	for _, f := range fields {
+		fv := &flagValue{isBool: f.value.Kind() == reflect.Bool}
+		flags[f.flag] = fv
+		fs.Var(fv, f.flag, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *path != "" {
+		if err := loadFile(*path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	for _, f := range fields {
+		if raw, ok := os.LookupEnv(f.env); ok {
+			if err := setValue(f.value, raw); err != nil {
+				return cfg, fmt.Errorf("config: %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if fv := flags[f.flag]; fv.set {
+			if err := setValue(f.value, fv.raw); err != nil {
+				return cfg, fmt.Errorf("config: -%s: %w", f.flag, err)
+			}
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// loadFile decodes a YAML or TOML file on top of cfg.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		err = yaml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Redacted returns a human-readable dump of the configuration with secrets masked.
+func (c Config) Redacted() string {
+	fields := collectFields(&c)
+	sort.Slice(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+
+	var b strings.Builder
+	for _, f := range fields {
+		value := fmt.Sprint(f.value.Interface())
+		switch f.secret {
+		case "true":
+			value = maskSecret(value)
+		case "uri":
+			value = maskURI(value)
+		}
+		fmt.Fprintf(&b, "%s = %s\n", f.key, value)
+	}
+	return b.String()
+}
+
+// collectFields walks the Config struct and returns its tagged leaf fields.
+func collectFields(cfg *Config) []field {
+	return walk(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func walk(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Time{}) {
+			fields = append(fields, walk(v.Field(i), key)...)
+			continue
+		}
+
+		fields = append(fields, field{
+			key:    key,
+			env:    sf.Tag.Get("env"),
+			flag:   sf.Tag.Get("flag"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret"),
+			value:  v.Field(i),
+		})
+	}
+	return fields
+}
+
+// setValue parses raw into the kind of v.
+func setValue(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var parts []string
+		if raw != "" {
+			parts = strings.Split(raw, ",")
+		}
+		s := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setValue(s.Index(i), part); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+// maskSecret hides a secret entirely; only whether it is set is shown.
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "****"
+}
+
+// maskURI hides the password component of a connection string.
+func maskURI(s string) string {
+	at := strings.LastIndex(s, "@")
+	scheme := strings.Index(s, "://")
+	if at < 0 || scheme < 0 || at < scheme {
+		return s
+	}
+	creds := s[scheme+3 : at]
+	if colon := strings.Index(creds, ":"); colon >= 0 {
+		creds = creds[:colon] + ":****"
+	}
+	return s[:scheme+3] + creds + s[at:]
+}
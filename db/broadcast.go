@@ -0,0 +1,235 @@
+//db/broadcast.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"regexp"
+	"time"
+)
+
+// Broadcast audiences.
+const (
+	AudienceUsers  = "users"  // Private chats of users from the users collection
+	AudienceGroups = "groups" // Groups from the groups collection
+)
+
+// Beta filters of an audience.
+const (
+	BetaApplied = "applied" // Users with a beta application
+	BetaNone    = "none"    // Users without one
+)
+
+// Broadcast statuses.
+const (
+	BroadcastDraft     = "draft"     // Waiting for the admin to confirm the preview
+	BroadcastScheduled = "scheduled" // Confirmed, waiting for its send time
+	BroadcastSending   = "sending"   // Being delivered; resumed after a restart
+	BroadcastDone      = "done"      // Every recipient was attempted
+	BroadcastCancelled = "cancelled" // Stopped by an admin
+)
+
+// Delivery statuses.
+const (
+	DeliveryPending = "pending" // Not attempted yet
+	DeliverySending = "sending" // Handed to Telegram; unknown outcome if the bot stopped here
+	DeliverySent    = "sent"    // Delivered
+	DeliveryFailed  = "failed"  // Telegram refused it
+	DeliverySkipped = "skipped" // Not attempted, e.g. the chat is unreachable or the outcome is unknown
+)
+
+// Audience selects the recipients of a broadcast.
+type Audience struct {
+	Target   string `bson:"target"`   // AudienceUsers or AudienceGroups
+	InGroup  bool   `bson:"in_group"` // Users only: only users seen in a group
+	Active   bool   `bson:"active"`   // Groups only: only active groups
+	Language string `bson:"language"` // Users only: language code prefix, empty for all
+	Beta     string `bson:"beta"`     // Users only: BetaApplied, BetaNone or empty for all
+}
+
+// Broadcast represents a message sent to many chats.
+type Broadcast struct {
+	ID          string    `bson:"_id"`          // Short random identifier shown to admins
+	CreatedBy   int64     `bson:"created_by"`   // Admin who drafted the broadcast
+	Text        string    `bson:"text"`         // Message to deliver
+	Audience    Audience  `bson:"audience"`     // Who receives it
+	Status      string    `bson:"status"`       // One of the Broadcast* statuses
+	ScheduledAt time.Time `bson:"scheduled_at"` // When to start sending, zero for right away
+	Created     time.Time `bson:"created"`      // Timestamp of when the draft was made
+	Started     time.Time `bson:"started"`      // Timestamp of when sending began
+	Finished    time.Time `bson:"finished"`     // Timestamp of when sending ended or was cancelled
+	Total       int       `bson:"total"`        // Recipients resolved when sending began
+	Sent        int       `bson:"sent"`         // Deliveries that succeeded
+	Failed      int       `bson:"failed"`       // Deliveries Telegram refused
+	Skipped     int       `bson:"skipped"`      // Deliveries not attempted
+}
+
+// Delivery represents the state of a broadcast for one recipient.
+type Delivery struct {
+	BroadcastID string    `bson:"broadcast_id"` // Broadcast being delivered
+	ChatID      int64     `bson:"chat_id"`      // Recipient chat
+	Status      string    `bson:"status"`       // One of the Delivery* statuses
+	MessageID   int       `bson:"message_id"`   // Telegram message ID once sent
+	Error       string    `bson:"error"`        // Why the delivery failed or was skipped
+	Updated     time.Time `bson:"updated"`      // Timestamp of the last status change
+}
+
+// SaveBroadcast creates or replaces a broadcast in the database.
+func (db *DB) SaveBroadcast(broadcast Broadcast) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("broadcasts")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": broadcast.ID}, broadcast, opts)
+	return err
+}
+
+// GetBroadcast retrieves a broadcast from the database.
+func (db *DB) GetBroadcast(id string) (*Broadcast, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("broadcasts")
+	broadcast := &Broadcast{}
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(broadcast)
+	return broadcast, notFound(err)
+}
+
+// ListBroadcasts retrieves the broadcasts in the given statuses, newest first.
+// With no statuses it returns every broadcast.
+func (db *DB) ListBroadcasts(statuses ...string) ([]Broadcast, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("broadcasts")
+	filter := bson.M{}
+	if len(statuses) > 0 {
+		filter["status"] = bson.M{"$in": statuses}
+	}
+
+	opts := options.Find().SetSort(bson.M{"created": -1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var broadcasts []Broadcast
+	err = cursor.All(ctx, &broadcasts)
+	return broadcasts, err
+}
+
+// Recipients returns the chat IDs matching an audience.
+func (db *DB) Recipients(audience Audience) ([]int64, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	if audience.Target == AudienceGroups {
+		filter := bson.M{}
+		if audience.Active {
+			filter["isactive"] = true
+		}
+		ids, err := db.client.Database(db.name).Collection("groups").Distinct(ctx, "groupid", filter)
+		return toChatIDs(ids), err
+	}
+
+	filter := bson.M{"user.isbot": bson.M{"$ne": true}}
+	if audience.InGroup {
+		filter["is_in_group"] = true
+	}
+	if audience.Language != "" {
+		filter["user.languagecode"] = bson.M{"$regex": "^" + regexp.QuoteMeta(audience.Language)}
+	}
+	if audience.Beta != "" {
+		applicants, err := db.client.Database(db.name).Collection("beta").Distinct(ctx, "userid", bson.M{})
+		if err != nil {
+			return nil, err
+		}
+		ids := toChatIDs(applicants)
+		if audience.Beta == BetaApplied {
+			filter["user.id"] = bson.M{"$in": ids}
+		} else {
+			filter["user.id"] = bson.M{"$nin": ids}
+		}
+	}
+
+	ids, err := db.client.Database(db.name).Collection("users").Distinct(ctx, "user.id", filter)
+	return toChatIDs(ids), err
+}
+
+// AddDeliveries records a pending delivery for every recipient that has none yet,
+// so it can be called again after a crash without resetting finished deliveries.
+func (db *DB) AddDeliveries(broadcastID string, chatIDs []int64) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	if len(chatIDs) == 0 {
+		return nil
+	}
+
+	collection := db.client.Database(db.name).Collection("deliveries")
+	now := time.Now()
+	models := make([]mongo.WriteModel, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		delivery := Delivery{BroadcastID: broadcastID, ChatID: chatID, Status: DeliveryPending, Updated: now}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"broadcast_id": broadcastID, "chat_id": chatID}).
+			SetUpdate(bson.M{"$setOnInsert": delivery}).
+			SetUpsert(true))
+	}
+
+	_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// ListDeliveries retrieves the deliveries of a broadcast in the given statuses.
+// With no statuses it returns every delivery of the broadcast.
+func (db *DB) ListDeliveries(broadcastID string, statuses ...string) ([]Delivery, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("deliveries")
+	filter := bson.M{"broadcast_id": broadcastID}
+	if len(statuses) > 0 {
+		filter["status"] = bson.M{"$in": statuses}
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []Delivery
+	err = cursor.All(ctx, &deliveries)
+	return deliveries, err
+}
+
+// SaveDelivery updates the state of a delivery in the database.
+func (db *DB) SaveDelivery(delivery Delivery) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("deliveries")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.M{"broadcast_id": delivery.BroadcastID, "chat_id": delivery.ChatID}
+	_, err := collection.ReplaceOne(ctx, filter, delivery, opts)
+	return err
+}
+
+// toChatIDs converts the numeric values returned by Distinct to chat IDs.
+func toChatIDs(values []interface{}) []int64 {
+	ids := make([]int64, 0, len(values))
+	for _, v := range values {
+		switch n := v.(type) {
+		case int32:
+			ids = append(ids, int64(n))
+		case int64:
+			ids = append(ids, n)
+		case float64:
+			ids = append(ids, int64(n))
+		}
+	}
+	return ids
+}
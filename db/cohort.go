@@ -0,0 +1,137 @@
+//db/cohort.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Cohort is a wave of approved beta applicants onboarded together.
+type Cohort struct {
+	Name      string    `bson:"_id"`        // Unique name, e.g. "wave1"
+	Capacity  int       `bson:"capacity"`   // Seats, held by redeemed and outstanding invites
+	CreatedBy int64     `bson:"created_by"` // Admin who created the cohort
+	Created   time.Time `bson:"created"`    // Timestamp of when the cohort was created
+	Updated   time.Time `bson:"updated"`    // Timestamp of the last change
+}
+
+// Invite is a one-time code admitting a beta applicant to a cohort.
+type Invite struct {
+	Code       string    `bson:"_id"`         // Code carried by the deep link
+	Cohort     string    `bson:"cohort"`      // Cohort the code admits to
+	BetaID     string    `bson:"beta_id"`     // Application the code was issued for
+	UserID     int64     `bson:"user_id"`     // Applicant the code was issued to
+	CreatedBy  int64     `bson:"created_by"`  // Admin who issued the code
+	Created    time.Time `bson:"created"`     // Timestamp of when the code was issued
+	Expires    time.Time `bson:"expires"`     // Timestamp after which the code cannot be redeemed
+	Revoked    bool      `bson:"revoked"`     // Whether an admin withdrew the code
+	RedeemedBy int64     `bson:"redeemed_by"` // User who redeemed the code, 0 while unused
+	Redeemed   time.Time `bson:"redeemed"`    // Timestamp of the redemption
+}
+
+// Holds reports whether the invite takes up a seat of its cohort: it was
+// redeemed, or it can still be.
+func (i Invite) Holds(now time.Time) bool {
+	return i.RedeemedBy != 0 || (!i.Revoked && now.Before(i.Expires))
+}
+
+// SaveCohort creates or replaces a cohort in the database.
+func (db *DB) SaveCohort(cohort Cohort) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("cohorts")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": cohort.Name}, cohort, opts)
+	return err
+}
+
+// GetCohort retrieves a cohort from the database.
+func (db *DB) GetCohort(name string) (*Cohort, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("cohorts")
+	cohort := &Cohort{}
+	err := collection.FindOne(ctx, bson.M{"_id": name}).Decode(cohort)
+	return cohort, notFound(err)
+}
+
+// ListCohorts retrieves every cohort from the database, oldest first.
+func (db *DB) ListCohorts() ([]Cohort, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("cohorts")
+	opts := options.Find().SetSort(bson.M{"created": 1})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var cohorts []Cohort
+	err = cursor.All(ctx, &cohorts)
+	return cohorts, err
+}
+
+// SaveInvite creates or replaces an invite in the database.
+func (db *DB) SaveInvite(invite Invite) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("invites")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": invite.Code}, invite, opts)
+	return err
+}
+
+// GetInvite retrieves an invite by its code from the database.
+func (db *DB) GetInvite(code string) (*Invite, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("invites")
+	invite := &Invite{}
+	err := collection.FindOne(ctx, bson.M{"_id": code}).Decode(invite)
+	return invite, notFound(err)
+}
+
+// ListInvites retrieves the invites to a cohort, or to every cohort when it is empty, from the database, oldest first.
+func (db *DB) ListInvites(cohort string) ([]Invite, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("invites")
+	filter := bson.M{}
+	if cohort != "" {
+		filter["cohort"] = cohort
+	}
+	opts := options.Find().SetSort(bson.M{"created": 1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var invites []Invite
+	err = cursor.All(ctx, &invites)
+	return invites, err
+}
+
+// RedeemInvite binds an unused, unrevoked and unexpired invite to the user in
+// the database, in one step so a code cannot be redeemed twice. It returns
+// ErrNotFound when the invite cannot be redeemed.
+func (db *DB) RedeemInvite(code string, userID int64, at time.Time) (*Invite, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("invites")
+	filter := bson.M{"_id": code, "redeemed_by": 0, "revoked": false, "expires": bson.M{"$gt": at}}
+	update := bson.M{"$set": bson.M{"redeemed_by": userID, "redeemed": at}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	invite := &Invite{}
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(invite)
+	return invite, notFound(err)
+}
@@ -8,18 +8,16 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	config "tg/config"
+	errors "tg/errors"
 	"time"
 )
 
-const (
-	connectionString = "mongodb://127.0.0.1:27017"
-	dbName           = "cntrlTGtest"
-)
-
-// DB represents the database client.
+// DB represents the database client. It is the MongoDB implementation of Store.
 type DB struct {
-	client *mongo.Client
-	ctx    context.Context
+	client  *mongo.Client
+	name    string        // Name of the database holding the bot's collections
+	timeout time.Duration // Bounds every database operation
 }
 
 // Message represents a message in the database.
@@ -42,9 +40,31 @@ type User struct {
 
 // Group represents a group in the database.
 type Group struct {
-	GroupName string // Name of the group
-	GroupID   int64  // Unique identifier of the group
-	IsActive  bool   // Whether the group is active or not
+	GroupName   string    // Name of the group
+	GroupID     int64     // Unique identifier of the group
+	IsActive    bool      // Whether the bot is still a member of the group
+	Type        string    // Chat type, "group" or "supergroup"
+	BotStatus   string    // The bot's member status, e.g. "member" or "administrator"
+	MemberCount int       // Number of members when last counted
+	Joined      time.Time // Timestamp of when the bot was added
+	Left        time.Time // Timestamp of when the bot was removed, zero while active
+	Updated     time.Time // Timestamp of the last change
+	Welcome     Welcome   // Welcome message for new members
+}
+
+// Welcome holds the settings of a group's welcome message.
+type Welcome struct {
+	Enabled     bool            `bson:"enabled"`      // Whether new members are welcomed; until UpdatedBy is set they are by default
+	Template    string          `bson:"template"`     // Message with {first_name}, {group} and {member_count} placeholders
+	Buttons     []WelcomeButton `bson:"buttons"`      // Link buttons shown under the message
+	DeleteAfter time.Duration   `bson:"delete_after"` // How long the message stays, 0 to keep it
+	UpdatedBy   int64           `bson:"updated_by"`   // Admin who last changed the settings
+}
+
+// WelcomeButton is a link button under a welcome message.
+type WelcomeButton struct {
+	Label string `bson:"label"`
+	URL   string `bson:"url"`
 }
 
 // Beta represents a beta in the database.
@@ -57,92 +77,354 @@ type Beta struct {
 	Model         string
 	Email         string
 	Name          string
-	ContactTime   string
-	ContactMethod string
+	ContactTime   string         // Contact window as answered, normalized
+	ContactMethod string         // One of the Contact* methods; free text on older applications
+	Phone         string         // Phone number in E.164 form, when contact is by phone
+	ContactWindow *ContactWindow // Parsed contact window; nil on applications from before it was asked
 	Created       time.Time
+
+	ID             string           // Short identifier used by reviewers; empty on applications from before reviews
+	Status         string           // One of the Beta* statuses; empty is treated as pending
+	ReviewerID     int64            // Staff member who made the latest decision
+	ReviewerName   string           // First name of that staff member
+	Reviewed       time.Time        // Timestamp of the latest decision
+	StaffMessageID int              // Message announcing the application in the review chat
+	History        []BetaTransition // Every status change, oldest first
+	Cohort         string           // Cohort the applicant joined by redeeming an invite
+	Joined         time.Time        // Timestamp of the redemption
+
+	EmailVerified bool      // Whether the applicant sent back a code mailed to Email
+	Verified      time.Time // Timestamp of the verification
+	CodeHash      string    // Hash of the outstanding verification code, empty when there is none
+	CodeExpires   time.Time // Timestamp after which the outstanding code is refused
+	CodeSent      time.Time // Timestamp of the latest code
+	CodesSent     int       // Codes sent for the application
+	CodeAttempts  int       // Wrong guesses of the outstanding code
 }
 
-// Connect initializes a new database client.
-func Connect() (*DB, error) {
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
+// Ways a beta applicant can be contacted.
+const (
+	ContactTelegram = "telegram"
+	ContactEmail    = "email"
+	ContactPhone    = "phone"
+)
 
-	clientOptions := options.Client().ApplyURI(connectionString)
+// ContactWindow is when a beta applicant would like to be contacted.
+type ContactWindow struct {
+	Days     []string // Days of the week, "mon" to "sun"; empty for every day
+	Start    int      // Minutes after midnight
+	End      int      // Minutes after midnight; before Start when the window spans midnight
+	Timezone string   // IANA name or UTC offset the times are in
+}
+
+// Beta application statuses.
+const (
+	BetaPending    = "pending"    // Waiting for a decision
+	BetaApproved   = "approved"   // Accepted into the beta
+	BetaRejected   = "rejected"   // Turned down
+	BetaWaitlisted = "waitlisted" // Accepted once there is room
+)
+
+// BetaTransition records one status change of a beta application.
+type BetaTransition struct {
+	From   string    // Status before the change, empty on submission
+	To     string    // Status after the change
+	ByID   int64     // Staff member who made the change, 0 for the applicant
+	ByName string    // First name of whoever made the change
+	Note   string    // What else happened, e.g. that the applicant could not be told
+	At     time.Time // Timestamp of the change
+}
+
+// ChatStatus represents whether the bot can still deliver messages to a chat.
+type ChatStatus struct {
+	ChatID        int64     `bson:"chat_id"`         // Chat the status belongs to
+	Reachable     bool      `bson:"reachable"`       // Whether the last delivery succeeded or was retryable
+	FailureCount  int       `bson:"failure_count"`   // Consecutive failed deliveries
+	LastErrorCode int       `bson:"last_error_code"` // Telegram error code of the last failure
+	LastError     string    `bson:"last_error"`      // Description of the last failure
+	LastFailure   time.Time `bson:"last_failure"`    // Timestamp of the last failure
+	Updated       time.Time `bson:"updated"`         // Timestamp of when the status last changed
+}
+
+// Connect initializes a new database client from the Mongo settings.
+func Connect(cfg config.MongoConfig) (*DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+
+	// Single reads and writes that fail on a transient network or election
+	// error are retried once by the driver, which is safe as every such
+	// operation is applied at most once. Handlers are not run again.
+	clientOptions := options.Client().ApplyURI(cfg.URI).SetRetryReads(true).SetRetryWrites(true)
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(errors.ErrDatabaseConnection, err)
 	}
 
 	err = client.Ping(ctx, nil)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(errors.ErrDatabaseConnection, err)
 	}
 
-	return &DB{client: client, ctx: ctx}, nil
+	return &DB{client: client, name: cfg.Database, timeout: cfg.OpTimeout}, nil
 }
 
-// SaveGroup saves a group in the database.
-func (db *DB) SaveGroup(group Group) (*mongo.InsertOneResult, error) {
-	collection := db.client.Database(dbName).Collection("groups")
-	return collection.InsertOne(db.ctx, group)
+// op returns the context of a single database operation, which gives up after the configured timeout.
+func (db *DB) op() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), db.timeout)
+}
+
+// Close waits for in-progress operations and disconnects from MongoDB.
+func (db *DB) Close(ctx context.Context) error {
+	return db.client.Disconnect(ctx)
+}
+
+// SaveGroup creates or replaces a group in the database.
+func (db *DB) SaveGroup(group Group) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("groups")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"groupid": group.GroupID}, group, opts)
+	return err
 }
 
 // GetGroup retrieves a group from the database.
 func (db *DB) GetGroup(groupID int64) (*Group, error) {
-	collection := db.client.Database(dbName).Collection("groups")
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("groups")
 	group := &Group{}
-	err := collection.FindOne(db.ctx, bson.M{"groupid": groupID}).Decode(group)
+	err := collection.FindOne(ctx, bson.M{"groupid": groupID}).Decode(group)
+	if err = notFound(err); errors.Is(err, ErrNotFound) {
+		err = errors.Wrap(errors.ErrGroupNotFound, err)
+	}
 	return group, err
 }
 
 // UpdateGroup updates a group in the database.
 func (db *DB) UpdateGroup(group Group) error {
-	collection := db.client.Database(dbName).Collection("groups")
-	_, err := collection.UpdateOne(db.ctx, bson.M{"groupid": group.GroupID}, bson.M{"$set": bson.M{"isactive": group.IsActive}})
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("groups")
+	_, err := collection.UpdateOne(ctx, bson.M{"groupid": group.GroupID}, bson.M{"$set": bson.M{"isactive": group.IsActive}})
 	return err
 }
 
 // DeactivateGroup deactivates a group in the database.
 func (db *DB) DeactivateGroup(groupID int64) error {
-	collection := db.client.Database(dbName).Collection("groups")
-	_, err := collection.UpdateOne(db.ctx, bson.M{"groupid": groupID}, bson.M{"$set": bson.M{"isactive": false}})
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("groups")
+	_, err := collection.UpdateOne(ctx, bson.M{"groupid": groupID}, bson.M{"$set": bson.M{"isactive": false}})
 	return err
 }
 
-// LogChatMessage logs a chat message in the database.
 // LogChatMessage logs a chat message in the database.
 func (db *DB) LogChatMessage(chatMessage Message) error {
-	collection := db.client.Database(dbName).Collection("messages")
-	_, err := collection.InsertOne(db.ctx, chatMessage)
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("messages")
+	_, err := collection.InsertOne(ctx, chatMessage)
 	return err
 }
 
-// LogUserProfile logs a user profile in the database.
+// LogUserProfile creates or updates a user profile in the database.
+// IsInGroup is left alone; it follows the user's memberships, see SetUserInGroup.
 func (db *DB) LogUserProfile(userProfile User) error {
-	collection := db.client.Database(dbName).Collection("users")
-	dbUser := User{
-		User: tgbotapi.User{
-			ID:           userProfile.User.ID,
-			FirstName:    userProfile.User.FirstName,
-			LastName:     userProfile.User.LastName,
-			UserName:     userProfile.User.UserName,
-			LanguageCode: userProfile.User.LanguageCode,
-			IsBot:        userProfile.User.IsBot,
-		},
-		IsInGroup:   true,
-		LastUpdated: time.Now(),
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("users")
+	profile := tgbotapi.User{
+		ID:           userProfile.User.ID,
+		FirstName:    userProfile.User.FirstName,
+		LastName:     userProfile.User.LastName,
+		UserName:     userProfile.User.UserName,
+		LanguageCode: userProfile.User.LanguageCode,
+		IsBot:        userProfile.User.IsBot,
 	}
 
 	opts := options.Update().SetUpsert(true)
 	filter := bson.M{"user.id": userProfile.User.ID}
-	update := bson.M{"$set": dbUser}
+	update := bson.M{
+		"$set":         bson.M{"user": profile, "last_updated": time.Now()},
+		"$setOnInsert": bson.M{"is_in_group": false},
+	}
 
-	_, err := collection.UpdateOne(db.ctx, filter, update, opts)
+	_, err := collection.UpdateOne(ctx, filter, update, opts)
 	return err
 }
 
+// SetUserInGroup records whether a user is in at least one of the bot's groups.
+// Users who never talked to the bot get a record holding only their ID.
+func (db *DB) SetUserInGroup(userID int, inGroup bool) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("users")
+	opts := options.Update().SetUpsert(true)
+	_, err := collection.UpdateOne(ctx, bson.M{"user.id": userID}, bson.M{"$set": bson.M{"is_in_group": inGroup}}, opts)
+	return err
+}
+
+// GetUser retrieves a user profile from the database.
+func (db *DB) GetUser(userID int) (*User, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("users")
+	user := &User{}
+	err := collection.FindOne(ctx, bson.M{"user.id": userID}).Decode(user)
+	if err = notFound(err); errors.Is(err, ErrNotFound) {
+		err = errors.Wrap(errors.ErrUserNotFound, err)
+	}
+	return user, err
+}
+
 // SaveBeta saves a beta in the database.
 func (db *DB) SaveBeta(betaInfo Beta) error {
-	collection := db.client.Database(dbName).Collection("beta")
-	_, err := collection.InsertOne(db.ctx, betaInfo)
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("beta")
+	_, err := collection.InsertOne(ctx, betaInfo)
+	return err
+}
+
+// GetBeta retrieves the most recent beta application of a user from the database.
+func (db *DB) GetBeta(userID int64) (*Beta, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("beta")
+	betaInfo := &Beta{}
+	opts := options.FindOne().SetSort(bson.M{"created": -1})
+	err := collection.FindOne(ctx, bson.M{"userid": userID}, opts).Decode(betaInfo)
+	return betaInfo, notFound(err)
+}
+
+// GetBetaByID retrieves a beta application by its review identifier from the database.
+func (db *DB) GetBetaByID(id string) (*Beta, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("beta")
+	betaInfo := &Beta{}
+	err := collection.FindOne(ctx, bson.M{"id": id}).Decode(betaInfo)
+	return betaInfo, notFound(err)
+}
+
+// UpdateBeta replaces a reviewed beta application, matched by its identifier, in the database.
+func (db *DB) UpdateBeta(betaInfo Beta) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("beta")
+	result, err := collection.ReplaceOne(ctx, bson.M{"id": betaInfo.ID}, betaInfo)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListBetas retrieves the beta applications with one of the statuses from the database, oldest first.
+// Applications from before reviews have no identifier and are left out.
+func (db *DB) ListBetas(statuses ...string) ([]Beta, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("beta")
+	filter := bson.M{"id": bson.M{"$nin": bson.A{nil, ""}}}
+	if len(statuses) > 0 {
+		filter["status"] = bson.M{"$in": statuses}
+	}
+	opts := options.Find().SetSort(bson.M{"created": 1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var betas []Beta
+	err = cursor.All(ctx, &betas)
+	return betas, err
+}
+
+// WizardState represents a user's progress through a multi-step wizard.
+type WizardState struct {
+	Wizard       string            `bson:"wizard"`         // Name of the wizard, e.g. "beta"
+	UserID       int64             `bson:"user_id"`        // User answering the wizard
+	ChatID       int64             `bson:"chat_id"`        // Chat the wizard runs in
+	OriginChatID int64             `bson:"origin_chat_id"` // Chat the wizard was started from
+	Username     string            `bson:"username"`       // Username of the user at start
+	Step         string            `bson:"step"`           // Name of the step awaiting an answer
+	Answers      map[string]string `bson:"answers"`        // Accepted answers keyed by step name
+	Started      time.Time         `bson:"started"`        // Timestamp of when the wizard was started
+	Updated      time.Time         `bson:"updated"`        // Timestamp of the last accepted answer
+}
+
+// wizardFilter selects the state of one wizard for a user in a chat.
+func wizardFilter(wizard string, userID int64, chatID int64) bson.M {
+	return bson.M{"wizard": wizard, "user_id": userID, "chat_id": chatID}
+}
+
+// LoadWizardState retrieves a wizard state from the database.
+func (db *DB) LoadWizardState(wizard string, userID int64, chatID int64) (*WizardState, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("wizard_states")
+	state := &WizardState{}
+	err := collection.FindOne(ctx, wizardFilter(wizard, userID, chatID)).Decode(state)
+	return state, notFound(err)
+}
+
+// SaveWizardState creates or replaces a wizard state in the database.
+func (db *DB) SaveWizardState(state WizardState) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("wizard_states")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, wizardFilter(state.Wizard, state.UserID, state.ChatID), state, opts)
+	return err
+}
+
+// DeleteWizardState removes a wizard state from the database.
+func (db *DB) DeleteWizardState(wizard string, userID int64, chatID int64) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("wizard_states")
+	_, err := collection.DeleteOne(ctx, wizardFilter(wizard, userID, chatID))
+	return err
+}
+
+// GetChatStatus retrieves the delivery status of a chat from the database.
+func (db *DB) GetChatStatus(chatID int64) (*ChatStatus, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("chat_status")
+	status := &ChatStatus{}
+	err := collection.FindOne(ctx, bson.M{"chat_id": chatID}).Decode(status)
+	return status, notFound(err)
+}
+
+// SaveChatStatus creates or replaces the delivery status of a chat in the database.
+func (db *DB) SaveChatStatus(status ChatStatus) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("chat_status")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"chat_id": status.ChatID}, status, opts)
 	return err
 }
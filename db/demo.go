@@ -0,0 +1,122 @@
+//db/demo.go
+
+package db
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	errors "tg/errors"
+	"time"
+)
+
+// DemoSession holds the conversation of /demo in a chat.
+type DemoSession struct {
+	ChatID   int64      `bson:"chat_id"`  // Chat the conversation is in
+	Active   bool       `bson:"active"`   // Whether plain messages in the chat go to the demo
+	Messages []DemoTurn `bson:"messages"` // Recent turns, oldest first
+	Started  time.Time  `bson:"started"`  // Timestamp of when the session was started
+	Updated  time.Time  `bson:"updated"`  // Timestamp of the last turn
+}
+
+// DemoTurn is one message of a demo conversation.
+type DemoTurn struct {
+	Role    string `bson:"role"`    // user or assistant
+	Content string `bson:"content"` // Text of the message
+}
+
+// DemoUsage counts the demo messages a user sent on a day.
+type DemoUsage struct {
+	UserID int64  `bson:"user_id"`
+	Day    string `bson:"day"` // UTC date, 2006-01-02
+	Count  int    `bson:"count"`
+}
+
+// GetDemoSession retrieves the demo conversation of a chat from the database.
+func (db *DB) GetDemoSession(chatID int64) (*DemoSession, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("demo_sessions")
+	session := &DemoSession{}
+	err := collection.FindOne(ctx, bson.M{"chat_id": chatID}).Decode(session)
+	return session, notFound(err)
+}
+
+// SaveDemoSession creates or replaces the demo conversation of a chat in the database.
+func (db *DB) SaveDemoSession(session DemoSession) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("demo_sessions")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"chat_id": session.ChatID}, session, opts)
+	return err
+}
+
+// DeleteDemoSession removes the demo conversation of a chat from the database.
+func (db *DB) DeleteDemoSession(chatID int64) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("demo_sessions")
+	_, err := collection.DeleteOne(ctx, bson.M{"chat_id": chatID})
+	return err
+}
+
+// GetDemoUsage returns how many demo messages a user sent on a day.
+func (db *DB) GetDemoUsage(userID int64, day string) (int, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("demo_usage")
+	usage := &DemoUsage{}
+	err := collection.FindOne(ctx, bson.M{"user_id": userID, "day": day}).Decode(usage)
+	if errors.IsNotFound(err) {
+		return 0, nil
+	}
+	return usage.Count, err
+}
+
+// ReserveDemoUsage counts one more demo message for a user on a day in the
+// database, unless the user already sent quota messages that day. It returns
+// the messages counted and whether one was reserved. Concurrent reservations
+// cannot exceed the quota, as the count is checked and raised in one update.
+func (db *DB) ReserveDemoUsage(userID int64, day string, quota int) (int, bool, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("demo_usage")
+	filter := bson.M{"user_id": userID, "day": day}
+
+	// Make sure the day has a counter for the conditional update to find. The
+	// identifier is derived from the key, so concurrent inserts cannot both succeed.
+	insert := bson.M{"$setOnInsert": bson.M{"_id": fmt.Sprintf("%d/%s", userID, day), "count": 0}}
+	_, err := collection.UpdateOne(ctx, filter, insert, options.Update().SetUpsert(true))
+	if err != nil && !errors.IsMongoDuplicateKey(err) {
+		return 0, false, err
+	}
+
+	usage := &DemoUsage{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	filter["count"] = bson.M{"$lt": quota}
+	err = collection.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"count": 1}}, opts).Decode(usage)
+	if errors.IsNotFound(notFound(err)) {
+		return quota, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return usage.Count, true, nil
+}
+
+// ReleaseDemoUsage gives back a demo message reserved for a user on a day in the database.
+func (db *DB) ReleaseDemoUsage(userID int64, day string) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("demo_usage")
+	filter := bson.M{"user_id": userID, "day": day, "count": bson.M{"$gt": 0}}
+	_, err := collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"count": -1}})
+	return err
+}
@@ -0,0 +1,81 @@
+//db/membership.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Membership roles.
+const (
+	RoleMember     = "member"     // Regular member
+	RoleAdmin      = "admin"      // Administrator or creator of the group
+	RoleRestricted = "restricted" // Member with restricted rights
+)
+
+// Membership represents a user's membership of one group.
+type Membership struct {
+	UserID        int64     `bson:"user_id"`         // Member
+	GroupID       int64     `bson:"group_id"`        // Group the user belongs to
+	Role          string    `bson:"role"`            // One of the Role* values
+	InGroup       bool      `bson:"in_group"`        // Whether the user is currently in the group
+	Joined        time.Time `bson:"joined"`          // Timestamp of when the user last joined
+	Left          time.Time `bson:"left"`            // Timestamp of when the user last left, zero while in the group
+	LastMessageID int       `bson:"last_message_id"` // Last message the user sent in the group
+	LastSeen      time.Time `bson:"last_seen"`       // Timestamp of that message
+	Updated       time.Time `bson:"updated"`         // Timestamp of the last change
+}
+
+// membershipFilter selects the membership of a user in a group.
+func membershipFilter(userID int64, groupID int64) bson.M {
+	return bson.M{"user_id": userID, "group_id": groupID}
+}
+
+// GetMembership retrieves the membership of a user in a group from the database.
+func (db *DB) GetMembership(userID int64, groupID int64) (*Membership, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("memberships")
+	membership := &Membership{}
+	err := collection.FindOne(ctx, membershipFilter(userID, groupID)).Decode(membership)
+	return membership, notFound(err)
+}
+
+// SaveMembership creates or replaces a membership in the database.
+func (db *DB) SaveMembership(membership Membership) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("memberships")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, membershipFilter(membership.UserID, membership.GroupID), membership, opts)
+	return err
+}
+
+// UserGroups retrieves the memberships of the groups a user is currently in.
+func (db *DB) UserGroups(userID int64) ([]Membership, error) {
+	return db.findMemberships(bson.M{"user_id": userID, "in_group": true})
+}
+
+// GroupMembers retrieves the memberships of the users currently in a group.
+func (db *DB) GroupMembers(groupID int64) ([]Membership, error) {
+	return db.findMemberships(bson.M{"group_id": groupID, "in_group": true})
+}
+
+func (db *DB) findMemberships(filter bson.M) ([]Membership, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("memberships")
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var memberships []Membership
+	err = cursor.All(ctx, &memberships)
+	return memberships, err
+}
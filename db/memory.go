@@ -0,0 +1,1115 @@
+//db/memory.go
+
+package db
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"sort"
+	"strings"
+	"sync"
+	errors "tg/errors"
+	"time"
+)
+
+// MemoryStore is an in-memory Store, used for tests and running without MongoDB.
+type MemoryStore struct {
+	mu       sync.Mutex
+	groups   map[int64]Group
+	users    map[int]User
+	messages []Message
+	betas    []Beta
+	wizards  map[wizardKey]WizardState
+	statuses map[int64]ChatStatus
+	members  map[membershipKey]Membership
+
+	broadcasts map[string]Broadcast
+	deliveries map[deliveryKey]Delivery
+	tickets    map[string]Ticket
+	prefs      map[int64]Preferences
+	news       map[string]NewsItem
+	progress   map[progressKey]TutorialProgress
+	steps      map[stepKey]TutorialStepStats
+	demos      map[int64]DemoSession
+	demoUsage  map[usageKey]int
+	social     map[string]SocialLink
+	clicks     map[clickKey]SocialClicks
+	cohorts    map[string]Cohort
+	invites    map[string]Invite
+	migrations map[int64]Migration
+}
+
+// clickKey identifies the clicks on a link from one chat.
+type clickKey struct {
+	network string
+	chatID  int64
+}
+
+// usageKey identifies a user's demo usage on a day.
+type usageKey struct {
+	userID int64
+	day    string
+}
+
+// progressKey identifies a user's progress through a tutorial.
+type progressKey struct {
+	userID   int64
+	tutorial string
+}
+
+// stepKey identifies a step of a tutorial version.
+type stepKey struct {
+	tutorial string
+	version  int
+	page     string
+}
+
+// membershipKey identifies the membership of a user in a group.
+type membershipKey struct {
+	userID  int64
+	groupID int64
+}
+
+// deliveryKey identifies the delivery of a broadcast to one chat.
+type deliveryKey struct {
+	broadcastID string
+	chatID      int64
+}
+
+// wizardKey identifies the state of one wizard for a user in a chat.
+type wizardKey struct {
+	wizard string
+	userID int64
+	chatID int64
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		groups:   make(map[int64]Group),
+		users:    make(map[int]User),
+		wizards:  make(map[wizardKey]WizardState),
+		statuses: make(map[int64]ChatStatus),
+		members:  make(map[membershipKey]Membership),
+
+		broadcasts: make(map[string]Broadcast),
+		deliveries: make(map[deliveryKey]Delivery),
+		tickets:    make(map[string]Ticket),
+		prefs:      make(map[int64]Preferences),
+		news:       make(map[string]NewsItem),
+		progress:   make(map[progressKey]TutorialProgress),
+		steps:      make(map[stepKey]TutorialStepStats),
+		demos:      make(map[int64]DemoSession),
+		demoUsage:  make(map[usageKey]int),
+		social:     make(map[string]SocialLink),
+		clicks:     make(map[clickKey]SocialClicks),
+		cohorts:    make(map[string]Cohort),
+		invites:    make(map[string]Invite),
+		migrations: make(map[int64]Migration),
+	}
+}
+
+// Close does nothing; it exists to satisfy Store.
+func (m *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// SaveGroup saves a group in memory.
+func (m *MemoryStore) SaveGroup(group Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.groups[group.GroupID] = group
+	return nil
+}
+
+// GetGroup retrieves a group from memory.
+func (m *MemoryStore) GetGroup(groupID int64) (*Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.groups[groupID]
+	if !ok {
+		return &Group{}, errors.Wrap(errors.ErrGroupNotFound, ErrNotFound)
+	}
+	return &group, nil
+}
+
+// UpdateGroup updates a group in memory.
+func (m *MemoryStore) UpdateGroup(group Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.groups[group.GroupID]; ok {
+		existing.IsActive = group.IsActive
+		m.groups[group.GroupID] = existing
+	}
+	return nil
+}
+
+// DeactivateGroup deactivates a group in memory.
+func (m *MemoryStore) DeactivateGroup(groupID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.groups[groupID]; ok {
+		existing.IsActive = false
+		m.groups[groupID] = existing
+	}
+	return nil
+}
+
+// MigrateChat moves a group and everything kept for it to a new chat ID in
+// memory, once, merging it into the records the new chat already has.
+func (m *MemoryStore) MigrateChat(from int64, to int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, claimed := m.migrations[from]; claimed {
+		return nil
+	}
+	m.migrations[from] = Migration{From: from, To: to, Started: time.Now()}
+
+	for i := range m.messages {
+		if m.messages[i].GroupID == from {
+			m.messages[i].GroupID = to
+		}
+	}
+	for i := range m.betas {
+		if m.betas[i].GroupID == from {
+			m.betas[i].GroupID = to
+		}
+	}
+	for id, ticket := range m.tickets {
+		if ticket.OriginChatID == from {
+			ticket.OriginChatID = to
+			m.tickets[id] = ticket
+		}
+	}
+	for key, membership := range m.members {
+		if key.groupID != from {
+			continue
+		}
+		delete(m.members, key)
+		moved := membershipKey{key.userID, to}
+		if _, exists := m.members[moved]; !exists {
+			membership.GroupID = to
+			m.members[moved] = membership
+		}
+	}
+	for key, state := range m.wizards {
+		if state.OriginChatID == from {
+			state.OriginChatID = to
+			m.wizards[key] = state
+		}
+		if key.chatID != from {
+			continue
+		}
+		delete(m.wizards, key)
+		moved := wizardKey{key.wizard, key.userID, to}
+		if _, exists := m.wizards[moved]; !exists {
+			state.ChatID = to
+			m.wizards[moved] = state
+		}
+	}
+	for key, delivery := range m.deliveries {
+		if key.chatID != from {
+			continue
+		}
+		delete(m.deliveries, key)
+		moved := deliveryKey{key.broadcastID, to}
+		if _, exists := m.deliveries[moved]; !exists {
+			delivery.ChatID = to
+			m.deliveries[moved] = delivery
+		}
+	}
+	for key, click := range m.clicks {
+		if key.chatID != from {
+			continue
+		}
+		delete(m.clicks, key)
+		moved := clickKey{key.network, to}
+		merged := m.clicks[moved]
+		merged.Network, merged.ChatID = key.network, to
+		merged.Count += click.Count
+		if click.Last.After(merged.Last) {
+			merged.Last = click.Last
+		}
+		m.clicks[moved] = merged
+	}
+	if prefs, ok := m.prefs[from]; ok {
+		delete(m.prefs, from)
+		if _, exists := m.prefs[to]; !exists {
+			prefs.ChatID = to
+			m.prefs[to] = prefs
+		}
+	}
+	if session, ok := m.demos[from]; ok {
+		delete(m.demos, from)
+		if _, exists := m.demos[to]; !exists {
+			session.ChatID = to
+			m.demos[to] = session
+		}
+	}
+	delete(m.statuses, from)
+
+	group, ok := m.groups[from]
+	if !ok {
+		return nil
+	}
+	var target *Group
+	if existing, exists := m.groups[to]; exists {
+		target = &existing
+	}
+	m.groups[to] = mergeGroup(group, target, to)
+	delete(m.groups, from)
+	return nil
+}
+
+// LogUserProfile creates or updates a user profile in memory, keeping IsInGroup.
+func (m *MemoryStore) LogUserProfile(userProfile User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[userProfile.User.ID] = User{
+		User: tgbotapi.User{
+			ID:           userProfile.User.ID,
+			FirstName:    userProfile.User.FirstName,
+			LastName:     userProfile.User.LastName,
+			UserName:     userProfile.User.UserName,
+			LanguageCode: userProfile.User.LanguageCode,
+			IsBot:        userProfile.User.IsBot,
+		},
+		IsInGroup:   m.users[userProfile.User.ID].IsInGroup,
+		LastUpdated: time.Now(),
+	}
+	return nil
+}
+
+// SetUserInGroup records in memory whether a user is in at least one group.
+func (m *MemoryStore) SetUserInGroup(userID int, inGroup bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		user.User.ID = userID
+	}
+	user.IsInGroup = inGroup
+	m.users[userID] = user
+	return nil
+}
+
+// GetMembership retrieves the membership of a user in a group from memory.
+func (m *MemoryStore) GetMembership(userID int64, groupID int64) (*Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	membership, ok := m.members[membershipKey{userID, groupID}]
+	if !ok {
+		return &Membership{}, ErrNotFound
+	}
+	return &membership, nil
+}
+
+// SaveMembership creates or replaces a membership in memory.
+func (m *MemoryStore) SaveMembership(membership Membership) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.members[membershipKey{membership.UserID, membership.GroupID}] = membership
+	return nil
+}
+
+// UserGroups retrieves from memory the memberships of the groups a user is currently in.
+func (m *MemoryStore) UserGroups(userID int64) ([]Membership, error) {
+	return m.findMemberships(func(ms Membership) bool { return ms.UserID == userID && ms.InGroup })
+}
+
+// GroupMembers retrieves from memory the memberships of the users currently in a group.
+func (m *MemoryStore) GroupMembers(groupID int64) ([]Membership, error) {
+	return m.findMemberships(func(ms Membership) bool { return ms.GroupID == groupID && ms.InGroup })
+}
+
+func (m *MemoryStore) findMemberships(match func(Membership) bool) ([]Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var memberships []Membership
+	for _, membership := range m.members {
+		if match(membership) {
+			memberships = append(memberships, membership)
+		}
+	}
+	return memberships, nil
+}
+
+// GetUser retrieves a user profile from memory.
+func (m *MemoryStore) GetUser(userID int) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return &User{}, errors.Wrap(errors.ErrUserNotFound, ErrNotFound)
+	}
+	return &user, nil
+}
+
+// LogChatMessage logs a chat message in memory.
+func (m *MemoryStore) LogChatMessage(chatMessage Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, chatMessage)
+	return nil
+}
+
+// Messages returns a copy of every logged chat message, oldest first.
+func (m *MemoryStore) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// SaveBeta saves a beta in memory.
+func (m *MemoryStore) SaveBeta(betaInfo Beta) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	betaInfo.History = append([]BetaTransition(nil), betaInfo.History...)
+	m.betas = append(m.betas, betaInfo)
+	return nil
+}
+
+// GetBeta retrieves the most recent beta application of a user from memory.
+func (m *MemoryStore) GetBeta(userID int64) (*Beta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest *Beta
+	for i := range m.betas {
+		if m.betas[i].UserID == userID && (latest == nil || !m.betas[i].Created.Before(latest.Created)) {
+			betaInfo := m.betas[i]
+			latest = &betaInfo
+		}
+	}
+	if latest == nil {
+		return &Beta{}, ErrNotFound
+	}
+	latest.History = append([]BetaTransition(nil), latest.History...)
+	return latest, nil
+}
+
+// GetBetaByID retrieves a beta application by its review identifier from memory.
+func (m *MemoryStore) GetBetaByID(id string) (*Beta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, betaInfo := range m.betas {
+		if betaInfo.ID != "" && betaInfo.ID == id {
+			betaInfo.History = append([]BetaTransition(nil), betaInfo.History...)
+			return &betaInfo, nil
+		}
+	}
+	return &Beta{}, ErrNotFound
+}
+
+// UpdateBeta replaces a reviewed beta application, matched by its identifier, in memory.
+func (m *MemoryStore) UpdateBeta(betaInfo Beta) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.betas {
+		if m.betas[i].ID != "" && m.betas[i].ID == betaInfo.ID {
+			betaInfo.History = append([]BetaTransition(nil), betaInfo.History...)
+			m.betas[i] = betaInfo
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// ListBetas retrieves the beta applications with one of the statuses from memory, oldest first.
+// Applications from before reviews have no identifier and are left out.
+func (m *MemoryStore) ListBetas(statuses ...string) ([]Beta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var betas []Beta
+	for _, betaInfo := range m.betas {
+		if betaInfo.ID == "" || (len(statuses) > 0 && !contains(statuses, betaInfo.Status)) {
+			continue
+		}
+		betaInfo.History = append([]BetaTransition(nil), betaInfo.History...)
+		betas = append(betas, betaInfo)
+	}
+	sort.SliceStable(betas, func(i, j int) bool {
+		return betas[i].Created.Before(betas[j].Created)
+	})
+	return betas, nil
+}
+
+// LoadWizardState retrieves a wizard state from memory.
+func (m *MemoryStore) LoadWizardState(wizard string, userID int64, chatID int64) (*WizardState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, ok := m.wizards[wizardKey{wizard, userID, chatID}]
+	if !ok {
+		return &WizardState{}, ErrNotFound
+	}
+	state.Answers = copyAnswers(state.Answers)
+	return &state, nil
+}
+
+// SaveWizardState creates or replaces a wizard state in memory.
+func (m *MemoryStore) SaveWizardState(state WizardState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state.Answers = copyAnswers(state.Answers)
+	m.wizards[wizardKey{state.Wizard, state.UserID, state.ChatID}] = state
+	return nil
+}
+
+// DeleteWizardState removes a wizard state from memory.
+func (m *MemoryStore) DeleteWizardState(wizard string, userID int64, chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.wizards, wizardKey{wizard, userID, chatID})
+	return nil
+}
+
+// GetChatStatus retrieves the delivery status of a chat from memory.
+func (m *MemoryStore) GetChatStatus(chatID int64) (*ChatStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status, ok := m.statuses[chatID]
+	if !ok {
+		return &ChatStatus{}, ErrNotFound
+	}
+	return &status, nil
+}
+
+// SaveChatStatus creates or replaces the delivery status of a chat in memory.
+func (m *MemoryStore) SaveChatStatus(status ChatStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.statuses[status.ChatID] = status
+	return nil
+}
+
+// SaveBroadcast creates or replaces a broadcast in memory.
+func (m *MemoryStore) SaveBroadcast(broadcast Broadcast) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.broadcasts[broadcast.ID] = broadcast
+	return nil
+}
+
+// GetBroadcast retrieves a broadcast from memory.
+func (m *MemoryStore) GetBroadcast(id string) (*Broadcast, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	broadcast, ok := m.broadcasts[id]
+	if !ok {
+		return &Broadcast{}, ErrNotFound
+	}
+	return &broadcast, nil
+}
+
+// ListBroadcasts retrieves the broadcasts in the given statuses from memory, newest first.
+func (m *MemoryStore) ListBroadcasts(statuses ...string) ([]Broadcast, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var broadcasts []Broadcast
+	for _, broadcast := range m.broadcasts {
+		if len(statuses) == 0 || contains(statuses, broadcast.Status) {
+			broadcasts = append(broadcasts, broadcast)
+		}
+	}
+	sort.Slice(broadcasts, func(i, j int) bool { return broadcasts[i].Created.After(broadcasts[j].Created) })
+	return broadcasts, nil
+}
+
+// Recipients returns the chat IDs in memory matching an audience.
+func (m *MemoryStore) Recipients(audience Audience) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []int64
+	if audience.Target == AudienceGroups {
+		for _, group := range m.groups {
+			if !audience.Active || group.IsActive {
+				ids = append(ids, group.GroupID)
+			}
+		}
+		return ids, nil
+	}
+
+	applied := make(map[int64]bool)
+	for _, betaInfo := range m.betas {
+		applied[betaInfo.UserID] = true
+	}
+	for _, user := range m.users {
+		switch {
+		case user.IsBot:
+		case audience.InGroup && !user.IsInGroup:
+		case !strings.HasPrefix(user.LanguageCode, audience.Language):
+		case audience.Beta == BetaApplied && !applied[int64(user.ID)]:
+		case audience.Beta == BetaNone && applied[int64(user.ID)]:
+		default:
+			ids = append(ids, int64(user.ID))
+		}
+	}
+	return ids, nil
+}
+
+// AddDeliveries records a pending delivery in memory for every recipient that has none yet.
+func (m *MemoryStore) AddDeliveries(broadcastID string, chatIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, chatID := range chatIDs {
+		key := deliveryKey{broadcastID, chatID}
+		if _, ok := m.deliveries[key]; !ok {
+			m.deliveries[key] = Delivery{BroadcastID: broadcastID, ChatID: chatID, Status: DeliveryPending, Updated: now}
+		}
+	}
+	return nil
+}
+
+// ListDeliveries retrieves the deliveries of a broadcast in the given statuses from memory.
+func (m *MemoryStore) ListDeliveries(broadcastID string, statuses ...string) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deliveries []Delivery
+	for key, delivery := range m.deliveries {
+		if key.broadcastID == broadcastID && (len(statuses) == 0 || contains(statuses, delivery.Status)) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ChatID < deliveries[j].ChatID })
+	return deliveries, nil
+}
+
+// SaveDelivery updates the state of a delivery in memory.
+func (m *MemoryStore) SaveDelivery(delivery Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliveries[deliveryKey{delivery.BroadcastID, delivery.ChatID}] = delivery
+	return nil
+}
+
+// SaveTicket creates or replaces a ticket in memory.
+func (m *MemoryStore) SaveTicket(ticket Ticket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ticket.Messages = append([]TicketMessage(nil), ticket.Messages...)
+	ticket.StaffMessageIDs = append([]int(nil), ticket.StaffMessageIDs...)
+	m.tickets[ticket.ID] = ticket
+	return nil
+}
+
+// GetTicket retrieves a ticket from memory.
+func (m *MemoryStore) GetTicket(id string) (*Ticket, error) {
+	return m.findTicket(func(t Ticket) bool { return t.ID == id })
+}
+
+// ActiveTicket retrieves the most recent open or pending ticket of a user from memory.
+func (m *MemoryStore) ActiveTicket(userID int64) (*Ticket, error) {
+	return m.findTicket(func(t Ticket) bool {
+		return t.UserID == userID && (t.Status == TicketOpen || t.Status == TicketPending)
+	})
+}
+
+// TicketByStaffMessage retrieves from memory the ticket a message in the staff chat belongs to.
+func (m *MemoryStore) TicketByStaffMessage(messageID int) (*Ticket, error) {
+	return m.findTicket(func(t Ticket) bool {
+		for _, id := range t.StaffMessageIDs {
+			if id == messageID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ListTickets retrieves the tickets in the given statuses from memory, oldest first.
+func (m *MemoryStore) ListTickets(statuses ...string) ([]Ticket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tickets []Ticket
+	for _, ticket := range m.tickets {
+		if len(statuses) == 0 || contains(statuses, ticket.Status) {
+			tickets = append(tickets, ticket)
+		}
+	}
+	sort.Slice(tickets, func(i, j int) bool { return tickets[i].Created.Before(tickets[j].Created) })
+	return tickets, nil
+}
+
+// findTicket returns the most recent ticket matching, or ErrNotFound.
+func (m *MemoryStore) findTicket(match func(Ticket) bool) (*Ticket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest *Ticket
+	for _, ticket := range m.tickets {
+		if match(ticket) && (latest == nil || ticket.Created.After(latest.Created)) {
+			t := ticket
+			latest = &t
+		}
+	}
+	if latest == nil {
+		return &Ticket{}, ErrNotFound
+	}
+	latest.Messages = append([]TicketMessage(nil), latest.Messages...)
+	latest.StaffMessageIDs = append([]int(nil), latest.StaffMessageIDs...)
+	return latest, nil
+}
+
+// GetPreferences retrieves the notification settings of a chat from memory.
+func (m *MemoryStore) GetPreferences(chatID int64) (*Preferences, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefs, ok := m.prefs[chatID]
+	if !ok {
+		return &Preferences{}, ErrNotFound
+	}
+	prefs.Muted = append([]string(nil), prefs.Muted...)
+	prefs.Subscribed = append([]string(nil), prefs.Subscribed...)
+	return &prefs, nil
+}
+
+// SavePreferences creates or replaces the notification settings of a chat in memory.
+func (m *MemoryStore) SavePreferences(prefs Preferences) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefs.Muted = append([]string(nil), prefs.Muted...)
+	prefs.Subscribed = append([]string(nil), prefs.Subscribed...)
+	m.prefs[prefs.ChatID] = prefs
+	return nil
+}
+
+// Subscribers returns the chats subscribed to a category from memory.
+func (m *MemoryStore) Subscribers(category string) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []int64
+	for chatID, prefs := range m.prefs {
+		if contains(prefs.Subscribed, category) {
+			ids = append(ids, chatID)
+		}
+	}
+	return ids, nil
+}
+
+// AddNewsItem stores an item in memory unless one with the same GUID exists.
+func (m *MemoryStore) AddNewsItem(item NewsItem) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.news[item.GUID]; exists {
+		return false, nil
+	}
+	m.news[item.GUID] = item
+	return true, nil
+}
+
+// ListNews retrieves news items from memory, newest first, skipping offset items.
+func (m *MemoryStore) ListNews(offset int, limit int) ([]NewsItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	items := make([]NewsItem, 0, len(m.news))
+	for _, item := range m.news {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].Published.Equal(items[j].Published) {
+			return items[i].Published.After(items[j].Published)
+		}
+		return items[i].GUID < items[j].GUID
+	})
+
+	if offset >= len(items) {
+		return nil, nil
+	}
+	items = items[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
+
+// CountNews counts the news items in memory from a source, or every item when source is empty.
+func (m *MemoryStore) CountNews(source string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, item := range m.news {
+		if source == "" || item.Source == source {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UnpushedNews retrieves from memory the items subscribers have not been sent yet, oldest first.
+func (m *MemoryStore) UnpushedNews() ([]NewsItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var items []NewsItem
+	for _, item := range m.news {
+		if !item.Pushed {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Published.Before(items[j].Published) })
+	return items, nil
+}
+
+// MarkNewsPushed records in memory that subscribers were sent an item.
+func (m *MemoryStore) MarkNewsPushed(guid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if item, ok := m.news[guid]; ok {
+		item.Pushed = true
+		m.news[guid] = item
+	}
+	return nil
+}
+
+// GetTutorialProgress retrieves a user's progress through a tutorial from memory.
+func (m *MemoryStore) GetTutorialProgress(userID int64, tutorial string) (*TutorialProgress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	progress, ok := m.progress[progressKey{userID, tutorial}]
+	if !ok {
+		return &TutorialProgress{}, ErrNotFound
+	}
+	progress.Seen = append([]string(nil), progress.Seen...)
+	progress.Completed = append([]string(nil), progress.Completed...)
+	return &progress, nil
+}
+
+// SaveTutorialProgress creates or replaces a user's progress through a tutorial in memory.
+func (m *MemoryStore) SaveTutorialProgress(progress TutorialProgress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	progress.Seen = append([]string(nil), progress.Seen...)
+	progress.Completed = append([]string(nil), progress.Completed...)
+	m.progress[progressKey{progress.UserID, progress.Tutorial}] = progress
+	return nil
+}
+
+// CountTutorialStep increments one of the Step* counters of a tutorial step in memory.
+func (m *MemoryStore) CountTutorialStep(tutorial string, version int, page string, counter string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := stepKey{tutorial, version, page}
+	stats, ok := m.steps[key]
+	if !ok {
+		stats = TutorialStepStats{Tutorial: tutorial, Version: version, Page: page}
+	}
+	switch counter {
+	case StepReached:
+		stats.Reached++
+	case StepCompleted:
+		stats.Completed++
+	}
+	m.steps[key] = stats
+	return nil
+}
+
+// TutorialStats retrieves the step counters of a tutorial version from memory.
+func (m *MemoryStore) TutorialStats(tutorial string, version int) ([]TutorialStepStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var stats []TutorialStepStats
+	for key, s := range m.steps {
+		if key.tutorial == tutorial && key.version == version {
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
+// GetDemoSession retrieves the demo conversation of a chat from memory.
+func (m *MemoryStore) GetDemoSession(chatID int64) (*DemoSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.demos[chatID]
+	if !ok {
+		return &DemoSession{}, ErrNotFound
+	}
+	session.Messages = append([]DemoTurn(nil), session.Messages...)
+	return &session, nil
+}
+
+// SaveDemoSession creates or replaces the demo conversation of a chat in memory.
+func (m *MemoryStore) SaveDemoSession(session DemoSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session.Messages = append([]DemoTurn(nil), session.Messages...)
+	m.demos[session.ChatID] = session
+	return nil
+}
+
+// DeleteDemoSession removes the demo conversation of a chat from memory.
+func (m *MemoryStore) DeleteDemoSession(chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.demos, chatID)
+	return nil
+}
+
+// GetDemoUsage returns how many demo messages a user sent on a day, from memory.
+func (m *MemoryStore) GetDemoUsage(userID int64, day string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.demoUsage[usageKey{userID, day}], nil
+}
+
+// ReserveDemoUsage counts one more demo message for a user on a day in
+// memory, unless the user already sent quota messages that day.
+func (m *MemoryStore) ReserveDemoUsage(userID int64, day string, quota int) (int, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := usageKey{userID, day}
+	if m.demoUsage[key] >= quota {
+		return m.demoUsage[key], false, nil
+	}
+	m.demoUsage[key]++
+	return m.demoUsage[key], true, nil
+}
+
+// ReleaseDemoUsage gives back a demo message reserved for a user on a day in memory.
+func (m *MemoryStore) ReleaseDemoUsage(userID int64, day string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key := (usageKey{userID, day}); m.demoUsage[key] > 0 {
+		m.demoUsage[key]--
+	}
+	return nil
+}
+
+// SaveSocialLink creates or replaces a link of the directory in memory.
+func (m *MemoryStore) SaveSocialLink(link SocialLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.social[link.Network] = link
+	return nil
+}
+
+// GetSocialLink retrieves a link of the directory from memory.
+func (m *MemoryStore) GetSocialLink(network string) (*SocialLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	link, ok := m.social[network]
+	if !ok {
+		return &SocialLink{}, ErrNotFound
+	}
+	return &link, nil
+}
+
+// DeleteSocialLink removes a link from the directory in memory.
+// It returns ErrNotFound when there is no such link.
+func (m *MemoryStore) DeleteSocialLink(network string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.social[network]; !ok {
+		return ErrNotFound
+	}
+	delete(m.social, network)
+	return nil
+}
+
+// ListSocialLinks retrieves the links of the directory from memory in button order.
+func (m *MemoryStore) ListSocialLinks() ([]SocialLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var links []SocialLink
+	for _, link := range m.social {
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Position != links[j].Position {
+			return links[i].Position < links[j].Position
+		}
+		return links[i].Network < links[j].Network
+	})
+	return links, nil
+}
+
+// CountSocialClick counts one click on a link from a chat in memory.
+func (m *MemoryStore) CountSocialClick(network string, chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := clickKey{network, chatID}
+	clicks := m.clicks[key]
+	clicks.Network, clicks.ChatID = network, chatID
+	clicks.Count++
+	clicks.Last = time.Now()
+	m.clicks[key] = clicks
+	return nil
+}
+
+// ListSocialClicks retrieves the click counts of every link and chat from memory, most clicked first.
+func (m *MemoryStore) ListSocialClicks() ([]SocialClicks, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var clicks []SocialClicks
+	for _, c := range m.clicks {
+		clicks = append(clicks, c)
+	}
+	sort.Slice(clicks, func(i, j int) bool {
+		return clicks[i].Count > clicks[j].Count
+	})
+	return clicks, nil
+}
+
+// SaveCohort creates or replaces a cohort in memory.
+func (m *MemoryStore) SaveCohort(cohort Cohort) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cohorts[cohort.Name] = cohort
+	return nil
+}
+
+// GetCohort retrieves a cohort from memory.
+func (m *MemoryStore) GetCohort(name string) (*Cohort, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cohort, ok := m.cohorts[name]
+	if !ok {
+		return &Cohort{}, ErrNotFound
+	}
+	return &cohort, nil
+}
+
+// ListCohorts retrieves every cohort from memory, oldest first.
+func (m *MemoryStore) ListCohorts() ([]Cohort, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var cohorts []Cohort
+	for _, cohort := range m.cohorts {
+		cohorts = append(cohorts, cohort)
+	}
+	sort.Slice(cohorts, func(i, j int) bool {
+		return cohorts[i].Created.Before(cohorts[j].Created)
+	})
+	return cohorts, nil
+}
+
+// SaveInvite creates or replaces an invite in memory.
+func (m *MemoryStore) SaveInvite(invite Invite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.invites[invite.Code] = invite
+	return nil
+}
+
+// GetInvite retrieves an invite by its code from memory.
+func (m *MemoryStore) GetInvite(code string) (*Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invite, ok := m.invites[code]
+	if !ok {
+		return &Invite{}, ErrNotFound
+	}
+	return &invite, nil
+}
+
+// ListInvites retrieves the invites to a cohort, or to every cohort when it is empty, from memory, oldest first.
+func (m *MemoryStore) ListInvites(cohort string) ([]Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var invites []Invite
+	for _, invite := range m.invites {
+		if cohort == "" || invite.Cohort == cohort {
+			invites = append(invites, invite)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].Created.Before(invites[j].Created)
+	})
+	return invites, nil
+}
+
+// RedeemInvite binds an unused, unrevoked and unexpired invite to the user in
+// memory. It returns ErrNotFound when the invite cannot be redeemed.
+func (m *MemoryStore) RedeemInvite(code string, userID int64, at time.Time) (*Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	invite, ok := m.invites[code]
+	if !ok || invite.RedeemedBy != 0 || invite.Revoked || !at.Before(invite.Expires) {
+		return &Invite{}, ErrNotFound
+	}
+	invite.RedeemedBy = userID
+	invite.Redeemed = at
+	m.invites[code] = invite
+	return &invite, nil
+}
+
+// contains reports whether s is one of values.
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// copyAnswers keeps callers from mutating stored answers through a shared map.
+func copyAnswers(answers map[string]string) map[string]string {
+	c := make(map[string]string, len(answers))
+	for k, v := range answers {
+		c[k] = v
+	}
+	return c
+}
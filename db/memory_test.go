@@ -0,0 +1,79 @@
+//db/memory_test.go
+
+package db
+
+import (
+	"testing"
+	errors "tg/errors"
+	"time"
+)
+
+func TestMemoryStoreNotFound(t *testing.T) {
+	store := NewMemoryStore()
+	lookups := map[string]func() error{
+		"GetGroup":        func() error { _, err := store.GetGroup(1); return err },
+		"GetUser":         func() error { _, err := store.GetUser(1); return err },
+		"GetMembership":   func() error { _, err := store.GetMembership(1, 2); return err },
+		"GetBeta":         func() error { _, err := store.GetBeta(1); return err },
+		"GetBetaByID":     func() error { _, err := store.GetBetaByID("x"); return err },
+		"LoadWizardState": func() error { _, err := store.LoadWizardState("beta", 1, 1); return err },
+		"GetTicket":       func() error { _, err := store.GetTicket("x"); return err },
+		"GetPreferences":  func() error { _, err := store.GetPreferences(1); return err },
+	}
+	for name, lookup := range lookups {
+		if err := lookup(); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s of a missing record = %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestMigrateChat(t *testing.T) {
+	const from, to = int64(-100), int64(-1001)
+	joined := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	store := NewMemoryStore()
+	store.SaveGroup(Group{GroupID: from, GroupName: "Old", Joined: joined, Welcome: Welcome{Enabled: true, Template: "Hi {first_name}"}})
+	// The report from the new chat was handled first and recorded it afresh
+	store.SaveGroup(Group{GroupID: to, GroupName: "New", IsActive: true, Joined: joined.Add(time.Hour)})
+	store.SaveMembership(Membership{UserID: 7, GroupID: from, InGroup: true})
+	store.SaveWizardState(WizardState{Wizard: "beta", UserID: 7, ChatID: from, OriginChatID: from, Step: "email"})
+	store.SaveTicket(Ticket{ID: "t1", UserID: 7, OriginChatID: from})
+	store.SavePreferences(Preferences{ChatID: from, Muted: []string{"news"}})
+	store.CountSocialClick("x", from)
+	store.CountSocialClick("x", to)
+
+	// Telegram reports the upgrade in both chats
+	for i := 0; i < 2; i++ {
+		if err := store.MigrateChat(from, to); err != nil {
+			t.Fatalf("MigrateChat: %v", err)
+		}
+	}
+
+	if _, err := store.GetGroup(from); !errors.IsNotFound(err) {
+		t.Errorf("old group still stored: %v", err)
+	}
+	group, err := store.GetGroup(to)
+	if err != nil {
+		t.Fatalf("GetGroup(to): %v", err)
+	}
+	if group.GroupName != "New" || !group.Joined.Equal(joined) || !group.Welcome.Enabled || group.Welcome.Template != "Hi {first_name}" {
+		t.Errorf("merged group = %+v, want the new name with the old welcome and join time", group)
+	}
+	if _, err := store.GetMembership(7, to); err != nil {
+		t.Errorf("membership not moved: %v", err)
+	}
+	state, err := store.LoadWizardState("beta", 7, to)
+	if err != nil || state.OriginChatID != to {
+		t.Errorf("wizard state = %+v, %v, want it moved", state, err)
+	}
+	if ticket, _ := store.GetTicket("t1"); ticket.OriginChatID != to {
+		t.Errorf("ticket origin = %d, want %d", ticket.OriginChatID, to)
+	}
+	if prefs, err := store.GetPreferences(to); err != nil || len(prefs.Muted) != 1 {
+		t.Errorf("preferences = %+v, %v, want them moved", prefs, err)
+	}
+	clicks, _ := store.ListSocialClicks()
+	if len(clicks) != 1 || clicks[0].ChatID != to || clicks[0].Count != 2 {
+		t.Errorf("clicks = %+v, want one record with both clicks", clicks)
+	}
+}
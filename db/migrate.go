@@ -0,0 +1,245 @@
+//db/migrate.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	errors "tg/errors"
+	"time"
+)
+
+// Migration records that the records of a chat were moved to the new chat ID
+// of the supergroup it was upgraded to. Telegram reports an upgrade twice, in
+// the old chat and in the new one, and the record lets only one of them move
+// the data.
+type Migration struct {
+	From    int64     `bson:"_id"`     // Chat ID of the group before the upgrade
+	To      int64     `bson:"to"`      // Chat ID of the supergroup
+	Started time.Time `bson:"started"` // Timestamp of when the move began
+}
+
+// mergeGroup returns the record of the supergroup a group was upgraded to,
+// starting from what is already known about the supergroup and keeping the
+// settings and history of the group that it lacks. target is nil when the
+// supergroup has no record yet.
+func mergeGroup(old Group, target *Group, to int64) Group {
+	if target == nil {
+		old.GroupID = to
+		old.Type = "supergroup"
+		old.Updated = time.Now()
+		return old
+	}
+
+	merged := *target
+	if merged.GroupName == "" {
+		merged.GroupName = old.GroupName
+	}
+	if merged.BotStatus == "" {
+		merged.BotStatus = old.BotStatus
+	}
+	if merged.MemberCount == 0 {
+		merged.MemberCount = old.MemberCount
+	}
+	if !old.Joined.IsZero() && (merged.Joined.IsZero() || old.Joined.Before(merged.Joined)) {
+		merged.Joined = old.Joined
+	}
+	if merged.Welcome.UpdatedBy == 0 && merged.Welcome.Template == "" {
+		merged.Welcome = old.Welcome
+	}
+	merged.Type = "supergroup"
+	merged.Updated = time.Now()
+	return merged
+}
+
+// MigrateChat moves a group that was upgraded to a supergroup to its new chat
+// ID, together with everything kept per chat: logged messages, beta
+// applications, memberships, wizard states, tickets, preferences, demo
+// sessions, social clicks and broadcast deliveries. Records the supergroup
+// already has are kept and the old ones merged into them or dropped. The move
+// is claimed first, so of the two reports of an upgrade only one does it. The
+// old chat's delivery status is dropped, as nothing can be sent to it anymore.
+func (db *DB) MigrateChat(from int64, to int64) error {
+	database := db.client.Database(db.name)
+	claims := database.Collection("migrations")
+	ctx, cancel := db.op()
+	_, err := claims.InsertOne(ctx, Migration{From: from, To: to, Started: time.Now()})
+	cancel()
+	if err != nil {
+		if errors.IsMongoDuplicateKey(err) {
+			return nil // Moved, or being moved, by the other report
+		}
+		return err
+	}
+
+	if err := db.migrate(database, from, to); err != nil {
+		// Let the next report of the upgrade try again
+		ctx, cancel := db.op()
+		defer cancel()
+		if _, cleanupErr := claims.DeleteOne(ctx, bson.M{"_id": from}); cleanupErr != nil {
+			return errors.Join(err, cleanupErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// migrate moves the records of a chat once the move was claimed. Every step
+// can be repeated, so a move that failed halfway is completed by the next one.
+// Each step has the timeout of a single operation.
+func (db *DB) migrate(database *mongo.Database, from int64, to int64) error {
+	for _, rename := range []struct{ collection, field string }{
+		{"messages", "groupid"},
+		{"beta", "groupid"},
+		{"tickets", "origin_chat_id"},
+		{"wizard_states", "origin_chat_id"},
+	} {
+		ctx, cancel := db.op()
+		_, err := database.Collection(rename.collection).UpdateMany(ctx, bson.M{rename.field: from}, bson.M{"$set": bson.M{rename.field: to}})
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, move := range []struct {
+		collection, field string
+		keys              []string
+	}{
+		{"memberships", "group_id", []string{"user_id"}},
+		{"wizard_states", "chat_id", []string{"wizard", "user_id"}},
+		{"preferences", "chat_id", nil},
+		{"demo_sessions", "chat_id", nil},
+		{"deliveries", "chat_id", []string{"broadcast_id"}},
+	} {
+		if err := db.moveKeyed(database.Collection(move.collection), move.field, from, to, move.keys...); err != nil {
+			return err
+		}
+	}
+
+	if err := db.mergeSocialClicks(database.Collection("social_clicks"), from, to); err != nil {
+		return err
+	}
+	if err := db.deleteOne(database.Collection("chat_status"), bson.M{"chat_id": from}); err != nil {
+		return err
+	}
+
+	group, err := db.GetGroup(from)
+	if errors.IsNotFound(err) {
+		return nil // Never recorded
+	}
+	if err != nil {
+		return err
+	}
+	var target *Group
+	if existing, err := db.GetGroup(to); err == nil {
+		target = existing
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+	if err := db.SaveGroup(mergeGroup(*group, target, to)); err != nil {
+		return err
+	}
+	return db.deleteOne(database.Collection("groups"), bson.M{"groupid": from})
+}
+
+// deleteOne deletes the first record of the collection matching filter.
+func (db *DB) deleteOne(collection *mongo.Collection, filter bson.M) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	_, err := collection.DeleteOne(ctx, filter)
+	return err
+}
+
+// moveKeyed sets field from the old chat ID to the new one on every record
+// of the collection, unless the new chat already has a record with the same
+// keys, in which case the old record is dropped.
+func (db *DB) moveKeyed(collection *mongo.Collection, field string, from int64, to int64, keys ...string) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{field: from})
+	if err != nil {
+		return err
+	}
+	var records []bson.M
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		existing := bson.M{field: to}
+		for _, key := range keys {
+			existing[key] = record[key]
+		}
+		count, err := collection.CountDocuments(ctx, existing)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			_, err = collection.DeleteOne(ctx, bson.M{"_id": record["_id"]})
+		} else {
+			_, err = collection.UpdateOne(ctx, bson.M{"_id": record["_id"]}, bson.M{"$set": bson.M{field: to}})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeSocialClicks adds the clicks counted in the old chat to those of the new one.
+//
+// The counts are added together with a mark of the old chat on the new
+// document, in one write that skips documents already marked. A migration
+// interrupted before the old document is deleted can then run again without
+// counting its clicks twice.
+func (db *DB) mergeSocialClicks(collection *mongo.Collection, from int64, to int64) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{"chat_id": from})
+	if err != nil {
+		return err
+	}
+	var clicks []SocialClicks
+	if err := cursor.All(ctx, &clicks); err != nil {
+		return err
+	}
+
+	for _, click := range clicks {
+		source := bson.M{"network": click.Network, "chat_id": from}
+		target := bson.M{"network": click.Network, "chat_id": to}
+
+		update := bson.M{
+			"$inc":      bson.M{"count": click.Count},
+			"$max":      bson.M{"last": click.Last},
+			"$addToSet": bson.M{"merged_from": from},
+		}
+		result, err := collection.UpdateOne(ctx, bson.M{"network": click.Network, "chat_id": to, "merged_from": bson.M{"$ne": from}}, update)
+		if err != nil {
+			return err
+		}
+
+		if result.MatchedCount == 0 {
+			count, err := collection.CountDocuments(ctx, target)
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				// Nothing to add to; the old document becomes the new one
+				moved := bson.M{"$set": bson.M{"chat_id": to}, "$addToSet": bson.M{"merged_from": from}}
+				if _, err := collection.UpdateOne(ctx, source, moved); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if _, err := collection.DeleteOne(ctx, source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
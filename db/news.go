@@ -0,0 +1,93 @@
+//db/news.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// NewsItem represents an entry ingested from a news source.
+type NewsItem struct {
+	GUID      string    `bson:"_id"`       // Identifier from the feed, or derived from the source, used to deduplicate
+	Source    string    `bson:"source"`    // Feed URL or markdown file the item came from
+	Title     string    `bson:"title"`     // Headline
+	Link      string    `bson:"link"`      // Page of the full story, may be empty
+	Summary   string    `bson:"summary"`   // Short plain-text description
+	Published time.Time `bson:"published"` // Publication time given by the source
+	Fetched   time.Time `bson:"fetched"`   // Timestamp of when the item was first ingested
+	Pushed    bool      `bson:"pushed"`    // Whether subscribers were sent the item, or it predates pushing
+}
+
+// AddNewsItem stores an item unless one with the same GUID exists.
+// It reports whether the item was new.
+func (db *DB) AddNewsItem(item NewsItem) (bool, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("news")
+	opts := options.Update().SetUpsert(true)
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": item.GUID}, bson.M{"$setOnInsert": item}, opts)
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// ListNews retrieves news items, newest first, skipping offset items.
+func (db *DB) ListNews(offset int, limit int) ([]NewsItem, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("news")
+	opts := options.Find().SetSort(bson.D{{Key: "published", Value: -1}, {Key: "_id", Value: 1}}).SetSkip(int64(offset)).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []NewsItem
+	err = cursor.All(ctx, &items)
+	return items, err
+}
+
+// CountNews counts the news items from a source, or every item when source is empty.
+func (db *DB) CountNews(source string) (int, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	filter := bson.M{}
+	if source != "" {
+		filter["source"] = source
+	}
+	count, err := db.client.Database(db.name).Collection("news").CountDocuments(ctx, filter)
+	return int(count), err
+}
+
+// UnpushedNews retrieves the items subscribers have not been sent yet, oldest first.
+func (db *DB) UnpushedNews() ([]NewsItem, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("news")
+	opts := options.Find().SetSort(bson.M{"published": 1})
+	cursor, err := collection.Find(ctx, bson.M{"pushed": false}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []NewsItem
+	err = cursor.All(ctx, &items)
+	return items, err
+}
+
+// MarkNewsPushed records that subscribers were sent an item.
+func (db *DB) MarkNewsPushed(guid string) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("news")
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": guid}, bson.M{"$set": bson.M{"pushed": true}})
+	return err
+}
@@ -0,0 +1,66 @@
+//db/preferences.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Notification categories that can be muted.
+const (
+	CategoryBroadcasts = "broadcasts" // Announcements sent with /broadcast
+	CategoryNews       = "news"       // Items from the news feeds
+	CategoryReminders  = "reminders"  // Scheduled reminders
+)
+
+// Categories lists every notification category.
+// Nothing sends reminders yet; chats can still mute them ahead of time, and a
+// reminder sender must consult mute.Check with CategoryReminders like the others.
+var Categories = []string{CategoryBroadcasts, CategoryNews, CategoryReminders}
+
+// Preferences holds the notification settings of a chat: a user's private
+// chat, keyed by their user ID, or a group.
+type Preferences struct {
+	ChatID       int64     `bson:"chat_id"`       // Chat the settings apply to
+	Muted        []string  `bson:"muted"`         // Categories that are never sent
+	Subscribed   []string  `bson:"subscribed"`    // Opt-in categories the chat asked for, such as news
+	Timezone     string    `bson:"timezone"`      // IANA name or UTC offset the quiet hours are in, empty for UTC
+	QuietStart   int       `bson:"quiet_start"`   // Start of the quiet hours in minutes after midnight
+	QuietEnd     int       `bson:"quiet_end"`     // End of the quiet hours in minutes after midnight, equal to QuietStart when unset
+	SnoozedUntil time.Time `bson:"snoozed_until"` // Nothing is sent before this time
+	UpdatedBy    int64     `bson:"updated_by"`    // User who last changed the settings
+	Updated      time.Time `bson:"updated"`       // Timestamp of the last change
+}
+
+// GetPreferences retrieves the notification settings of a chat from the database.
+func (db *DB) GetPreferences(chatID int64) (*Preferences, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("preferences")
+	prefs := &Preferences{}
+	err := collection.FindOne(ctx, bson.M{"chat_id": chatID}).Decode(prefs)
+	return prefs, notFound(err)
+}
+
+// SavePreferences creates or replaces the notification settings of a chat in the database.
+func (db *DB) SavePreferences(prefs Preferences) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("preferences")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"chat_id": prefs.ChatID}, prefs, opts)
+	return err
+}
+
+// Subscribers returns the chats subscribed to a category.
+func (db *DB) Subscribers(category string) ([]int64, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	ids, err := db.client.Database(db.name).Collection("preferences").Distinct(ctx, "chat_id", bson.M{"subscribed": category})
+	return toChatIDs(ids), err
+}
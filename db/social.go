@@ -0,0 +1,114 @@
+//db/social.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// SocialLink is an entry of the /social directory.
+type SocialLink struct {
+	Network   string    `bson:"_id"`        // Short name used in commands and click counts, e.g. "twitter"
+	Label     string    `bson:"label"`      // Text of the button
+	URL       string    `bson:"url"`        // Page the button leads to
+	Position  int       `bson:"position"`   // Order of the button, lowest first
+	UpdatedBy int64     `bson:"updated_by"` // Admin who last changed the link
+	Updated   time.Time `bson:"updated"`    // Timestamp of the last change
+}
+
+// SocialClicks counts the clicks on a link coming from one chat.
+type SocialClicks struct {
+	Network string    `bson:"network"` // Link that was clicked
+	ChatID  int64     `bson:"chat_id"` // Chat /social was shown in, 0 when unknown
+	Count   int       `bson:"count"`   // Number of clicks
+	Last    time.Time `bson:"last"`    // Timestamp of the latest click
+
+	MergedFrom []int64 `bson:"merged_from,omitempty"` // Upgraded chats whose clicks were added to these
+}
+
+// SaveSocialLink creates or replaces a link of the directory in the database.
+func (db *DB) SaveSocialLink(link SocialLink) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("social_links")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": link.Network}, link, opts)
+	return err
+}
+
+// GetSocialLink retrieves a link of the directory from the database.
+func (db *DB) GetSocialLink(network string) (*SocialLink, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("social_links")
+	link := &SocialLink{}
+	err := collection.FindOne(ctx, bson.M{"_id": network}).Decode(link)
+	return link, notFound(err)
+}
+
+// DeleteSocialLink removes a link from the directory in the database.
+// It returns ErrNotFound when there is no such link.
+func (db *DB) DeleteSocialLink(network string) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("social_links")
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": network})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListSocialLinks retrieves the links of the directory from the database in button order.
+func (db *DB) ListSocialLinks() ([]SocialLink, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("social_links")
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []SocialLink
+	err = cursor.All(ctx, &links)
+	return links, err
+}
+
+// CountSocialClick counts one click on a link from a chat in the database.
+func (db *DB) CountSocialClick(network string, chatID int64) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("social_clicks")
+	opts := options.Update().SetUpsert(true)
+	update := bson.M{"$inc": bson.M{"count": 1}, "$set": bson.M{"last": time.Now()}}
+	_, err := collection.UpdateOne(ctx, bson.M{"network": network, "chat_id": chatID}, update, opts)
+	return err
+}
+
+// ListSocialClicks retrieves the click counts of every link and chat from the database, most clicked first.
+func (db *DB) ListSocialClicks() ([]SocialClicks, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("social_clicks")
+	opts := options.Find().SetSort(bson.D{{Key: "count", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var clicks []SocialClicks
+	err = cursor.All(ctx, &clicks)
+	return clicks, err
+}
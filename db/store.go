@@ -0,0 +1,132 @@
+//db/store.go
+
+package db
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	errors "tg/errors"
+	"time"
+)
+
+// ErrNotFound is returned by every Store when a lookup matches nothing,
+// possibly wrapped together with a more specific sentinel such as
+// errors.ErrGroupNotFound. Check for it with errors.IsNotFound.
+var ErrNotFound = errors.ErrNotFound
+
+// notFound marks the driver's error for a lookup that matched nothing as
+// ErrNotFound, keeping it as the cause. Other errors are returned unchanged.
+func notFound(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errors.Wrap(ErrNotFound, err)
+	}
+	return err
+}
+
+// Store is the persistence layer used by the handlers.
+// DB implements it on top of MongoDB and MemoryStore keeps everything in memory.
+type Store interface {
+	// Close releases the underlying connection once every pending write is done.
+	Close(ctx context.Context) error
+
+	// Groups
+	SaveGroup(group Group) error
+	GetGroup(groupID int64) (*Group, error)
+	UpdateGroup(group Group) error
+	DeactivateGroup(groupID int64) error
+	MigrateChat(from int64, to int64) error
+
+	// Users
+	LogUserProfile(userProfile User) error
+	GetUser(userID int) (*User, error)
+	SetUserInGroup(userID int, inGroup bool) error
+
+	// Memberships
+	GetMembership(userID int64, groupID int64) (*Membership, error)
+	SaveMembership(membership Membership) error
+	UserGroups(userID int64) ([]Membership, error)
+	GroupMembers(groupID int64) ([]Membership, error)
+
+	// Messages
+	LogChatMessage(chatMessage Message) error
+
+	// Beta applications
+	SaveBeta(betaInfo Beta) error
+	GetBeta(userID int64) (*Beta, error)
+	GetBetaByID(id string) (*Beta, error)
+	UpdateBeta(betaInfo Beta) error
+	ListBetas(statuses ...string) ([]Beta, error)
+
+	// Beta cohorts
+	SaveCohort(cohort Cohort) error
+	GetCohort(name string) (*Cohort, error)
+	ListCohorts() ([]Cohort, error)
+	SaveInvite(invite Invite) error
+	GetInvite(code string) (*Invite, error)
+	ListInvites(cohort string) ([]Invite, error)
+	RedeemInvite(code string, userID int64, at time.Time) (*Invite, error)
+
+	// Wizard progress
+	LoadWizardState(wizard string, userID int64, chatID int64) (*WizardState, error)
+	SaveWizardState(state WizardState) error
+	DeleteWizardState(wizard string, userID int64, chatID int64) error
+
+	// Delivery status
+	GetChatStatus(chatID int64) (*ChatStatus, error)
+	SaveChatStatus(status ChatStatus) error
+
+	// Broadcasts
+	SaveBroadcast(broadcast Broadcast) error
+	GetBroadcast(id string) (*Broadcast, error)
+	ListBroadcasts(statuses ...string) ([]Broadcast, error)
+	Recipients(audience Audience) ([]int64, error)
+	AddDeliveries(broadcastID string, chatIDs []int64) error
+	ListDeliveries(broadcastID string, statuses ...string) ([]Delivery, error)
+	SaveDelivery(delivery Delivery) error
+
+	// Support tickets
+	SaveTicket(ticket Ticket) error
+	GetTicket(id string) (*Ticket, error)
+	ActiveTicket(userID int64) (*Ticket, error)
+	TicketByStaffMessage(messageID int) (*Ticket, error)
+	ListTickets(statuses ...string) ([]Ticket, error)
+
+	// Notification preferences
+	GetPreferences(chatID int64) (*Preferences, error)
+	SavePreferences(prefs Preferences) error
+	Subscribers(category string) ([]int64, error)
+
+	// News
+	AddNewsItem(item NewsItem) (bool, error)
+	ListNews(offset int, limit int) ([]NewsItem, error)
+	CountNews(source string) (int, error)
+	UnpushedNews() ([]NewsItem, error)
+	MarkNewsPushed(guid string) error
+
+	// Tutorials
+	GetTutorialProgress(userID int64, tutorial string) (*TutorialProgress, error)
+	SaveTutorialProgress(progress TutorialProgress) error
+	CountTutorialStep(tutorial string, version int, page string, counter string) error
+	TutorialStats(tutorial string, version int) ([]TutorialStepStats, error)
+
+	// Demo
+	GetDemoSession(chatID int64) (*DemoSession, error)
+	SaveDemoSession(session DemoSession) error
+	DeleteDemoSession(chatID int64) error
+	GetDemoUsage(userID int64, day string) (int, error)
+	ReserveDemoUsage(userID int64, day string, quota int) (int, bool, error)
+	ReleaseDemoUsage(userID int64, day string) error
+
+	// Social links
+	SaveSocialLink(link SocialLink) error
+	GetSocialLink(network string) (*SocialLink, error)
+	DeleteSocialLink(network string) error
+	ListSocialLinks() ([]SocialLink, error)
+	CountSocialClick(network string, chatID int64) error
+	ListSocialClicks() ([]SocialClicks, error)
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
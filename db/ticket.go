@@ -0,0 +1,122 @@
+//db/ticket.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Ticket statuses.
+const (
+	TicketOpen     = "open"     // Waiting for the support team
+	TicketPending  = "pending"  // Waiting for the user
+	TicketResolved = "resolved" // Closed by the support team
+)
+
+// Attachment kinds.
+const (
+	AttachmentPhoto    = "photo"
+	AttachmentDocument = "document"
+	AttachmentVideo    = "video"
+	AttachmentVoice    = "voice"
+	AttachmentAudio    = "audio"
+)
+
+// Ticket represents a support request and its conversation.
+type Ticket struct {
+	ID              string          `bson:"_id"`               // Short random identifier shown to users and staff
+	UserID          int64           `bson:"user_id"`           // User who opened the ticket
+	Username        string          `bson:"username"`          // Username of the user at creation
+	Name            string          `bson:"name"`              // Display name of the user at creation
+	OriginChatID    int64           `bson:"origin_chat_id"`    // Chat /submit was sent in
+	Subject         string          `bson:"subject"`           // First line of the first message
+	Status          string          `bson:"status"`            // One of the Ticket* statuses
+	AssigneeID      int64           `bson:"assignee_id"`       // Staff member handling the ticket, 0 when unassigned
+	AssigneeName    string          `bson:"assignee_name"`     // Display name of the assignee
+	Messages        []TicketMessage `bson:"messages"`          // Conversation, oldest first
+	StaffMessageIDs []int           `bson:"staff_message_ids"` // Messages in the staff chat that belong to the ticket
+	Created         time.Time       `bson:"created"`           // Timestamp of when the ticket was opened
+	Updated         time.Time       `bson:"updated"`           // Timestamp of the last change
+	FirstResponse   time.Time       `bson:"first_response"`    // Timestamp of the first staff reply
+	LastUserReply   time.Time       `bson:"last_user_reply"`   // Timestamp of the last message from the user
+	LastStaffReply  time.Time       `bson:"last_staff_reply"`  // Timestamp of the last message from staff
+	Resolved        time.Time       `bson:"resolved"`          // Timestamp of when the ticket was last resolved
+}
+
+// TicketMessage is one message of a ticket's conversation.
+type TicketMessage struct {
+	FromID      int64        `bson:"from_id"`     // Sender
+	FromStaff   bool         `bson:"from_staff"`  // Whether a staff member sent it
+	Text        string       `bson:"text"`        // Text or caption
+	Attachments []Attachment `bson:"attachments"` // Files sent with the message
+	Sent        time.Time    `bson:"sent"`        // Timestamp of the message
+}
+
+// Attachment is a file sent with a ticket message, kept as a Telegram file ID.
+type Attachment struct {
+	Kind     string `bson:"kind"`      // One of the Attachment* kinds
+	FileID   string `bson:"file_id"`   // Telegram file ID, reusable by the bot
+	FileName string `bson:"file_name"` // Original name, for documents
+}
+
+// SaveTicket creates or replaces a ticket in the database.
+func (db *DB) SaveTicket(ticket Ticket) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("tickets")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": ticket.ID}, ticket, opts)
+	return err
+}
+
+// GetTicket retrieves a ticket from the database.
+func (db *DB) GetTicket(id string) (*Ticket, error) {
+	return db.findTicket(bson.M{"_id": id})
+}
+
+// ActiveTicket retrieves the most recent open or pending ticket of a user.
+func (db *DB) ActiveTicket(userID int64) (*Ticket, error) {
+	return db.findTicket(bson.M{"user_id": userID, "status": bson.M{"$in": []string{TicketOpen, TicketPending}}})
+}
+
+// TicketByStaffMessage retrieves the ticket a message in the staff chat belongs to.
+func (db *DB) TicketByStaffMessage(messageID int) (*Ticket, error) {
+	return db.findTicket(bson.M{"staff_message_ids": messageID})
+}
+
+// ListTickets retrieves the tickets in the given statuses, oldest first.
+// With no statuses it returns every ticket.
+func (db *DB) ListTickets(statuses ...string) ([]Ticket, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("tickets")
+	filter := bson.M{}
+	if len(statuses) > 0 {
+		filter["status"] = bson.M{"$in": statuses}
+	}
+
+	opts := options.Find().SetSort(bson.M{"created": 1})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var tickets []Ticket
+	err = cursor.All(ctx, &tickets)
+	return tickets, err
+}
+
+func (db *DB) findTicket(filter bson.M) (*Ticket, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("tickets")
+	ticket := &Ticket{}
+	opts := options.FindOne().SetSort(bson.M{"created": -1})
+	err := collection.FindOne(ctx, filter, opts).Decode(ticket)
+	return ticket, notFound(err)
+}
@@ -0,0 +1,88 @@
+//db/tutorial.go
+
+package db
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Tutorial step counters.
+const (
+	StepReached   = "reached"   // Users who saw the step
+	StepCompleted = "completed" // Users who moved past the step
+)
+
+// TutorialProgress records how far a user got through a tutorial.
+type TutorialProgress struct {
+	UserID    int64     `bson:"user_id"`   // User following the tutorial
+	Tutorial  string    `bson:"tutorial"`  // Tutorial name
+	Version   int       `bson:"version"`   // Content version the progress refers to
+	Page      string    `bson:"page"`      // ID of the page the user is on
+	Seen      []string  `bson:"seen"`      // IDs of the pages the user has reached
+	Completed []string  `bson:"completed"` // IDs of the pages the user has moved past
+	Finished  bool      `bson:"finished"`  // Whether the user reached the end
+	Started   time.Time `bson:"started"`   // Timestamp of when the user started this version
+	Updated   time.Time `bson:"updated"`   // Timestamp of the last page change
+}
+
+// TutorialStepStats counts the users reaching and completing one step of a tutorial version.
+type TutorialStepStats struct {
+	Tutorial  string `bson:"tutorial"`
+	Version   int    `bson:"version"`
+	Page      string `bson:"page"`
+	Reached   int    `bson:"reached"`
+	Completed int    `bson:"completed"`
+}
+
+// GetTutorialProgress retrieves a user's progress through a tutorial from the database.
+func (db *DB) GetTutorialProgress(userID int64, tutorial string) (*TutorialProgress, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("tutorial_progress")
+	progress := &TutorialProgress{}
+	err := collection.FindOne(ctx, bson.M{"user_id": userID, "tutorial": tutorial}).Decode(progress)
+	return progress, notFound(err)
+}
+
+// SaveTutorialProgress creates or replaces a user's progress through a tutorial in the database.
+func (db *DB) SaveTutorialProgress(progress TutorialProgress) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("tutorial_progress")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.M{"user_id": progress.UserID, "tutorial": progress.Tutorial}
+	_, err := collection.ReplaceOne(ctx, filter, progress, opts)
+	return err
+}
+
+// CountTutorialStep increments one of the Step* counters of a tutorial step in the database.
+func (db *DB) CountTutorialStep(tutorial string, version int, page string, counter string) error {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("tutorial_stats")
+	opts := options.Update().SetUpsert(true)
+	filter := bson.M{"tutorial": tutorial, "version": version, "page": page}
+	_, err := collection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{counter: 1}}, opts)
+	return err
+}
+
+// TutorialStats retrieves the step counters of a tutorial version from the database.
+func (db *DB) TutorialStats(tutorial string, version int) ([]TutorialStepStats, error) {
+	ctx, cancel := db.op()
+	defer cancel()
+
+	collection := db.client.Database(db.name).Collection("tutorial_stats")
+	cursor, err := collection.Find(ctx, bson.M{"tutorial": tutorial, "version": version})
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []TutorialStepStats
+	err = cursor.All(ctx, &stats)
+	return stats, err
+}
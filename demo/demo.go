@@ -0,0 +1,322 @@
+//demo/demo.go
+
+package demo
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"strings"
+	"sync"
+	config "tg/config"
+	db "tg/db"
+	errors "tg/errors"
+	llm "tg/llm"
+	locks "tg/locks"
+	router "tg/router"
+	sender "tg/sender"
+	"time"
+)
+
+// idleTimeout ends demo mode in a chat nobody has written to for a while,
+// so later messages are not mistaken for demo prompts.
+const idleTimeout = 30 * time.Minute
+
+// replyTimeout bounds how long the provider may take to answer.
+const replyTimeout = 60 * time.Second
+
+// maxReply is the number of characters of an answer shown, leaving room for the banner.
+const maxReply = 3800
+
+// systemPrompt sets up the provider for every conversation.
+const systemPrompt = "You are the demo of a Telegram bot's chat assistant, shown to people trying the beta. Keep answers friendly and under 200 words."
+
+// Demo lets users try the chat assistant of the beta against the configured
+// model provider. In a private chat /demo turns on demo mode, in which every
+// message is a prompt; anywhere, /demo followed by text asks one question.
+// Each chat keeps its recent turns as context, and each user has a daily quota.
+//
+// Answers are written in the background and sent when they are ready, as the
+// provider can take far longer than the dispatch worker of a chat should be
+// held up for.
+type Demo struct {
+	store    db.Store
+	out      *sender.Sender
+	provider llm.Provider
+	cfg      config.DemoConfig
+	chats    locks.Keyed[int64] // Held while a chat's session is read and saved
+
+	ctx     context.Context // Cancelled by Stop to interrupt the provider
+	stop    context.CancelFunc
+	mu      sync.Mutex
+	stopped bool           // Set by Stop; no more answers are started
+	wg      sync.WaitGroup // Answers being written
+}
+
+// New creates the demo on top of the provider selected by cfg.
+func New(store db.Store, out *sender.Sender, cfg config.DemoConfig) *Demo {
+	provider, err := llm.New(cfg)
+	if err != nil {
+		log.Printf("Failed to set up the demo provider, using the stub: %v", err)
+		provider = llm.Stub{}
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &Demo{store: store, out: out, provider: provider, cfg: cfg, ctx: ctx, stop: stop}
+}
+
+// Stop interrupts the answers being written and waits for them to return.
+func (d *Demo) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	d.stopped = true
+	d.mu.Unlock()
+	d.stop()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Register adds the /demo command to the router.
+func (d *Demo) Register(r *router.Router) {
+	r.MustRegister(router.Command{
+		Name:        "demo",
+		Description: "Get a demo of the bot's functionalities",
+		Usage:       "[message|stop|reset]",
+		MaxArgs:     -1,
+		Handler:     d.handleCommand,
+	})
+}
+
+// handleCommand turns demo mode on or off, forgets the conversation, or asks one question.
+func (d *Demo) handleCommand(ctx *router.Context) (tgbotapi.Chattable, error) {
+	if d.cfg.DailyQuota == 0 {
+		return ctx.Reply("The demo is not available right now."), nil
+	}
+	chatID := ctx.Message.Chat.ID
+	userID := int64(ctx.Message.From.ID)
+
+	switch strings.ToLower(strings.TrimSpace(ctx.RawArgs)) {
+	case "":
+		if !ctx.Message.Chat.IsPrivate() {
+			return ctx.Reply("Send /demo followed by your message to try the assistant here, or open a private chat with me for a conversation."), nil
+		}
+		err := d.update(chatID, func(session *db.DemoSession) {
+			session.Active = true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		left, err := d.left(userID)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Reply(fmt.Sprintf("%s\n\nDemo mode is on: send me anything and the demo assistant will answer. "+
+			"Replies are samples of the beta, not the full product. You have %d of %d messages left today.\n\n"+
+			"Send /demo reset to start a new conversation, or /demo stop to leave.", d.banner(), left, d.cfg.DailyQuota)), nil
+	case "stop":
+		unlock := d.chats.Lock(chatID)
+		err := d.store.DeleteDemoSession(chatID)
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Reply("Demo mode is off and the conversation is forgotten. Send /beta to apply for the full product."), nil
+	case "reset":
+		err := d.update(chatID, func(session *db.DemoSession) {
+			session.Messages = nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Reply("The demo assistant has forgotten this conversation."), nil
+	}
+
+	return d.ask(chatID, userID, strings.TrimSpace(ctx.RawArgs))
+}
+
+// HandleMessage answers a plain message in a private chat that is in demo mode.
+// The boolean result reports whether the message was a demo prompt.
+func (d *Demo) HandleMessage(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	message := update.Message
+	if d.cfg.DailyQuota == 0 || message == nil || message.From == nil || message.Chat == nil || !message.Chat.IsPrivate() {
+		return nil, false, nil
+	}
+	text := strings.TrimSpace(message.Text)
+	if text == "" {
+		return nil, false, nil
+	}
+
+	session, err := d.store.GetDemoSession(message.Chat.ID)
+	if errors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if !session.Active {
+		return nil, false, nil
+	}
+	if time.Since(session.Updated) > idleTimeout {
+		session.Active = false
+		if err := d.store.SaveDemoSession(*session); err != nil {
+			errors.HandleError(err)
+		}
+		return nil, false, nil
+	}
+
+	response, err := d.ask(message.Chat.ID, int64(message.From.ID), text)
+	return response, true, err
+}
+
+// ask reserves a message of the user's quota and has the provider answer
+// the prompt in the background. The answer is sent when it is ready.
+func (d *Demo) ask(chatID int64, userID int64, prompt string) (tgbotapi.Chattable, error) {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return tgbotapi.NewMessage(chatID, "The demo assistant is not available right now."), nil
+	}
+	d.wg.Add(1)
+	d.mu.Unlock()
+
+	day := today()
+	used, reserved, err := d.store.ReserveDemoUsage(userID, day, d.cfg.DailyQuota)
+	if err != nil || !reserved {
+		d.wg.Done()
+		if err != nil {
+			return nil, err
+		}
+		return tgbotapi.NewMessage(chatID, fmt.Sprintf("You have used your %d demo messages for today. Come back tomorrow, or send /beta to apply for full access.", d.cfg.DailyQuota)), nil
+	}
+
+	// Best effort; the answer is sent regardless
+	if _, err := d.out.Send(tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping)); err != nil {
+		log.Printf("Failed to show typing in %d: %v", chatID, err)
+	}
+
+	go func() {
+		defer d.wg.Done()
+		if err := d.answer(chatID, userID, day, prompt, d.cfg.DailyQuota-used); err != nil {
+			log.Printf("Failed to answer a demo prompt in %d: %v", chatID, errors.HandleError(err))
+		}
+	}()
+	return nil, nil
+}
+
+// answer sends a prompt with the chat's context to the provider and sends the
+// answer under the demo banner. A prompt the provider could not answer is
+// given back to the user's quota of the day. The turn is not kept if /demo stop
+// deleted the session while the answer was written.
+func (d *Demo) answer(chatID int64, userID int64, day string, prompt string, left int) error {
+	session, err := d.store.GetDemoSession(chatID)
+	existed := err == nil
+	if errors.IsNotFound(err) {
+		session, err = &db.DemoSession{ChatID: chatID, Started: time.Now()}, nil
+	}
+	if err != nil {
+		d.release(userID, day)
+		return err
+	}
+	messages := []llm.Message{{Role: llm.RoleSystem, Content: systemPrompt}}
+	for _, turn := range session.Messages {
+		messages = append(messages, llm.Message{Role: turn.Role, Content: turn.Content})
+	}
+	messages = append(messages, llm.Message{Role: llm.RoleUser, Content: prompt})
+
+	ctx, cancel := context.WithTimeout(d.ctx, replyTimeout)
+	answer, err := d.provider.Complete(ctx, messages)
+	cancel()
+	if err != nil {
+		log.Printf("Demo provider %s failed for chat %d: %v", d.provider.Name(), chatID, err)
+		d.release(userID, day)
+		_, err = d.out.Send(tgbotapi.NewMessage(chatID, "The demo assistant could not answer right now. Please try again in a moment."))
+		return err
+	}
+
+	unlock := d.chats.Lock(chatID)
+	session, saveErr := d.store.GetDemoSession(chatID)
+	if errors.IsNotFound(saveErr) && !existed {
+		session, saveErr = &db.DemoSession{ChatID: chatID, Started: time.Now()}, nil
+	}
+	if saveErr == nil {
+		session.Messages = append(session.Messages,
+			db.DemoTurn{Role: llm.RoleUser, Content: prompt},
+			db.DemoTurn{Role: llm.RoleAssistant, Content: answer},
+		)
+		if extra := len(session.Messages) - d.cfg.ContextTurns; extra > 0 {
+			session.Messages = session.Messages[extra:]
+		}
+		session.Updated = time.Now()
+		saveErr = d.store.SaveDemoSession(*session)
+	} else if errors.IsNotFound(saveErr) {
+		saveErr = nil
+	}
+	unlock()
+
+	// The answer is sent even if the conversation could not be saved
+	if r := []rune(answer); len(r) > maxReply {
+		answer = string(r[:maxReply-1]) + "…"
+	}
+	_, err = d.out.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("%s\n\n%s\n\n%d of %d demo messages left today.", d.banner(), answer, left, d.cfg.DailyQuota)))
+	return errors.Join(saveErr, err)
+}
+
+// release gives a reserved message back to the user's quota of the day.
+func (d *Demo) release(userID int64, day string) {
+	if err := d.store.ReleaseDemoUsage(userID, day); err != nil {
+		log.Printf("Failed to give back a demo message of %d: %v", userID, errors.HandleError(err))
+	}
+}
+
+// load returns the chat's demo session, or a new inactive one.
+func (d *Demo) load(chatID int64) (*db.DemoSession, error) {
+	session, err := d.store.GetDemoSession(chatID)
+	if errors.IsNotFound(err) {
+		return &db.DemoSession{ChatID: chatID, Started: time.Now()}, nil
+	}
+	return session, err
+}
+
+// update applies change to the chat's session, or a new one, and saves it.
+func (d *Demo) update(chatID int64, change func(*db.DemoSession)) error {
+	unlock := d.chats.Lock(chatID)
+	defer unlock()
+
+	session, err := d.load(chatID)
+	if err != nil {
+		return err
+	}
+	change(session)
+	session.Updated = time.Now()
+	return d.store.SaveDemoSession(*session)
+}
+
+// left returns how many demo messages the user may still send today.
+func (d *Demo) left(userID int64) (int, error) {
+	used, err := d.store.GetDemoUsage(userID, today())
+	if err != nil {
+		return 0, err
+	}
+	return d.cfg.DailyQuota - used, nil
+}
+
+// banner marks every demo reply, naming the provider that wrote it.
+func (d *Demo) banner() string {
+	return fmt.Sprintf("[DEMO · %s]", d.provider.Name())
+}
+
+// today returns the UTC day quotas are counted on.
+func today() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
@@ -0,0 +1,159 @@
+//demo/demo_test.go
+
+package demo
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strings"
+	"sync"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	llm "tg/llm"
+	sender "tg/sender"
+)
+
+// fakeAPI records the messages that are sent.
+type fakeAPI struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := c.(tgbotapi.MessageConfig); ok {
+		f.sent = append(f.sent, m.Text)
+	}
+	return tgbotapi.Message{MessageID: 1}, nil
+}
+
+func (f *fakeAPI) count(substr string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, text := range f.sent {
+		if strings.Contains(text, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+// gatedProvider answers once release is closed, or fails if err is set.
+type gatedProvider struct {
+	release chan struct{}
+	err     error
+	mu      sync.Mutex
+	calls   int
+}
+
+func (p *gatedProvider) Name() string {
+	return "gated"
+}
+
+func (p *gatedProvider) Complete(ctx context.Context, messages []llm.Message) (string, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if p.err != nil {
+		return "", p.err
+	}
+	return "answer to " + messages[len(messages)-1].Content, nil
+}
+
+func newDemo(quota int, provider llm.Provider) (*Demo, *fakeAPI, *db.MemoryStore) {
+	store := db.NewMemoryStore()
+	api := &fakeAPI{}
+	d := New(store, sender.New(api, store, nil, sender.Options{}), config.DemoConfig{Provider: "stub", DailyQuota: quota, ContextTurns: 10})
+	d.provider = provider
+	return d, api, store
+}
+
+func TestQuotaIsReserved(t *testing.T) {
+	const quota, prompts = 3, 10
+	provider := &gatedProvider{release: make(chan struct{})}
+	d, api, store := newDemo(quota, provider)
+
+	// Every ask returns while the provider is still blocked
+	var wg sync.WaitGroup
+	refused := make(chan struct{}, prompts)
+	for i := 0; i < prompts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := d.ask(42, 7, fmt.Sprintf("prompt %d", i))
+			if err != nil {
+				t.Errorf("ask: %v", err)
+			}
+			if response != nil {
+				refused <- struct{}{}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(provider.release)
+	d.wg.Wait()
+
+	if len(refused) != prompts-quota {
+		t.Errorf("%d prompts refused, want %d", len(refused), prompts-quota)
+	}
+	if provider.calls != quota {
+		t.Errorf("provider called %d times, want %d", provider.calls, quota)
+	}
+	if n := api.count("[DEMO · gated]"); n != quota {
+		t.Errorf("%d answers sent, want %d", n, quota)
+	}
+	if used, _ := store.GetDemoUsage(7, today()); used != quota {
+		t.Errorf("usage is %d, want %d", used, quota)
+	}
+	session, err := store.GetDemoSession(42)
+	if err != nil {
+		t.Fatalf("GetDemoSession: %v", err)
+	}
+	if len(session.Messages) != 2*quota {
+		t.Errorf("session has %d turns, want %d", len(session.Messages), 2*quota)
+	}
+}
+
+func TestFailedAnswer(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		stopped bool // Stop is called while the provider is blocked
+	}{
+		{name: "provider error"},
+		{name: "stopped", stopped: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &gatedProvider{release: make(chan struct{}), err: fmt.Errorf("unavailable")}
+			d, api, store := newDemo(1, provider)
+
+			if response, err := d.ask(42, 7, "hello"); response != nil || err != nil {
+				t.Fatalf("ask = %v, %v; want the answer in the background", response, err)
+			}
+			if !tt.stopped {
+				close(provider.release)
+			}
+			if err := d.Stop(context.Background()); err != nil {
+				t.Fatalf("Stop: %v", err)
+			}
+
+			if used, _ := store.GetDemoUsage(7, today()); used != 0 {
+				t.Errorf("usage is %d, want the message given back", used)
+			}
+			if n := api.count("could not answer"); n != 1 {
+				t.Errorf("%d apologies sent, want 1", n)
+			}
+			if response, _ := d.ask(42, 7, "again"); response == nil {
+				t.Error("ask after Stop started an answer")
+			}
+		})
+	}
+}
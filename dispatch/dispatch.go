@@ -0,0 +1,194 @@
+//dispatch/dispatch.go
+
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	updates "tg/updates"
+	"time"
+)
+
+// ErrStopped is returned by Submit once the pool is stopping.
+var ErrStopped = errors.New("dispatch: pool stopped")
+
+// HandleFunc processes a single update.
+type HandleFunc func(update updates.Update)
+
+// Pool fans updates out to a fixed set of workers by chat ID.
+//
+// Every chat is pinned to one worker, so updates from the same chat are
+// handled strictly in the order they were submitted while different chats
+// are handled in parallel. Each worker has a bounded queue; Submit blocks
+// when the queue of the target worker is full, which in turn slows down
+// whoever is fetching updates.
+type Pool struct {
+	handle HandleFunc
+	queues []chan updates.Update
+	wg     sync.WaitGroup
+	quit   chan struct{} // Closed when Stop is called, to release blocked Submits
+	once   sync.Once
+	mu     sync.RWMutex // Held for reading while submitting, for writing while closing the queues
+	closed bool
+
+	submitted   atomic.Int64 // Updates accepted by Submit
+	processed   atomic.Int64 // Updates fully handled
+	inFlight    atomic.Int64 // Updates currently being handled
+	blocked     atomic.Int64 // Submits that had to wait for queue space
+	blockedTime atomic.Int64 // Total time spent waiting for queue space, in nanoseconds
+}
+
+// Stats is a snapshot of the pool's throughput and backpressure counters.
+type Stats struct {
+	Workers     int
+	Submitted   int64
+	Processed   int64
+	InFlight    int64
+	Queued      int           // Updates waiting in all queues
+	MaxQueued   int           // Length of the fullest queue
+	QueueSize   int           // Capacity of each queue
+	Blocked     int64         // Submits that had to wait for queue space
+	BlockedTime time.Duration // Total time spent waiting for queue space
+}
+
+// New creates a pool with the given number of workers and per-worker queue size.
+// Call Start to begin processing.
+func New(workers int, queueSize int, handle HandleFunc) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	p := &Pool{
+		handle: handle,
+		queues: make([]chan updates.Update, workers),
+		quit:   make(chan struct{}),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan updates.Update, queueSize)
+	}
+	return p
+}
+
+// Start launches the workers.
+func (p *Pool) Start() {
+	for _, queue := range p.queues {
+		p.wg.Add(1)
+		go p.work(queue)
+	}
+}
+
+// Submit queues an update on the worker owning its chat.
+// It blocks while that worker's queue is full, and drops the update with
+// ErrStopped once Stop has been called.
+func (p *Pool) Submit(update updates.Update) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrStopped
+	}
+
+	queue := p.queues[p.shard(updates.ChatID(update))]
+	select {
+	case queue <- update:
+		p.submitted.Add(1)
+		return nil
+	default:
+	}
+
+	start := time.Now()
+	p.blocked.Add(1)
+	defer func() { p.blockedTime.Add(int64(time.Since(start))) }()
+	select {
+	case queue <- update:
+		p.submitted.Add(1)
+		return nil
+	case <-p.quit:
+		return ErrStopped
+	}
+}
+
+// Stop closes the queues and waits until every queued update has been handled.
+// Submits still waiting for queue space give up, and later ones fail.
+func (p *Pool) Stop() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.mu.Lock()
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+		p.mu.Unlock()
+	})
+	p.wg.Wait()
+}
+
+// Shutdown is like Stop but gives up waiting when ctx is done.
+// Updates still queued at that point are reported in the error.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s := p.Stats()
+		return fmt.Errorf("dispatch: %w with %d updates queued and %d in flight", ctx.Err(), s.Queued, s.InFlight)
+	}
+}
+
+// Stats returns a snapshot of the pool's counters.
+func (p *Pool) Stats() Stats {
+	s := Stats{
+		Workers:     len(p.queues),
+		Submitted:   p.submitted.Load(),
+		Processed:   p.processed.Load(),
+		InFlight:    p.inFlight.Load(),
+		QueueSize:   cap(p.queues[0]),
+		Blocked:     p.blocked.Load(),
+		BlockedTime: time.Duration(p.blockedTime.Load()),
+	}
+	for _, queue := range p.queues {
+		n := len(queue)
+		s.Queued += n
+		if n > s.MaxQueued {
+			s.MaxQueued = n
+		}
+	}
+	return s
+}
+
+// String formats the stats for logging.
+func (s Stats) String() string {
+	return fmt.Sprintf("workers=%d submitted=%d processed=%d in_flight=%d queued=%d max_queued=%d/%d blocked=%d blocked_time=%s",
+		s.Workers, s.Submitted, s.Processed, s.InFlight, s.Queued, s.MaxQueued, s.QueueSize, s.Blocked, s.BlockedTime)
+}
+
+// work handles the updates of one queue until it is closed.
+func (p *Pool) work(queue <-chan updates.Update) {
+	defer p.wg.Done()
+
+	for update := range queue {
+		p.inFlight.Add(1)
+		p.handle(update)
+		p.inFlight.Add(-1)
+		p.processed.Add(1)
+	}
+}
+
+// shard maps a chat ID to a worker index.
+func (p *Pool) shard(chatID int64) int {
+	if chatID < 0 {
+		chatID = -chatID
+	}
+	return int(chatID % int64(len(p.queues)))
+}
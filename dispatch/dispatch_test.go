@@ -0,0 +1,170 @@
+//dispatch/dispatch_test.go
+
+package dispatch
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"sync"
+	"testing"
+	updates "tg/updates"
+	"time"
+)
+
+// update builds a message update in a chat.
+func update(id int, chatID int64) updates.Update {
+	return updates.Update{Update: tgbotapi.Update{UpdateID: id, Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}}
+}
+
+func TestOrderPerChat(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = map[int64][]int{}
+	)
+	p := New(4, 10, func(u updates.Update) {
+		mu.Lock()
+		defer mu.Unlock()
+		chatID := updates.ChatID(u)
+		seen[chatID] = append(seen[chatID], u.UpdateID)
+	})
+	p.Start()
+
+	chats := []int64{1, 2, -3, -100, 7}
+	for i := 0; i < 100; i++ {
+		if err := p.Submit(update(i, chats[i%len(chats)])); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	p.Stop()
+
+	for _, chatID := range chats {
+		ids := seen[chatID]
+		if len(ids) != 100/len(chats) {
+			t.Errorf("chat %d: handled %d updates, want %d", chatID, len(ids), 100/len(chats))
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Errorf("chat %d: handled in order %v", chatID, ids)
+				break
+			}
+		}
+	}
+	if s := p.Stats(); s.Submitted != 100 || s.Processed != 100 || s.InFlight != 0 {
+		t.Errorf("stats = %s", s)
+	}
+}
+
+func TestChatsInParallel(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan int64, 2)
+	p := New(2, 1, func(u updates.Update) {
+		started <- updates.ChatID(u)
+		<-release
+	})
+	p.Start()
+	defer p.Stop()
+
+	// Chats 1 and 2 are on different workers, so both are handled while neither has finished
+	for _, chatID := range []int64{1, 2} {
+		if err := p.Submit(update(int(chatID), chatID)); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("%d of 2 chats started, want both at once", i)
+		}
+	}
+	if s := p.Stats(); s.InFlight != 2 {
+		t.Errorf("in flight = %d, want 2", s.InFlight)
+	}
+	close(release)
+}
+
+func TestBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	p := New(1, 1, func(updates.Update) {
+		started <- struct{}{}
+		<-release
+	})
+	p.Start()
+
+	// One update is handled, one queued; the third has to wait for space
+	if err := p.Submit(update(0, 1)); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-started
+	if err := p.Submit(update(1, 1)); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	submitted := make(chan error)
+	go func() { submitted <- p.Submit(update(2, 1)) }()
+
+	select {
+	case err := <-submitted:
+		t.Fatalf("Submit returned %v on a full queue, want it to block", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-submitted; err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	p.Stop()
+
+	s := p.Stats()
+	if s.Blocked != 1 || s.BlockedTime < 50*time.Millisecond {
+		t.Errorf("stats = %s, want one Submit blocked for at least 50ms", s)
+	}
+	if s.Submitted != 3 || s.Processed != 3 {
+		t.Errorf("stats = %s, want 3 submitted and processed", s)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{}, 1)
+	p := New(1, 2, func(updates.Update) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	})
+	p.Start()
+	if err := p.Submit(update(0, 1)); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-started
+	for i := 1; i < 3; i++ {
+		if err := p.Submit(update(i, 1)); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+
+	// A Submit waiting for space gives up once the pool stops
+	blocked := make(chan error)
+	go func() { blocked <- p.Submit(update(3, 1)) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := p.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %s, want it to stop at the deadline", elapsed)
+	}
+
+	if err := <-blocked; !errors.Is(err, ErrStopped) {
+		t.Errorf("blocked Submit = %v, want ErrStopped", err)
+	}
+	if err := p.Submit(update(4, 1)); !errors.Is(err, ErrStopped) {
+		t.Errorf("Submit after Shutdown = %v, want ErrStopped", err)
+	}
+}
@@ -3,94 +3,304 @@
 package errors
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"go.mongodb.org/mongo-driver/mongo"
 	"log"
 	"net"
 	"strings"
 )
 
-type ErrorCheckFunc func(err error) bool
+// Sentinel errors for the failures the bot distinguishes.
+// Wrap them together with their cause so both stay reachable through Is and As.
+var (
+	ErrNotFound               = errors.New("not found")
+	ErrUserNotFound           = errors.New("user not found")
+	ErrGroupNotFound          = errors.New("group not found")
+	ErrBotKicked              = errors.New("bot was kicked from the chat")
+	ErrBotBlocked             = errors.New("bot was blocked by the user")
+	ErrInvalidMessage         = errors.New("invalid message")
+	ErrInvalidCommand         = errors.New("invalid command")
+	ErrFailedToSendMessage    = errors.New("failed to send message")
+	ErrFailedToUpdateDatabase = errors.New("failed to update database")
+	ErrDatabaseConnection     = errors.New("failed to connect to database")
+)
+
+// TelegramAPIError is an error response from the Telegram Bot API.
+type TelegramAPIError struct {
+	Code            int    // HTTP-like error code, e.g. 400, 403 or 429
+	Description     string // Human-readable description returned by Telegram
+	RetryAfter      int    // Seconds to wait before retrying, set on 429
+	MigrateToChatID int64  // New chat ID when a group was upgraded to a supergroup
+	Err             error  // Underlying error, if any
+}
+
+func (e *TelegramAPIError) Error() string {
+	if e.Code == 0 {
+		return "telegram: " + e.Description
+	}
+	return fmt.Sprintf("telegram: %d %s", e.Code, e.Description)
+}
+
+// Unwrap returns the underlying error.
+func (e *TelegramAPIError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinels a Telegram error stands for, so
+// errors.Is(err, ErrBotKicked) works on a 403 "bot was kicked" response.
+func (e *TelegramAPIError) Is(target error) bool {
+	description := strings.ToLower(e.Description)
+	switch target {
+	case ErrBotKicked:
+		return e.Code == 403 && strings.Contains(description, "kicked")
+	case ErrBotBlocked:
+		return e.Code == 403 && strings.Contains(description, "blocked")
+	}
+	return false
+}
+
+// kindError attaches a sentinel kind to a cause.
+type kindError struct {
+	kind  error
+	cause error
+}
+
+func (e *kindError) Error() string {
+	return e.kind.Error() + ": " + e.cause.Error()
+}
+
+// Unwrap exposes both the kind and the cause to Is and As.
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.cause}
+}
+
+// Wrap marks cause as being of the given kind, e.g. Wrap(ErrUserNotFound, err).
+// It returns nil when cause is nil.
+func Wrap(kind error, cause error) error {
+	if cause == nil {
+		return nil
+	}
+	return &kindError{kind: kind, cause: cause}
+}
+
+// New, Is, As and Join mirror the standard library so callers importing this
+// package as "errors" keep access to them.
+func New(text string) error                 { return errors.New(text) }
+func Is(err error, target error) bool       { return errors.Is(err, target) }
+func As(err error, target interface{}) bool { return errors.As(err, target) }
+func Join(errs ...error) error              { return errors.Join(errs...) }
 
-var errorChecks = []ErrorCheckFunc{
-	IsBotKicked,
-	IsMongoNoDocuments,
-	IsMongoDuplicateKey,
-	IsTelegramAPIError,
-	IsRateLimited,
-	IsTimeout,
-	IsDatabaseConnectionError,
-	IsInvalidMessage,
-	IsInvalidCommand,
-	IsUserNotFound,
-	IsGroupNotFound,
-	IsFailedToSendMessage,
-	IsFailedToUpdateDatabase,
+// Category is the kind of failure an error represents.
+type Category int
+
+const (
+	Unknown      Category = iota // Anything not recognized below
+	NotFound                     // A lookup matched nothing
+	Duplicate                    // A write conflicted with an existing record
+	Timeout                      // An operation ran out of time
+	RateLimited                  // Telegram asked us to slow down
+	Unavailable                  // The database or network could not be reached
+	Forbidden                    // The bot was kicked, blocked or lacks rights in the chat
+	InvalidInput                 // The user or the caller sent something unusable
+	Telegram                     // Any other Telegram API error
+)
+
+// String returns the name of the category.
+func (c Category) String() string {
+	switch c {
+	case NotFound:
+		return "not_found"
+	case Duplicate:
+		return "duplicate"
+	case Timeout:
+		return "timeout"
+	case RateLimited:
+		return "rate_limited"
+	case Unavailable:
+		return "unavailable"
+	case Forbidden:
+		return "forbidden"
+	case InvalidInput:
+		return "invalid_input"
+	case Telegram:
+		return "telegram"
+	default:
+		return "unknown"
+	}
+}
+
+// Classify returns the category of err, or Unknown if err is nil or unrecognized.
+func Classify(err error) Category {
+	switch {
+	case err == nil:
+		return Unknown
+	case IsRateLimited(err):
+		return RateLimited
+	case IsBotKicked(err), IsBotBlocked(err), IsForbidden(err):
+		return Forbidden
+	case IsTimeout(err):
+		return Timeout
+	case IsDatabaseConnectionError(err):
+		return Unavailable
+	case IsMongoDuplicateKey(err):
+		return Duplicate
+	case IsNotFound(err):
+		return NotFound
+	case IsInvalidMessage(err), IsInvalidCommand(err), IsBadRequest(err):
+		return InvalidInput
+	case IsTelegramAPIError(err):
+		return Telegram
+	}
+	return Unknown
 }
 
+// HandleError logs err with its category and returns it unchanged.
 func HandleError(err error) error {
 	if err != nil {
-		for _, check := range errorChecks {
-			if check(err) {
-				log.Printf("Handled error: %v", err)
-				break
-			}
-		}
-		return errors.New(err.Error())
+		log.Printf("Handled error (%s): %v", Classify(err), err)
 	}
-	return nil
+	return err
+}
+
+// telegramError extracts the Telegram API error from err's chain.
+func telegramError(err error) (*TelegramAPIError, bool) {
+	var apiErr *TelegramAPIError
+	ok := errors.As(err, &apiErr)
+	return apiErr, ok
 }
 
 func IsBotKicked(err error) bool {
-	return err != nil && err.Error() == "Forbidden: bot was kicked from the group chat"
+	return errors.Is(err, ErrBotKicked)
+}
+
+func IsBotBlocked(err error) bool {
+	return errors.Is(err, ErrBotBlocked)
+}
+
+func IsForbidden(err error) bool {
+	apiErr, ok := telegramError(err)
+	return ok && apiErr.Code == 403
+}
+
+func IsBadRequest(err error) bool {
+	apiErr, ok := telegramError(err)
+	return ok && apiErr.Code == 400
+}
+
+// IsNotFound reports whether err says that a lookup matched nothing, in
+// whichever Store it was made.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || IsMongoNoDocuments(err) || IsUserNotFound(err) || IsGroupNotFound(err)
 }
 
 func IsMongoNoDocuments(err error) bool {
-	return err == mongo.ErrNoDocuments
+	return errors.Is(err, mongo.ErrNoDocuments)
 }
 
 func IsMongoDuplicateKey(err error) bool {
-	we, ok := err.(mongo.WriteException)
-	return ok && we.HasErrorCode(11000)
+	return mongo.IsDuplicateKeyError(err)
 }
 
 func IsTelegramAPIError(err error) bool {
-	return err != nil && err.Error() == "TELEGRAM_ERROR"
+	_, ok := telegramError(err)
+	return ok
 }
 
 func IsRateLimited(err error) bool {
-	return strings.Contains(err.Error(), "rate limit")
+	apiErr, ok := telegramError(err)
+	return ok && (apiErr.Code == 429 || apiErr.RetryAfter > 0)
 }
 
 func IsTimeout(err error) bool {
-	_, ok := err.(net.Error)
-	return ok && err.(net.Error).Timeout()
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// IsNotSent reports whether err happened before a request reached the
+// server: the connection could not be opened or the host not resolved.
+// Repeating such a request cannot make it take effect twice.
+func IsNotSent(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &dnsErr)
 }
 
 func IsDatabaseConnectionError(err error) bool {
-	return strings.Contains(err.Error(), "failed to connect to database")
+	return errors.Is(err, ErrDatabaseConnection) || errors.Is(err, mongo.ErrClientDisconnected) || mongo.IsNetworkError(err)
 }
 
 func IsInvalidMessage(err error) bool {
-	return strings.Contains(err.Error(), "invalid message")
+	return errors.Is(err, ErrInvalidMessage)
 }
 
 func IsInvalidCommand(err error) bool {
-	return strings.Contains(err.Error(), "invalid command")
+	return errors.Is(err, ErrInvalidCommand)
 }
 
 func IsUserNotFound(err error) bool {
-	return strings.Contains(err.Error(), "user not found")
+	return errors.Is(err, ErrUserNotFound)
 }
 
 func IsGroupNotFound(err error) bool {
-	return strings.Contains(err.Error(), "group not found")
+	return errors.Is(err, ErrGroupNotFound)
 }
 
 func IsFailedToSendMessage(err error) bool {
-	return strings.Contains(err.Error(), "failed to send message")
+	return errors.Is(err, ErrFailedToSendMessage)
 }
 
 func IsFailedToUpdateDatabase(err error) bool {
-	return strings.Contains(err.Error(), "failed to update database")
+	return errors.Is(err, ErrFailedToUpdateDatabase)
+}
+
+// telegramCodes maps the description prefixes Telegram uses to their error codes.
+var telegramCodes = map[string]int{
+	"Bad Request":       400,
+	"Unauthorized":      401,
+	"Forbidden":         403,
+	"Not Found":         404,
+	"Conflict":          409,
+	"Too Many Requests": 429,
+}
+
+// FromTelegram converts an error returned by the tgbotapi client into a *TelegramAPIError.
+// Errors that did not come from the API, such as network failures, are returned unchanged.
+func FromTelegram(err error) error {
+	var tgErr tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		return err
+	}
+
+	code := 0
+	if prefix, _, ok := strings.Cut(tgErr.Message, ":"); ok {
+		code = telegramCodes[prefix]
+	}
+	if code == 0 && tgErr.RetryAfter > 0 {
+		code = 429
+	}
+
+	return &TelegramAPIError{
+		Code:            code,
+		Description:     tgErr.Message,
+		RetryAfter:      tgErr.RetryAfter,
+		MigrateToChatID: tgErr.MigrateToChatID,
+		Err:             err,
+	}
+}
+
+// FromResponse returns the error described by a failed API response, or nil if it succeeded.
+func FromResponse(resp tgbotapi.APIResponse) error {
+	if resp.Ok {
+		return nil
+	}
+
+	apiErr := &TelegramAPIError{Code: resp.ErrorCode, Description: resp.Description}
+	if resp.Parameters != nil {
+		apiErr.RetryAfter = resp.Parameters.RetryAfter
+		apiErr.MigrateToChatID = resp.Parameters.MigrateToChatID
+	}
+	return apiErr
 }
@@ -0,0 +1,30 @@
+//groups/admin.go
+
+package groups
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	errors "tg/errors"
+	router "tg/router"
+	updates "tg/updates"
+)
+
+// MemberAPI is the part of the Telegram client IsAdmin needs. *tgbotapi.BotAPI implements it.
+type MemberAPI interface {
+	GetChatMember(config tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error)
+}
+
+// IsAdmin reports whether the user administers the group, or the bot.
+// Bot admins are those of commands, which may be nil; group admins are
+// looked up with Telegram.
+func IsAdmin(api MemberAPI, commands *router.Router, chatID int64, userID int) (bool, error) {
+	if commands != nil && commands.IsAdmin(int64(userID)) {
+		return true, nil
+	}
+
+	member, err := api.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID})
+	if err != nil {
+		return false, errors.FromTelegram(err)
+	}
+	return member.Status == updates.StatusCreator || member.Status == updates.StatusAdministrator, nil
+}
@@ -0,0 +1,217 @@
+//groups/groups.go
+
+package groups
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"sync"
+	db "tg/db"
+	errors "tg/errors"
+	updates "tg/updates"
+	"time"
+)
+
+// ChatAPI is the part of the Telegram client the tracker needs. *tgbotapi.BotAPI implements it.
+type ChatAPI interface {
+	GetChatMembersCount(config tgbotapi.ChatConfig) (int, error)
+}
+
+// Tracker keeps the groups collection in line with the chats the bot is in.
+//
+// It follows the bot being added, promoted, demoted and removed through
+// my_chat_member updates, and title changes, member joins and supergroup
+// migrations through service messages. In private chats, my_chat_member
+// tells when a user blocks or unblocks the bot. The memberships of users
+// are kept from chat_member updates, joins, leaves and messages.
+type Tracker struct {
+	store db.Store
+	api   ChatAPI
+	botID int // The bot's own user ID
+
+	mu     sync.Mutex
+	titles map[int64]string // Titles of the groups known to be stored and active
+}
+
+// New creates a tracker writing to the store for the bot with the given user ID.
+func New(store db.Store, api ChatAPI, botID int) *Tracker {
+	return &Tracker{
+		store:  store,
+		api:    api,
+		botID:  botID,
+		titles: make(map[int64]string),
+	}
+}
+
+// HandleMyChatMember records a change of the bot's own status in a chat.
+func (t *Tracker) HandleMyChatMember(change *updates.ChatMemberUpdated) error {
+	chat := change.Chat
+	oldStatus, newStatus := change.OldChatMember.Status, change.NewChatMember.Status
+
+	if chat.IsPrivate() {
+		return t.setReachable(chat.ID, updates.IsPresent(newStatus), change.Time())
+	}
+	if !chat.IsGroup() && !chat.IsSuperGroup() {
+		return nil // Channels are not tracked
+	}
+
+	group, err := t.load(chat.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	wasPresent, isPresent := updates.IsPresent(oldStatus), updates.IsPresent(newStatus)
+	switch {
+	case !wasPresent && isPresent:
+		log.Printf("Bot was added to %s %d (%s) by %d", chat.Type, chat.ID, chat.Title, change.From.ID)
+		group.Joined = change.Time()
+		group.Left = time.Time{}
+	case wasPresent && !isPresent:
+		log.Printf("Bot was removed from %s %d (%s) by %d", chat.Type, chat.ID, chat.Title, change.From.ID)
+		group.Left = change.Time()
+	case newStatus == updates.StatusAdministrator && oldStatus != updates.StatusAdministrator:
+		log.Printf("Bot was promoted to administrator in %d (%s)", chat.ID, chat.Title)
+	case oldStatus == updates.StatusAdministrator && newStatus != updates.StatusAdministrator:
+		log.Printf("Bot was demoted to %s in %d (%s)", newStatus, chat.ID, chat.Title)
+	}
+
+	group.GroupName = chat.Title
+	group.Type = chat.Type
+	group.BotStatus = newStatus
+	group.IsActive = isPresent
+	group.Updated = now
+	if isPresent {
+		group.MemberCount = t.countMembers(chat.ID, group.MemberCount)
+	}
+	return t.save(*group)
+}
+
+// HandleMessage keeps the records of a group and its members current from a
+// message sent in it: it records groups the bot was added to before tracking
+// started and follows title changes, member joins and leaves, and supergroup
+// migrations.
+func (t *Tracker) HandleMessage(message *tgbotapi.Message) error {
+	if message == nil || message.Chat == nil || !(message.Chat.IsGroup() || message.Chat.IsSuperGroup()) {
+		return nil
+	}
+	if message.LeftChatMember != nil && message.LeftChatMember.ID == t.botID {
+		return nil // my_chat_member records the removal
+	}
+
+	switch {
+	case message.MigrateToChatID != 0:
+		return t.migrate(message.Chat.ID, message.MigrateToChatID)
+	case message.MigrateFromChatID != 0:
+		if err := t.migrate(message.MigrateFromChatID, message.Chat.ID); err != nil {
+			return err
+		}
+	}
+
+	return errors.Join(t.trackGroup(message), t.trackMembers(message))
+}
+
+// trackGroup updates the group's record when its title or member count may have changed.
+func (t *Tracker) trackGroup(message *tgbotapi.Message) error {
+	chat := message.Chat
+	membersChanged := message.NewChatMembers != nil || message.LeftChatMember != nil
+
+	t.mu.Lock()
+	title, known := t.titles[chat.ID]
+	t.mu.Unlock()
+	if known && title == chat.Title && !membersChanged {
+		return nil
+	}
+
+	group, err := t.load(chat.ID)
+	if err != nil {
+		return err
+	}
+	if !group.IsActive {
+		// The bot is evidently in the group; the update saying so was missed
+		group.IsActive = true
+		group.Joined = message.Time()
+		group.Left = time.Time{}
+		if group.BotStatus == "" || !updates.IsPresent(group.BotStatus) {
+			group.BotStatus = updates.StatusMember
+		}
+	}
+	if group.GroupName != chat.Title {
+		log.Printf("Group %d is now called %q", chat.ID, chat.Title)
+	}
+
+	group.GroupName = chat.Title
+	group.Type = chat.Type
+	group.Updated = time.Now()
+	if membersChanged || group.MemberCount == 0 {
+		group.MemberCount = t.countMembers(chat.ID, group.MemberCount)
+	}
+	return t.save(*group)
+}
+
+// migrate moves a group that was upgraded to a supergroup to its new chat ID.
+// It runs for the reports in both chats; the store moves the records once.
+func (t *Tracker) migrate(from int64, to int64) error {
+	t.mu.Lock()
+	delete(t.titles, from)
+	delete(t.titles, to)
+	t.mu.Unlock()
+
+	log.Printf("Group %d was upgraded to supergroup %d", from, to)
+	return t.store.MigrateChat(from, to)
+}
+
+// load returns the stored group, or a fresh record if there is none.
+func (t *Tracker) load(chatID int64) (*db.Group, error) {
+	group, err := t.store.GetGroup(chatID)
+	if errors.IsNotFound(err) {
+		return &db.Group{GroupID: chatID}, nil
+	}
+	return group, err
+}
+
+// save stores the group and remembers its title while it is active.
+func (t *Tracker) save(group db.Group) error {
+	if err := t.store.SaveGroup(group); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if group.IsActive {
+		t.titles[group.GroupID] = group.GroupName
+	} else {
+		delete(t.titles, group.GroupID)
+	}
+	return nil
+}
+
+// countMembers asks Telegram for the member count, keeping the previous count on failure.
+func (t *Tracker) countMembers(chatID int64, previous int) int {
+	count, err := t.api.GetChatMembersCount(tgbotapi.ChatConfig{ChatID: chatID})
+	if err != nil {
+		log.Printf("Failed to count the members of %d: %v", chatID, errors.HandleError(errors.FromTelegram(err)))
+		return previous
+	}
+	return count
+}
+
+// setReachable records a user blocking or unblocking the bot in their private chat.
+func (t *Tracker) setReachable(chatID int64, reachable bool, at time.Time) error {
+	status, err := t.store.GetChatStatus(chatID)
+	if errors.IsNotFound(err) {
+		status, err = &db.ChatStatus{ChatID: chatID}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	status.Reachable = reachable
+	status.Updated = at
+	if reachable {
+		status.FailureCount = 0
+	} else {
+		log.Printf("User %d blocked the bot", chatID)
+	}
+	return t.store.SaveChatStatus(*status)
+}
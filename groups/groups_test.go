@@ -0,0 +1,254 @@
+//groups/groups_test.go
+
+package groups
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"testing"
+	db "tg/db"
+	router "tg/router"
+	updates "tg/updates"
+)
+
+const botID = 1
+
+// fakeAPI counts members and answers member lookups from its statuses.
+type fakeAPI struct {
+	statuses map[int]string // Member status by user ID
+	lookups  int
+}
+
+func (f *fakeAPI) GetChatMembersCount(config tgbotapi.ChatConfig) (int, error) {
+	return 3, nil
+}
+
+func (f *fakeAPI) GetChatMember(config tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error) {
+	f.lookups++
+	status, ok := f.statuses[config.UserID]
+	if !ok {
+		return tgbotapi.ChatMember{}, tgbotapi.Error{Message: "Bad Request: user not found"}
+	}
+	return tgbotapi.ChatMember{Status: status}, nil
+}
+
+// change builds a chat_member or my_chat_member update of a user in a chat.
+func change(chat tgbotapi.Chat, userID int, from string, to string, at int64) *updates.ChatMemberUpdated {
+	user := &tgbotapi.User{ID: userID}
+	return &updates.ChatMemberUpdated{
+		Chat:          chat,
+		From:          tgbotapi.User{ID: 7},
+		Date:          at,
+		OldChatMember: tgbotapi.ChatMember{User: user, Status: from},
+		NewChatMember: tgbotapi.ChatMember{User: user, Status: to},
+	}
+}
+
+func TestHandleMyChatMember(t *testing.T) {
+	group := tgbotapi.Chat{ID: -100, Type: "supergroup", Title: "Club"}
+	type step struct {
+		from, to string
+	}
+	tests := []struct {
+		name   string
+		steps  []step
+		active bool
+		status string
+		left   bool // Whether the group has a time the bot left
+	}{
+		{"join", []step{{"left", "member"}}, true, "member", false},
+		{"promoted", []step{{"left", "member"}, {"member", "administrator"}}, true, "administrator", false},
+		{"kicked", []step{{"left", "administrator"}, {"administrator", "kicked"}}, false, "kicked", true},
+		{"leave", []step{{"left", "member"}, {"member", "left"}}, false, "left", true},
+		{"rejoin", []step{{"left", "member"}, {"member", "left"}, {"left", "member"}}, true, "member", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.NewMemoryStore()
+			tracker := New(store, &fakeAPI{}, botID)
+
+			for i, s := range tt.steps {
+				if err := tracker.HandleMyChatMember(change(group, botID, s.from, s.to, int64(1000+i))); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+			}
+
+			g, err := store.GetGroup(group.ID)
+			if err != nil {
+				t.Fatalf("GetGroup: %v", err)
+			}
+			if g.IsActive != tt.active || g.BotStatus != tt.status || g.GroupName != "Club" {
+				t.Errorf("group = %+v, want active %v with status %s", g, tt.active, tt.status)
+			}
+			if g.Left.IsZero() == tt.left {
+				t.Errorf("left = %v, want set %v", g.Left, tt.left)
+			}
+			if g.MemberCount != 3 {
+				t.Errorf("member count = %d, want 3", g.MemberCount)
+			}
+		})
+	}
+}
+
+func TestHandleMyChatMemberPrivate(t *testing.T) {
+	private := tgbotapi.Chat{ID: 42, Type: "private"}
+	store := db.NewMemoryStore()
+	tracker := New(store, &fakeAPI{}, botID)
+
+	for _, tt := range []struct {
+		from, to  string
+		reachable bool
+	}{
+		{"member", "kicked", false},
+		{"kicked", "member", true},
+	} {
+		if err := tracker.HandleMyChatMember(change(private, botID, tt.from, tt.to, 1000)); err != nil {
+			t.Fatalf("HandleMyChatMember: %v", err)
+		}
+		status, err := store.GetChatStatus(private.ID)
+		if err != nil {
+			t.Fatalf("GetChatStatus: %v", err)
+		}
+		if status.Reachable != tt.reachable {
+			t.Errorf("%s -> %s: reachable = %v, want %v", tt.from, tt.to, status.Reachable, tt.reachable)
+		}
+	}
+	if _, err := store.GetGroup(private.ID); err == nil {
+		t.Error("a private chat was stored as a group")
+	}
+}
+
+func TestHandleChatMember(t *testing.T) {
+	group := tgbotapi.Chat{ID: -100, Type: "group", Title: "Club"}
+	tests := []struct {
+		name    string
+		to      []string // Statuses reported in turn
+		inGroup bool
+		role    string
+	}{
+		{"join", []string{"member"}, true, db.RoleMember},
+		{"promoted", []string{"member", "administrator"}, true, db.RoleAdmin},
+		{"restricted", []string{"restricted"}, true, db.RoleRestricted},
+		{"kicked", []string{"member", "kicked"}, false, db.RoleMember},
+		{"leave", []string{"administrator", "left"}, false, db.RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.NewMemoryStore()
+			tracker := New(store, &fakeAPI{}, botID)
+
+			from := "left"
+			for i, status := range tt.to {
+				if err := tracker.HandleChatMember(change(group, 7, from, status, int64(1000+i))); err != nil {
+					t.Fatalf("HandleChatMember: %v", err)
+				}
+				from = status
+			}
+
+			membership, err := store.GetMembership(7, group.ID)
+			if err != nil {
+				t.Fatalf("GetMembership: %v", err)
+			}
+			if membership.InGroup != tt.inGroup || membership.Role != tt.role {
+				t.Errorf("membership = %+v, want in group %v as %s", membership, tt.inGroup, tt.role)
+			}
+			if membership.InGroup != membership.Left.IsZero() {
+				t.Errorf("left = %v while in group %v", membership.Left, membership.InGroup)
+			}
+			user, err := store.GetUser(7)
+			if err != nil {
+				t.Fatalf("GetUser: %v", err)
+			}
+			if user.IsInGroup != tt.inGroup {
+				t.Errorf("IsInGroup = %v, want %v", user.IsInGroup, tt.inGroup)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	const from, to = int64(-100), int64(-1001234)
+	store := db.NewMemoryStore()
+	tracker := New(store, &fakeAPI{}, botID)
+
+	old := tgbotapi.Chat{ID: from, Type: "group", Title: "Club"}
+	if err := tracker.HandleMyChatMember(change(old, botID, "left", "member", 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.HandleChatMember(change(old, 7, "left", "member", 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Telegram reports the upgrade in both chats; the records move once
+	reports := []*tgbotapi.Message{
+		{MessageID: 1, Chat: &old, MigrateToChatID: to, Date: 2000},
+		{MessageID: 1, Chat: &tgbotapi.Chat{ID: to, Type: "supergroup", Title: "Club"}, MigrateFromChatID: from, Date: 2000},
+	}
+	for _, message := range reports {
+		if err := tracker.HandleMessage(message); err != nil {
+			t.Fatalf("HandleMessage: %v", err)
+		}
+	}
+
+	if _, err := store.GetGroup(from); err == nil {
+		t.Error("the old group is still stored")
+	}
+	g, err := store.GetGroup(to)
+	if err != nil {
+		t.Fatalf("GetGroup: %v", err)
+	}
+	if !g.IsActive || g.Type != "supergroup" {
+		t.Errorf("group = %+v, want the active supergroup", g)
+	}
+	if membership, err := store.GetMembership(7, to); err != nil || !membership.InGroup {
+		t.Errorf("membership = %+v, %v; want it moved to the supergroup", membership, err)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	commands := router.New("testbot")
+	commands.SetAdmins([]int64{99})
+	api := &fakeAPI{statuses: map[int]string{
+		10: updates.StatusCreator,
+		11: updates.StatusAdministrator,
+		12: updates.StatusMember,
+		13: updates.StatusRestricted,
+		14: updates.StatusLeft,
+	}}
+
+	tests := []struct {
+		userID  int
+		want    bool
+		wantErr bool
+	}{
+		{99, true, false}, // Bot admin, not looked up
+		{10, true, false},
+		{11, true, false},
+		{12, false, false},
+		{13, false, false},
+		{14, false, false},
+		{15, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.userID), func(t *testing.T) {
+			lookups := api.lookups
+			got, err := IsAdmin(api, commands, -100, tt.userID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsAdmin = %v, want %v", got, tt.want)
+			}
+			if tt.userID == 99 && api.lookups != lookups {
+				t.Error("a bot admin was looked up")
+			}
+		})
+	}
+
+	// Without a router bot admins are only admins where Telegram says so
+	if ok, _ := IsAdmin(api, nil, -100, 99); ok {
+		t.Error("a bot admin counted without a router")
+	}
+}
@@ -0,0 +1,132 @@
+//groups/members.go
+
+package groups
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	db "tg/db"
+	errors "tg/errors"
+	updates "tg/updates"
+	"time"
+)
+
+// HandleChatMember records a change of a member's status in a group.
+func (t *Tracker) HandleChatMember(change *updates.ChatMemberUpdated) error {
+	member := change.NewChatMember.User
+	if member == nil || member.ID == t.botID || !(change.Chat.IsGroup() || change.Chat.IsSuperGroup()) {
+		return nil
+	}
+	return t.setMember(int64(member.ID), change.Chat.ID, change.NewChatMember.Status, change.Time())
+}
+
+// trackMembers records joins, leaves and the last message of the sender.
+func (t *Tracker) trackMembers(message *tgbotapi.Message) error {
+	groupID := message.Chat.ID
+	var errs []error
+
+	if message.NewChatMembers != nil {
+		for _, member := range *message.NewChatMembers {
+			if member.ID != t.botID {
+				errs = append(errs, t.setMember(int64(member.ID), groupID, updates.StatusMember, message.Time()))
+			}
+		}
+	}
+	if message.LeftChatMember != nil {
+		errs = append(errs, t.setMember(int64(message.LeftChatMember.ID), groupID, updates.StatusLeft, message.Time()))
+	}
+	if message.From != nil && !message.From.IsBot && message.LeftChatMember == nil {
+		errs = append(errs, t.seen(int64(message.From.ID), groupID, message))
+	}
+
+	return errors.Join(errs...)
+}
+
+// setMember applies a member status reported by Telegram to the membership record.
+func (t *Tracker) setMember(userID int64, groupID int64, status string, at time.Time) error {
+	membership, err := t.loadMembership(userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	present := updates.IsPresent(status)
+	changed := present != membership.InGroup
+	switch {
+	case present && changed:
+		membership.Joined = at
+		membership.Left = time.Time{}
+	case !present && changed:
+		membership.Left = at
+	}
+	membership.InGroup = present
+	if present {
+		membership.Role = role(status)
+	}
+	membership.Updated = time.Now()
+
+	if err := t.store.SaveMembership(*membership); err != nil {
+		return err
+	}
+	if changed {
+		return t.refreshUser(userID)
+	}
+	return nil
+}
+
+// seen records the last message of a member, who is evidently in the group.
+func (t *Tracker) seen(userID int64, groupID int64, message *tgbotapi.Message) error {
+	membership, err := t.loadMembership(userID, groupID)
+	if err != nil {
+		return err
+	}
+
+	joined := !membership.InGroup
+	if joined {
+		membership.InGroup = true
+		membership.Joined = message.Time()
+		membership.Left = time.Time{}
+		if membership.Role == "" {
+			membership.Role = db.RoleMember
+		}
+	}
+	membership.LastMessageID = message.MessageID
+	membership.LastSeen = message.Time()
+	membership.Updated = time.Now()
+
+	if err := t.store.SaveMembership(*membership); err != nil {
+		return err
+	}
+	if joined {
+		return t.refreshUser(userID)
+	}
+	return nil
+}
+
+// loadMembership returns the stored membership, or a fresh record if there is none.
+func (t *Tracker) loadMembership(userID int64, groupID int64) (*db.Membership, error) {
+	membership, err := t.store.GetMembership(userID, groupID)
+	if errors.IsNotFound(err) {
+		return &db.Membership{UserID: userID, GroupID: groupID}, nil
+	}
+	return membership, err
+}
+
+// refreshUser updates the user's IsInGroup flag from their memberships.
+func (t *Tracker) refreshUser(userID int64) error {
+	memberships, err := t.store.UserGroups(userID)
+	if err != nil {
+		return err
+	}
+	return t.store.SetUserInGroup(int(userID), len(memberships) > 0)
+}
+
+// role maps a Telegram member status to a membership role.
+func role(status string) string {
+	switch status {
+	case updates.StatusCreator, updates.StatusAdministrator:
+		return db.RoleAdmin
+	case updates.StatusRestricted:
+		return db.RoleRestricted
+	default:
+		return db.RoleMember
+	}
+}
@@ -5,111 +5,220 @@ package handlers
 import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"log"
-	"strings"
 	beta "tg/beta"
+	broadcast "tg/broadcast"
+	cohort "tg/cohort"
+	config "tg/config"
 	db "tg/db"
+	demo "tg/demo"
 	errors "tg/errors"
+	groups "tg/groups"
 	help "tg/help"
+	lifecycle "tg/lifecycle"
+	mail "tg/mail"
+	middleware "tg/middleware"
+	mute "tg/mute"
+	news "tg/news"
+	router "tg/router"
+	sender "tg/sender"
+	social "tg/social"
+	start "tg/start"
+	support "tg/support"
+	tutorial "tg/tutorial"
+	updates "tg/updates"
+	welcome "tg/welcome"
 	"time"
 )
 
+// Handler routes updates to the feature packages and logs them to the store.
+type Handler struct {
+	store      db.Store           // Store messages and profiles are logged to
+	commands   *router.Router     // Router every command is registered with
+	beta       *beta.Handler      // Beta signup wizard
+	broadcasts *broadcast.Service // Admin broadcasts
+	groups     *groups.Tracker    // Keeps the groups collection current
+	welcome    *welcome.Welcomer  // Greets new group members
+	support    *support.Desk      // Support tickets
+	mute       *mute.Muter        // Notification preferences
+	news       *news.Service      // News feeds
+	tutorials  *tutorial.Guide    // Onboarding tutorials
+	demo       *demo.Demo         // Demo of the chat assistant
+	start      *start.Start       // /start and the deep links it carries
+	social     *social.Directory  // Social links directory
+	cohorts    *cohort.Manager    // Beta cohorts and invite codes
+	out        *sender.Sender     // Answers button presses no feature claims
+	pipeline   middleware.Handler // HandleMessage wrapped in the error handling middleware
+}
+
+// New creates a handler for the bot and registers every command with a fresh router.
+// Messages the features send on their own, outside of a reply, go through out.
+func New(bot *tgbotapi.BotAPI, store db.Store, out *sender.Sender, cfg config.Config) *Handler {
+	mailer, err := mail.New(cfg.Mail)
+	if err != nil {
+		log.Printf("Failed to set up the mailer, logging email instead: %v", err)
+		mailer = &mail.File{From: cfg.Mail.From}
+	}
+
+	h := &Handler{
+		store:      store,
+		out:        out,
+		commands:   router.New(bot.Self.UserName),
+		beta:       beta.New(store, out, mailer, cfg.Beta),
+		broadcasts: broadcast.New(store, out),
+		groups:     groups.New(store, bot, bot.Self.ID),
+		welcome:    welcome.New(store, bot, out, cfg.Welcome),
+		support:    support.New(store, out, cfg.Support),
+		mute:       mute.New(store, bot),
+		news:       news.New(store, bot, out, cfg.News),
+		tutorials:  tutorial.New(store, out, cfg.Tutorial),
+		demo:       demo.New(store, out, cfg.Demo),
+		start:      start.New(),
+		social:     social.New(store, bot.Self.UserName, cfg.Social),
+		cohorts:    cohort.New(store, out, bot.Self.UserName, cfg.Beta),
+	}
+	h.commands.SetAdmins(cfg.Telegram.Admins)
+
+	h.beta.Register(h.commands)
+	h.broadcasts.Register(h.commands)
+	h.welcome.Register(h.commands)
+	h.support.Register(h.commands)
+	h.mute.Register(h.commands)
+	h.news.Register(h.commands)
+	h.tutorials.Register(h.commands)
+	h.demo.Register(h.commands)
+	h.start.Register(h.commands)
+	h.social.Register(h.commands, h.start)
+	h.cohorts.Register(h.commands, h.start)
+	help.Register(h.commands)
+
+	h.pipeline = middleware.Chain(h.HandleMessage,
+		middleware.Errors(middleware.DefaultOptions),
+		middleware.Recover,
+	)
+
+	return h
+}
+
+// Commands returns the router holding every registered command.
+func (h *Handler) Commands() *router.Router {
+	return h.commands
+}
+
+// Hooks returns the background work of the features, to be started and stopped with the bot.
+func (h *Handler) Hooks() []lifecycle.Hook {
+	return []lifecycle.Hook{
+		{Name: "broadcasts", OnStart: h.broadcasts.Start, OnStop: h.broadcasts.Stop},
+		{Name: "welcome batches", OnStop: h.welcome.Stop},
+		{Name: "news", OnStart: h.news.Start, OnStop: h.news.Stop},
+		{Name: "social redirects", OnStart: h.social.Start, OnStop: h.social.Stop},
+		{Name: "demo answers", OnStop: h.demo.Stop},
+	}
+}
+
+// Handle runs HandleMessage through the error handling middleware.
+// Failures are retried, apologized for or escalated there; they never stop the bot.
+func (h *Handler) Handle(update *updates.Update) tgbotapi.Chattable {
+	response, _ := h.pipeline(update)
+	return response
+}
+
 // HandleMessage logs the chat message and user profile in the database.
 // It also returns a response based on the content of the message.
-func HandleMessage(update *tgbotapi.Update) tgbotapi.Chattable {
-	var response tgbotapi.Chattable
-	var betaInfo db.Beta // Create a variable to store the Beta information
+func (h *Handler) HandleMessage(u *updates.Update) (tgbotapi.Chattable, error) {
+	var (
+		update   = &u.Update
+		response tgbotapi.Chattable
+		err      error
+	)
+
+	// Membership changes only update the records; there is no one to apologize to
+	if u.MyChatMember != nil || u.ChatMember != nil {
+		var err error
+		if u.MyChatMember != nil {
+			err = h.groups.HandleMyChatMember(u.MyChatMember)
+		} else {
+			err = h.groups.HandleChatMember(u.ChatMember)
+		}
+		if err != nil {
+			errors.HandleError(err)
+		}
+		return nil, nil
+	}
 
 	// Check if the update is a callback query or a message
 	if update.CallbackQuery != nil {
-		response, betaInfo = handleCallbackQuery(update, betaInfo)
+		response, err = h.handleCallbackQuery(update)
 	} else if update.Message != nil {
-		response, betaInfo = handleTextMessage(update, betaInfo)
+		// Tracking is best effort; the message is handled regardless
+		if trackErr := h.groups.HandleMessage(update.Message); trackErr != nil {
+			errors.HandleError(trackErr)
+		}
+		if joinErr := h.welcome.HandleJoin(update.Message); joinErr != nil {
+			errors.HandleError(joinErr)
+		}
+		response, err = h.handleTextMessage(update)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// Log the message and user profile if there is a response
 	if response != nil {
-		logMessageAndUserProfile(update, response)
+		// The response is still sent if logging fails
+		err = h.logMessageAndUserProfile(update, response)
 	}
 
-	return response
+	return response, err
 }
 
 // handleCallbackQuery handles a callback query from a user.
-func handleCallbackQuery(update *tgbotapi.Update, betaInfo db.Beta) (tgbotapi.Chattable, db.Beta) {
-	var response tgbotapi.Chattable // Define response here
-
-	// Handle callback queries here
-	switch update.CallbackQuery.Data {
-	case "yes":
-		betaInfo.APIKey = true
-		response, betaInfo = beta.HandleProvider(update, betaInfo)
-	case "no":
-		betaInfo.APIKey = false
-		response = tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, "Please obtain an API key.")
-	case "azure", "openai":
-		response, betaInfo = beta.HandleModel(update, betaInfo)
-	case "gpt3.5", "gpt4", "gpt4-32k":
-		response, betaInfo = beta.HandleEmail(update, betaInfo) // Adjusted call to HandleEmail
-
-	case "name":
-		response, betaInfo = beta.HandleContact(update, betaInfo)
-	case "contact":
-		betaInfo = db.Beta{
-			Username:      update.CallbackQuery.From.UserName,
-			UserID:        int64(update.CallbackQuery.From.ID),
-			GroupID:       update.CallbackQuery.Message.Chat.ID,
-			APIKey:        betaInfo.APIKey,
-			Provider:      betaInfo.Provider,
-			Model:         betaInfo.Model,
-			Email:         betaInfo.Email,
-			Name:          betaInfo.Name,
-			ContactTime:   betaInfo.ContactTime,
-			ContactMethod: betaInfo.ContactMethod,
-			Created:       time.Now(),
-		}
-		response = beta.HandleSummary(update, betaInfo)
-	case "submit":
-		err := db.SaveBeta(betaInfo) // Save the Beta information to the database
-		if err != nil {
-			log.Fatal(errors.HandleError(err))
-		}
-	case "reset":
-		response, betaInfo = beta.Handle(int64(update.CallbackQuery.From.ID), update.CallbackQuery.Message.Chat.ID, update.CallbackQuery.From.UserName)
+func (h *Handler) handleCallbackQuery(update *tgbotapi.Update) (tgbotapi.Chattable, error) {
+	if response, ok, err := h.broadcasts.HandleCallback(update); ok {
+		return response, err
+	}
+	if response, ok, err := h.support.HandleCallback(update); ok {
+		return response, err
+	}
+	if response, ok, err := h.news.HandleCallback(update); ok {
+		return response, err
 	}
-	return response, betaInfo
+	if response, ok, err := h.tutorials.HandleCallback(update); ok {
+		return response, err
+	}
+	if response, ok, err := h.beta.HandleReview(update); ok {
+		return response, err
+	}
+
+	// Other button presses belong to the wizard that rendered them
+	response, ok, err := h.beta.HandleUpdate(update)
+	if !ok {
+		h.out.Answer(update.CallbackQuery.ID, "This button no longer works.")
+	}
+	return response, err
 }
 
 // handleTextMessage handles a text message from a user.
-func handleTextMessage(update *tgbotapi.Update, betaInfo db.Beta) (tgbotapi.Chattable, db.Beta) {
-	var response tgbotapi.Chattable // Define response here
-
-	// Handle text messages here
-	switch update.Message.Text {
-	case "/beta":
-		response, _ = beta.Handle(int64(update.Message.From.ID), update.Message.Chat.ID, update.Message.From.UserName)
-	default:
-		if strings.Contains(update.Message.Text, "@") && betaInfo.Email == "" {
-			// Assume the message is an email if it contains "@"
-			betaInfo.Email = update.Message.Text
-			response, _ = beta.HandleName(update)
-		} else if betaInfo.Email != "" && betaInfo.Name == "" {
-			// If the bot is waiting for the user's name, store the incoming message as the name
-			betaInfo.Name = update.Message.Text
-			response, _ = beta.HandleContact(update, betaInfo)
-		} else if betaInfo.Name != "" && betaInfo.ContactMethod == "" {
-			// If the bot is waiting for the user's contact information, store the incoming message as the contact method
-			betaInfo.ContactMethod = update.Message.Text
-			response = beta.HandleSummary(update, betaInfo)
-		} else {
-			// Handle other messages
-			response = handleOtherMessages(update)
-		}
+func (h *Handler) handleTextMessage(update *tgbotapi.Update) (tgbotapi.Chattable, error) {
+	// Commands are routed first; anything else may be a wizard answer, a verification code, a demo prompt or part of a ticket
+	if response, ok, err := h.commands.Dispatch(update); ok {
+		return response, err
+	}
+	if response, ok, err := h.beta.HandleUpdate(update); ok {
+		return response, err
 	}
-	return response, betaInfo
+	if response, ok, err := h.beta.HandleMessage(update); ok {
+		return response, err
+	}
+	if response, ok, err := h.demo.HandleMessage(update); ok {
+		return response, err
+	}
+
+	response, _, err := h.support.HandleMessage(update)
+	return response, err
 }
 
 // logMessageAndUserProfile logs a chat message and user profile in the database.
-func logMessageAndUserProfile(update *tgbotapi.Update, response tgbotapi.Chattable) {
+func (h *Handler) logMessageAndUserProfile(update *tgbotapi.Update, response tgbotapi.Chattable) error {
 	var message db.Message
 	var user *db.User
 
@@ -134,12 +243,12 @@ func logMessageAndUserProfile(update *tgbotapi.Update, response tgbotapi.Chattab
 
 		user = &db.User{
 			User: tgbotapi.User{
-				ID:        update.Message.From.ID,
-				FirstName: update.Message.From.FirstName,
-				LastName:  update.Message.From.LastName,
-				UserName:  update.Message.From.UserName,
+				ID:           update.Message.From.ID,
+				FirstName:    update.Message.From.FirstName,
+				LastName:     update.Message.From.LastName,
+				UserName:     update.Message.From.UserName,
+				LanguageCode: update.Message.From.LanguageCode,
 			},
-			IsInGroup:   true,
 			LastUpdated: time.Now(),
 		}
 	} else if update.CallbackQuery != nil {
@@ -155,37 +264,19 @@ func logMessageAndUserProfile(update *tgbotapi.Update, response tgbotapi.Chattab
 
 		user = &db.User{
 			User: tgbotapi.User{
-				ID:        update.CallbackQuery.From.ID,
-				FirstName: update.CallbackQuery.From.FirstName,
-				LastName:  update.CallbackQuery.From.LastName,
-				UserName:  update.CallbackQuery.From.UserName,
+				ID:           update.CallbackQuery.From.ID,
+				FirstName:    update.CallbackQuery.From.FirstName,
+				LastName:     update.CallbackQuery.From.LastName,
+				UserName:     update.CallbackQuery.From.UserName,
+				LanguageCode: update.CallbackQuery.From.LanguageCode,
 			},
-			IsInGroup:   true,
 			LastUpdated: time.Now(),
 		}
 	}
 
 	// Log the chat message and user profile
-	err := db.LogChatMessage(message)
-	if err != nil {
-		log.Fatal(errors.HandleError(err))
-	}
-
-	err = db.LogUserProfile(*user)
-	if err != nil {
-		log.Fatal(errors.HandleError(err))
+	if err := h.store.LogChatMessage(message); err != nil {
+		return err
 	}
-}
-
-// handleOtherMessages handles other types of messages from a user.
-func handleOtherMessages(update *tgbotapi.Update) tgbotapi.Chattable {
-	var response tgbotapi.Chattable
-
-	// Other cases
-	switch update.Message.Text {
-	case "/help":
-		response = tgbotapi.NewMessage(update.Message.Chat.ID, help.Handle())
-
-	}
-	return response
+	return h.store.LogUserProfile(*user)
 }
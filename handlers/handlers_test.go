@@ -0,0 +1,454 @@
+//handlers/handlers_test.go
+
+package handlers
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	sender "tg/sender"
+	updates "tg/updates"
+)
+
+const (
+	applicant  = 7
+	staff      = 99
+	staffChat  = int64(-500)
+	reviewChat = int64(-300)
+	group      = int64(-100)
+)
+
+// fakeAPI records what the features send through the sender, numbers the
+// messages and keeps the texts button presses were answered with.
+type fakeAPI struct {
+	mu      sync.Mutex
+	sent    []tgbotapi.Chattable
+	answers []string
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, c)
+	return tgbotapi.Message{MessageID: 1000 + len(f.sent)}, nil
+}
+
+func (f *fakeAPI) AnswerCallbackQuery(c tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.answers = append(f.answers, c.Text)
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// answered returns the texts button presses were answered with.
+func (f *fakeAPI) answered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.answers...)
+}
+
+// to returns the texts sent to a chat.
+func (f *fakeAPI) to(chatID int64) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var texts []string
+	for _, c := range f.sent {
+		switch m := c.(type) {
+		case tgbotapi.MessageConfig:
+			if m.ChatID == chatID {
+				texts = append(texts, m.Text)
+			}
+		case tgbotapi.EditMessageTextConfig:
+			if m.ChatID == chatID {
+				texts = append(texts, m.Text)
+			}
+		}
+	}
+	return texts
+}
+
+// telegram answers the calls made with the bot client itself, such as
+// admin lookups, without a network.
+type telegram struct{}
+
+func (telegram) RoundTrip(req *http.Request) (*http.Response, error) {
+	result := `{"message_id":1,"date":0,"chat":{"id":1}}`
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/getChatMember"):
+		result = `{"user":{"id":1},"status":"member"}`
+	case strings.HasSuffix(req.URL.Path, "/getChatMembersCount"):
+		result = `5`
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":` + result + `}`)),
+		Request:    req,
+	}, nil
+}
+
+func newHandler(t *testing.T) (*Handler, *fakeAPI, *db.MemoryStore) {
+	cfg := config.Default()
+	cfg.Beta.ReviewChatID = reviewChat
+	cfg.Support.StaffChatID = staffChat
+	cfg.Mail.File = filepath.Join(t.TempDir(), "mail.txt")
+	cfg.Tutorial.Dir = t.TempDir()
+
+	bot := &tgbotapi.BotAPI{
+		Token:  "test",
+		Self:   tgbotapi.User{ID: 1, UserName: "testbot", IsBot: true},
+		Client: &http.Client{Transport: telegram{}},
+	}
+	store := db.NewMemoryStore()
+	api := &fakeAPI{}
+	return New(bot, store, sender.New(api, store, nil, sender.Options{}), cfg), api, store
+}
+
+// message builds a text message from a user, in their private chat unless chatID is set.
+func message(userID int, chatID int64, text string) *updates.Update {
+	chat := &tgbotapi.Chat{ID: int64(userID), Type: "private"}
+	if chatID != 0 {
+		chat = &tgbotapi.Chat{ID: chatID, Type: "supergroup", Title: "Group"}
+	}
+	return &updates.Update{Update: tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: userID, FirstName: name(userID)},
+		Chat:      chat,
+		Text:      text,
+	}}}
+}
+
+// press builds a button press under a message in a chat.
+func press(userID int, chatID int64, data string) *updates.Update {
+	chatType := "private"
+	if chatID < 0 {
+		chatType = "supergroup"
+	}
+	return &updates.Update{Update: tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "q",
+		From: &tgbotapi.User{ID: userID, FirstName: name(userID)},
+		Message: &tgbotapi.Message{
+			MessageID: 50,
+			Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType},
+		},
+		Data: data,
+	}}}
+}
+
+// name returns the first name of a test user.
+func name(userID int) string {
+	if userID == staff {
+		return "Sam"
+	}
+	return "Ann"
+}
+
+// text returns the text of a response, or "" when there is none.
+func text(response tgbotapi.Chattable) string {
+	switch r := response.(type) {
+	case tgbotapi.MessageConfig:
+		return r.Text
+	case tgbotapi.EditMessageTextConfig:
+		return r.Text
+	}
+	return ""
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		update *updates.Update
+		want   string // Part of the response; empty for no response
+	}{
+		{"help", message(applicant, 0, "/help"), "/beta"},
+		{"addressed to the bot", message(applicant, group, "/help@testbot"), "/beta"},
+		{"alias", message(applicant, 0, "/signup"), "Do you have an API Key?"},
+		{"unknown in private", message(applicant, 0, "/nope"), "Unknown command /nope"},
+		{"unknown in a group", message(applicant, group, "/nope"), ""},
+		{"another bot", message(applicant, group, "/help@otherbot"), ""},
+		{"admin only", message(applicant, 0, "/broadcast hi"), "Unknown command /broadcast"},
+		{"private only", message(applicant, group, "/verify 123456"), "only works in private chats"},
+		{"plain text", message(applicant, 0, "hello"), ""},
+		{"unknown button", press(applicant, applicant, "nothing|here"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _, _ := newHandler(t)
+
+			response, err := h.HandleMessage(tt.update)
+			if err != nil {
+				t.Fatalf("HandleMessage: %v", err)
+			}
+			got := text(response)
+			if tt.want == "" && response != nil {
+				t.Errorf("response = %#v, want none", response)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("response = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPressesAreAnswered(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup []*updates.Update // Updates handled before the press
+		press *updates.Update
+		toast string // Part of the answer; empty for an answer without text
+	}{
+		{"wizard", []*updates.Update{message(applicant, 0, "/beta")}, press(applicant, applicant, "beta|api_key|yes"), ""},
+		{"expired wizard", nil, press(applicant, applicant, "beta|api_key|yes"), ""},
+		{"broadcast by a non-admin", nil, press(applicant, group, "broadcast|confirm|b1"), "Only bot administrators"},
+		{"ticket outside the staff chat", nil, press(staff, group, "ticket|resolve|t1"), "only be handled in the staff chat"},
+		{"unknown ticket", nil, press(staff, staffChat, "ticket|resolve|zz"), "no such ticket"},
+		{"news page", nil, press(applicant, applicant, "news|page|0"), ""},
+		{"broken news page", nil, press(applicant, applicant, "news|page|x"), "no longer works"},
+		{"missing tutorial", nil, press(applicant, applicant, "tutorial|getstarted|1"), "no longer available"},
+		{"review outside the review chat", nil, press(staff, group, "review|approve|a1"), "only be reviewed in the review chat"},
+		{"unknown application", nil, press(staff, reviewChat, "review|approve|zz"), "no longer exists"},
+		{"unknown button", nil, press(applicant, applicant, "nothing|here"), "no longer works"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api, _ := newHandler(t)
+			for _, update := range tt.setup {
+				if _, err := h.HandleMessage(update); err != nil {
+					t.Fatalf("setup: %v", err)
+				}
+			}
+
+			if _, err := h.HandleMessage(tt.press); err != nil {
+				t.Fatalf("HandleMessage: %v", err)
+			}
+			answers := api.answered()
+			if len(answers) != 1 {
+				t.Fatalf("press answered %d times, want once", len(answers))
+			}
+			if (tt.toast == "" && answers[0] != "") || !strings.Contains(answers[0], tt.toast) {
+				t.Errorf("answer = %q, want %q", answers[0], tt.toast)
+			}
+		})
+	}
+}
+
+func TestBetaWizard(t *testing.T) {
+	type step struct {
+		update *updates.Update
+		want   string // Part of the response
+	}
+	tests := []struct {
+		name   string
+		steps  []step
+		status string // Of the stored application; empty for none
+	}{
+		{
+			name: "submitted",
+			steps: []step{
+				{message(applicant, group, "/beta"), "Do you have an API Key?"},
+				{press(applicant, applicant, "beta|api_key|yes"), "Azure or OpenAI"},
+				{press(applicant, applicant, "beta|provider|openai"), "What model"},
+				{message(applicant, 0, "gpt4"), "Please use the buttons below."},
+				{press(applicant, applicant, "beta|model|gpt4"), "enter your email"},
+				{message(applicant, 0, "not an address"), "enter your email"},
+				{message(applicant, 0, "ann@example.com"), "What is your name?"},
+				{message(applicant, 0, "Ann"), "How should we contact you?"},
+				{press(applicant, applicant, "beta|provider|azure"), "belongs to an earlier question"},
+				{press(applicant, applicant, "beta|contact_method|telegram"), "best time to contact you"},
+				{message(applicant, 0, "weekdays 9:00-17:00 UTC"), "Please review your information"},
+				{press(applicant, applicant, "beta|confirm|submit"), "Your beta application has been submitted"},
+			},
+			status: db.BetaPending,
+		},
+		{
+			name: "no API key",
+			steps: []step{
+				{message(applicant, 0, "/beta"), "Do you have an API Key?"},
+				{press(applicant, applicant, "beta|api_key|no"), "obtain an API key"},
+				{press(applicant, applicant, "beta|provider|openai"), "This form has expired"},
+			},
+		},
+		{
+			name: "reset",
+			steps: []step{
+				{message(applicant, 0, "/beta"), "Do you have an API Key?"},
+				{press(applicant, applicant, "beta|api_key|yes"), "Azure or OpenAI"},
+				{press(applicant, applicant, "beta|provider|azure"), "What model"},
+				{press(applicant, applicant, "beta|model|gpt3.5"), "enter your email"},
+				{message(applicant, 0, "ann@example.com"), "What is your name?"},
+				{message(applicant, 0, "Ann"), "How should we contact you?"},
+				{press(applicant, applicant, "beta|contact_method|email"), "best time to contact you"},
+				{message(applicant, 0, "18:00-20:00 UTC+2"), "Please review your information"},
+				{press(applicant, applicant, "beta|confirm|reset"), "Do you have an API Key?"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api, store := newHandler(t)
+
+			for i, s := range tt.steps {
+				response, err := h.HandleMessage(s.update)
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if got := text(response); !strings.Contains(got, s.want) {
+					t.Fatalf("step %d: response = %q, want it to contain %q", i, got, s.want)
+				}
+			}
+
+			applications, err := store.ListBetas(db.BetaPending)
+			if err != nil {
+				t.Fatalf("ListBetas: %v", err)
+			}
+			if tt.status == "" {
+				if len(applications) != 0 {
+					t.Errorf("stored %d applications, want none", len(applications))
+				}
+				return
+			}
+			if len(applications) != 1 {
+				t.Fatalf("stored %d applications, want 1", len(applications))
+			}
+			betaInfo := applications[0]
+			if betaInfo.UserID != applicant || betaInfo.GroupID != group || betaInfo.Email != "ann@example.com" || betaInfo.Model != "gpt4" {
+				t.Errorf("application = %+v", betaInfo)
+			}
+			if posts := api.to(reviewChat); len(posts) != 1 || !strings.Contains(posts[0], "ann@example.com") {
+				t.Errorf("review chat got %q, want the application", posts)
+			}
+			if betaInfo.StaffMessageID == 0 {
+				t.Error("the review post was not recorded")
+			}
+		})
+	}
+}
+
+func TestSupportTicket(t *testing.T) {
+	h, api, store := newHandler(t)
+
+	response, err := h.HandleMessage(message(applicant, 0, "/submit I can't log in"))
+	if err != nil || !strings.Contains(text(response), "is open") {
+		t.Fatalf("/submit = %q, %v", text(response), err)
+	}
+	ticket, err := store.ActiveTicket(applicant)
+	if err != nil {
+		t.Fatalf("ActiveTicket: %v", err)
+	}
+	header := ticket.StaffMessageIDs[0]
+
+	tests := []struct {
+		name   string
+		update *updates.Update
+		chat   int64  // Chat the message is relayed to
+		want   string // Part of the relayed message
+	}{
+		{"user follows up", message(applicant, 0, "It says my password is wrong"), staffChat, "It says my password is wrong"},
+		{"staff answers", reply(staff, staffChat, header, "Try resetting it"), applicant, "Try resetting it"},
+		{"staff answers the relayed follow-up", reply(staff, staffChat, header+1, "Did that work?"), applicant, "Did that work?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(api.to(tt.chat))
+			if _, err := h.HandleMessage(tt.update); err != nil {
+				t.Fatalf("HandleMessage: %v", err)
+			}
+			sent := api.to(tt.chat)
+			if len(sent) != before+1 || !strings.Contains(sent[len(sent)-1], tt.want) {
+				t.Errorf("chat %d got %q, want %q relayed", tt.chat, sent[before:], tt.want)
+			}
+		})
+	}
+
+	ticket, err = store.GetTicket(ticket.ID)
+	if err != nil {
+		t.Fatalf("GetTicket: %v", err)
+	}
+	if len(ticket.Messages) != 4 || ticket.AssigneeID != staff || ticket.AssigneeName != "Sam" {
+		t.Errorf("ticket = %+v, want 4 messages assigned to Sam", ticket)
+	}
+
+	// Unrelated staff chatter is not relayed
+	before := len(api.to(applicant))
+	if _, err := h.HandleMessage(message(staff, staffChat, "lunch?")); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.to(applicant)) != before {
+		t.Error("a staff message that answers no ticket was relayed")
+	}
+}
+
+// reply builds a message in a group that replies to another message.
+func reply(userID int, chatID int64, to int, text string) *updates.Update {
+	update := message(userID, chatID, text)
+	update.Message.ReplyToMessage = &tgbotapi.Message{MessageID: to}
+	return update
+}
+
+func TestBetaReview(t *testing.T) {
+	tests := []struct {
+		name    string
+		update  *updates.Update
+		status  string // Of the application afterwards
+		verdict string // Part of the message to the applicant; empty for none
+	}{
+		{"approve", press(staff, reviewChat, "review|approve|a1"), db.BetaApproved, "approved"},
+		{"reject", press(staff, reviewChat, "review|reject|a1"), db.BetaRejected, "can't offer you a place"},
+		{"waitlist", press(staff, reviewChat, "review|waitlist|a1"), db.BetaWaitlisted, "on the waitlist"},
+		{"outside the review chat", press(staff, group, "review|approve|a1"), db.BetaPending, ""},
+		{"unknown action", press(staff, reviewChat, "review|promote|a1"), db.BetaPending, ""},
+		{"unknown application", press(staff, reviewChat, "review|approve|zz"), db.BetaPending, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, api, store := newHandler(t)
+			err := store.SaveBeta(db.Beta{ID: "a1", UserID: applicant, Name: "Ann", Email: "ann@example.com", Status: db.BetaPending})
+			if err != nil {
+				t.Fatalf("SaveBeta: %v", err)
+			}
+
+			// The second press of the same button changes nothing
+			for i := 0; i < 2; i++ {
+				response, err := h.HandleMessage(tt.update)
+				if err != nil {
+					t.Fatalf("press %d: %v", i, err)
+				}
+				if decided := i == 0 && tt.verdict != ""; decided != (response != nil) {
+					t.Errorf("press %d: response = %#v", i, response)
+				}
+			}
+
+			betaInfo, err := store.GetBetaByID("a1")
+			if err != nil {
+				t.Fatalf("GetBetaByID: %v", err)
+			}
+			if betaInfo.Status != tt.status {
+				t.Errorf("status = %q, want %q", betaInfo.Status, tt.status)
+			}
+
+			told := api.to(applicant)
+			if tt.verdict == "" {
+				if len(told) != 0 {
+					t.Errorf("applicant was told %q", told)
+				}
+				return
+			}
+			if len(told) != 1 || !strings.Contains(told[0], tt.verdict) {
+				t.Errorf("applicant was told %q, want one message about %q", told, tt.verdict)
+			}
+			if betaInfo.ReviewerID != staff || len(betaInfo.History) != 1 || betaInfo.History[0].To != tt.status {
+				t.Errorf("application = %+v, want the decision recorded once", betaInfo)
+			}
+		})
+	}
+}
@@ -2,20 +2,32 @@
 
 package help
 
-// Handle responds with the list of available commands and their descriptions.
-func Handle() string {
-	return `Here are the available commands:
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strings"
+	router "tg/router"
+)
+
+// Register adds the /help command to the router.
+func Register(r *router.Router) {
+	r.MustRegister(router.Command{
+		Name:        "help",
+		Aliases:     []string{"commands"},
+		Description: "Get a list of available commands",
+		MaxArgs:     -1,
+		Handler: func(ctx *router.Context) (tgbotapi.Chattable, error) {
+			return ctx.Reply(Handle(r)), nil
+		},
+	})
+}
 
-/welcome - Welcome the new users
-/broadcast - Broadcast a message to all users
-/getstarted - Get started with the bot
-/submit - Submit a ticket for support
-/demo - Get a demo of the bot's functionalities
-/beta - Participate in the beta testing of the bot
-/mute - Mute the bot's notifications
-/help - Get a list of available commands
-/howto - Learn how to use the bot
-/social - Connect with us on social media
-/news - Get the latest news
-/support - Get support for any issues`
+// Handle responds with the list of available commands and their descriptions.
+func Handle(r *router.Router) string {
+	var b strings.Builder
+	b.WriteString("Here are the available commands:\n")
+	for _, cmd := range r.Commands() {
+		fmt.Fprintf(&b, "\n/%s - %s", cmd.Name, cmd.Description)
+	}
+	return b.String()
 }
@@ -0,0 +1,112 @@
+//lifecycle/lifecycle.go
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Hook is a pair of callbacks run when the application starts and stops.
+// Either callback may be nil.
+type Hook struct {
+	Name    string                          // Name used in logs and errors
+	OnStart func(ctx context.Context) error // Called in registration order
+	OnStop  func(ctx context.Context) error // Called in reverse registration order
+}
+
+// Manager runs the registered hooks in order on start and in reverse on stop,
+// so components are stopped before the things they depend on.
+type Manager struct {
+	mu      sync.Mutex
+	hooks   []Hook
+	started int // Number of hooks whose OnStart succeeded
+}
+
+// New creates an empty lifecycle manager.
+func New() *Manager {
+	return &Manager{}
+}
+
+// Append registers a hook. Hooks registered after Start are not started.
+func (m *Manager) Append(hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks = append(m.hooks, hook)
+}
+
+// Start runs every OnStart callback in registration order.
+// If one fails, the hooks already started are stopped again and the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]Hook(nil), m.hooks...)
+	m.mu.Unlock()
+
+	for i, hook := range hooks {
+		if hook.OnStart != nil {
+			if err := hook.OnStart(ctx); err != nil {
+				m.setStarted(i)
+				return errors.Join(fmt.Errorf("starting %s: %w", hook.Name, err), m.Stop(ctx))
+			}
+		}
+		log.Printf("Started %s", hook.Name)
+	}
+	m.setStarted(len(hooks))
+	return nil
+}
+
+// Stop runs the OnStop callbacks of the started hooks in reverse order.
+// Every hook is stopped even if an earlier one fails; the errors are joined.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	hooks := append([]Hook(nil), m.hooks[:m.started]...)
+	m.started = 0
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		if hook.OnStop != nil {
+			if err := hook.OnStop(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("stopping %s: %w", hook.Name, err))
+				continue
+			}
+		}
+		log.Printf("Stopped %s", hook.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// Run starts the hooks, waits for SIGINT, SIGTERM or ctx to be done,
+// then stops the hooks, giving them at most timeout to finish.
+func (m *Manager) Run(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := m.Start(ctx); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	cancel() // A second signal now kills the process immediately
+	log.Printf("Shutting down, waiting up to %s", timeout)
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), timeout)
+	defer stopCancel()
+	return m.Stop(stopCtx)
+}
+
+func (m *Manager) setStarted(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.started = n
+}
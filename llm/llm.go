@@ -0,0 +1,47 @@
+//llm/llm.go
+
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	config "tg/config"
+	"time"
+)
+
+// Message roles.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one turn of a conversation.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Provider answers a conversation with the next assistant message.
+type Provider interface {
+	// Name identifies the provider in logs and the demo banner.
+	Name() string
+	// Complete returns the reply to the conversation, oldest message first.
+	Complete(ctx context.Context, messages []Message) (string, error)
+}
+
+// New creates the provider selected by cfg.
+func New(cfg config.DemoConfig) (Provider, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	switch cfg.Provider {
+	case config.ProviderStub, "":
+		return Stub{}, nil
+	case config.ProviderOpenAI:
+		return &OpenAI{APIKey: cfg.APIKey, Model: cfg.Model, BaseURL: cfg.Endpoint, Client: client}, nil
+	case config.ProviderAzure:
+		return &Azure{APIKey: cfg.APIKey, Endpoint: cfg.Endpoint, Deployment: cfg.Deployment, APIVersion: cfg.APIVersion, Client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown demo provider %q", cfg.Provider)
+}
@@ -0,0 +1,116 @@
+//llm/openai.go
+
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxResponseSize caps how much of a provider response is read.
+const maxResponseSize = 1 << 20
+
+// OpenAI talks to the OpenAI chat completions API, or any server compatible with it.
+type OpenAI struct {
+	APIKey  string
+	Model   string
+	BaseURL string // Defaults to https://api.openai.com/v1
+	Client  *http.Client
+}
+
+// Name identifies the provider.
+func (p *OpenAI) Name() string {
+	return "openai/" + p.Model
+}
+
+// Complete asks the model for the next message.
+func (p *OpenAI) Complete(ctx context.Context, messages []Message) (string, error) {
+	base := strings.TrimSuffix(p.BaseURL, "/")
+	if base == "" {
+		base = "https://api.openai.com/v1"
+	}
+	header := http.Header{"Authorization": {"Bearer " + p.APIKey}}
+	return chatCompletion(ctx, p.Client, base+"/chat/completions", header, chatRequest{Model: p.Model, Messages: messages})
+}
+
+// Azure talks to a chat model deployed on Azure OpenAI.
+type Azure struct {
+	APIKey     string
+	Endpoint   string // e.g. https://my-resource.openai.azure.com
+	Deployment string // Name of the model deployment
+	APIVersion string // e.g. 2024-02-01
+	Client     *http.Client
+}
+
+// Name identifies the provider.
+func (p *Azure) Name() string {
+	return "azure/" + p.Deployment
+}
+
+// Complete asks the deployed model for the next message.
+func (p *Azure) Complete(ctx context.Context, messages []Message) (string, error) {
+	url := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		strings.TrimSuffix(p.Endpoint, "/"), p.Deployment, p.APIVersion)
+	header := http.Header{"Api-Key": {p.APIKey}}
+	return chatCompletion(ctx, p.Client, url, header, chatRequest{Messages: messages})
+}
+
+type chatRequest struct {
+	Model    string    `json:"model,omitempty"`
+	Messages []Message `json:"messages"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message Message `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+		Code    any    `json:"code"`
+	} `json:"error"`
+}
+
+// chatCompletion posts a chat completions request shared by OpenAI and Azure
+// and returns the content of the first choice.
+func chatCompletion(ctx context.Context, client *http.Client, url string, header http.Header, body chatRequest) (string, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", err
+	}
+	var parsed chatResponse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return "", fmt.Errorf("chat completion: %s: %w", resp.Status, err)
+	}
+	if parsed.Error != nil {
+		return "", fmt.Errorf("chat completion: %s: %s", resp.Status, parsed.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("chat completion: %s", resp.Status)
+	}
+	if len(parsed.Choices) == 0 {
+		return "", fmt.Errorf("chat completion: no choices in the response")
+	}
+	return parsed.Choices[0].Message.Content, nil
+}
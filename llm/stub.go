@@ -0,0 +1,57 @@
+//llm/stub.go
+
+package llm
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// stubReplies are the answers Stub picks from.
+var stubReplies = []string{
+	"Here is how I would approach %q: break it into small steps, then tackle them one at a time.",
+	"Good question about %q. In the full product I would answer with your own provider and model.",
+	"Thinking about %q: the short answer is that it depends on what you want to achieve.",
+	"Let me summarize %q back to you, so you can see how a conversation flows.",
+}
+
+// Stub is a provider that needs no network and no key. The same
+// conversation always gets the same reply, which makes it suitable for
+// trying the bot out and for tests.
+type Stub struct{}
+
+// Name identifies the stub.
+func (Stub) Name() string {
+	return "stub"
+}
+
+// Complete picks a canned reply from a hash of the conversation and quotes the last user message.
+func (Stub) Complete(ctx context.Context, messages []Message) (string, error) {
+	last, turns := "", 0
+	h := fnv.New32a()
+	for _, m := range messages {
+		h.Write([]byte(m.Role + ":" + m.Content + "\n"))
+		if m.Role == RoleUser {
+			last = m.Content
+			turns++
+		}
+	}
+	if last == "" {
+		return "Say something and I will answer.", nil
+	}
+
+	quoted := strings.Join(strings.Fields(last), " ")
+	if r := []rune(quoted); len(r) > 60 {
+		quoted = string(r[:59]) + "…"
+	}
+	reply := fmt.Sprintf(stubReplies[h.Sum32()%uint32(len(stubReplies))], quoted)
+	switch {
+	case turns == 2:
+		reply += " (I remember the message you sent before this one.)"
+	case turns > 2:
+		reply += fmt.Sprintf(" (I remember the %d messages you sent before this one.)", turns-1)
+	}
+	return reply, nil
+}
@@ -0,0 +1,47 @@
+//locks/locks.go
+
+package locks
+
+import "sync"
+
+// Keyed is a set of mutexes, one per key, so that work on one user or record
+// is serialized without holding up work on the others. Mutexes are created
+// when first locked and dropped once nobody holds or waits for them.
+// The zero value is ready to use.
+type Keyed[K comparable] struct {
+	mu    sync.Mutex
+	locks map[K]*entry
+}
+
+// entry is the mutex of a key and the number of holders and waiters.
+type entry struct {
+	mu    sync.Mutex
+	users int
+}
+
+// Lock locks the mutex of the key and returns the function that unlocks it.
+func (k *Keyed[K]) Lock(key K) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[K]*entry)
+	}
+	e, ok := k.locks[key]
+	if !ok {
+		e = &entry{}
+		k.locks[key] = e
+	}
+	e.users++
+	k.mu.Unlock()
+
+	e.mu.Lock()
+	return func() {
+		e.mu.Unlock()
+
+		k.mu.Lock()
+		e.users--
+		if e.users == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,51 @@
+//locks/locks_test.go
+
+package locks
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedSerializesOneKey(t *testing.T) {
+	var k Keyed[int64]
+	var wg sync.WaitGroup
+	count := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := k.Lock(1)
+			defer unlock()
+			n := count
+			time.Sleep(time.Microsecond)
+			count = n + 1
+		}()
+	}
+	wg.Wait()
+
+	if count != 50 {
+		t.Errorf("count = %d, want 50", count)
+	}
+	if len(k.locks) != 0 {
+		t.Errorf("%d locks left after use, want none", len(k.locks))
+	}
+}
+
+func TestKeyedKeysAreIndependent(t *testing.T) {
+	var k Keyed[string]
+	unlock := k.Lock("a")
+	defer unlock()
+
+	done := make(chan struct{})
+	go func() {
+		k.Lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking another key waited for the held one")
+	}
+}
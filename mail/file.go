@@ -0,0 +1,55 @@
+//mail/file.go
+
+package mail
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// File is a mailer that delivers nothing. It appends every message to a
+// local file, or logs it when no file is set, so the bot can run and be
+// tested without a mail server.
+type File struct {
+	Path string // File the messages are appended to; empty logs them
+	From string // Sender address
+
+	mu sync.Mutex // Keeps messages from interleaving in the file
+}
+
+// Name identifies the mailer.
+func (f *File) Name() string {
+	if f.Path == "" {
+		return "log"
+	}
+	return "file/" + f.Path
+}
+
+// Send appends the message to the file, or logs it.
+func (f *File) Send(ctx context.Context, msg Message) error {
+	data, err := compose(f.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if f.Path == "" {
+		log.Printf("Email to %s:\n%s", msg.To, data)
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	// Messages are separated like in an mbox file
+	entry := "From bot " + time.Now().Format(time.ANSIC) + "\r\n" + string(data) + "\r\n"
+	if _, err := file.WriteString(entry); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,80 @@
+//mail/mail.go
+
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	config "tg/config"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string // Recipient address, e.g. ann@example.com
+	Subject string
+	Body    string
+}
+
+// Mailer sends email.
+type Mailer interface {
+	// Name identifies the mailer in logs.
+	Name() string
+	// Send delivers the message, or returns why it could not.
+	Send(ctx context.Context, msg Message) error
+}
+
+// New creates the mailer selected by cfg.
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Mailer {
+	case config.MailerFile, "":
+		return &File{Path: cfg.File, From: cfg.From}, nil
+	case config.MailerSMTP:
+		return &SMTP{Host: cfg.Host, Port: cfg.Port, Username: cfg.Username, Password: cfg.Password, From: cfg.From}, nil
+	}
+	return nil, fmt.Errorf("unknown mailer %q", cfg.Mailer)
+}
+
+// compose renders a message as sent over the wire, with CRLF line endings.
+// It refuses addresses that do not parse and headers that would break out of their line.
+func compose(from string, msg Message, at time.Time) ([]byte, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+	recipient, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, fmt.Errorf("subject %q spans lines", msg.Subject)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", sender)
+	fmt.Fprintf(&b, "To: %s\r\n", recipient)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", at.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n")
+	b.WriteString(body)
+	if !strings.HasSuffix(body, "\r\n") {
+		b.WriteString("\r\n")
+	}
+	return b.Bytes(), nil
+}
+
+// address returns the bare address of a possibly named address, e.g. beta@example.com for "Beta <beta@example.com>".
+func address(s string) (string, error) {
+	parsed, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Address, nil
+}
@@ -0,0 +1,114 @@
+//mail/smtp.go
+
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// implicitTLSPort is the SMTP submission port spoken over TLS from the first byte.
+const implicitTLSPort = 465
+
+// SMTP is a mailer that delivers through an SMTP server. On port 465 the
+// connection is TLS from the start; on other ports it is upgraded with
+// STARTTLS when the server offers it. Credentials are only sent over TLS.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string // Empty to send without authentication
+	Password string
+	From     string // Sender address, e.g. Beta Bot <beta@example.com>
+}
+
+// Name identifies the mailer.
+func (s *SMTP) Name() string {
+	return "smtp/" + s.Host
+}
+
+// Send delivers the message. The context bounds the whole conversation with the server.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	data, err := compose(s.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := address(s.From)
+	if err != nil {
+		return err
+	}
+	to, err := address(msg.To)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", s.Host, err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && s.Port != implicitTLSPort {
+		if err := client.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return fmt.Errorf("starting TLS with %s: %w", s.Host, err)
+		}
+	}
+	if s.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("authenticating with %s: %w", s.Host, err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// dial connects to the server, with TLS on the implicit TLS port, and
+// applies the deadline of the context to the connection.
+func (s *SMTP) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if s.Port == implicitTLSPort {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: s.Host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
@@ -3,51 +3,229 @@
 package main
 
 import (
+	"context"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"log"
+	"os"
+	config "tg/config"
 	db "tg/db"
+	dispatch "tg/dispatch"
+	errors "tg/errors"
 	"tg/handlers"
+	lifecycle "tg/lifecycle"
+	ratelimit "tg/ratelimit"
+	sender "tg/sender"
+	updates "tg/updates"
+	webhook "tg/webhook"
+	"time"
 )
 
 func main() {
-	bot, updates, err := initializeBot()
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
+	log.Printf("Loaded configuration:\n%s", cfg.Redacted())
 
-	handleUpdates(bot, updates)
-}
+	app := lifecycle.New()
 
-func initializeBot() (*tgbotapi.BotAPI, tgbotapi.UpdatesChannel, error) {
-	bot, err := tgbotapi.NewBotAPI("6609686170:AAE-yBE_s3NmxUu_q5Ir62iQ-OaLur1BUCU")
+	bot, handler, out, err := initializeBot(cfg, app)
 	if err != nil {
-		return nil, nil, err
+		log.Fatal(err)
 	}
 
-	bot.Debug = true // Change this to false in production
+	pool := dispatch.New(cfg.Workers.Count, cfg.Workers.QueueSize, func(update updates.Update) {
+		handleUpdate(out, handler, cfg.Limits.ReplyTimeout, update)
+	})
+	app.Append(lifecycle.Hook{
+		Name: "worker pool",
+		OnStart: func(ctx context.Context) error {
+			pool.Start()
+			if cfg.Workers.StatsInterval > 0 {
+				go logStats(ctx, pool, cfg.Workers.StatsInterval)
+			}
+			return nil
+		},
+		OnStop: pool.Shutdown, // Drain the in-flight updates
+	})
 
-	db.Connect() // Connect to your MongoDB database
+	// Registered last so it is stopped first: no new updates are fetched while the pool drains
+	app.Append(updateSource(bot, cfg, pool))
 
-	handlers.SetBot(bot) // Set the bot in your handlers package
+	if err := app.Run(context.Background(), cfg.ShutdownTimeout); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+func initializeBot(cfg config.Config, app *lifecycle.Manager) (*tgbotapi.BotAPI, *handlers.Handler, *sender.Sender, error) {
+	bot, err := tgbotapi.NewBotAPI(cfg.Telegram.Token)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	updates, err := bot.GetUpdatesChan(u)
+	bot.Debug = cfg.Telegram.Debug
+
+	database, err := db.Connect(cfg.Mongo) // Connect to your MongoDB database
 	if err != nil {
+		return nil, nil, nil, err
+	}
+	app.Append(lifecycle.Hook{Name: "mongo", OnStop: database.Close})
+
+	limiter := ratelimit.New(cfg.Limits)
+	out := sender.New(bot, database, limiter, sender.DefaultOptions) // Every outbound message goes through the sender
+	handler := handlers.New(bot, database, out, cfg)                 // Inject the store into the handlers
+	for _, hook := range handler.Hooks() {
+		app.Append(hook)
+	}
+
+	if cfg.Workers.StatsInterval > 0 {
+		app.Append(lifecycle.Hook{
+			Name: "rate limiter stats",
+			OnStart: func(ctx context.Context) error {
+				go logLimiterStats(ctx, limiter, cfg.Workers.StatsInterval)
+				return nil
+			},
+		})
+	}
+
+	if err := handler.Commands().SetMyCommands(bot); err != nil {
+		log.Printf("Failed to publish the command menu: %v", err)
+	}
+
+	return bot, handler, out, nil
+}
+
+// updateSource returns the hook that receives updates in the configured mode and feeds them to the pool.
+func updateSource(bot *tgbotapi.BotAPI, cfg config.Config, pool *dispatch.Pool) lifecycle.Hook {
+	var (
+		stop func(ctx context.Context) error
+		quit = make(chan struct{})
+		done = make(chan struct{})
+	)
+
+	return lifecycle.Hook{
+		Name: "updates (" + cfg.Telegram.Mode + ")",
+		OnStart: func(ctx context.Context) error {
+			received, stopReceiving, err := receiveUpdates(bot, cfg)
+			if err != nil {
+				return err
+			}
+			stop = stopReceiving
+
+			// Submit fails once the pool is stopped, which ends the forwarder even
+			// when stopping this hook timed out before the backlog was handed over
+			submit := func(update updates.Update) bool {
+				if err := pool.Submit(update); err != nil {
+					log.Printf("Dropped update %d: %v", update.UpdateID, err)
+					return false
+				}
+				return true
+			}
+
+			go func() {
+				defer close(done)
+				for {
+					select {
+					case update, ok := <-received:
+						if !ok || !submit(update) {
+							return
+						}
+					case <-quit:
+						// Hand over whatever was already received, then stop
+						for {
+							select {
+							case update, ok := <-received:
+								if !ok || !submit(update) {
+									return
+								}
+							default:
+								return
+							}
+						}
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			err := stop(ctx)
+			close(quit)
+
+			select {
+			case <-done:
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// receiveUpdates starts long polling or the webhook server, depending on the configured mode.
+// The returned function stops receiving updates.
+func receiveUpdates(bot *tgbotapi.BotAPI, cfg config.Config) (<-chan updates.Update, func(context.Context) error, error) {
+	if cfg.Telegram.Mode == config.ModeWebhook {
+		server, err := webhook.Serve(bot, cfg.Webhook, bot.Buffer)
+		if err != nil {
+			return nil, nil, err
+		}
+		return server.Updates(), server.Shutdown, nil
+	}
+
+	// getUpdates is refused while a webhook is registered
+	if _, err := bot.RemoveWebhook(); err != nil {
 		return nil, nil, err
 	}
 
-	return bot, updates, nil
+	poller := updates.Poll(bot, cfg.Telegram.UpdateTimeout, bot.Buffer)
+	stop := func(context.Context) error {
+		poller.Stop()
+		return nil
+	}
+	return poller.Updates(), stop, nil
+}
+
+// handleUpdate runs on a worker of the pool; updates of the same chat arrive in order.
+// The reply gives up after timeout rather than hold up the chats sharing the worker.
+func handleUpdate(out *sender.Sender, handler *handlers.Handler, timeout time.Duration, update updates.Update) {
+	response := handler.Handle(&update)
+	if response != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if _, err := out.SendContext(ctx, response); err != nil {
+			log.Printf("Failed to reply to update %d: %v", update.UpdateID, errors.HandleError(err))
+		}
+	}
 }
 
-func handleUpdates(bot *tgbotapi.BotAPI, updates tgbotapi.UpdatesChannel) {
-	for update := range updates {
-		if update.Message != nil || update.CallbackQuery != nil {
-			response := handlers.HandleMessage(&update)
-			if response != nil {
-				bot.Send(response)
+// logStats periodically logs the worker pool's throughput and backpressure until ctx is done.
+func logStats(ctx context.Context, pool *dispatch.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			log.Printf("Worker pool: %s", pool.Stats())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// logLimiterStats periodically logs how much outbound traffic each lane of the limiter held back.
+func logLimiterStats(ctx context.Context, limiter *ratelimit.Limiter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, s := range limiter.Stats() {
+				log.Printf("Rate limiter: %s", s)
 			}
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,154 @@
+//middleware/middleware.go
+
+package middleware
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"runtime/debug"
+	errors "tg/errors"
+	updates "tg/updates"
+)
+
+// Replies sent when an update could not be handled.
+const (
+	Apology     = "Sorry, something went wrong on our side. Please try again in a moment."
+	RetryPrompt = "Sorry, we couldn't process that just now. Please send it again."
+)
+
+// Handler handles one update and returns the response to send.
+type Handler func(update *updates.Update) (tgbotapi.Chattable, error)
+
+// Middleware wraps a Handler with extra behaviour.
+type Middleware func(next Handler) Handler
+
+// Action is what the error middleware does with a failed update.
+type Action int
+
+const (
+	Ignore    Action = iota // Treat the update as handled, e.g. a duplicate write
+	Retry                   // A transient failure: ask the user to send the update again
+	Apologize               // Tell the user something went wrong
+	Escalate                // Apologize and report the failure to the operators
+)
+
+// String returns the name of the action.
+func (a Action) String() string {
+	switch a {
+	case Ignore:
+		return "ignore"
+	case Retry:
+		return "retry"
+	case Apologize:
+		return "apologize"
+	default:
+		return "escalate"
+	}
+}
+
+// Decide picks the action for an error from its category.
+func Decide(err error) Action {
+	switch errors.Classify(err) {
+	case errors.Duplicate:
+		return Ignore
+	case errors.Forbidden:
+		return Ignore // The chat cannot be replied to anyway
+	case errors.Timeout, errors.Unavailable, errors.RateLimited:
+		return Retry
+	case errors.NotFound, errors.InvalidInput:
+		return Apologize
+	default:
+		return Escalate
+	}
+}
+
+// Options configures the error middleware.
+type Options struct {
+	Escalate func(update *updates.Update, err error) // Called for errors that need an operator; logs when nil
+}
+
+// DefaultOptions escalates to the log.
+var DefaultOptions = Options{}
+
+// Chain wraps h with the middlewares; the first one is the outermost.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// Recover turns a panic in the handler into an error so one bad update cannot stop the bot.
+func Recover(next Handler) Handler {
+	return func(update *updates.Update) (response tgbotapi.Chattable, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic handling update %d: %v\n%s", update.UpdateID, r, debug.Stack())
+			}
+		}()
+		return next(update)
+	}
+}
+
+// Errors decides, for every error returned by the handler, whether to ignore
+// it, apologize to the user or escalate. It never returns an error itself.
+//
+// Handlers are never run twice: by the time one fails it may already have
+// sent messages, saved records or mailed someone, and running it again would
+// do all of that twice. Transient failures are retried where that is safe,
+// on the single store call or Telegram request that failed (see db.Connect
+// and the sender); when they still fail, the user is asked to try again.
+//
+// A handler that returns a response together with an error has done its main
+// work; the response is sent and the error is only reported.
+func Errors(opts Options) Middleware {
+	return func(next Handler) Handler {
+		return func(update *updates.Update) (tgbotapi.Chattable, error) {
+			response, err := next(update)
+			if err == nil {
+				return response, nil
+			}
+
+			action := Decide(err)
+			if response != nil {
+				if action != Ignore {
+					log.Printf("Update %d handled with error (%s): %v", update.UpdateID, action, errors.HandleError(err))
+				}
+				return response, nil
+			}
+
+			switch action {
+			case Ignore:
+				log.Printf("Update %d: ignoring %v", update.UpdateID, err)
+				return nil, nil
+			case Retry:
+				log.Printf("Update %d failed transiently, asking to send it again: %v", update.UpdateID, errors.HandleError(err))
+				return reply(update, RetryPrompt), nil
+			case Apologize:
+				log.Printf("Update %d failed: %v", update.UpdateID, errors.HandleError(err))
+			default:
+				opts.escalate(update, err)
+			}
+			return reply(update, Apology), nil
+		}
+	}
+}
+
+// escalate reports an error that needs an operator.
+func (o Options) escalate(update *updates.Update, err error) {
+	if o.Escalate != nil {
+		o.Escalate(update, err)
+		return
+	}
+	log.Printf("ESCALATE: update %d in chat %d failed: %v", update.UpdateID, updates.ChatID(*update), errors.HandleError(err))
+}
+
+// reply builds a message with the text for the chat the update came from.
+func reply(update *updates.Update, text string) tgbotapi.Chattable {
+	chatID := updates.ChatID(*update)
+	if chatID == 0 {
+		return nil
+	}
+	return tgbotapi.NewMessage(chatID, text)
+}
@@ -0,0 +1,72 @@
+//middleware/middleware_test.go
+
+package middleware
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+	errors "tg/errors"
+	updates "tg/updates"
+)
+
+func TestErrors(t *testing.T) {
+	reply := tgbotapi.NewMessage(1, "done")
+	tests := []struct {
+		name      string
+		response  tgbotapi.Chattable
+		err       error
+		want      string // Text of the response, empty for none
+		escalated bool
+	}{
+		{"success", reply, nil, "done", false},
+		{"response with error", reply, errors.ErrFailedToUpdateDatabase, "done", false},
+		{"transient", nil, fmt.Errorf("saving: %w", errors.ErrDatabaseConnection), RetryPrompt, false},
+		{"rate limited", nil, &errors.TelegramAPIError{Code: 429, Description: "Too Many Requests", RetryAfter: 5}, RetryPrompt, false},
+		{"duplicate", nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}, "", false},
+		{"forbidden", nil, &errors.TelegramAPIError{Code: 403, Description: "Forbidden: bot was blocked by the user"}, "", false},
+		{"not found", nil, mongo.ErrNoDocuments, Apology, false},
+		{"unknown", nil, fmt.Errorf("boom"), Apology, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, escalated := 0, false
+			handler := Chain(func(*updates.Update) (tgbotapi.Chattable, error) {
+				calls++
+				return tt.response, tt.err
+			}, Errors(Options{Escalate: func(*updates.Update, error) { escalated = true }}))
+
+			update := &updates.Update{Update: tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}}}}
+			response, err := handler(update)
+			if err != nil {
+				t.Fatalf("middleware returned %v", err)
+			}
+			if calls != 1 {
+				t.Errorf("handler ran %d times, want once", calls)
+			}
+			text := ""
+			if msg, ok := response.(tgbotapi.MessageConfig); ok {
+				text = msg.Text
+			}
+			if text != tt.want {
+				t.Errorf("response %q, want %q", text, tt.want)
+			}
+			if escalated != tt.escalated {
+				t.Errorf("escalated = %v, want %v", escalated, tt.escalated)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	handler := Chain(func(*updates.Update) (tgbotapi.Chattable, error) {
+		panic("bad update")
+	}, Errors(Options{Escalate: func(*updates.Update, error) {}}), Recover)
+
+	update := &updates.Update{Update: tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}}}}
+	response, err := handler(update)
+	if err != nil || response == nil {
+		t.Fatalf("got %v, %v; want an apology", response, err)
+	}
+}
@@ -0,0 +1,98 @@
+//mute/check.go
+
+package mute
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	db "tg/db"
+	errors "tg/errors"
+	validate "tg/validate"
+	"time"
+)
+
+// Decision is how a notification may reach a chat.
+type Decision int
+
+const (
+	Deliver  Decision = iota // Send it as usual
+	Silent                   // Send it without a sound, during quiet hours
+	Suppress                 // Do not send it
+)
+
+// PreferenceStore is the part of the store Check needs.
+type PreferenceStore interface {
+	GetPreferences(chatID int64) (*db.Preferences, error)
+}
+
+// Check decides how a notification of the category may reach a chat at now.
+// Chats without preferences get everything. Every path that sends
+// notifications on its own, rather than in reply to the user, must call it,
+// including the reminder sender once there is one.
+func Check(store PreferenceStore, chatID int64, category string, now time.Time) (Decision, string, error) {
+	prefs, err := store.GetPreferences(chatID)
+	if errors.IsNotFound(err) {
+		return Deliver, "", nil
+	}
+	if err != nil {
+		return Suppress, "", err
+	}
+	decision, reason := Decide(prefs, category, now)
+	return decision, reason, nil
+}
+
+// Decide applies a chat's preferences to a notification of the category at now.
+// The reason says why a notification is suppressed or silent.
+func Decide(prefs *db.Preferences, category string, now time.Time) (Decision, string) {
+	if now.Before(prefs.SnoozedUntil) {
+		return Suppress, "snoozed until " + prefs.SnoozedUntil.UTC().Format(time.RFC1123)
+	}
+	for _, muted := range prefs.Muted {
+		if muted == category {
+			return Suppress, category + " are muted"
+		}
+	}
+	if inQuietHours(prefs, now) {
+		return Silent, "quiet hours"
+	}
+	return Deliver, ""
+}
+
+// inQuietHours reports whether now falls in the chat's quiet hours, which may span midnight.
+func inQuietHours(prefs *db.Preferences, now time.Time) bool {
+	if prefs.QuietStart == prefs.QuietEnd {
+		return false
+	}
+
+	loc, err := validate.Location(prefs.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if prefs.QuietStart < prefs.QuietEnd {
+		return minute >= prefs.QuietStart && minute < prefs.QuietEnd
+	}
+	return minute >= prefs.QuietStart || minute < prefs.QuietEnd
+}
+
+// parseClock parses a time of day such as 22:00 or 7 into minutes after midnight.
+func parseClock(s string) (int, error) {
+	hours, minutes, _ := strings.Cut(strings.TrimSpace(s), ":")
+	h, err := strconv.Atoi(hours)
+	m := 0
+	if err == nil && minutes != "" {
+		m, err = strconv.Atoi(minutes)
+	}
+	if err != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// clock formats minutes after midnight as HH:MM.
+func clock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
@@ -0,0 +1,116 @@
+//mute/check_test.go
+
+package mute
+
+import (
+	"testing"
+	db "tg/db"
+	"time"
+)
+
+func TestInQuietHours(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		timezone   string
+		at         string // UTC time of day checked on 15 January 2024
+		quiet      bool
+	}{
+		{"unset", "00:00", "00:00", "", "03:00", false},
+		{"same day inside", "13:00", "15:00", "", "14:59", true},
+		{"same day at the end", "13:00", "15:00", "", "15:00", false},
+		{"same day before", "13:00", "15:00", "", "12:59", false},
+		{"over midnight late", "22:00", "07:30", "", "23:10", true},
+		{"over midnight early", "22:00", "07:30", "", "07:29", true},
+		{"over midnight at the start", "22:00", "07:30", "", "22:00", true},
+		{"over midnight day", "22:00", "07:30", "", "12:00", false},
+		{"zone ahead", "22:00", "07:00", "Europe/Berlin", "21:30", true},      // 22:30 in Berlin
+		{"zone ahead day", "22:00", "07:00", "Europe/Berlin", "06:30", false}, // 07:30 in Berlin
+		{"zone behind", "22:00", "07:00", "UTC-5", "04:00", true},             // 23:00 the day before
+		{"zone behind day", "22:00", "07:00", "UTC-5", "13:00", false},        // 08:00
+		{"half hour zone", "09:00", "10:00", "+05:30", "03:45", true},         // 09:15
+		{"unknown zone is UTC", "22:00", "07:00", "Mars/Olympus", "23:00", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, _ := parseClock(tt.start)
+			end, _ := parseClock(tt.end)
+			at, err := time.Parse("2006-01-02 15:04", "2024-01-15 "+tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefs := &db.Preferences{QuietStart: start, QuietEnd: end, Timezone: tt.timezone}
+			if got := inQuietHours(prefs, at); got != tt.quiet {
+				t.Errorf("inQuietHours at %s UTC = %v, want %v", tt.at, got, tt.quiet)
+			}
+		})
+	}
+}
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2024, 1, 15, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		prefs    db.Preferences
+		category string
+		want     Decision
+	}{
+		{"no settings", db.Preferences{}, db.CategoryNews, Deliver},
+		{"muted", db.Preferences{Muted: []string{db.CategoryNews}}, db.CategoryNews, Suppress},
+		{"other muted", db.Preferences{Muted: []string{db.CategoryNews}}, db.CategoryBroadcasts, Deliver},
+		{"reminders muted", db.Preferences{Muted: []string{db.CategoryReminders}}, db.CategoryReminders, Suppress},
+		{"snoozed", db.Preferences{SnoozedUntil: now.Add(time.Hour)}, db.CategoryBroadcasts, Suppress},
+		{"snooze over", db.Preferences{SnoozedUntil: now.Add(-time.Hour)}, db.CategoryBroadcasts, Deliver},
+		{"quiet hours", db.Preferences{QuietStart: 22 * 60, QuietEnd: 7 * 60}, db.CategoryBroadcasts, Silent},
+		{"muted in quiet hours", db.Preferences{Muted: []string{db.CategoryNews}, QuietStart: 22 * 60, QuietEnd: 7 * 60}, db.CategoryNews, Suppress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := Decide(&tt.prefs, tt.category, now)
+			if got != tt.want {
+				t.Errorf("Decide = %v (%s), want %v", got, reason, tt.want)
+			}
+			if (reason == "") != (got == Deliver) {
+				t.Errorf("reason = %q for decision %v", reason, got)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	store := db.NewMemoryStore()
+	now := time.Now()
+	if got, _, err := Check(store, 42, db.CategoryNews, now); err != nil || got != Deliver {
+		t.Errorf("Check without preferences = %v, %v; want Deliver", got, err)
+	}
+
+	if err := store.SavePreferences(db.Preferences{ChatID: 42, Muted: []string{db.CategoryNews}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := Check(store, 42, db.CategoryNews, now); err != nil || got != Suppress {
+		t.Errorf("Check = %v, %v; want Suppress", got, err)
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"22:00", 22 * 60, true},
+		{"7", 7 * 60, true},
+		{" 07:30 ", 7*60 + 30, true},
+		{"0:05", 5, true},
+		{"24:00", 0, false},
+		{"12:60", 0, false},
+		{"noon", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseClock(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseClock(%q) = %d, %v; want %d, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+//mute/mute.go
+
+package mute
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strconv"
+	"strings"
+	db "tg/db"
+	errors "tg/errors"
+	groups "tg/groups"
+	router "tg/router"
+	validate "tg/validate"
+	"time"
+)
+
+// maxSnoozeDays is the longest snooze; muting is meant for anything longer.
+const maxSnoozeDays = 90
+
+// badClock answers a quiet hour that is not a time of day.
+const badClock = "%q is not a time of day. Use 24-hour times like 22:00-07:30."
+
+// usage lists the forms of the command.
+const usage = `<broadcasts|news|reminders|all> - mute a kind of notification
+off [broadcasts|news|reminders|all] - unmute, and end a snooze
+quiet <22:00-07:00|off> - send silently during these hours
+tz <Europe/Berlin|UTC+2> - the time zone of the quiet hours
+snooze <days|off> - pause every notification for a while`
+
+// Muter lets users and group admins choose which notifications reach their chat.
+// In a private chat the settings are the user's; in a group they are the group's.
+type Muter struct {
+	store    db.Store
+	api      groups.MemberAPI // Looks up group admins
+	commands *router.Router   // Set by Register; bot admins may manage any group
+}
+
+// New creates a muter keeping preferences in the store.
+func New(store db.Store, api groups.MemberAPI) *Muter {
+	return &Muter{store: store, api: api}
+}
+
+// Register adds the /mute command to the router.
+func (m *Muter) Register(r *router.Router) {
+	m.commands = r
+	r.MustRegister(router.Command{
+		Name:        "mute",
+		Description: "Mute the bot's notifications",
+		Usage:       usage,
+		MaxArgs:     2,
+		Handler:     m.handleCommand,
+	})
+}
+
+// handleCommand shows or changes the chat's notification preferences.
+func (m *Muter) handleCommand(ctx *router.Context) (tgbotapi.Chattable, error) {
+	chatID := ctx.Message.Chat.ID
+	userID := ctx.Message.From.ID
+
+	prefs, err := m.store.GetPreferences(chatID)
+	if errors.IsNotFound(err) {
+		prefs, err = &db.Preferences{ChatID: chatID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sub := ""
+	if len(ctx.Args) > 0 {
+		sub = strings.ToLower(ctx.Args[0])
+	}
+	if sub == "" || sub == "show" {
+		return ctx.Reply(describe(prefs, time.Now()) + "\n\nUsage: /mute " + usage), nil
+	}
+
+	if !ctx.Message.Chat.IsPrivate() {
+		admin, err := groups.IsAdmin(m.api, m.commands, chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !admin {
+			return ctx.Reply("Only group admins can change the group's notifications."), nil
+		}
+	}
+
+	arg := ""
+	if len(ctx.Args) > 1 {
+		arg = strings.ToLower(ctx.Args[1])
+	}
+
+	var reply string
+	switch sub {
+	case "off":
+		if arg == "" || arg == "all" {
+			prefs.Muted = nil
+			prefs.SnoozedUntil = time.Time{}
+			reply = "All notifications are on again."
+			break
+		}
+		if !isCategory(arg) {
+			return ctx.Reply(fmt.Sprintf("Unknown notification %q; use %s or all.", arg, strings.Join(db.Categories, ", "))), nil
+		}
+		prefs.Muted = remove(prefs.Muted, arg)
+		reply = fmt.Sprintf("%s are on again.", capitalize(arg))
+	case "quiet":
+		if arg == "" {
+			return ctx.Reply("Usage: /mute quiet <22:00-07:00|off>"), nil
+		}
+		if arg == "off" {
+			prefs.QuietStart, prefs.QuietEnd = 0, 0
+			reply = "Quiet hours are off."
+			break
+		}
+		from, to, ok := strings.Cut(arg, "-")
+		if !ok {
+			return ctx.Reply("Usage: /mute quiet <22:00-07:00|off>"), nil
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return ctx.Reply(fmt.Sprintf(badClock, from)), nil
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return ctx.Reply(fmt.Sprintf(badClock, to)), nil
+		}
+		if start == end {
+			return ctx.Reply("Quiet hours must start and end at different times."), nil
+		}
+		prefs.QuietStart, prefs.QuietEnd = start, end
+		reply = fmt.Sprintf("Notifications will be silent from %s to %s (%s).", clock(start), clock(end), zoneName(prefs.Timezone))
+		if prefs.Timezone == "" {
+			reply += " Set your time zone with /mute tz <zone> if that is not yours."
+		}
+	case "tz":
+		if len(ctx.Args) < 2 {
+			return ctx.Reply("Usage: /mute tz <Europe/Berlin|UTC+2>"), nil
+		}
+		loc, err := validate.Location(ctx.Args[1])
+		if err != nil {
+			return ctx.Reply(err.Error()), nil
+		}
+		prefs.Timezone = loc.String()
+		reply = fmt.Sprintf("Quiet hours are now in %s, where it is %s.", prefs.Timezone, time.Now().In(loc).Format("15:04"))
+	case "snooze":
+		if arg == "off" {
+			prefs.SnoozedUntil = time.Time{}
+			reply = "The snooze is over."
+			break
+		}
+		days, err := strconv.Atoi(strings.TrimSuffix(arg, "d"))
+		if err != nil || days < 1 || days > maxSnoozeDays {
+			return ctx.Reply(fmt.Sprintf("Usage: /mute snooze <days>, from 1 to %d, or /mute snooze off", maxSnoozeDays)), nil
+		}
+		prefs.SnoozedUntil = time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		reply = fmt.Sprintf("Every notification is paused until %s.", prefs.SnoozedUntil.UTC().Format(time.RFC1123))
+	case "all":
+		prefs.Muted = append([]string(nil), db.Categories...)
+		reply = "All notifications are muted. Send /mute off to turn them back on."
+	default:
+		if !isCategory(sub) {
+			return ctx.Reply("Usage: /mute " + usage), nil
+		}
+		if !contains(prefs.Muted, sub) {
+			prefs.Muted = append(prefs.Muted, sub)
+		}
+		reply = fmt.Sprintf("%s are muted. Send /mute off %s to turn them back on.", capitalize(sub), sub)
+	}
+
+	prefs.UpdatedBy = int64(userID)
+	prefs.Updated = time.Now()
+	if err := m.store.SavePreferences(*prefs); err != nil {
+		return nil, err
+	}
+	return ctx.Reply(reply), nil
+}
+
+// describe summarizes the preferences for /mute.
+func describe(prefs *db.Preferences, now time.Time) string {
+	var b strings.Builder
+	b.WriteString("Notifications in this chat:\n")
+	for _, category := range db.Categories {
+		state := "on"
+		if contains(prefs.Muted, category) {
+			state = "muted"
+		}
+		fmt.Fprintf(&b, "\n%s: %s", capitalize(category), state)
+	}
+
+	if prefs.QuietStart != prefs.QuietEnd {
+		fmt.Fprintf(&b, "\nQuiet hours: %s to %s (%s)", clock(prefs.QuietStart), clock(prefs.QuietEnd), zoneName(prefs.Timezone))
+	} else {
+		b.WriteString("\nQuiet hours: off")
+	}
+	if now.Before(prefs.SnoozedUntil) {
+		fmt.Fprintf(&b, "\nSnoozed until %s", prefs.SnoozedUntil.UTC().Format(time.RFC1123))
+	}
+	return b.String()
+}
+
+// zoneName names the time zone of the quiet hours.
+func zoneName(timezone string) string {
+	if timezone == "" {
+		return "UTC"
+	}
+	return timezone
+}
+
+// isCategory reports whether s names a notification category.
+func isCategory(s string) bool {
+	return contains(db.Categories, s)
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(values []string, s string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != s {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,92 @@
+//mute/mute_test.go
+
+package mute
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strings"
+	"testing"
+	db "tg/db"
+	router "tg/router"
+)
+
+const (
+	userID  = 7
+	groupID = int64(-100)
+)
+
+// fakeAPI answers member lookups with the user's status.
+type fakeAPI struct {
+	status string
+}
+
+func (f *fakeAPI) GetChatMember(config tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error) {
+	return tgbotapi.ChatMember{Status: f.status}, nil
+}
+
+// command builds an update of the user sending text in a chat.
+func command(chatID int64, text string) *tgbotapi.Update {
+	chatType := "private"
+	if chatID < 0 {
+		chatType = "group"
+	}
+	return &tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: userID},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType},
+		Text:      text,
+		Entities:  &[]tgbotapi.MessageEntity{{Type: "bot_command", Length: len(strings.Fields(text)[0])}},
+	}}
+}
+
+func TestCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		chatID   int64
+		status   string // Status of the user in the group
+		commands []string
+		reply    string // Part of the last reply
+		prefs    db.Preferences
+	}{
+		{"mute", userID, "", []string{"/mute news"}, "News are muted", db.Preferences{Muted: []string{db.CategoryNews}}},
+		{"mute reminders", userID, "", []string{"/mute reminders"}, "Reminders are muted", db.Preferences{Muted: []string{db.CategoryReminders}}},
+		{"mute all", userID, "", []string{"/mute all"}, "All notifications are muted", db.Preferences{Muted: db.Categories}},
+		{"unmute", userID, "", []string{"/mute all", "/mute off news"}, "News are on again", db.Preferences{Muted: []string{db.CategoryBroadcasts, db.CategoryReminders}}},
+		{"unknown category", userID, "", []string{"/mute off weather"}, `Unknown notification "weather"`, db.Preferences{}},
+		{"quiet hours", userID, "", []string{"/mute tz UTC+2", "/mute quiet 22:00-7:30"}, "silent from 22:00 to 07:30 (UTC+2)", db.Preferences{Timezone: "UTC+2", QuietStart: 22 * 60, QuietEnd: 7*60 + 30}},
+		{"bad quiet hours", userID, "", []string{"/mute quiet 22:00-25:00"}, `"25:00" is not a time of day. Use 24-hour times like 22:00-07:30.`, db.Preferences{}},
+		{"group admin", groupID, "administrator", []string{"/mute broadcasts"}, "Broadcasts are muted", db.Preferences{Muted: []string{db.CategoryBroadcasts}}},
+		{"group member", groupID, "member", []string{"/mute broadcasts"}, "Only group admins", db.Preferences{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.NewMemoryStore()
+			r := router.New("bot")
+			New(store, &fakeAPI{status: tt.status}).Register(r)
+
+			var reply tgbotapi.MessageConfig
+			for _, text := range tt.commands {
+				response, ok, err := r.Dispatch(command(tt.chatID, text))
+				if !ok || err != nil {
+					t.Fatalf("Dispatch(%q) = %v, %v", text, ok, err)
+				}
+				reply, _ = response.(tgbotapi.MessageConfig)
+			}
+			if !strings.Contains(reply.Text, tt.reply) {
+				t.Errorf("reply = %q, want it to contain %q", reply.Text, tt.reply)
+			}
+
+			prefs, err := store.GetPreferences(tt.chatID)
+			if err != nil {
+				prefs = &db.Preferences{}
+			}
+			if strings.Join(prefs.Muted, ",") != strings.Join(tt.prefs.Muted, ",") {
+				t.Errorf("muted = %v, want %v", prefs.Muted, tt.prefs.Muted)
+			}
+			if prefs.Timezone != tt.prefs.Timezone || prefs.QuietStart != tt.prefs.QuietStart || prefs.QuietEnd != tt.prefs.QuietEnd {
+				t.Errorf("quiet hours = %d-%d in %q, want %d-%d in %q",
+					prefs.QuietStart, prefs.QuietEnd, prefs.Timezone, tt.prefs.QuietStart, tt.prefs.QuietEnd, tt.prefs.Timezone)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+//news/feed.go
+
+package news
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	db "tg/db"
+	"time"
+	"unicode/utf8"
+)
+
+// maxFeedSize caps how much of a feed response is read.
+const maxFeedSize = 5 << 20
+
+// maxSummary is the number of characters of an item's description kept as its summary.
+const maxSummary = 300
+
+// feed decodes RSS 2.0, RSS 1.0 (RDF) and Atom documents alike: each format
+// only fills the fields it has.
+type feed struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`  // RSS 1.0 keeps items outside the channel
+	Entries []atomEntry `xml:"entry"` // Atom
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"date"` // Dublin Core, used by RSS 1.0
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+}
+
+// FetchFeed downloads an RSS or Atom feed and returns its items. Items
+// without a GUID are identified by their link, or their title and date.
+func FetchFeed(ctx context.Context, client *http.Client, url string) ([]db.NewsItem, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+	return ParseFeed(body, url)
+}
+
+// ParseFeed reads the items of an RSS or Atom document fetched from source.
+func ParseFeed(data []byte, source string) ([]db.NewsItem, error) {
+	var f feed
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charsetReader
+	if err := decoder.Decode(&f); err != nil {
+		return nil, fmt.Errorf("parsing feed %s: %w", source, err)
+	}
+
+	var items []db.NewsItem
+	for _, it := range append(f.Channel.Items, f.Items...) {
+		items = append(items, newItem(source, it.GUID, it.Title, strings.TrimSpace(it.Link), it.Description, firstNonEmpty(it.PubDate, it.Date)))
+	}
+	for _, entry := range f.Entries {
+		link := ""
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = l.Href
+				break
+			}
+		}
+		items = append(items, newItem(source, entry.ID, entry.Title, link, firstNonEmpty(entry.Summary, entry.Content), firstNonEmpty(entry.Published, entry.Updated)))
+	}
+	return items, nil
+}
+
+// newItem builds an item from the fields common to every feed format.
+func newItem(source, guid, title, link, description, date string) db.NewsItem {
+	item := db.NewsItem{
+		Title:     plainText(title, 0),
+		Link:      link,
+		Summary:   plainText(description, maxSummary),
+		Published: parseDate(date),
+	}
+
+	guid = strings.TrimSpace(guid)
+	switch {
+	case guid != "":
+		item.GUID = guid
+	case link != "":
+		item.GUID = link
+	default:
+		item.GUID = source + "#" + item.Title + "@" + item.Published.UTC().Format(time.RFC3339)
+	}
+	if item.Title == "" {
+		item.Title = "(untitled)"
+	}
+	return item
+}
+
+// dateLayouts are the date formats met in feeds, most common first.
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseDate reads a feed date, returning the zero time if no layout fits.
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+var tags = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// plainText strips markup from s, collapses whitespace and shortens it to max characters, 0 for no limit.
+func plainText(s string, max int) string {
+	s = html.UnescapeString(tags.ReplaceAllString(s, " "))
+	s = strings.Join(strings.Fields(s), " ")
+	if max > 0 && utf8.RuneCountInString(s) > max {
+		s = string([]rune(s)[:max-1]) + "…"
+	}
+	return s
+}
+
+// charsetReader lets the decoder read Latin-1 feeds besides UTF-8 ones.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "latin1", "latin-1", "windows-1252":
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return nil, fmt.Errorf("unsupported feed encoding %q", label)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+//news/feed_test.go
+
+package news
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rss2 = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+  <channel>
+    <title>Example</title>
+    <item>
+      <guid>post-1</guid>
+      <title><![CDATA[First &amp; <b>bold</b>]]></title>
+      <link>https://example.com/1</link>
+      <description>&lt;p&gt;Hello   world&lt;/p&gt;</description>
+      <pubDate>Mon, 02 Jan 2006 15:04:05 +0000</pubDate>
+    </item>
+    <item>
+      <title>No GUID</title>
+      <link> https://example.com/2 </link>
+    </item>
+  </channel>
+</rss>`
+
+const rss1 = `<?xml version="1.0" encoding="UTF-8"?>
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel rdf:about="https://example.com/">
+    <title>Example</title>
+  </channel>
+  <item rdf:about="https://example.com/1">
+    <title>RDF item</title>
+    <link>https://example.com/1</link>
+    <description>Summary</description>
+    <dc:date>2006-01-02T15:04:05Z</dc:date>
+  </item>
+</rdf:RDF>`
+
+const atom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Example</title>
+  <entry>
+    <id>urn:uuid:1</id>
+    <title>Atom entry</title>
+    <link rel="self" href="https://example.com/self"/>
+    <link href="https://example.com/1"/>
+    <content>Full content</content>
+    <updated>2006-01-02T15:04:05Z</updated>
+  </entry>
+</feed>`
+
+// latin1 declares ISO-8859-1 and spells "Café" with the single byte 0xE9.
+const latin1 = "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n" +
+	"<rss version=\"2.0\"><channel><item><guid>cafe</guid><title>Caf\xe9</title></item></channel></rss>"
+
+func TestFetchFeed(t *testing.T) {
+	published := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		body      string
+		guids     []string
+		title     string // Of the first item
+		link      string
+		summary   string
+		published time.Time
+	}{
+		{
+			name:      "RSS 2.0",
+			body:      rss2,
+			guids:     []string{"post-1", "https://example.com/2"},
+			title:     "First & bold",
+			link:      "https://example.com/1",
+			summary:   "Hello world",
+			published: published,
+		},
+		{
+			name:      "RSS 1.0",
+			body:      rss1,
+			guids:     []string{"https://example.com/1"},
+			title:     "RDF item",
+			link:      "https://example.com/1",
+			summary:   "Summary",
+			published: published,
+		},
+		{
+			name:      "Atom",
+			body:      atom,
+			guids:     []string{"urn:uuid:1"},
+			title:     "Atom entry",
+			link:      "https://example.com/1",
+			summary:   "Full content",
+			published: published,
+		},
+		{
+			name:  "Latin-1",
+			body:  latin1,
+			guids: []string{"cafe"},
+			title: "Café",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/xml")
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			items, err := FetchFeed(context.Background(), server.Client(), server.URL)
+			if err != nil {
+				t.Fatalf("FetchFeed: %v", err)
+			}
+			if len(items) != len(tt.guids) {
+				t.Fatalf("got %d items, want %d", len(items), len(tt.guids))
+			}
+			for i, guid := range tt.guids {
+				if items[i].GUID != guid {
+					t.Errorf("item %d GUID = %q, want %q", i, items[i].GUID, guid)
+				}
+			}
+
+			first := items[0]
+			if first.Title != tt.title {
+				t.Errorf("Title = %q, want %q", first.Title, tt.title)
+			}
+			if first.Link != tt.link {
+				t.Errorf("Link = %q, want %q", first.Link, tt.link)
+			}
+			if first.Summary != tt.summary {
+				t.Errorf("Summary = %q, want %q", first.Summary, tt.summary)
+			}
+			if !first.Published.Equal(tt.published) {
+				t.Errorf("Published = %v, want %v", first.Published, tt.published)
+			}
+		})
+	}
+}
+
+func TestFetchFeedErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{name: "not found", status: http.StatusNotFound, body: rss2, want: "404"},
+		{name: "server error", status: http.StatusInternalServerError, body: rss2, want: "500"},
+		{name: "not a feed", status: http.StatusOK, body: "<html><body", want: "parsing feed"},
+		{name: "unknown encoding", status: http.StatusOK, body: `<?xml version="1.0" encoding="KOI8-R"?><rss/>`, want: "unsupported feed encoding"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			items, err := FetchFeed(context.Background(), server.Client(), server.URL)
+			if err == nil {
+				t.Fatalf("FetchFeed returned %d items, want an error", len(items))
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
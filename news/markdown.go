@@ -0,0 +1,98 @@
+//news/markdown.go
+
+package news
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	db "tg/db"
+)
+
+// ReadDir reads every .md file of a directory as one news item.
+//
+// A file may start with a front matter block between "---" lines holding
+// title, date, link and guid fields. Without a title there, the first "#"
+// heading is used. The summary is the first paragraph of the text. Items are
+// identified by their guid field or their file name, and dated by their date
+// field or the file's modification time.
+func ReadDir(dir string) ([]db.NewsItem, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+
+	var items []db.NewsItem
+	for _, path := range paths {
+		item, err := readMarkdown(path)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// readMarkdown reads one markdown news file.
+func readMarkdown(path string) (db.NewsItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return db.NewsItem{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return db.NewsItem{}, err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	item := db.NewsItem{GUID: "md:" + name, Published: info.ModTime()}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	inFrontMatter, lineNo := false, 0
+	var paragraph []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lineNo++
+
+		switch {
+		case lineNo == 1 && line == "---":
+			inFrontMatter = true
+		case inFrontMatter && line == "---":
+			inFrontMatter = false
+		case inFrontMatter:
+			key, value, _ := strings.Cut(line, ":")
+			value = strings.Trim(strings.TrimSpace(value), `"'`)
+			switch strings.ToLower(strings.TrimSpace(key)) {
+			case "title":
+				item.Title = value
+			case "date":
+				if t := parseDate(value); !t.IsZero() {
+					item.Published = t
+				}
+			case "link":
+				item.Link = value
+			case "guid":
+				item.GUID = value
+			}
+		case strings.HasPrefix(line, "#"):
+			if item.Title == "" {
+				item.Title = strings.TrimSpace(strings.TrimLeft(line, "#"))
+			}
+		case line == "":
+			if len(paragraph) > 0 && item.Summary == "" {
+				item.Summary = plainText(strings.Join(paragraph, " "), maxSummary)
+			}
+			paragraph = nil
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	if len(paragraph) > 0 && item.Summary == "" {
+		item.Summary = plainText(strings.Join(paragraph, " "), maxSummary)
+	}
+	if item.Title == "" {
+		item.Title = name
+	}
+	return item, scanner.Err()
+}
@@ -0,0 +1,394 @@
+//news/news.go
+
+package news
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	config "tg/config"
+	db "tg/db"
+	errors "tg/errors"
+	groups "tg/groups"
+	mute "tg/mute"
+	ratelimit "tg/ratelimit"
+	router "tg/router"
+	sender "tg/sender"
+	"time"
+)
+
+// pageSize is the number of items on a /news page.
+const pageSize = 5
+
+// callbackPrefix starts the data of the page buttons.
+const callbackPrefix = "news"
+
+// Service ingests news from feeds and a markdown directory, serves it with
+// /news and pushes new items to subscribed chats.
+//
+// Items are deduplicated by GUID. Those found on the first read of a source
+// are stored as already pushed, so adding a feed does not flood subscribers
+// with its backlog. Pushing skips chats that muted news.
+type Service struct {
+	store    db.Store
+	api      groups.MemberAPI // Looks up group admins
+	out      *sender.Sender
+	cfg      config.NewsConfig
+	client   *http.Client
+	commands *router.Router // Set by Register; bot admins may manage any group
+
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// New creates a news service reading the sources of cfg.
+func New(store db.Store, api groups.MemberAPI, out *sender.Sender, cfg config.NewsConfig) *Service {
+	return &Service{
+		store:  store,
+		api:    api,
+		out:    out,
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Register adds the /news command to the router.
+func (s *Service) Register(r *router.Router) {
+	s.commands = r
+	r.MustRegister(router.Command{
+		Name:        "news",
+		Description: "Get the latest news",
+		Usage:       "[page|subscribe|unsubscribe]",
+		MaxArgs:     1,
+		Handler:     s.handleCommand,
+	})
+}
+
+// Start reads the sources right away and then every interval.
+func (s *Service) Start(ctx context.Context) error {
+	if len(s.cfg.Feeds) == 0 && s.cfg.Dir == "" {
+		return nil
+	}
+
+	runCtx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			s.Sync(runCtx)
+			select {
+			case <-ticker.C:
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop interrupts reading and pushing and waits for them to return.
+func (s *Service) Stop(ctx context.Context) error {
+	if s.stop == nil {
+		return nil
+	}
+	s.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sync reads every source once and pushes the new items. A source that
+// fails is logged and retried on the next sync.
+func (s *Service) Sync(ctx context.Context) {
+	for _, url := range s.cfg.Feeds {
+		items, err := FetchFeed(ctx, s.client, url)
+		if err != nil {
+			log.Printf("Failed to read news feed %s: %v", url, err)
+			continue
+		}
+		s.ingest(url, items)
+	}
+	if s.cfg.Dir != "" {
+		items, err := ReadDir(s.cfg.Dir)
+		if err != nil {
+			log.Printf("Failed to read news directory %s: %v", s.cfg.Dir, err)
+		} else {
+			s.ingest(s.cfg.Dir, items)
+		}
+	}
+
+	if s.cfg.Push {
+		if err := s.push(ctx); err != nil {
+			log.Printf("Failed to push news: %v", errors.HandleError(err))
+		}
+	}
+}
+
+// ingest stores the items of a source that are not known yet.
+func (s *Service) ingest(source string, items []db.NewsItem) {
+	known, err := s.store.CountNews(source)
+	if err != nil {
+		log.Printf("Failed to count the news from %s: %v", source, errors.HandleError(err))
+		return
+	}
+
+	now := time.Now()
+	added := 0
+	for _, item := range items {
+		item.Source = source
+		item.Fetched = now
+		if item.Published.IsZero() {
+			item.Published = now
+		}
+		// The backlog of a new source, and anything read while pushing is off, is never pushed
+		item.Pushed = known == 0 || !s.cfg.Push
+
+		isNew, err := s.store.AddNewsItem(item)
+		if err != nil {
+			log.Printf("Failed to store news item %s: %v", item.GUID, errors.HandleError(err))
+			return
+		}
+		if isNew {
+			added++
+		}
+	}
+	if added > 0 {
+		log.Printf("Stored %d new items from %s", added, source)
+	}
+}
+
+// push sends the items not pushed yet to every subscribed chat that has not muted news.
+// An item is marked as pushed before it is sent, so a crash cannot send it twice.
+func (s *Service) push(ctx context.Context) error {
+	items, err := s.store.UnpushedNews()
+	if err != nil || len(items) == 0 {
+		return err
+	}
+	subscribers, err := s.store.Subscribers(db.CategoryNews)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := s.store.MarkNewsPushed(item.GUID); err != nil {
+			return err
+		}
+
+		for _, chatID := range subscribers {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !s.out.Reachable(chatID) {
+				continue
+			}
+			decision, _, err := mute.Check(s.store, chatID, db.CategoryNews, time.Now())
+			if err != nil {
+				return err
+			}
+			if decision == mute.Suppress {
+				continue
+			}
+
+			msg := tgbotapi.NewMessage(chatID, render(item))
+			msg.DisableNotification = decision == mute.Silent
+			if _, err := s.out.SendPriority(ctx, msg, ratelimit.Broadcast); err != nil {
+				log.Printf("Failed to push news item %s to %d: %v", item.GUID, chatID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// handleCommand shows a page of news or changes the chat's subscription.
+func (s *Service) handleCommand(ctx *router.Context) (tgbotapi.Chattable, error) {
+	arg := ""
+	if len(ctx.Args) > 0 {
+		arg = strings.ToLower(ctx.Args[0])
+	}
+
+	switch arg {
+	case "subscribe", "unsubscribe":
+		return s.subscribe(ctx, arg == "subscribe")
+	case "":
+		text, markup, err := s.page(0)
+		if err != nil {
+			return nil, err
+		}
+		return pageMessage(ctx.Message.Chat.ID, text, markup), nil
+	}
+
+	number, err := strconv.Atoi(arg)
+	if err != nil || number < 1 {
+		return ctx.Reply("Usage: /news [page|subscribe|unsubscribe]"), nil
+	}
+	text, markup, err := s.page(number - 1)
+	if err != nil {
+		return nil, err
+	}
+	return pageMessage(ctx.Message.Chat.ID, text, markup), nil
+}
+
+// HandleCallback handles the Previous and Next buttons under a page of news.
+// The boolean result reports whether the button belonged to the news; those
+// presses are answered.
+func (s *Service) HandleCallback(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	query := update.CallbackQuery
+	if query == nil || query.Message == nil {
+		return nil, false, nil
+	}
+
+	parts := strings.SplitN(query.Data, "|", 3)
+	if len(parts) != 3 || parts[0] != callbackPrefix || parts[1] != "page" {
+		return nil, false, nil
+	}
+	number, err := strconv.Atoi(parts[2])
+	if err != nil || number < 0 {
+		s.out.Answer(query.ID, "This button no longer works.")
+		return nil, true, nil
+	}
+	defer s.out.Answer(query.ID, "")
+
+	text, markup, err := s.page(number)
+	if err != nil {
+		return nil, true, err
+	}
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.DisableWebPagePreview = true
+	if markup != nil {
+		edit.ReplyMarkup = markup
+	}
+	return edit, true, nil
+}
+
+// page renders a page of news, counted from 0, with the buttons leading to its neighbours.
+func (s *Service) page(number int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	total, err := s.store.CountNews("")
+	if err != nil {
+		return "", nil, err
+	}
+	if total == 0 {
+		return "There is no news yet.", nil, nil
+	}
+
+	pages := (total + pageSize - 1) / pageSize
+	if number >= pages {
+		number = pages - 1
+	}
+	items, err := s.store.ListNews(number*pageSize, pageSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Latest news, page %d of %d:", number+1, pages)
+	for i, item := range items {
+		fmt.Fprintf(&b, "\n\n%d. %s (%s)", number*pageSize+i+1, item.Title, item.Published.Format("2 Jan 2006"))
+		if item.Summary != "" {
+			fmt.Fprintf(&b, "\n%s", item.Summary)
+		}
+		if item.Link != "" {
+			fmt.Fprintf(&b, "\n%s", item.Link)
+		}
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if number > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("« Previous", fmt.Sprintf("%s|page|%d", callbackPrefix, number-1)))
+	}
+	if number < pages-1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Next »", fmt.Sprintf("%s|page|%d", callbackPrefix, number+1)))
+	}
+	if len(row) == 0 {
+		return b.String(), nil, nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(row)
+	return b.String(), &markup, nil
+}
+
+// subscribe adds the chat to, or removes it from, the chats new items are pushed to.
+func (s *Service) subscribe(ctx *router.Context, on bool) (tgbotapi.Chattable, error) {
+	chatID := ctx.Message.Chat.ID
+	userID := ctx.Message.From.ID
+
+	if !ctx.Message.Chat.IsPrivate() {
+		admin, err := groups.IsAdmin(s.api, s.commands, chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !admin {
+			return ctx.Reply("Only group admins can subscribe the group to news."), nil
+		}
+	}
+
+	prefs, err := s.store.GetPreferences(chatID)
+	if errors.IsNotFound(err) {
+		prefs, err = &db.Preferences{ChatID: chatID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []string
+	for _, category := range prefs.Subscribed {
+		if category != db.CategoryNews {
+			kept = append(kept, category)
+		}
+	}
+	prefs.Subscribed = kept
+	reply := "This chat will no longer get news as it is published."
+	if on {
+		prefs.Subscribed = append(prefs.Subscribed, db.CategoryNews)
+		reply = "This chat will get news as it is published. Send /news unsubscribe to stop."
+		if !s.cfg.Push {
+			reply = "Subscribed. News is not sent out automatically yet; until it is, use /news to read it."
+		}
+	}
+
+	prefs.UpdatedBy = int64(userID)
+	prefs.Updated = time.Now()
+	if err := s.store.SavePreferences(*prefs); err != nil {
+		return nil, err
+	}
+	return ctx.Reply(reply), nil
+}
+
+// render formats an item pushed on its own.
+func render(item db.NewsItem) string {
+	text := item.Title
+	if item.Summary != "" {
+		text += "\n\n" + item.Summary
+	}
+	if item.Link != "" {
+		text += "\n\n" + item.Link
+	}
+	return text
+}
+
+// pageMessage builds the message holding a page of news.
+func pageMessage(chatID int64, text string, markup *tgbotapi.InlineKeyboardMarkup) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.DisableWebPagePreview = true
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	return msg
+}
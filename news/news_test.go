@@ -0,0 +1,119 @@
+//news/news_test.go
+
+package news
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	sender "tg/sender"
+	"time"
+)
+
+const subscriber = int64(42)
+
+// fakeAPI records the texts that are sent.
+type fakeAPI struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := c.(tgbotapi.MessageConfig); ok {
+		f.sent = append(f.sent, m.Text)
+	}
+	return tgbotapi.Message{MessageID: 1}, nil
+}
+
+func (f *fakeAPI) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+// feedServer serves an RSS feed of the titles it is given.
+type feedServer struct {
+	mu     sync.Mutex
+	titles []string
+}
+
+func (s *feedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprint(w, `<rss version="2.0"><channel>`)
+	for _, title := range s.titles {
+		fmt.Fprintf(w, "<item><guid>%s</guid><title>%s</title></item>", title, title)
+	}
+	fmt.Fprint(w, `</channel></rss>`)
+}
+
+func (s *feedServer) publish(title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.titles = append(s.titles, title)
+}
+
+func TestSyncPushesOnlyNewItems(t *testing.T) {
+	tests := []struct {
+		name   string
+		push   bool
+		pushed []string // Titles sent to the subscriber
+	}{
+		{name: "push on", push: true, pushed: []string{"third"}},
+		{name: "push off", push: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feed := &feedServer{titles: []string{"first", "second"}}
+			server := httptest.NewServer(feed)
+			defer server.Close()
+
+			store := db.NewMemoryStore()
+			if err := store.SavePreferences(db.Preferences{ChatID: subscriber, Subscribed: []string{db.CategoryNews}}); err != nil {
+				t.Fatalf("SavePreferences: %v", err)
+			}
+			api := &fakeAPI{}
+			s := New(store, nil, sender.New(api, store, nil, sender.Options{}), config.NewsConfig{Feeds: []string{server.URL}, Interval: time.Hour, Push: tt.push})
+
+			// The backlog of a new source is stored but not pushed
+			s.Sync(context.Background())
+			if sent := api.texts(); len(sent) != 0 {
+				t.Fatalf("backlog pushed: %q", sent)
+			}
+			if n, _ := store.CountNews(server.URL); n != 2 {
+				t.Fatalf("stored %d items, want 2", n)
+			}
+
+			// Items seen again are not stored twice, new ones are pushed once
+			feed.publish("third")
+			s.Sync(context.Background())
+			s.Sync(context.Background())
+
+			if n, _ := store.CountNews(server.URL); n != 3 {
+				t.Errorf("stored %d items, want 3", n)
+			}
+			sent := api.texts()
+			if len(sent) != len(tt.pushed) {
+				t.Fatalf("sent %q, want %d messages", sent, len(tt.pushed))
+			}
+			for i, title := range tt.pushed {
+				if !strings.Contains(sent[i], title) {
+					t.Errorf("message %d = %q, want %q", i, sent[i], title)
+				}
+			}
+			if unpushed, _ := store.UnpushedNews(); len(unpushed) != 0 {
+				t.Errorf("%d items left unpushed", len(unpushed))
+			}
+		})
+	}
+}
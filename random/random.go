@@ -0,0 +1,40 @@
+//random/random.go
+
+package random
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Digits is the alphabet of numeric codes.
+const Digits = "0123456789"
+
+// ID returns a short random identifier of eight hex digits, such as the ones
+// of broadcasts, tickets and beta applications. Identifiers only need to be
+// distinct, so the clock stands in when the system's randomness fails.
+func ID() string {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Code returns length characters drawn uniformly from alphabet. Codes must
+// not be guessable, so there is no fallback when the system's randomness fails.
+func Code(alphabet string, length int) (string, error) {
+	size := big.NewInt(int64(len(alphabet)))
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		code[i] = alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
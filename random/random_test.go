@@ -0,0 +1,42 @@
+//random/random_test.go
+
+package random
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := ID()
+		if len(id) != 8 || strings.Trim(id, "0123456789abcdef") != "" {
+			t.Fatalf("ID() = %q, want eight hex digits", id)
+		}
+		if seen[id] {
+			t.Fatalf("ID() repeated %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		alphabet string
+		length   int
+	}{
+		{Digits, 6},
+		{"ABCDEFGHJKLMNPQRSTUVWXYZ23456789", 8},
+		{"x", 3},
+	}
+	for _, tt := range tests {
+		code, err := Code(tt.alphabet, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != tt.length || strings.Trim(code, tt.alphabet) != "" {
+			t.Errorf("Code(%q, %d) = %q", tt.alphabet, tt.length, code)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+//ratelimit/ratelimit.go
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	config "tg/config"
+	"time"
+)
+
+// Priority is the lane a message is sent in. Lower values go first.
+type Priority int
+
+const (
+	Interactive Priority = iota // Replies to something a user just did
+	Broadcast                   // Bulk sends such as /broadcast and news pushes
+	lanes
+)
+
+// String returns the name of the lane.
+func (p Priority) String() string {
+	if p == Interactive {
+		return "interactive"
+	}
+	return "broadcast"
+}
+
+// idleAfter is how long an unused chat bucket is kept before it is dropped.
+const idleAfter = 5 * time.Minute
+
+// bucket is a token bucket refilled continuously at rate tokens per second.
+type bucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64
+	last     time.Time
+}
+
+func newBucket(rate float64, capacity float64, now time.Time) *bucket {
+	return &bucket{tokens: capacity, capacity: capacity, rate: rate, last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// delay returns how long until a token is available.
+func (b *bucket) delay() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Limiter schedules outbound messages so the bot stays under Telegram's
+// global, per-chat and per-group limits. Interactive messages always get
+// the next free slot before broadcasts do.
+type Limiter struct {
+	mu      sync.Mutex
+	cfg     config.LimitConfig
+	global  *bucket
+	chats   map[int64]*bucket
+	groups  map[int64]*bucket
+	waiting [lanes]int // Callers currently waiting in each lane
+	swept   time.Time
+
+	granted  [lanes]atomic.Int64
+	delayed  [lanes]atomic.Int64
+	waitTime [lanes]atomic.Int64
+}
+
+// Stats is a snapshot of the limiter's counters for one lane.
+type Stats struct {
+	Lane     Priority
+	Granted  int64         // Messages let through
+	Delayed  int64         // Messages that had to wait for a token
+	WaitTime time.Duration // Total time spent waiting
+	Waiting  int           // Messages waiting right now
+}
+
+// New creates a limiter with the configured rates.
+func New(cfg config.LimitConfig) *Limiter {
+	now := time.Now()
+	return &Limiter{
+		cfg:    cfg,
+		global: newBucket(cfg.Global, cfg.Global, now),
+		chats:  make(map[int64]*bucket),
+		groups: make(map[int64]*bucket),
+		swept:  now,
+	}
+}
+
+// Wait blocks until a message to chatID may be sent in the given lane, or ctx is done.
+// Group chats, which have negative IDs, are also held to the per-group limit.
+// A chatID of 0 is only held to the global limit, for calls that are not
+// messages to a chat.
+func (l *Limiter) Wait(ctx context.Context, chatID int64, p Priority) error {
+	if p < Interactive || p >= lanes {
+		p = Broadcast
+	}
+
+	start := time.Now()
+	queued := false
+	defer func() {
+		if queued {
+			l.mu.Lock()
+			l.waiting[p]--
+			l.mu.Unlock()
+		}
+	}()
+
+	for {
+		wait := l.reserve(chatID, p, &queued)
+		if wait == 0 {
+			l.granted[p].Add(1)
+			if queued {
+				l.delayed[p].Add(1)
+				l.waitTime[p].Add(int64(time.Since(start)))
+			}
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve takes a token from every bucket that applies and returns 0, or returns
+// how long to wait before trying again. The first time it has to wait, the caller
+// is counted in its lane so that lower lanes yield to it.
+func (l *Limiter) reserve(chatID int64, p Priority, queued *bool) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	buckets := []*bucket{l.global}
+	if chatID != 0 {
+		buckets = append(buckets, l.chatBucket(chatID, now))
+	}
+	if chatID < 0 {
+		buckets = append(buckets, l.groupBucket(chatID, now))
+	}
+
+	var wait time.Duration
+	for _, b := range buckets {
+		b.refill(now)
+		if d := b.delay(); d > wait {
+			wait = d
+		}
+	}
+
+	// Leave the next global slot to the higher lanes while they have someone waiting
+	if wait == 0 && l.higherWaiting(p) {
+		wait = time.Duration(float64(time.Second) / l.cfg.Global)
+	}
+
+	if wait > 0 {
+		if !*queued {
+			*queued = true
+			l.waiting[p]++
+		}
+		return wait
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0
+}
+
+func (l *Limiter) higherWaiting(p Priority) bool {
+	for lane := Interactive; lane < p; lane++ {
+		if l.waiting[lane] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Limiter) chatBucket(chatID int64, now time.Time) *bucket {
+	b, ok := l.chats[chatID]
+	if !ok {
+		b = newBucket(l.cfg.PerChat, 1, now)
+		l.chats[chatID] = b
+	}
+	return b
+}
+
+func (l *Limiter) groupBucket(chatID int64, now time.Time) *bucket {
+	b, ok := l.groups[chatID]
+	if !ok {
+		b = newBucket(l.cfg.PerGroupMinute/60, l.cfg.PerGroupMinute, now)
+		l.groups[chatID] = b
+	}
+	return b
+}
+
+// sweep drops buckets that have not been used for a while; they would be full again anyway.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < idleAfter {
+		return
+	}
+	l.swept = now
+
+	for _, buckets := range []map[int64]*bucket{l.chats, l.groups} {
+		for id, b := range buckets {
+			if now.Sub(b.last) > idleAfter {
+				delete(buckets, id)
+			}
+		}
+	}
+}
+
+// Stats returns a snapshot of the counters of every lane.
+func (l *Limiter) Stats() []Stats {
+	l.mu.Lock()
+	waiting := l.waiting
+	l.mu.Unlock()
+
+	stats := make([]Stats, 0, lanes)
+	for p := Interactive; p < lanes; p++ {
+		stats = append(stats, Stats{
+			Lane:     p,
+			Granted:  l.granted[p].Load(),
+			Delayed:  l.delayed[p].Load(),
+			WaitTime: time.Duration(l.waitTime[p].Load()),
+			Waiting:  waiting[p],
+		})
+	}
+	return stats
+}
+
+// String formats the stats for logging.
+func (s Stats) String() string {
+	return fmt.Sprintf("lane=%s granted=%d delayed=%d wait_time=%s waiting=%d",
+		s.Lane, s.Granted, s.Delayed, s.WaitTime, s.Waiting)
+}
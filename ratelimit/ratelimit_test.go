@@ -0,0 +1,114 @@
+//ratelimit/ratelimit_test.go
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	config "tg/config"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.LimitConfig
+		chatID int64
+		want   int // Of 50 sends, the ones let through within the test window
+	}{
+		{"private chat", config.LimitConfig{Global: 1000, PerChat: 1, PerGroupMinute: 20}, 42, 1},
+		{"group", config.LimitConfig{Global: 1000, PerChat: 1000, PerGroupMinute: 20}, -42, 20},
+		{"no chat", config.LimitConfig{Global: 1000, PerChat: 1, PerGroupMinute: 20}, 0, 50},
+		{"global", config.LimitConfig{Global: 2, PerChat: 1000, PerGroupMinute: 1000}, 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.cfg)
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			granted := 0
+			for i := 0; i < 50; i++ {
+				if err := l.Wait(ctx, tt.chatID, Interactive); err != nil {
+					break
+				}
+				granted++
+			}
+			if granted != tt.want {
+				t.Errorf("granted %d sends, want %d", granted, tt.want)
+			}
+
+			stats := l.Stats()[Interactive]
+			if stats.Granted != int64(tt.want) || stats.Waiting != 0 {
+				t.Errorf("stats = %s, want %d granted and none waiting", stats, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefill(t *testing.T) {
+	l := New(config.LimitConfig{Global: 1000, PerChat: 20, PerGroupMinute: 20})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background(), 42, Broadcast); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	// The first send takes the full bucket, the next two wait 50ms each for a refill
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || elapsed > time.Second {
+		t.Errorf("three sends took %s, want about 100ms", elapsed)
+	}
+
+	stats := l.Stats()[Broadcast]
+	if stats.Granted != 3 || stats.Delayed != 2 || stats.WaitTime < 80*time.Millisecond {
+		t.Errorf("stats = %s, want 3 granted, 2 delayed", stats)
+	}
+}
+
+func TestInteractiveFirst(t *testing.T) {
+	l := New(config.LimitConfig{Global: 10, PerChat: 1000, PerGroupMinute: 1000})
+
+	// Use up the global bucket
+	for i := 0; i < 10; i++ {
+		if err := l.Wait(context.Background(), int64(i+1), Broadcast); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+
+	var (
+		mu    sync.Mutex
+		order []Priority
+		wg    sync.WaitGroup
+	)
+	send := func(chatID int64, p Priority) {
+		defer wg.Done()
+		if err := l.Wait(context.Background(), chatID, p); err != nil {
+			t.Errorf("Wait: %v", err)
+		}
+		mu.Lock()
+		order = append(order, p)
+		mu.Unlock()
+	}
+
+	// The broadcast has been waiting longer, but the reply goes first
+	wg.Add(2)
+	go send(100, Broadcast)
+	time.Sleep(20 * time.Millisecond)
+	go send(200, Interactive)
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != Interactive {
+		t.Errorf("sent in order %v, want the interactive lane first", order)
+	}
+
+	stats := l.Stats()
+	if stats[Interactive].Delayed != 1 || stats[Broadcast].Delayed != 1 || stats[Broadcast].Granted != 11 {
+		t.Errorf("stats = %s; %s", stats[Interactive], stats[Broadcast])
+	}
+	if stats[Broadcast].WaitTime <= stats[Interactive].WaitTime {
+		t.Errorf("broadcast waited %s, reply %s; want the broadcast to wait longer", stats[Broadcast].WaitTime, stats[Interactive].WaitTime)
+	}
+}
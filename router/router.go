@@ -0,0 +1,315 @@
+//router/router.go
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Chat types a command can be restricted to.
+const (
+	Private = "private" // One-to-one chats with the bot
+	Group   = "group"   // Groups and supergroups
+)
+
+// HandlerFunc handles a parsed command and returns the response to send, if any.
+// Errors are passed up to the caller of Dispatch.
+type HandlerFunc func(ctx *Context) (tgbotapi.Chattable, error)
+
+// Command describes a bot command and how it is dispatched.
+type Command struct {
+	Name        string      // Canonical name without the leading slash, e.g. "beta"
+	Aliases     []string    // Alternative names routed to the same handler
+	Description string      // One-line description shown in /help and the command menu
+	Usage       string      // Argument synopsis, e.g. "<email>", shown when arguments are wrong
+	MinArgs     int         // Minimum number of arguments
+	MaxArgs     int         // Maximum number of arguments, -1 for unlimited
+	ChatTypes   []string    // Chat types the command is allowed in, empty for all
+	Hidden      bool        // Whether to leave the command out of /help and the command menu
+	Admin       bool        // Whether only bot administrators may run the command; admin commands are never listed
+	Handler     HandlerFunc // Function invoked for the command
+}
+
+// Context carries the update being handled and the parsed command.
+type Context struct {
+	Update  *tgbotapi.Update
+	Message *tgbotapi.Message
+	Command string   // Canonical command name
+	Args    []string // Parsed arguments
+	RawArgs string   // Everything after the command, untouched
+}
+
+// Reply builds a text message to the chat the command came from.
+func (c *Context) Reply(text string) tgbotapi.MessageConfig {
+	return tgbotapi.NewMessage(c.Message.Chat.ID, text)
+}
+
+// Router maps command names and aliases to their handlers.
+type Router struct {
+	mu       sync.RWMutex
+	botName  string              // Username of the bot, used to filter "/cmd@OtherBot"
+	commands []*Command          // Commands in registration order
+	index    map[string]*Command // Lookup by lower-cased name and alias
+	admins   map[int64]bool      // User IDs allowed to run admin commands
+}
+
+// New creates a router for the bot with the given username.
+func New(botName string) *Router {
+	return &Router{
+		botName: strings.ToLower(botName),
+		index:   make(map[string]*Command),
+		admins:  make(map[int64]bool),
+	}
+}
+
+// SetAdmins replaces the users allowed to run admin commands.
+func (r *Router) SetAdmins(userIDs []int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.admins = make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		r.admins[id] = true
+	}
+}
+
+// IsAdmin reports whether the user may run admin commands.
+func (r *Router) IsAdmin(userID int64) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.admins[userID]
+}
+
+// Register adds a command to the router.
+// It fails if the name or one of the aliases is already taken.
+func (r *Router) Register(cmd Command) error {
+	if cmd.Handler == nil {
+		return fmt.Errorf("router: command %q has no handler", cmd.Name)
+	}
+	if cmd.MaxArgs == 0 && cmd.MinArgs > 0 {
+		cmd.MaxArgs = -1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		if !validName(name) {
+			return fmt.Errorf("router: invalid command name %q", name)
+		}
+		if _, exists := r.index[strings.ToLower(name)]; exists {
+			return fmt.Errorf("router: command %q already registered", name)
+		}
+	}
+
+	c := &cmd
+	for _, name := range names {
+		r.index[strings.ToLower(name)] = c
+	}
+	r.commands = append(r.commands, c)
+	return nil
+}
+
+// MustRegister is like Register but panics on error. It is meant for package setup.
+func (r *Router) MustRegister(cmd Command) {
+	if err := r.Register(cmd); err != nil {
+		panic(err)
+	}
+}
+
+// Dispatch routes a message to its command handler. Commands are read from
+// the text of a message, or from the caption of a photo or file, so that the
+// handler can take the file from the same message.
+// The boolean result reports whether the message was a command meant for this bot.
+func (r *Router) Dispatch(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	message := update.Message
+	if message == nil {
+		return nil, false, nil
+	}
+
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+	name, rawArgs, ok := Parse(text, r.botName)
+	if !ok {
+		return nil, false, nil
+	}
+
+	r.mu.RLock()
+	cmd := r.index[name]
+	r.mu.RUnlock()
+
+	if cmd == nil {
+		if message.Chat.IsPrivate() {
+			return tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Unknown command /%s. Send /help to see what I can do.", name)), true, nil
+		}
+		return nil, true, nil // Another bot's command in a shared group
+	}
+
+	if cmd.Admin && (message.From == nil || !r.IsAdmin(int64(message.From.ID))) {
+		if message.Chat.IsPrivate() {
+			return tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Unknown command /%s. Send /help to see what I can do.", name)), true, nil
+		}
+		return nil, true, nil
+	}
+
+	if !allowedIn(cmd, message.Chat) {
+		return tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("/%s only works in %s chats.", cmd.Name, strings.Join(cmd.ChatTypes, " or "))), true, nil
+	}
+
+	args := SplitArgs(rawArgs)
+	if len(args) < cmd.MinArgs || (cmd.MaxArgs >= 0 && len(args) > cmd.MaxArgs) {
+		return tgbotapi.NewMessage(message.Chat.ID, "Usage: "+cmd.Synopsis()), true, nil
+	}
+
+	ctx := &Context{
+		Update:  update,
+		Message: message,
+		Command: cmd.Name,
+		Args:    args,
+		RawArgs: rawArgs,
+	}
+	response, err := cmd.Handler(ctx)
+	return response, true, err
+}
+
+// Commands returns the visible commands in registration order.
+func (r *Router) Commands() []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var cmds []Command
+	for _, c := range r.commands {
+		if !c.Hidden && !c.Admin {
+			cmds = append(cmds, *c)
+		}
+	}
+	return cmds
+}
+
+// Synopsis returns the command with its usage, e.g. "/beta <email>".
+func (c Command) Synopsis() string {
+	if c.Usage == "" {
+		return "/" + c.Name
+	}
+	return "/" + c.Name + " " + c.Usage
+}
+
+// SetMyCommands publishes the visible commands as the bot's command menu.
+func (r *Router) SetMyCommands(bot *tgbotapi.BotAPI) error {
+	type botCommand struct {
+		Command     string `json:"command"`
+		Description string `json:"description"`
+	}
+
+	var menu []botCommand
+	for _, c := range r.Commands() {
+		menu = append(menu, botCommand{Command: strings.ToLower(c.Name), Description: c.Description})
+	}
+
+	data, err := json.Marshal(menu)
+	if err != nil {
+		return err
+	}
+
+	_, err = bot.MakeRequest("setMyCommands", url.Values{"commands": {string(data)}})
+	return err
+}
+
+// Parse splits a message into a lower-cased command name and its raw arguments.
+// It reports false if the text is not a command or is addressed to another bot.
+func Parse(text string, botName string) (string, string, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+
+	head, rest := text[1:], ""
+	if i := strings.IndexFunc(head, unicode.IsSpace); i >= 0 {
+		head, rest = head[:i], head[i:]
+	}
+
+	name, target, addressed := strings.Cut(head, "@")
+	if addressed && botName != "" && !strings.EqualFold(target, botName) {
+		return "", "", false
+	}
+	if name == "" {
+		return "", "", false
+	}
+
+	return strings.ToLower(name), strings.TrimSpace(rest), true
+}
+
+// SplitArgs splits an argument string on whitespace, keeping quoted strings
+// together. Double quotes always quote; a single quote only does at the start
+// of an argument and when it is closed later, so apostrophes as in "can't"
+// stay part of their word.
+func SplitArgs(s string) []string {
+	var (
+		args    []string
+		current strings.Builder
+		quote   rune
+		inArg   bool
+	)
+
+	for i, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && r == '"',
+			quote == 0 && r == '\'' && !inArg && strings.ContainsRune(s[i+1:], '\''):
+			quote = r
+			inArg = true
+		case quote == 0 && unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// allowedIn reports whether the command may run in the given chat.
+func allowedIn(cmd *Command, chat *tgbotapi.Chat) bool {
+	if len(cmd.ChatTypes) == 0 {
+		return true
+	}
+	for _, t := range cmd.ChatTypes {
+		switch {
+		case t == Private && chat.IsPrivate():
+			return true
+		case t == Group && (chat.IsGroup() || chat.IsSuperGroup()):
+			return true
+		}
+	}
+	return false
+}
+
+// validName reports whether name is usable as a Telegram bot command.
+func validName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+//router/router_test.go
+
+package router
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  one   two ", []string{"one", "two"}},
+		{`say "hello there" now`, []string{"say", "hello there", "now"}},
+		{`'single quoted' word`, []string{"single quoted", "word"}},
+		{"I can't log in", []string{"I", "can't", "log", "in"}},
+		{"don't won't", []string{"don't", "won't"}},
+		{"'tis fine", []string{"'tis", "fine"}},
+		{`"unterminated quote`, []string{"unterminated quote"}},
+	}
+	for _, tt := range tests {
+		if got := SplitArgs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	r := New("bot")
+	var got *Context
+	r.MustRegister(Command{Name: "submit", MaxArgs: -1, Handler: func(ctx *Context) (tgbotapi.Chattable, error) {
+		got = ctx
+		return nil, nil
+	}})
+	r.MustRegister(Command{Name: "ban", Admin: true, Handler: func(ctx *Context) (tgbotapi.Chattable, error) {
+		t.Error("admin command ran for a user")
+		return nil, nil
+	}})
+	r.MustRegister(Command{Name: "only", ChatTypes: []string{Private}, Handler: func(ctx *Context) (tgbotapi.Chattable, error) {
+		return nil, nil
+	}})
+
+	tests := []struct {
+		name     string
+		message  tgbotapi.Message
+		chatType string
+		handled  bool
+		args     string // RawArgs the handler saw, "-" when it did not run
+		reply    string // Start of the reply, if any
+	}{
+		{"text", tgbotapi.Message{Text: "/submit it broke"}, "private", true, "it broke", ""},
+		{"caption", tgbotapi.Message{Caption: "/submit see photo", Photo: &[]tgbotapi.PhotoSize{{FileID: "p"}}}, "private", true, "see photo", ""},
+		{"addressed to the bot", tgbotapi.Message{Text: "/submit@bot hi"}, "group", true, "hi", ""},
+		{"another bot", tgbotapi.Message{Text: "/submit@other hi"}, "group", false, "-", ""},
+		{"not a command", tgbotapi.Message{Text: "hello"}, "private", false, "-", ""},
+		{"unknown in private", tgbotapi.Message{Text: "/nope"}, "private", true, "-", "Unknown command /nope"},
+		{"unknown in a group", tgbotapi.Message{Text: "/nope"}, "group", true, "-", ""},
+		{"admin only", tgbotapi.Message{Text: "/ban"}, "private", true, "-", "Unknown command /ban"},
+		{"wrong chat type", tgbotapi.Message{Text: "/only"}, "group", true, "-", "/only only works in private chats"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			message := tt.message
+			message.From = &tgbotapi.User{ID: 1}
+			message.Chat = &tgbotapi.Chat{ID: 1, Type: tt.chatType}
+
+			response, handled, err := r.Dispatch(&tgbotapi.Update{Message: &message})
+			if err != nil || handled != tt.handled {
+				t.Fatalf("Dispatch = %v, %v, want handled %v", handled, err, tt.handled)
+			}
+			args := "-"
+			if got != nil {
+				args = got.RawArgs
+			}
+			if args != tt.args {
+				t.Errorf("handler saw %q, want %q", args, tt.args)
+			}
+			reply, _ := response.(tgbotapi.MessageConfig)
+			if !strings.HasPrefix(reply.Text, tt.reply) || (tt.reply == "" && response != nil) {
+				t.Errorf("reply = %#v, want one starting with %q", response, tt.reply)
+			}
+		})
+	}
+}
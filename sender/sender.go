@@ -0,0 +1,257 @@
+//sender/sender.go
+
+package sender
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"reflect"
+	"sync"
+	db "tg/db"
+	errors "tg/errors"
+	ratelimit "tg/ratelimit"
+	"time"
+)
+
+// API is the part of the Telegram client the sender needs. *tgbotapi.BotAPI implements it.
+type API interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+}
+
+// Answerer answers callback queries. *tgbotapi.BotAPI implements it.
+type Answerer interface {
+	AnswerCallbackQuery(config tgbotapi.CallbackConfig) (tgbotapi.APIResponse, error)
+}
+
+// StatusStore persists the delivery status of chats. db.Store implements it.
+type StatusStore interface {
+	GetChatStatus(chatID int64) (*db.ChatStatus, error)
+	SaveChatStatus(status db.ChatStatus) error
+}
+
+// Options configures retries of failed sends.
+type Options struct {
+	MaxRetries    int           // Extra attempts after a rate limit or a failure to connect
+	Backoff       time.Duration // Delay before the first retry of a failure to connect, doubled each time
+	MaxRetryAfter time.Duration // Longest retry_after the sender is willing to wait for
+}
+
+// DefaultOptions retries three times and waits at most a minute for a rate limit to lift.
+var DefaultOptions = Options{
+	MaxRetries:    3,
+	Backoff:       time.Second,
+	MaxRetryAfter: time.Minute,
+}
+
+// Sender delivers outbound messages, decodes Telegram errors, honors
+// retry_after and records which chats can no longer be reached.
+type Sender struct {
+	api     API
+	store   StatusStore
+	limiter *ratelimit.Limiter // Nil sends without scheduling
+	opts    Options
+	mu      sync.Mutex
+	failed  map[int64]bool // Whether the last delivery to a chat failed; absent when not yet known
+}
+
+// New creates a sender on top of the Telegram client and the status store.
+// Every attempt waits for the limiter first, if one is given.
+func New(api API, store StatusStore, limiter *ratelimit.Limiter, opts Options) *Sender {
+	return &Sender{
+		api:     api,
+		store:   store,
+		limiter: limiter,
+		opts:    opts,
+		failed:  make(map[int64]bool),
+	}
+}
+
+// Send delivers c in the interactive lane, retrying rate limits and failures to connect.
+func (s *Sender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return s.SendContext(context.Background(), c)
+}
+
+// SendContext is like Send but stops waiting when ctx is done.
+func (s *Sender) SendContext(ctx context.Context, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return s.SendPriority(ctx, c, ratelimit.Interactive)
+}
+
+// SendPriority delivers c in the given lane of the limiter.
+//
+// Errors from the API are returned as *errors.TelegramAPIError wrapped with
+// errors.ErrFailedToSendMessage, so both errors.Classify and errors.Is work on them.
+func (s *Sender) SendPriority(ctx context.Context, c tgbotapi.Chattable, p ratelimit.Priority) (tgbotapi.Message, error) {
+	chatID := ChatID(c)
+	backoff := s.opts.Backoff
+
+	// Chat actions and deletes are not messages and do not count against the chat's limits
+	limitID := chatID
+	switch c.(type) {
+	case tgbotapi.ChatActionConfig, tgbotapi.DeleteMessageConfig:
+		limitID = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if s.limiter != nil {
+			if err := s.limiter.Wait(ctx, limitID, p); err != nil {
+				return tgbotapi.Message{}, errors.Wrap(errors.ErrFailedToSendMessage, err)
+			}
+		}
+
+		msg, err := s.api.Send(c)
+		if err == nil {
+			s.recordSuccess(chatID)
+			return msg, nil
+		}
+		err = errors.FromTelegram(err)
+
+		// A retry that cannot happen before ctx ends is not waited for
+		wait, retry := s.retryDelay(err, &backoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			retry = false
+		}
+		if !retry || attempt >= s.opts.MaxRetries {
+			s.recordFailure(chatID, err)
+			return msg, errors.Wrap(errors.ErrFailedToSendMessage, err)
+		}
+
+		log.Printf("Sending to chat %d failed (%s), retrying in %s: %v", chatID, errors.Classify(err), wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			s.recordFailure(chatID, err)
+			return msg, errors.Wrap(errors.ErrFailedToSendMessage, err)
+		}
+	}
+}
+
+// Answer stops the loading indicator on a pressed button, showing text as a
+// notification when it is not empty. Presses are answered outside the limiter;
+// nothing happens if the API cannot answer them.
+func (s *Sender) Answer(queryID string, text string) {
+	answerer, ok := s.api.(Answerer)
+	if !ok || queryID == "" {
+		return
+	}
+	if _, err := answerer.AnswerCallbackQuery(tgbotapi.NewCallback(queryID, text)); err != nil {
+		log.Printf("Failed to answer callback query %s: %v", queryID, errors.FromTelegram(err))
+	}
+}
+
+// Reachable reports whether the chat has not been marked as unreachable.
+func (s *Sender) Reachable(chatID int64) bool {
+	status, err := s.store.GetChatStatus(chatID)
+	if err != nil {
+		return true // Unknown chats are assumed reachable
+	}
+	return status.Reachable
+}
+
+// retryDelay decides whether err is safe and worth retrying, and how long to wait first.
+func (s *Sender) retryDelay(err error, backoff *time.Duration) (time.Duration, bool) {
+	switch errors.Classify(err) {
+	case errors.RateLimited:
+		var apiErr *errors.TelegramAPIError
+		wait := *backoff
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = time.Duration(apiErr.RetryAfter) * time.Second
+		}
+		if s.opts.MaxRetryAfter > 0 && wait > s.opts.MaxRetryAfter {
+			return 0, false
+		}
+		return wait, true
+	}
+	// Other failures may have happened after Telegram got the request, e.g. a
+	// read timeout or a reset connection, and retrying them could deliver the
+	// message twice. Only a request that never left is safe to repeat.
+	if errors.IsNotSent(err) {
+		wait := *backoff
+		*backoff *= 2
+		return wait, true
+	}
+	return 0, false
+}
+
+// recordSuccess marks a previously failing chat as reachable again.
+// The stored status is read at most once per chat while the sender runs.
+func (s *Sender) recordSuccess(chatID int64) {
+	if chatID == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	failed, known := s.failed[chatID]
+	s.failed[chatID] = false
+	s.mu.Unlock()
+
+	if known && !failed {
+		return
+	}
+	if !known {
+		status, err := s.store.GetChatStatus(chatID)
+		if err != nil || (status.Reachable && status.FailureCount == 0) {
+			return
+		}
+	}
+	s.save(db.ChatStatus{ChatID: chatID, Reachable: true, Updated: time.Now()})
+}
+
+// recordFailure stores the failure; chats that blocked or removed the bot become unreachable.
+func (s *Sender) recordFailure(chatID int64, err error) {
+	if chatID == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	s.failed[chatID] = true
+	s.mu.Unlock()
+
+	status, getErr := s.store.GetChatStatus(chatID)
+	if getErr != nil {
+		status = &db.ChatStatus{ChatID: chatID}
+	}
+
+	now := time.Now()
+	status.FailureCount++
+	status.LastError = err.Error()
+	status.LastFailure = now
+	status.Updated = now
+	status.Reachable = errors.Classify(err) != errors.Forbidden
+
+	var apiErr *errors.TelegramAPIError
+	if errors.As(err, &apiErr) {
+		status.LastErrorCode = apiErr.Code
+		if apiErr.Code == 400 && apiErr.Description == "Bad Request: chat not found" {
+			status.Reachable = false
+		}
+	}
+	if !status.Reachable {
+		log.Printf("Chat %d is unreachable: %v", chatID, err)
+	}
+
+	s.save(*status)
+}
+
+func (s *Sender) save(status db.ChatStatus) {
+	if err := s.store.SaveChatStatus(status); err != nil {
+		log.Printf("Failed to save the status of chat %d: %v", status.ChatID, errors.HandleError(err))
+	}
+}
+
+// ChatID returns the chat a Chattable is addressed to, or 0 if it has none.
+func ChatID(c tgbotapi.Chattable) int64 {
+	v := reflect.ValueOf(c)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+
+	// ChatID is promoted from BaseChat or BaseEdit in every config type
+	if f := v.FieldByName("ChatID"); f.IsValid() && f.Kind() == reflect.Int64 {
+		return f.Int()
+	}
+	return 0
+}
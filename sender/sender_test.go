@@ -0,0 +1,116 @@
+//sender/sender_test.go
+
+package sender
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	ratelimit "tg/ratelimit"
+	"time"
+)
+
+// flakyAPI fails with its errors in turn, then succeeds.
+type flakyAPI struct {
+	errs  []error
+	calls int
+}
+
+func (f *flakyAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return tgbotapi.Message{}, f.errs[f.calls-1]
+	}
+	return tgbotapi.Message{MessageID: f.calls}, nil
+}
+
+func TestSendRetries(t *testing.T) {
+	dialErr := &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	resetErr := &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+	dnsErr := &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: &net.DNSError{Err: "no such host", Name: "api.telegram.org"}}
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+		reachable bool
+	}{
+		{"success", nil, 1, false, true},
+		{"rate limited", []error{tgbotapi.Error{Message: "Too Many Requests: retry later"}}, 2, false, true},
+		{"dial error", []error{dialErr, dialErr}, 3, false, true},
+		{"dns error", []error{dnsErr}, 2, false, true},
+		{"reset after sending is not retried", []error{resetErr}, 1, true, true},
+		{"timeout is not retried", []error{context.DeadlineExceeded}, 1, true, true},
+		{"unknown error is not retried", []error{fmt.Errorf("EOF")}, 1, true, true},
+		{"blocked", []error{tgbotapi.Error{Message: "Forbidden: bot was blocked by the user"}}, 1, true, false},
+		{"gives up", []error{dialErr, dialErr, dialErr, dialErr, dialErr}, 4, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &flakyAPI{errs: tt.errs}
+			store := db.NewMemoryStore()
+			s := New(api, store, nil, Options{MaxRetries: 3, Backoff: time.Millisecond, MaxRetryAfter: time.Second})
+
+			_, err := s.Send(tgbotapi.NewMessage(42, "hi"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if api.calls != tt.wantCalls {
+				t.Errorf("API called %d times, want %d", api.calls, tt.wantCalls)
+			}
+			if got := s.Reachable(42); got != tt.reachable {
+				t.Errorf("Reachable = %v, want %v", got, tt.reachable)
+			}
+		})
+	}
+}
+
+func TestSendGivesUpBeforeDeadline(t *testing.T) {
+	limited := tgbotapi.Error{Message: "Too Many Requests: retry after 30", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 30}}
+	api := &flakyAPI{errs: []error{limited}}
+	s := New(api, db.NewMemoryStore(), nil, DefaultOptions)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := s.SendContext(ctx, tgbotapi.NewMessage(42, "hi")); err == nil {
+		t.Fatal("send succeeded, want the rate limit returned")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("gave up after %s, want at once", elapsed)
+	}
+	if api.calls != 1 {
+		t.Errorf("API called %d times, want once", api.calls)
+	}
+}
+
+func TestSendExemptFromChatLimit(t *testing.T) {
+	limiter := ratelimit.New(config.LimitConfig{Global: 1000, PerChat: 1, PerGroupMinute: 20})
+	s := New(&flakyAPI{}, db.NewMemoryStore(), limiter, DefaultOptions)
+
+	tests := []struct {
+		name    string
+		c       tgbotapi.Chattable
+		wantErr bool
+	}{
+		{"message", tgbotapi.NewMessage(42, "hi"), false},
+		{"typing", tgbotapi.NewChatAction(42, tgbotapi.ChatTyping), false},
+		{"delete", tgbotapi.NewDeleteMessage(42, 1), false},
+		{"second message", tgbotapi.NewMessage(42, "again"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if _, err := s.SendContext(ctx, tt.c); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
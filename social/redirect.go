@@ -0,0 +1,88 @@
+//social/redirect.go
+
+package social
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	errors "tg/errors"
+	"time"
+)
+
+// ServeHTTP counts a click on a directory button and redirects to the link.
+// Requests are GET <redirect path>/<network>?chat=<chat ID>.
+func (d *Directory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	network := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	link, err := d.store.GetSocialLink(network)
+	if errors.IsNotFound(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up the %s link: %v", network, errors.HandleError(err))
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Link previews fetch with HEAD and are not clicks
+	if r.Method == http.MethodGet {
+		source, _ := strconv.ParseInt(r.URL.Query().Get("chat"), 10, 64)
+		d.count(network, source)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, link.URL, http.StatusFound)
+}
+
+// Start serves the redirects on the configured address when a redirect URL is set.
+func (d *Directory) Start(ctx context.Context) error {
+	if d.cfg.RedirectURL == "" {
+		return nil
+	}
+	base, err := url.Parse(d.cfg.RedirectURL)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", d.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(strings.TrimSuffix(base.Path, "/")+"/", d)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	d.mu.Lock()
+	d.server = server
+	d.mu.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Social redirect server stopped: %v", err)
+		}
+	}()
+	log.Printf("Serving social redirects on %s", d.cfg.Listen)
+	return nil
+}
+
+// Stop shuts the redirect server down, letting in-progress redirects finish.
+func (d *Directory) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	server := d.server
+	d.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
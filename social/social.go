@@ -0,0 +1,354 @@
+//social/social.go
+
+package social
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	config "tg/config"
+	db "tg/db"
+	errors "tg/errors"
+	router "tg/router"
+	start "tg/start"
+	"time"
+	"unicode"
+)
+
+// payloadPrefix marks the deep links of the directory buttons.
+const payloadPrefix = "s"
+
+// maxNetwork is the longest network name, keeping deep links under Telegram's limit.
+const maxNetwork = 20
+
+// usage lists every form of /social.
+const usage = "Usage: /social, or for bot admins /social set <network> <url> [label], /social remove <network>, /social move <network> <position>, /social list or /social stats"
+
+// Directory serves the social and community links set by the bot admins as
+// buttons, and counts the clicks on them per network and per group.
+//
+// Buttons never point at the networks directly. With a redirect URL they go
+// through the redirect server, which counts the click and sends the browser
+// on; otherwise they open the bot with a deep link, and the bot counts the
+// click and replies with the link.
+type Directory struct {
+	store    db.Store
+	botName  string
+	cfg      config.SocialConfig
+	commands *router.Router // Set by Register; bot admins manage the links
+
+	mu     sync.Mutex
+	server *http.Server
+}
+
+// New creates the directory of a bot. Deep links are issued for botName.
+func New(store db.Store, botName string, cfg config.SocialConfig) *Directory {
+	return &Directory{store: store, botName: botName, cfg: cfg}
+}
+
+// Register adds the /social command to the router and claims the directory's deep links.
+func (d *Directory) Register(r *router.Router, s *start.Start) {
+	d.commands = r
+	r.MustRegister(router.Command{
+		Name:        "social",
+		Description: "Find us on social networks",
+		MaxArgs:     -1,
+		Handler:     d.handleCommand,
+	})
+	s.Handle(payloadPrefix, d.handleClick)
+}
+
+// handleCommand shows the directory, or lets bot admins change it.
+func (d *Directory) handleCommand(ctx *router.Context) (tgbotapi.Chattable, error) {
+	if len(ctx.Args) == 0 {
+		return d.show(ctx)
+	}
+	if !d.commands.IsAdmin(int64(ctx.Message.From.ID)) {
+		return ctx.Reply("Send /social to see where to find us."), nil
+	}
+
+	args := ctx.Args[1:]
+	switch strings.ToLower(ctx.Args[0]) {
+	case "set":
+		if len(args) < 2 {
+			return ctx.Reply(usage), nil
+		}
+		return d.set(ctx, strings.ToLower(args[0]), args[1], strings.Join(args[2:], " "))
+	case "remove":
+		if len(args) != 1 {
+			return ctx.Reply(usage), nil
+		}
+		return d.remove(ctx, strings.ToLower(args[0]))
+	case "move":
+		if len(args) != 2 {
+			return ctx.Reply(usage), nil
+		}
+		position, err := strconv.Atoi(args[1])
+		if err != nil || position < 1 {
+			return ctx.Reply(usage), nil
+		}
+		return d.move(ctx, strings.ToLower(args[0]), position)
+	case "list":
+		return d.list(ctx)
+	case "stats":
+		return d.stats(ctx)
+	}
+	return ctx.Reply(usage), nil
+}
+
+// show replies with a button for every link, counting clicks for the chat it is shown in.
+func (d *Directory) show(ctx *router.Context) (tgbotapi.Chattable, error) {
+	links, err := d.store.ListSocialLinks()
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return ctx.Reply("There are no links yet."), nil
+	}
+
+	// Clicks from private chats are counted together
+	var source int64
+	if !ctx.Message.Chat.IsPrivate() {
+		source = ctx.Message.Chat.ID
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, link := range links {
+		target, err := d.target(link.Network, source)
+		if err != nil {
+			log.Printf("Failed to build the /social button for %s: %v", link.Network, err)
+			continue
+		}
+		button := tgbotapi.NewInlineKeyboardButtonURL(link.Label, target)
+		if i%2 == 0 {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+		} else {
+			rows[len(rows)-1] = append(rows[len(rows)-1], button)
+		}
+	}
+
+	msg := ctx.Reply("Find us here:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return msg, nil
+}
+
+// target returns the counted URL a button opens for a link shown in a chat.
+func (d *Directory) target(network string, source int64) (string, error) {
+	if d.cfg.RedirectURL != "" {
+		return fmt.Sprintf("%s/%s?chat=%d", strings.TrimSuffix(d.cfg.RedirectURL, "/"), url.PathEscape(network), source), nil
+	}
+	return start.Link(d.botName, payloadPrefix, network+"-"+strconv.FormatInt(source, 10))
+}
+
+// handleClick counts a click that came through a deep link and replies with the link.
+func (d *Directory) handleClick(ctx *router.Context, value string) (tgbotapi.Chattable, error) {
+	network, rawSource, _ := strings.Cut(value, "-")
+	source, err := strconv.ParseInt(rawSource, 10, 64)
+	if err != nil {
+		source = 0
+	}
+
+	link, err := d.store.GetSocialLink(network)
+	if errors.IsNotFound(err) {
+		return ctx.Reply("That link is no longer available. Send /social to see the current ones."), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.count(network, source)
+
+	msg := ctx.Reply(fmt.Sprintf("%s: %s", link.Label, link.URL))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL("Open "+link.Label, link.URL),
+	))
+	return msg, nil
+}
+
+// count records a click. Analytics are best effort.
+func (d *Directory) count(network string, source int64) {
+	if err := d.store.CountSocialClick(network, source); err != nil {
+		log.Printf("Failed to count a click on %s from %d: %v", network, source, errors.HandleError(err))
+	}
+}
+
+// set adds a link at the end of the directory, or changes an existing one in place.
+func (d *Directory) set(ctx *router.Context, network string, rawURL string, label string) (tgbotapi.Chattable, error) {
+	if !validNetwork(network) {
+		return ctx.Reply(fmt.Sprintf("Network names are 1-%d lowercase letters, digits or underscores, e.g. twitter.", maxNetwork)), nil
+	}
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return ctx.Reply("The link must be an http:// or https:// URL."), nil
+	}
+
+	link, err := d.store.GetSocialLink(network)
+	if errors.IsNotFound(err) {
+		links, err := d.store.ListSocialLinks()
+		if err != nil {
+			return nil, err
+		}
+		link = &db.SocialLink{Network: network, Position: len(links) + 1}
+		if n := len(links); n > 0 && links[n-1].Position >= link.Position {
+			link.Position = links[n-1].Position + 1
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	link.URL = rawURL
+	if label != "" {
+		link.Label = label
+	}
+	if link.Label == "" {
+		link.Label = defaultLabel(network)
+	}
+	link.UpdatedBy = int64(ctx.Message.From.ID)
+	link.Updated = time.Now()
+	if err := d.store.SaveSocialLink(*link); err != nil {
+		return nil, err
+	}
+	return ctx.Reply(fmt.Sprintf("%s now leads to %s.", link.Label, link.URL)), nil
+}
+
+// remove takes a link out of the directory. Its clicks are kept.
+func (d *Directory) remove(ctx *router.Context, network string) (tgbotapi.Chattable, error) {
+	err := d.store.DeleteSocialLink(network)
+	if errors.IsNotFound(err) {
+		return ctx.Reply(fmt.Sprintf("There is no %s link.", network)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ctx.Reply(fmt.Sprintf("Removed the %s link.", network)), nil
+}
+
+// move puts a link at a position, counted from 1, and renumbers the others.
+func (d *Directory) move(ctx *router.Context, network string, position int) (tgbotapi.Chattable, error) {
+	links, err := d.store.ListSocialLinks()
+	if err != nil {
+		return nil, err
+	}
+
+	var moved *db.SocialLink
+	var others []db.SocialLink
+	for i := range links {
+		if links[i].Network == network {
+			moved = &links[i]
+		} else {
+			others = append(others, links[i])
+		}
+	}
+	if moved == nil {
+		return ctx.Reply(fmt.Sprintf("There is no %s link.", network)), nil
+	}
+	if position > len(links) {
+		position = len(links)
+	}
+
+	ordered := append(append(append([]db.SocialLink(nil), others[:position-1]...), *moved), others[position-1:]...)
+	for i, link := range ordered {
+		if link.Position == i+1 {
+			continue
+		}
+		link.Position = i + 1
+		if err := d.store.SaveSocialLink(link); err != nil {
+			return nil, err
+		}
+	}
+	return ctx.Reply(fmt.Sprintf("%s is now button %d of %d.", moved.Label, position, len(ordered))), nil
+}
+
+// list shows the links with their network names and URLs.
+func (d *Directory) list(ctx *router.Context) (tgbotapi.Chattable, error) {
+	links, err := d.store.ListSocialLinks()
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return ctx.Reply("There are no links yet. Add one with /social set <network> <url> [label]."), nil
+	}
+
+	var b strings.Builder
+	b.WriteString("Links in button order:")
+	for i, link := range links {
+		fmt.Fprintf(&b, "\n%d. %s (%s): %s", i+1, link.Label, link.Network, link.URL)
+	}
+	msg := ctx.Reply(b.String())
+	msg.DisableWebPagePreview = true
+	return msg, nil
+}
+
+// stats reports the clicks on every network, split by the group the buttons were shown in.
+func (d *Directory) stats(ctx *router.Context) (tgbotapi.Chattable, error) {
+	clicks, err := d.store.ListSocialClicks()
+	if err != nil {
+		return nil, err
+	}
+	if len(clicks) == 0 {
+		return ctx.Reply("Nobody has clicked a link yet."), nil
+	}
+
+	totals := make(map[string]int)
+	sources := make(map[string][]db.SocialClicks)
+	for _, c := range clicks {
+		totals[c.Network] += c.Count
+		sources[c.Network] = append(sources[c.Network], c)
+	}
+	networks := make([]string, 0, len(totals))
+	for network := range totals {
+		networks = append(networks, network)
+	}
+	sort.Slice(networks, func(i, j int) bool {
+		if totals[networks[i]] != totals[networks[j]] {
+			return totals[networks[i]] > totals[networks[j]]
+		}
+		return networks[i] < networks[j]
+	})
+
+	var b strings.Builder
+	b.WriteString("Clicks per network and source:")
+	for _, network := range networks {
+		fmt.Fprintf(&b, "\n\n%s: %d", network, totals[network])
+		for _, c := range sources[network] {
+			fmt.Fprintf(&b, "\n  %s: %d", d.sourceName(c.ChatID), c.Count)
+		}
+	}
+	return ctx.Reply(b.String()), nil
+}
+
+// sourceName names the chat clicks came from.
+func (d *Directory) sourceName(chatID int64) string {
+	if chatID == 0 {
+		return "private chats"
+	}
+	group, err := d.store.GetGroup(chatID)
+	if err != nil || group.GroupName == "" {
+		return strconv.FormatInt(chatID, 10)
+	}
+	return group.GroupName
+}
+
+// validNetwork reports whether a network name fits in a deep link and a URL path as is.
+func validNetwork(network string) bool {
+	if network == "" || len(network) > maxNetwork {
+		return false
+	}
+	for _, r := range network {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultLabel labels a link after its network, e.g. "Twitter".
+func defaultLabel(network string) string {
+	r := []rune(strings.ReplaceAll(network, "_", " "))
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,154 @@
+//social/social_test.go
+
+package social
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	router "tg/router"
+	start "tg/start"
+)
+
+// newDirectory creates a directory with a twitter link, registered on a router.
+func newDirectory(t *testing.T, cfg config.SocialConfig) (*Directory, *router.Router, *db.MemoryStore) {
+	store := db.NewMemoryStore()
+	if err := store.SaveSocialLink(db.SocialLink{Network: "twitter", Label: "Twitter", URL: "https://twitter.com/example"}); err != nil {
+		t.Fatal(err)
+	}
+	d := New(store, "testbot", cfg)
+	r := router.New("testbot")
+	s := start.New()
+	s.Register(r)
+	d.Register(r, s)
+	return d, r, store
+}
+
+// clicks returns the counted clicks by network and source chat.
+func clicks(t *testing.T, store *db.MemoryStore) map[string]map[int64]int {
+	t.Helper()
+	counted, err := store.ListSocialClicks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byNetwork := make(map[string]map[int64]int)
+	for _, c := range counted {
+		if byNetwork[c.Network] == nil {
+			byNetwork[c.Network] = make(map[int64]int)
+		}
+		byNetwork[c.Network][c.ChatID] += c.Count
+	}
+	return byNetwork
+}
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		counted  bool // Whether a twitter click is counted
+		source   int64
+		code     int
+		location string
+	}{
+		{"group click", http.MethodGet, "/go/twitter?chat=-100", true, -100, http.StatusFound, "https://twitter.com/example"},
+		{"private click", http.MethodGet, "/go/twitter?chat=0", true, 0, http.StatusFound, "https://twitter.com/example"},
+		{"no source", http.MethodGet, "/go/twitter", true, 0, http.StatusFound, "https://twitter.com/example"},
+		{"bad source", http.MethodGet, "/go/twitter?chat=x", true, 0, http.StatusFound, "https://twitter.com/example"},
+		{"link preview", http.MethodHead, "/go/twitter?chat=-100", false, 0, http.StatusFound, "https://twitter.com/example"},
+		{"unknown network", http.MethodGet, "/go/myspace?chat=-100", false, 0, http.StatusNotFound, ""},
+		{"post", http.MethodPost, "/go/twitter?chat=-100", false, 0, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, _, store := newDirectory(t, config.SocialConfig{RedirectURL: "https://bot.example.com/go"})
+
+			w := httptest.NewRecorder()
+			d.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+				t.Errorf("response = %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), tt.code, tt.location)
+			}
+
+			want := map[string]map[int64]int{}
+			if tt.counted {
+				want["twitter"] = map[int64]int{tt.source: 1}
+			}
+			if counted := clicks(t, store); !reflect.DeepEqual(counted, want) {
+				t.Errorf("counted %v, want %v", counted, want)
+			}
+		})
+	}
+}
+
+func TestRedirectCountsEveryClick(t *testing.T) {
+	d, _, store := newDirectory(t, config.SocialConfig{RedirectURL: "https://bot.example.com/go"})
+	for _, target := range []string{"/go/twitter?chat=-100", "/go/twitter?chat=-100", "/go/twitter?chat=-200", "/go/twitter"} {
+		d.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	want := map[string]map[int64]int{"twitter": {-100: 2, -200: 1, 0: 1}}
+	if counted := clicks(t, store); !reflect.DeepEqual(counted, want) {
+		t.Errorf("counted %v, want %v", counted, want)
+	}
+}
+
+func TestButtons(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.SocialConfig
+		chatID int64
+		url    string
+	}{
+		{"redirect from a group", config.SocialConfig{RedirectURL: "https://bot.example.com/go/"}, -100, "https://bot.example.com/go/twitter?chat=-100"},
+		{"redirect from a private chat", config.SocialConfig{RedirectURL: "https://bot.example.com/go"}, 7, "https://bot.example.com/go/twitter?chat=0"},
+		{"deep link", config.SocialConfig{}, -100, "https://t.me/testbot?start=s-twitter--100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, r, _ := newDirectory(t, tt.cfg)
+			response, ok, err := r.Dispatch(message(tt.chatID, "/social"))
+			if !ok || err != nil {
+				t.Fatalf("Dispatch = %v, %v", ok, err)
+			}
+			reply, _ := response.(tgbotapi.MessageConfig)
+			markup, _ := reply.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+			if len(markup.InlineKeyboard) != 1 || markup.InlineKeyboard[0][0].URL == nil || *markup.InlineKeyboard[0][0].URL != tt.url {
+				t.Errorf("buttons = %#v, want one opening %s", markup.InlineKeyboard, tt.url)
+			}
+		})
+	}
+}
+
+func TestDeepLinkClick(t *testing.T) {
+	_, r, store := newDirectory(t, config.SocialConfig{})
+	for _, text := range []string{"/start s-twitter--100", "/start s-twitter-0", "/start s-myspace--100"} {
+		if _, ok, err := r.Dispatch(message(7, text)); !ok || err != nil {
+			t.Fatalf("Dispatch(%q) = %v, %v", text, ok, err)
+		}
+	}
+
+	want := map[string]map[int64]int{"twitter": {-100: 1, 0: 1}}
+	if counted := clicks(t, store); !reflect.DeepEqual(counted, want) {
+		t.Errorf("counted %v, want %v", counted, want)
+	}
+}
+
+// message builds an update of user 7 sending text in a chat.
+func message(chatID int64, text string) *tgbotapi.Update {
+	chatType := "private"
+	if chatID < 0 {
+		chatType = "group"
+	}
+	return &tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: 7},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType},
+		Text:      text,
+		Entities:  &[]tgbotapi.MessageEntity{{Type: "bot_command", Length: len(strings.Fields(text)[0])}},
+	}}
+}
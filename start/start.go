@@ -0,0 +1,104 @@
+//start/start.go
+
+package start
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strings"
+	"sync"
+	router "tg/router"
+)
+
+// MaxPayload is the longest payload Telegram passes through a deep link.
+const MaxPayload = 64
+
+// greeting answers /start without a payload, or with one nobody handles.
+const greeting = "Hi! I'm the bot of the beta. Send /getstarted for a quick tour, /beta to apply, or /help to see everything I can do."
+
+// PayloadFunc handles the value of a deep-link payload, without its prefix.
+type PayloadFunc func(ctx *router.Context, value string) (tgbotapi.Chattable, error)
+
+// Start handles /start, which Telegram sends when a user opens the bot,
+// followed by the payload when they came through a deep link
+// t.me/<bot>?start=<payload>. Features claim payloads of the form
+// "<prefix>-<value>" with Handle, and one may take the rest with HandleOther.
+type Start struct {
+	mu       sync.RWMutex
+	handlers map[string]PayloadFunc
+	other    PayloadFunc
+}
+
+// New creates a /start handler that only greets.
+func New() *Start {
+	return &Start{handlers: make(map[string]PayloadFunc)}
+}
+
+// Handle routes the payloads starting with prefix and a dash to fn.
+func (s *Start) Handle(prefix string, fn PayloadFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[prefix] = fn
+}
+
+// HandleOther routes the payloads no prefix claims to fn, which gets the whole
+// payload. The user is greeted when fn returns neither a response nor an error.
+func (s *Start) HandleOther(fn PayloadFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.other = fn
+}
+
+// Link returns the deep link that opens the bot with a payload, or an error
+// when the payload cannot be carried by a link. An empty prefix links the bare value.
+func Link(botName string, prefix string, value string) (string, error) {
+	payload := value
+	if prefix != "" {
+		payload = prefix + "-" + value
+	}
+	if len(payload) > MaxPayload || strings.TrimFunc(payload, isPayloadRune) != "" {
+		return "", fmt.Errorf("deep link payload %q must be at most %d characters of A-Z, a-z, 0-9, _ and -", payload, MaxPayload)
+	}
+	return fmt.Sprintf("https://t.me/%s?start=%s", botName, payload), nil
+}
+
+// Register adds the /start command to the router.
+func (s *Start) Register(r *router.Router) {
+	r.MustRegister(router.Command{
+		Name:        "start",
+		Description: "Start talking to the bot",
+		MaxArgs:     1,
+		Hidden:      true,
+		Handler:     s.handleCommand,
+	})
+}
+
+// handleCommand hands the payload to the feature that claimed its prefix, or greets.
+func (s *Start) handleCommand(ctx *router.Context) (tgbotapi.Chattable, error) {
+	if len(ctx.Args) == 0 {
+		return ctx.Reply(greeting), nil
+	}
+
+	payload := ctx.Args[0]
+	prefix, value, _ := strings.Cut(payload, "-")
+	s.mu.RLock()
+	fn, other := s.handlers[prefix], s.other
+	s.mu.RUnlock()
+	switch {
+	case fn != nil:
+		return fn(ctx, value)
+	case other != nil:
+		response, err := other(ctx, payload)
+		if response != nil || err != nil {
+			return response, err
+		}
+	}
+	return ctx.Reply(greeting), nil
+}
+
+// isPayloadRune reports whether r is allowed in a deep-link payload.
+func isPayloadRune(r rune) bool {
+	return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-'
+}
@@ -0,0 +1,251 @@
+//support/relay.go
+
+package support
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	db "tg/db"
+	errors "tg/errors"
+	"time"
+	"unicode/utf16"
+)
+
+// Telegram's limits on the length of a message and of the caption of a file.
+const (
+	maxText    = 4096
+	maxCaption = 1024
+)
+
+// HandleMessage relays a message that is part of a ticket's conversation:
+// a private message from a user with an active ticket, or a reply to a
+// ticket message in the staff chat. The boolean result reports whether the
+// message belonged to a ticket.
+func (d *Desk) HandleMessage(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	message := update.Message
+	if d.staffChat == 0 || message == nil || message.From == nil || message.Chat == nil {
+		return nil, false, nil
+	}
+
+	if message.Chat.ID == d.staffChat {
+		if message.ReplyToMessage == nil {
+			return nil, false, nil
+		}
+		ticket, err := d.store.TicketByStaffMessage(message.ReplyToMessage.MessageID)
+		if errors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		response, err := d.relayFromStaff(ticket, message)
+		return response, true, err
+	}
+
+	if !message.Chat.IsPrivate() {
+		return nil, false, nil
+	}
+	ticket, err := d.store.ActiveTicket(int64(message.From.ID))
+	if errors.IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	return nil, true, d.relayFromUser(ticket, message, text(message))
+}
+
+// relayFromUser copies a user's message into the ticket's thread in the staff chat
+// and reopens the ticket if it was waiting for the user.
+func (d *Desk) relayFromUser(ticket *db.Ticket, message *tgbotapi.Message, body string) error {
+	files := attachments(message)
+
+	var relayed tgbotapi.Chattable
+	if body == "" && len(files) == 0 {
+		// Stickers, locations and the like are forwarded as they are
+		relayed = tgbotapi.NewForward(d.staffChat, message.Chat.ID, message.MessageID)
+	} else {
+		heading := fmt.Sprintf("Ticket %s, %s:\n", ticket.ID, ticket.Name)
+		relayed = compose(d.staffChat, thread(ticket), heading+body, files)
+	}
+	sent, err := d.out.Send(relayed)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.update(ticket.ID, ticket.UserID, func(ticket *db.Ticket) {
+		now := time.Now()
+		ticket.StaffMessageIDs = append(ticket.StaffMessageIDs, sent.MessageID)
+		ticket.Messages = append(ticket.Messages, db.TicketMessage{
+			FromID:      ticket.UserID,
+			Text:        body,
+			Attachments: files,
+			Sent:        message.Time(),
+		})
+		if ticket.Subject == "" {
+			ticket.Subject = subject(body)
+		}
+		if ticket.Status == db.TicketPending {
+			ticket.Status = db.TicketOpen
+		}
+		ticket.LastUserReply = now
+		ticket.Updated = now
+	})
+	return err
+}
+
+// relayFromStaff sends a staff reply to the ticket's user and marks the ticket
+// as waiting for them.
+func (d *Desk) relayFromStaff(ticket *db.Ticket, message *tgbotapi.Message) (tgbotapi.Chattable, error) {
+	reply := func(text string) tgbotapi.Chattable {
+		m := tgbotapi.NewMessage(message.Chat.ID, text)
+		m.ReplyToMessageID = message.MessageID
+		return m
+	}
+
+	if ticket.Status == db.TicketResolved {
+		return reply(fmt.Sprintf("Ticket %s is resolved. Reopen it with /ticket %s reopen to answer.", ticket.ID, ticket.ID)), nil
+	}
+
+	body, files := text(message), attachments(message)
+	if body == "" && len(files) == 0 {
+		return reply("Only text, photos, documents, videos, voice messages and audio can be sent to the user."), nil
+	}
+
+	heading := fmt.Sprintf("Support team, ticket %s:\n", ticket.ID)
+	if _, err := d.out.Send(compose(ticket.UserID, 0, heading+body, files)); err != nil {
+		if errors.IsForbidden(err) {
+			return reply("The user has blocked the bot; the answer was not delivered."), nil
+		}
+		return nil, err
+	}
+
+	_, err := d.update(ticket.ID, ticket.UserID, func(ticket *db.Ticket) {
+		now := time.Now()
+		ticket.StaffMessageIDs = append(ticket.StaffMessageIDs, message.MessageID)
+		ticket.Messages = append(ticket.Messages, db.TicketMessage{
+			FromID:      int64(message.From.ID),
+			FromStaff:   true,
+			Text:        body,
+			Attachments: files,
+			Sent:        message.Time(),
+		})
+		if ticket.FirstResponse.IsZero() {
+			ticket.FirstResponse = now
+		}
+		if ticket.AssigneeID == 0 {
+			ticket.AssigneeID = int64(message.From.ID)
+			ticket.AssigneeName = message.From.FirstName
+			log.Printf("Ticket %s was assigned to %d by answering it", ticket.ID, message.From.ID)
+		}
+		ticket.Status = db.TicketPending
+		ticket.LastStaffReply = now
+		ticket.Updated = now
+	})
+	return nil, err
+}
+
+// update applies change to the stored ticket and saves it, holding the lock
+// of the ticket's user so that changes made at the same time are not lost.
+// Nothing may be sent from change.
+func (d *Desk) update(id string, userID int64, change func(ticket *db.Ticket)) (*db.Ticket, error) {
+	unlock := d.locks.Lock(userID)
+	defer unlock()
+
+	ticket, err := d.store.GetTicket(id)
+	if err != nil {
+		return nil, err
+	}
+	change(ticket)
+	return ticket, d.store.SaveTicket(*ticket)
+}
+
+// thread returns the staff chat message a ticket's messages are relayed under, 0 before it is posted.
+func thread(ticket *db.Ticket) int {
+	if len(ticket.StaffMessageIDs) == 0 {
+		return 0
+	}
+	return ticket.StaffMessageIDs[0]
+}
+
+// compose builds a message to chatID carrying the text and the first attachment,
+// which Telegram allows one of per message. The text is shortened to what
+// Telegram accepts; the ticket keeps all of it.
+func compose(chatID int64, replyTo int, body string, files []db.Attachment) tgbotapi.Chattable {
+	if len(files) == 0 {
+		m := tgbotapi.NewMessage(chatID, clamp(body, maxText))
+		m.ReplyToMessageID = replyTo
+		return m
+	}
+	body = clamp(body, maxCaption)
+
+	file := files[0]
+	switch file.Kind {
+	case db.AttachmentPhoto:
+		c := tgbotapi.NewPhotoShare(chatID, file.FileID)
+		c.Caption, c.ReplyToMessageID = body, replyTo
+		return c
+	case db.AttachmentVideo:
+		c := tgbotapi.NewVideoShare(chatID, file.FileID)
+		c.Caption, c.ReplyToMessageID = body, replyTo
+		return c
+	case db.AttachmentVoice:
+		c := tgbotapi.NewVoiceShare(chatID, file.FileID)
+		c.Caption, c.ReplyToMessageID = body, replyTo
+		return c
+	case db.AttachmentAudio:
+		c := tgbotapi.NewAudioShare(chatID, file.FileID)
+		c.Caption, c.ReplyToMessageID = body, replyTo
+		return c
+	default:
+		c := tgbotapi.NewDocumentShare(chatID, file.FileID)
+		c.Caption, c.ReplyToMessageID = body, replyTo
+		return c
+	}
+}
+
+// attachments returns the file a message carries, if it is of a kind tickets keep.
+func attachments(message *tgbotapi.Message) []db.Attachment {
+	switch {
+	case message.Photo != nil && len(*message.Photo) > 0:
+		sizes := *message.Photo
+		return []db.Attachment{{Kind: db.AttachmentPhoto, FileID: sizes[len(sizes)-1].FileID}} // Largest size last
+	case message.Document != nil:
+		return []db.Attachment{{Kind: db.AttachmentDocument, FileID: message.Document.FileID, FileName: message.Document.FileName}}
+	case message.Video != nil:
+		return []db.Attachment{{Kind: db.AttachmentVideo, FileID: message.Video.FileID}}
+	case message.Voice != nil:
+		return []db.Attachment{{Kind: db.AttachmentVoice, FileID: message.Voice.FileID}}
+	case message.Audio != nil:
+		return []db.Attachment{{Kind: db.AttachmentAudio, FileID: message.Audio.FileID}}
+	}
+	return nil
+}
+
+// text returns the text of a message, or the caption of a file.
+func text(message *tgbotapi.Message) string {
+	if message.Text != "" {
+		return message.Text
+	}
+	return message.Caption
+}
+
+// clamp shortens s to at most limit UTF-16 code units, the unit Telegram
+// counts in, ending it with an ellipsis when something was cut.
+func clamp(s string, limit int) string {
+	if len(utf16.Encode([]rune(s))) <= limit {
+		return s
+	}
+	units := 0
+	for i, r := range s {
+		units++
+		if r > 0xffff {
+			units++ // Encoded as a surrogate pair
+		}
+		if units > limit-1 { // One unit is left for the ellipsis
+			return s[:i] + "…"
+		}
+	}
+	return s
+}
@@ -0,0 +1,504 @@
+//support/support.go
+
+package support
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"strings"
+	config "tg/config"
+	db "tg/db"
+	errors "tg/errors"
+	locks "tg/locks"
+	random "tg/random"
+	router "tg/router"
+	sender "tg/sender"
+	"time"
+)
+
+// callbackPrefix starts the data of the buttons under a ticket in the staff chat.
+const callbackPrefix = "ticket"
+
+// maxSubject is the number of characters of the first message kept as the subject.
+const maxSubject = 80
+
+// queueSize is the number of tickets listed by /support in the staff chat.
+const queueSize = 20
+
+// ticketUsage explains the staff command; the ID can be left out when replying to a ticket message.
+const ticketUsage = "[id] show|assign|unassign|resolve|reopen"
+
+// Desk opens support tickets and relays their conversation.
+//
+// Users open a ticket with /submit and keep writing to the bot in private;
+// every message, files included, is forwarded to the staff chat. Staff answer
+// by replying to any message of the ticket there, and the answer is sent to
+// the user. A ticket is open while it waits for staff, pending while it waits
+// for the user, and resolved once staff close it.
+type Desk struct {
+	store     db.Store
+	out       *sender.Sender
+	staffChat int64 // Chat the support team works in, 0 when support is disabled
+
+	locks locks.Keyed[int64] // Serializes changes to the tickets of each user
+}
+
+// New creates a support desk relaying tickets to the staff chat of cfg.
+func New(store db.Store, out *sender.Sender, cfg config.SupportConfig) *Desk {
+	return &Desk{
+		store:     store,
+		out:       out,
+		staffChat: cfg.StaffChatID,
+	}
+}
+
+// Register adds the /submit and /support commands, and the /ticket command of the staff chat, to the router.
+func (d *Desk) Register(r *router.Router) {
+	r.MustRegister(router.Command{
+		Name:        "submit",
+		Description: "Submit a ticket for support",
+		Usage:       "[your question]",
+		MaxArgs:     -1,
+		Handler:     d.handleSubmit,
+	})
+	r.MustRegister(router.Command{
+		Name:        "support",
+		Description: "Get help from the support team",
+		Handler:     d.handleSupport,
+	})
+	r.MustRegister(router.Command{
+		Name:        "ticket",
+		Description: "Manage a support ticket",
+		Usage:       ticketUsage,
+		MaxArgs:     2,
+		ChatTypes:   []string{router.Group},
+		Hidden:      true,
+		Handler:     d.handleTicket,
+	})
+}
+
+// handleSubmit opens a ticket, or adds the question to the one the user already has open.
+func (d *Desk) handleSubmit(ctx *router.Context) (tgbotapi.Chattable, error) {
+	if d.staffChat == 0 {
+		return ctx.Reply("Support tickets are not available right now."), nil
+	}
+	if ctx.Message.Chat.ID == d.staffChat {
+		return ctx.Reply("Tickets are answered here by replying to them. Use /support to see the queue."), nil
+	}
+
+	from := ctx.Message.From
+	question := strings.TrimSpace(ctx.RawArgs)
+
+	ticket, created, err := d.open(ctx.Message, question)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		if question == "" {
+			return ctx.Reply(fmt.Sprintf("Ticket %s is still open. Send your messages, screenshots or files to me in private and the support team will see them.", ticket.ID)), nil
+		}
+		if err := d.relayFromUser(ticket, ctx.Message, question); err != nil {
+			return nil, err
+		}
+		return ctx.Reply(fmt.Sprintf("Your message was added to ticket %s.", ticket.ID)), nil
+	}
+
+	instructions := fmt.Sprintf("Ticket %s is open. Send any further details, screenshots or files to me here; the support team will answer in this chat.", ticket.ID)
+	if question == "" {
+		instructions = fmt.Sprintf("Ticket %s is open. Describe your problem here and attach screenshots or files if they help; the support team will answer in this chat.", ticket.ID)
+	}
+	if ctx.Message.Chat.IsPrivate() {
+		return ctx.Reply(instructions), nil
+	}
+
+	// The conversation continues in private, away from the group
+	if _, err := d.out.Send(tgbotapi.NewMessage(int64(from.ID), instructions)); err != nil {
+		log.Printf("Failed to message %d about ticket %s: %v", from.ID, ticket.ID, err)
+		return ctx.Reply(fmt.Sprintf("Ticket %s is open, but I can't message you. Start a private chat with me so the support team can answer you.", ticket.ID)), nil
+	}
+	return ctx.Reply(fmt.Sprintf("Ticket %s is open. I've messaged you privately to continue.", ticket.ID)), nil
+}
+
+// open creates a ticket and announces it in the staff chat, with the file the
+// message carries. When the user already has an active ticket, that one is
+// returned instead; the boolean result reports whether the ticket is new.
+func (d *Desk) open(message *tgbotapi.Message, question string) (*db.Ticket, bool, error) {
+	from := message.From
+	files := attachments(message)
+
+	ticket, created, err := d.create(message, question, files)
+	if err != nil || !created {
+		return ticket, false, err
+	}
+	log.Printf("User %d opened ticket %s", from.ID, ticket.ID)
+
+	// The ticket is already in the queue, so it is kept even if announcing it fails
+	header := tgbotapi.NewMessage(d.staffChat, clamp(describeHeader(ticket, question), maxText))
+	header.ReplyMarkup = buttons(ticket)
+	sent, err := d.out.Send(header)
+	if err != nil {
+		// Staff still see it in the /support queue, and the user's messages start its thread
+		log.Printf("Failed to announce ticket %s in the staff chat: %v", ticket.ID, errors.HandleError(err))
+		return ticket, true, nil
+	}
+	staffMessageIDs := []int{sent.MessageID}
+	if len(files) > 0 {
+		heading := fmt.Sprintf("Ticket %s, %s:", ticket.ID, ticket.Name)
+		if sent, err := d.out.Send(compose(d.staffChat, sent.MessageID, heading, files)); err != nil {
+			log.Printf("Failed to relay the file of ticket %s: %v", ticket.ID, errors.HandleError(err))
+		} else {
+			staffMessageIDs = append(staffMessageIDs, sent.MessageID)
+		}
+	}
+
+	ticket, err = d.update(ticket.ID, ticket.UserID, func(ticket *db.Ticket) {
+		// The header leads the thread even if messages were relayed meanwhile
+		ticket.StaffMessageIDs = append(staffMessageIDs, ticket.StaffMessageIDs...)
+	})
+	return ticket, true, err
+}
+
+// create stores a new ticket for the author of the message, unless they
+// already have an active one, which it returns instead. The boolean result
+// reports whether the ticket was created.
+func (d *Desk) create(message *tgbotapi.Message, question string, files []db.Attachment) (*db.Ticket, bool, error) {
+	from := message.From
+	unlock := d.locks.Lock(int64(from.ID))
+	defer unlock()
+
+	ticket, err := d.store.ActiveTicket(int64(from.ID))
+	if err == nil || !errors.IsNotFound(err) {
+		return ticket, false, err
+	}
+
+	now := time.Now()
+	ticket = &db.Ticket{
+		ID:           random.ID(),
+		UserID:       int64(from.ID),
+		Username:     from.UserName,
+		Name:         strings.TrimSpace(from.FirstName + " " + from.LastName),
+		OriginChatID: message.Chat.ID,
+		Subject:      subject(question),
+		Status:       db.TicketOpen,
+		Created:      now,
+		Updated:      now,
+	}
+	if question != "" || len(files) > 0 {
+		ticket.LastUserReply = now
+		ticket.Messages = append(ticket.Messages, db.TicketMessage{
+			FromID:      ticket.UserID,
+			Text:        question,
+			Attachments: files,
+			Sent:        message.Time(),
+		})
+	}
+	return ticket, true, d.store.SaveTicket(*ticket)
+}
+
+// handleSupport shows the queue in the staff chat and the user's ticket elsewhere.
+func (d *Desk) handleSupport(ctx *router.Context) (tgbotapi.Chattable, error) {
+	if d.staffChat != 0 && ctx.Message.Chat.ID == d.staffChat {
+		return d.queue(ctx)
+	}
+	if d.staffChat == 0 {
+		return ctx.Reply("Support tickets are not available right now."), nil
+	}
+
+	ticket, err := d.store.ActiveTicket(int64(ctx.Message.From.ID))
+	if errors.IsNotFound(err) {
+		return ctx.Reply("Need help? Send /submit followed by your question, and continue in a private chat with me. The support team answers there."), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := "The support team will answer you here."
+	if ticket.Status == db.TicketPending {
+		state = "The support team is waiting for your reply."
+	}
+	return ctx.Reply(fmt.Sprintf("Ticket %s is %s, opened %s ago. %s Anything you send me in private is added to it.",
+		ticket.ID, ticket.Status, age(ticket.Created), state)), nil
+}
+
+// queue lists the tickets waiting for staff first, then those waiting for users, oldest first.
+func (d *Desk) queue(ctx *router.Context) (tgbotapi.Chattable, error) {
+	open, err := d.store.ListTickets(db.TicketOpen)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := d.store.ListTickets(db.TicketPending)
+	if err != nil {
+		return nil, err
+	}
+	if len(open)+len(pending) == 0 {
+		return ctx.Reply("There are no open tickets."), nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d open, %d pending:\n", len(open), len(pending))
+	for i, ticket := range append(open, pending...) {
+		if i == queueSize {
+			fmt.Fprintf(&b, "\n...and %d more", len(open)+len(pending)-queueSize)
+			break
+		}
+		topic := ticket.Subject
+		if topic == "" {
+			topic = "(no question yet)"
+		}
+		fmt.Fprintf(&b, "\n%s - %s %s, %s, %s", ticket.ID, ticket.Status, waiting(&ticket), assignee(&ticket), topic)
+	}
+	return ctx.Reply(b.String()), nil
+}
+
+// handleTicket shows or changes a ticket from the staff chat.
+func (d *Desk) handleTicket(ctx *router.Context) (tgbotapi.Chattable, error) {
+	if d.staffChat == 0 || ctx.Message.Chat.ID != d.staffChat {
+		return nil, nil
+	}
+
+	args := ctx.Args
+	var ticket *db.Ticket
+	var err error
+	if reply := ctx.Message.ReplyToMessage; reply != nil && len(args) < 2 {
+		ticket, err = d.store.TicketByStaffMessage(reply.MessageID)
+	} else if len(args) > 0 {
+		ticket, err = d.store.GetTicket(strings.TrimPrefix(args[0], "#"))
+		args = args[1:]
+	} else {
+		return ctx.Reply("Reply to a ticket message or give its ID.\n\nUsage: /ticket " + ticketUsage), nil
+	}
+	if errors.IsNotFound(err) {
+		return ctx.Reply("There is no such ticket."), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	action := "show"
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+	if action == "show" {
+		return ctx.Reply(describe(ticket)), nil
+	}
+
+	_, text, err := d.apply(ticket.ID, action, ctx.Message.From)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.Reply(text), nil
+}
+
+// HandleCallback handles the buttons under a ticket in the staff chat.
+// The boolean result reports whether the button belonged to a ticket; those
+// presses are answered with the outcome, or why the action was not applied.
+func (d *Desk) HandleCallback(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	query := update.CallbackQuery
+	if query == nil || query.Message == nil {
+		return nil, false, nil
+	}
+
+	parts := strings.SplitN(query.Data, "|", 3)
+	if len(parts) != 3 || parts[0] != callbackPrefix {
+		return nil, false, nil
+	}
+
+	var toast string
+	defer func() { d.out.Answer(query.ID, toast) }()
+
+	if query.Message.Chat.ID != d.staffChat {
+		toast = "Tickets can only be handled in the staff chat."
+		return nil, true, nil
+	}
+
+	ticket, text, err := d.apply(parts[2], parts[1], query.From)
+	toast = text
+	if err != nil || ticket == nil {
+		return nil, true, err
+	}
+	log.Printf("%d pressed %s on ticket %s: %s", query.From.ID, parts[1], ticket.ID, text)
+
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, describeHeader(ticket, firstText(ticket)))
+	markup := buttons(ticket)
+	edit.ReplyMarkup = &markup
+	return edit, true, nil
+}
+
+// apply runs a staff action on a ticket and returns the updated ticket and a
+// message for staff. The ticket is nil when the action was not applied.
+func (d *Desk) apply(id string, action string, staff *tgbotapi.User) (*db.Ticket, string, error) {
+	found, err := d.store.GetTicket(id)
+	if errors.IsNotFound(err) {
+		return nil, "There is no such ticket.", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	ticket, text, err := d.change(found.UserID, id, action, staff)
+	if err != nil || ticket == nil {
+		return nil, text, err
+	}
+
+	if text != "" {
+		if _, err := d.out.Send(tgbotapi.NewMessage(ticket.UserID, text)); err != nil {
+			log.Printf("Failed to notify %d about ticket %s: %v", ticket.UserID, ticket.ID, err)
+		}
+	}
+	return ticket, fmt.Sprintf("Ticket %s is %s, %s.", ticket.ID, ticket.Status, assignee(ticket)), nil
+}
+
+// change applies a staff action to a ticket of the user while holding their
+// lock. It returns the changed ticket and the notice for the user, empty when
+// they need not know, or a nil ticket and why the action was not applied.
+func (d *Desk) change(userID int64, id string, action string, staff *tgbotapi.User) (*db.Ticket, string, error) {
+	unlock := d.locks.Lock(userID)
+	defer unlock()
+
+	ticket, err := d.store.GetTicket(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var notice string
+	switch action {
+	case "assign":
+		ticket.AssigneeID = int64(staff.ID)
+		ticket.AssigneeName = staff.FirstName
+	case "unassign":
+		ticket.AssigneeID, ticket.AssigneeName = 0, ""
+	case "resolve":
+		if ticket.Status == db.TicketResolved {
+			return nil, fmt.Sprintf("Ticket %s is already resolved.", ticket.ID), nil
+		}
+		ticket.Status = db.TicketResolved
+		ticket.Resolved = time.Now()
+		notice = fmt.Sprintf("Ticket %s was resolved. If you need more help, send /submit again.", ticket.ID)
+	case "reopen":
+		if ticket.Status != db.TicketResolved {
+			return nil, fmt.Sprintf("Ticket %s is not resolved.", ticket.ID), nil
+		}
+		if active, err := d.store.ActiveTicket(ticket.UserID); err == nil {
+			return nil, fmt.Sprintf("The user already has ticket %s open.", active.ID), nil
+		}
+		ticket.Status = db.TicketOpen
+		notice = fmt.Sprintf("Ticket %s was reopened. Anything you send me here is added to it.", ticket.ID)
+	default:
+		return nil, "Usage: /ticket " + ticketUsage, nil
+	}
+
+	ticket.Updated = time.Now()
+	return ticket, notice, d.store.SaveTicket(*ticket)
+}
+
+// describeHeader renders the message that introduces a ticket in the staff chat.
+func describeHeader(ticket *db.Ticket, question string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ticket %s from %s", ticket.ID, ticket.Name)
+	if ticket.Username != "" {
+		fmt.Fprintf(&b, " (@%s, %d)", ticket.Username, ticket.UserID)
+	} else {
+		fmt.Fprintf(&b, " (%d)", ticket.UserID)
+	}
+	fmt.Fprintf(&b, "\nStatus: %s, %s\n\n", ticket.Status, assignee(ticket))
+	if question != "" {
+		b.WriteString(question)
+	} else {
+		b.WriteString("(The question follows.)")
+	}
+	b.WriteString("\n\nReply to this message or the ones relayed under it to answer.")
+	return b.String()
+}
+
+// describe renders a ticket and its SLA timestamps for staff.
+func describe(ticket *db.Ticket) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ticket %s from %s (%d)\n", ticket.ID, ticket.Name, ticket.UserID)
+	fmt.Fprintf(&b, "Status: %s, %s\n", ticket.Status, assignee(ticket))
+	fmt.Fprintf(&b, "Subject: %s\n", ticket.Subject)
+	fmt.Fprintf(&b, "Messages: %d\n", len(ticket.Messages))
+	fmt.Fprintf(&b, "Opened: %s (%s ago)\n", ticket.Created.Format(time.RFC1123), age(ticket.Created))
+	if ticket.FirstResponse.IsZero() {
+		fmt.Fprintf(&b, "First response: none yet\n")
+	} else {
+		fmt.Fprintf(&b, "First response: after %s\n", ticket.FirstResponse.Sub(ticket.Created).Round(time.Minute))
+	}
+	if !ticket.LastUserReply.IsZero() {
+		fmt.Fprintf(&b, "Last from user: %s ago\n", age(ticket.LastUserReply))
+	}
+	if !ticket.LastStaffReply.IsZero() {
+		fmt.Fprintf(&b, "Last from staff: %s ago\n", age(ticket.LastStaffReply))
+	}
+	if ticket.Status == db.TicketResolved {
+		fmt.Fprintf(&b, "Resolved: after %s\n", ticket.Resolved.Sub(ticket.Created).Round(time.Minute))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// buttons returns the actions offered under a ticket in the staff chat.
+func buttons(ticket *db.Ticket) tgbotapi.InlineKeyboardMarkup {
+	data := func(action string) string {
+		return callbackPrefix + "|" + action + "|" + ticket.ID
+	}
+
+	if ticket.Status == db.TicketResolved {
+		return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Reopen", data("reopen")),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Assign to me", data("assign")),
+		tgbotapi.NewInlineKeyboardButtonData("Resolve", data("resolve")),
+	))
+}
+
+// assignee describes who handles a ticket.
+func assignee(ticket *db.Ticket) string {
+	if ticket.AssigneeID == 0 {
+		return "unassigned"
+	}
+	return "assigned to " + ticket.AssigneeName
+}
+
+// waiting describes how long a ticket has waited on whoever has to answer next.
+func waiting(ticket *db.Ticket) string {
+	since := ticket.Created
+	if ticket.Status == db.TicketOpen && !ticket.LastUserReply.IsZero() {
+		since = ticket.LastUserReply
+	} else if ticket.Status == db.TicketPending && !ticket.LastStaffReply.IsZero() {
+		since = ticket.LastStaffReply
+	}
+	return age(since)
+}
+
+// age returns the time elapsed since t, rounded for display.
+func age(t time.Time) string {
+	d := time.Since(t)
+	if d < time.Minute {
+		return "<1m"
+	}
+	if d < time.Hour {
+		return d.Round(time.Minute).String()
+	}
+	return d.Round(time.Hour).String()
+}
+
+// firstText returns the ticket's first message, for redrawing its header.
+func firstText(ticket *db.Ticket) string {
+	if len(ticket.Messages) == 0 {
+		return ""
+	}
+	return ticket.Messages[0].Text
+}
+
+// subject returns the first line of a question, shortened to maxSubject characters.
+// It is empty until the user has written something.
+func subject(question string) string {
+	line, _, _ := strings.Cut(question, "\n")
+	line = strings.TrimSpace(line)
+	if r := []rune(line); len(r) > maxSubject {
+		return string(r[:maxSubject-1]) + "…"
+	}
+	return line
+}
@@ -0,0 +1,245 @@
+//support/support_test.go
+
+package support
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strings"
+	"sync"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	router "tg/router"
+	sender "tg/sender"
+	"unicode/utf16"
+)
+
+const (
+	staffChat = int64(-500)
+	userID    = 7
+)
+
+// fakeAPI records what is sent and numbers the messages.
+type fakeAPI struct {
+	mu   sync.Mutex
+	sent []tgbotapi.Chattable
+	down bool // Whether sends to the staff chat fail
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := c.(tgbotapi.MessageConfig); ok && f.down && m.ChatID == staffChat {
+		return tgbotapi.Message{}, tgbotapi.Error{Message: "Bad Request: chat not found"}
+	}
+	f.sent = append(f.sent, c)
+	return tgbotapi.Message{MessageID: 1000 + len(f.sent)}, nil
+}
+
+func (f *fakeAPI) messages() []tgbotapi.Chattable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]tgbotapi.Chattable(nil), f.sent...)
+}
+
+func newDesk() (*Desk, *router.Router, *fakeAPI, *db.MemoryStore) {
+	store := db.NewMemoryStore()
+	api := &fakeAPI{}
+	d := New(store, sender.New(api, store, nil, sender.Options{}), config.SupportConfig{StaffChatID: staffChat})
+	r := router.New("bot")
+	d.Register(r)
+	return d, r, api, store
+}
+
+func private(text string) *tgbotapi.Update {
+	return &tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: userID, FirstName: "Ann"},
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+		Text:      text,
+	}}
+}
+
+func TestSubmit(t *testing.T) {
+	photo := private("")
+	photo.Message.Caption = "/submit the upload breaks"
+	photo.Message.Photo = &[]tgbotapi.PhotoSize{{FileID: "small"}, {FileID: "large"}}
+
+	tests := []struct {
+		name     string
+		update   *tgbotapi.Update
+		question string
+		files    []string // File IDs relayed to the staff chat
+	}{
+		{"text", private("/submit I can't log in"), "I can't log in", nil},
+		{"no question", private("/submit"), "", nil},
+		{"photo caption", photo, "the upload breaks", []string{"large"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, r, api, store := newDesk()
+
+			response, ok, err := r.Dispatch(tt.update)
+			if !ok || err != nil {
+				t.Fatalf("Dispatch = %v, %v", ok, err)
+			}
+			if reply, _ := response.(tgbotapi.MessageConfig); !strings.Contains(reply.Text, "is open") {
+				t.Errorf("reply = %q, want the ticket to be open", reply.Text)
+			}
+
+			ticket, err := store.ActiveTicket(userID)
+			if err != nil {
+				t.Fatalf("ActiveTicket: %v", err)
+			}
+			if ticket.Subject != tt.question {
+				t.Errorf("subject = %q, want %q", ticket.Subject, tt.question)
+			}
+
+			sent := api.messages()
+			if len(sent) != 1+len(tt.files) || len(ticket.StaffMessageIDs) != len(sent) {
+				t.Fatalf("sent %d messages and recorded %v, want a header and %d files", len(sent), ticket.StaffMessageIDs, len(tt.files))
+			}
+			if header, _ := sent[0].(tgbotapi.MessageConfig); header.ChatID != staffChat || header.ReplyMarkup == nil {
+				t.Errorf("first message = %#v, want the header with buttons in the staff chat", sent[0])
+			}
+			for i, id := range tt.files {
+				if photo, _ := sent[1+i].(tgbotapi.PhotoConfig); photo.FileID != id || photo.ReplyToMessageID != ticket.StaffMessageIDs[0] {
+					t.Errorf("message %d = %#v, want photo %s under the header", 1+i, sent[1+i], id)
+				}
+			}
+		})
+	}
+}
+
+func TestSubmitUnannounced(t *testing.T) {
+	d, r, api, store := newDesk()
+	api.down = true
+
+	response, ok, err := r.Dispatch(private("/submit I can't log in"))
+	if !ok || err != nil {
+		t.Fatalf("Dispatch = %v, %v", ok, err)
+	}
+	if reply, _ := response.(tgbotapi.MessageConfig); !strings.Contains(reply.Text, "is open") {
+		t.Errorf("reply = %q, want the ticket to be open", reply.Text)
+	}
+	ticket, err := store.ActiveTicket(userID)
+	if err != nil {
+		t.Fatalf("ActiveTicket: %v", err)
+	}
+	if len(ticket.StaffMessageIDs) != 0 {
+		t.Errorf("staff messages = %v, want none", ticket.StaffMessageIDs)
+	}
+
+	// Once the staff chat is back, the next message starts the ticket's thread
+	api.mu.Lock()
+	api.down = false
+	api.mu.Unlock()
+	if _, _, err := d.HandleMessage(private("still broken")); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	ticket, err = store.GetTicket(ticket.ID)
+	if err != nil {
+		t.Fatalf("GetTicket: %v", err)
+	}
+	if len(ticket.StaffMessageIDs) != 1 || len(ticket.Messages) != 2 {
+		t.Errorf("ticket = %+v, want the message relayed as its thread", ticket)
+	}
+}
+
+func TestConversation(t *testing.T) {
+	d, r, api, store := newDesk()
+	if _, _, err := r.Dispatch(private("/submit help")); err != nil {
+		t.Fatal(err)
+	}
+	ticket, _ := store.ActiveTicket(userID)
+	header := ticket.StaffMessageIDs[0]
+
+	// The user and staff write at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := d.HandleMessage(private(fmt.Sprintf("more %d", i))); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			answer := &tgbotapi.Update{Message: &tgbotapi.Message{
+				MessageID:      100 + i,
+				From:           &tgbotapi.User{ID: 99, FirstName: "Sam"},
+				Chat:           &tgbotapi.Chat{ID: staffChat, Type: "supergroup"},
+				Text:           fmt.Sprintf("answer %d", i),
+				ReplyToMessage: &tgbotapi.Message{MessageID: header},
+			}}
+			if _, _, err := d.HandleMessage(answer); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	ticket, err := store.GetTicket(ticket.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ticket.Messages) != 21 {
+		t.Errorf("ticket has %d messages, want 21", len(ticket.Messages))
+	}
+	if ticket.AssigneeName != "Sam" || ticket.FirstResponse.IsZero() {
+		t.Errorf("ticket = %+v, want it assigned to the first staff member to answer", ticket)
+	}
+	if got := len(api.messages()); got != 21 {
+		t.Errorf("sent %d messages, want the header, 10 relays and 10 answers", got)
+	}
+
+	// Resolving notifies the user and closes the ticket
+	if _, text, err := d.apply(ticket.ID, "resolve", &tgbotapi.User{ID: 99}); err != nil || !strings.Contains(text, "resolved") {
+		t.Errorf("resolve = %q, %v", text, err)
+	}
+	if _, ok, _ := d.HandleMessage(private("hello?")); ok {
+		t.Error("message after resolving was relayed")
+	}
+}
+
+func TestCompose(t *testing.T) {
+	long := strings.Repeat("é", 5000)
+	emoji := strings.Repeat("😀", 600) // Two UTF-16 units each
+
+	tests := []struct {
+		name  string
+		body  string
+		files []db.Attachment
+		limit int
+	}{
+		{"text", long, nil, maxText},
+		{"caption", long, []db.Attachment{{Kind: db.AttachmentDocument, FileID: "f"}}, maxCaption},
+		{"emoji caption", emoji, []db.Attachment{{Kind: db.AttachmentPhoto, FileID: "p"}}, maxCaption},
+		{"short", "hi", []db.Attachment{{Kind: db.AttachmentVoice, FileID: "v"}}, maxCaption},
+	}
+	for _, tt := range tests {
+		var text string
+		switch c := compose(1, 0, tt.body, tt.files).(type) {
+		case tgbotapi.MessageConfig:
+			text = c.Text
+		case tgbotapi.DocumentConfig:
+			text = c.Caption
+		case tgbotapi.PhotoConfig:
+			text = c.Caption
+		case tgbotapi.VoiceConfig:
+			text = c.Caption
+		}
+		units := len(utf16.Encode([]rune(text)))
+		if units > tt.limit {
+			t.Errorf("%s: %d units, want at most %d", tt.name, units, tt.limit)
+		}
+		if len(tt.body) < tt.limit && text != tt.body {
+			t.Errorf("%s: %q was changed to %q", tt.name, tt.body, text)
+		}
+		if len(tt.body) > tt.limit && !strings.HasSuffix(text, "…") {
+			t.Errorf("%s: shortened text does not end with an ellipsis", tt.name)
+		}
+	}
+}
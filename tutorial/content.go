@@ -0,0 +1,147 @@
+//tutorial/content.go
+
+package tutorial
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+)
+
+// Telegram's limits on message text and media captions.
+const (
+	maxText    = 4096
+	maxCaption = 1024
+)
+
+// Media kinds a page can show.
+const (
+	MediaPhoto     = "photo"
+	MediaVideo     = "video"
+	MediaAnimation = "animation"
+	MediaDocument  = "document"
+)
+
+// Tutorial is a multi-page walkthrough loaded from a content file.
+//
+// Bump Version whenever pages are added, removed or reordered: progress
+// made on another version resumes on the page with the same ID, and step
+// analytics are kept per version.
+type Tutorial struct {
+	Name        string   `yaml:"name"`        // Command that opens the tutorial, without the slash
+	Aliases     []string `yaml:"aliases"`     // Other commands that open it
+	Version     int      `yaml:"version"`     // Content version
+	Title       string   `yaml:"title"`       // Shown above every page
+	Description string   `yaml:"description"` // Shown in /help
+	Pages       []Page   `yaml:"pages"`       // Pages in order
+}
+
+// Page is one step of a tutorial.
+type Page struct {
+	ID    string `yaml:"id"`    // Stable identifier used for progress and analytics
+	Text  string `yaml:"text"`  // Body of the page
+	Media *Media `yaml:"media"` // Optional picture, video, animation or document
+	Links []Link `yaml:"links"` // Optional buttons opening URLs
+}
+
+// Media is a file shown with a page, given as a URL or a Telegram file ID.
+type Media struct {
+	Type string `yaml:"type"` // One of the Media* kinds
+	File string `yaml:"file"` // URL or file ID
+}
+
+// Link is a URL button under a page.
+type Link struct {
+	Label string `yaml:"label"`
+	URL   string `yaml:"url"`
+}
+
+// Load reads every .yaml and .yml file of dir as a tutorial, sorted by name.
+func Load(dir string) ([]*Tutorial, error) {
+	var paths []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	var tutorials []*Tutorial
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		t := &Tutorial{}
+		if err := yaml.Unmarshal(data, t); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		tutorials = append(tutorials, t)
+	}
+	return tutorials, nil
+}
+
+// validate checks that the tutorial can be shown as written.
+func (t *Tutorial) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("tutorial has no name")
+	}
+	if t.Version < 1 {
+		return fmt.Errorf("tutorial %s needs a version of 1 or more", t.Name)
+	}
+	if len(t.Pages) == 0 {
+		return fmt.Errorf("tutorial %s has no pages", t.Name)
+	}
+
+	ids := make(map[string]bool)
+	for i, page := range t.Pages {
+		if page.ID == "" {
+			return fmt.Errorf("page %d of %s has no id", i+1, t.Name)
+		}
+		if ids[page.ID] {
+			return fmt.Errorf("page id %q is used twice in %s", page.ID, t.Name)
+		}
+		ids[page.ID] = true
+
+		limit := maxText
+		if page.Media != nil {
+			switch page.Media.Type {
+			case MediaPhoto, MediaVideo, MediaAnimation, MediaDocument:
+			default:
+				return fmt.Errorf("page %s of %s has unknown media type %q", page.ID, t.Name, page.Media.Type)
+			}
+			if page.Media.File == "" {
+				return fmt.Errorf("page %s of %s has media without a file", page.ID, t.Name)
+			}
+			limit = maxCaption
+		}
+		// Leave room for the title and page number
+		if n := utf8.RuneCountInString(page.Text) + utf8.RuneCountInString(t.Title) + 32; n > limit {
+			return fmt.Errorf("page %s of %s is too long for Telegram (%d of %d characters)", page.ID, t.Name, n, limit)
+		}
+		for _, link := range page.Links {
+			if link.Label == "" || link.URL == "" {
+				return fmt.Errorf("page %s of %s has a link without a label or URL", page.ID, t.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// index returns the position of the page with the given ID, or -1.
+func (t *Tutorial) index(id string) int {
+	for i, page := range t.Pages {
+		if page.ID == id {
+			return i
+		}
+	}
+	return -1
+}
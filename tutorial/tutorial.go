@@ -0,0 +1,354 @@
+//tutorial/tutorial.go
+
+package tutorial
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"strconv"
+	"strings"
+	config "tg/config"
+	db "tg/db"
+	errors "tg/errors"
+	router "tg/router"
+	sender "tg/sender"
+	"time"
+)
+
+// callbackPrefix starts the data of the navigation buttons.
+const callbackPrefix = "tutorial"
+
+// done is the button value that finishes a tutorial.
+const done = "done"
+
+// Guide serves the tutorials of the content directory, one command each,
+// and remembers where every user is in them.
+type Guide struct {
+	store     db.Store
+	out       *sender.Sender
+	tutorials []*Tutorial
+	byName    map[string]*Tutorial
+}
+
+// New creates a guide for the tutorials in cfg.Dir. Content that fails to
+// load is logged and leaves the guide without tutorials.
+func New(store db.Store, out *sender.Sender, cfg config.TutorialConfig) *Guide {
+	g := &Guide{store: store, out: out, byName: make(map[string]*Tutorial)}
+
+	tutorials, err := Load(cfg.Dir)
+	if err != nil {
+		log.Printf("Failed to load the tutorials from %s: %v", cfg.Dir, err)
+		return g
+	}
+	for _, t := range tutorials {
+		g.tutorials = append(g.tutorials, t)
+		g.byName[t.Name] = t
+	}
+	log.Printf("Loaded %d tutorials from %s", len(g.tutorials), cfg.Dir)
+	return g
+}
+
+// Register adds a command for every tutorial, and the admin-only /tutorials report, to the router.
+func (g *Guide) Register(r *router.Router) {
+	for _, t := range g.tutorials {
+		description := t.Description
+		if description == "" {
+			description = t.Title
+		}
+		r.MustRegister(router.Command{
+			Name:        t.Name,
+			Aliases:     t.Aliases,
+			Description: description,
+			Usage:       "[restart]",
+			MaxArgs:     1,
+			ChatTypes:   []string{router.Private},
+			Handler:     g.handleOpen(t),
+		})
+	}
+	r.MustRegister(router.Command{
+		Name:        "tutorials",
+		Description: "Show how far users get through the tutorials",
+		ChatTypes:   []string{router.Private},
+		Admin:       true,
+		Handler:     g.handleStats,
+	})
+}
+
+// handleOpen returns the handler that resumes a tutorial where the user left
+// it, or starts it over when it was finished or restart is asked for.
+func (g *Guide) handleOpen(t *Tutorial) router.HandlerFunc {
+	return func(ctx *router.Context) (tgbotapi.Chattable, error) {
+		restart := len(ctx.Args) > 0 && strings.EqualFold(ctx.Args[0], "restart")
+		if len(ctx.Args) > 0 && !restart {
+			return ctx.Reply(fmt.Sprintf("Usage: /%s [restart]", t.Name)), nil
+		}
+
+		progress, err := g.load(int64(ctx.Message.From.ID), t)
+		if err != nil {
+			return nil, err
+		}
+
+		index := 0
+		if !restart && !progress.Finished {
+			if i := t.index(progress.Page); i > 0 {
+				index = i
+			}
+		}
+		if progress.Finished || restart {
+			progress.Finished = false
+			progress.Started = time.Now()
+		}
+
+		if err := g.visit(progress, t, index); err != nil {
+			return nil, err
+		}
+		return pageMessage(ctx.Message.Chat.ID, t, index), nil
+	}
+}
+
+// HandleCallback handles the navigation buttons under a tutorial page.
+// The boolean result reports whether the button belonged to a tutorial; those
+// presses are answered.
+func (g *Guide) HandleCallback(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	query := update.CallbackQuery
+	if query == nil || query.Message == nil {
+		return nil, false, nil
+	}
+
+	parts := strings.SplitN(query.Data, "|", 3)
+	if len(parts) != 3 || parts[0] != callbackPrefix {
+		return nil, false, nil
+	}
+
+	var toast string
+	defer func() { g.out.Answer(query.ID, toast) }()
+
+	t, ok := g.byName[parts[1]]
+	if !ok {
+		toast = "This tutorial is no longer available."
+		return nil, true, nil
+	}
+
+	progress, err := g.load(int64(query.From.ID), t)
+	if err != nil {
+		return nil, true, err
+	}
+	current := t.index(progress.Page)
+	chatID, messageID := query.Message.Chat.ID, query.Message.MessageID
+
+	if parts[2] == done {
+		if current >= 0 {
+			g.complete(progress, t.Pages[current].ID, t)
+		}
+		progress.Finished = true
+		progress.Updated = time.Now()
+		if err := g.store.SaveTutorialProgress(*progress); err != nil {
+			return nil, true, err
+		}
+		text := fmt.Sprintf("You've finished %s. Send /%s to go through it again.", t.Title, t.Name)
+		return g.replace(query.Message, tgbotapi.NewMessage(chatID, text)), true, nil
+	}
+
+	index, err := strconv.Atoi(parts[2])
+	if err != nil || index < 0 {
+		toast = "This button no longer works."
+		return nil, true, nil
+	}
+	if index >= len(t.Pages) {
+		index = len(t.Pages) - 1
+	}
+	if current >= 0 && index > current {
+		g.complete(progress, t.Pages[current].ID, t)
+	}
+	if err := g.visit(progress, t, index); err != nil {
+		return nil, true, err
+	}
+
+	page := t.Pages[index]
+	if page.Media == nil && !hasMedia(query.Message) {
+		edit := tgbotapi.NewEditMessageText(chatID, messageID, pageText(t, index))
+		markup := keyboard(t, index)
+		edit.ReplyMarkup = &markup
+		return edit, true, nil
+	}
+	// A text message cannot become a media message or the other way round
+	return g.replace(query.Message, pageMessage(chatID, t, index)), true, nil
+}
+
+// replace shows next in place of the previous page. Text is edited into the
+// previous message when both are text; otherwise next is sent as a new
+// message and the previous one loses its buttons, so only the newest page
+// can be navigated.
+func (g *Guide) replace(previous *tgbotapi.Message, next tgbotapi.Chattable) tgbotapi.Chattable {
+	if !hasMedia(previous) {
+		if msg, ok := next.(tgbotapi.MessageConfig); ok {
+			return tgbotapi.NewEditMessageText(previous.Chat.ID, previous.MessageID, msg.Text)
+		}
+	}
+
+	empty := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	if _, err := g.out.Send(tgbotapi.NewEditMessageReplyMarkup(previous.Chat.ID, previous.MessageID, empty)); err != nil {
+		log.Printf("Failed to remove the buttons of message %d in %d: %v", previous.MessageID, previous.Chat.ID, err)
+	}
+	return next
+}
+
+// load returns the user's progress through the current version of a tutorial.
+// Progress on another version carries over the page if it still exists.
+func (g *Guide) load(userID int64, t *Tutorial) (*db.TutorialProgress, error) {
+	progress, err := g.store.GetTutorialProgress(userID, t.Name)
+	if errors.IsNotFound(err) {
+		return &db.TutorialProgress{UserID: userID, Tutorial: t.Name, Version: t.Version, Started: time.Now()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if progress.Version != t.Version {
+		page := progress.Page
+		if t.index(page) < 0 {
+			page = ""
+		}
+		// Analytics are per version, so the user counts afresh
+		progress = &db.TutorialProgress{UserID: userID, Tutorial: t.Name, Version: t.Version, Page: page, Started: time.Now()}
+	}
+	return progress, nil
+}
+
+// visit moves the user to a page and saves the progress, counting the step
+// as reached the first time the user sees it.
+func (g *Guide) visit(progress *db.TutorialProgress, t *Tutorial, index int) error {
+	id := t.Pages[index].ID
+	progress.Page = id
+	progress.Updated = time.Now()
+	if !contains(progress.Seen, id) {
+		progress.Seen = append(progress.Seen, id)
+		g.count(t, id, db.StepReached)
+	}
+	return g.store.SaveTutorialProgress(*progress)
+}
+
+// complete counts a step as completed the first time the user moves past it.
+// The caller saves the progress.
+func (g *Guide) complete(progress *db.TutorialProgress, id string, t *Tutorial) {
+	if contains(progress.Completed, id) {
+		return
+	}
+	progress.Completed = append(progress.Completed, id)
+	g.count(t, id, db.StepCompleted)
+}
+
+// count increments a step counter. Analytics are best effort.
+func (g *Guide) count(t *Tutorial, id string, counter string) {
+	if err := g.store.CountTutorialStep(t.Name, t.Version, id, counter); err != nil {
+		log.Printf("Failed to count step %s of %s: %v", id, t.Name, errors.HandleError(err))
+	}
+}
+
+// handleStats reports, for the current version of every tutorial, how many
+// users reached and completed each step.
+func (g *Guide) handleStats(ctx *router.Context) (tgbotapi.Chattable, error) {
+	if len(g.tutorials) == 0 {
+		return ctx.Reply("No tutorials are loaded."), nil
+	}
+
+	var b strings.Builder
+	for i, t := range g.tutorials {
+		stats, err := g.store.TutorialStats(t.Name, t.Version)
+		if err != nil {
+			return nil, err
+		}
+		byPage := make(map[string]db.TutorialStepStats, len(stats))
+		for _, s := range stats {
+			byPage[s.Page] = s
+		}
+
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "/%s, version %d:", t.Name, t.Version)
+		for j, page := range t.Pages {
+			s := byPage[page.ID]
+			fmt.Fprintf(&b, "\n%d. %s: %d reached, %d completed", j+1, page.ID, s.Reached, s.Completed)
+			if s.Reached > 0 {
+				fmt.Fprintf(&b, " (%d%%)", s.Completed*100/s.Reached)
+			}
+		}
+	}
+	return ctx.Reply(b.String()), nil
+}
+
+// pageMessage builds a new message showing a page, with its media if it has any.
+func pageMessage(chatID int64, t *Tutorial, index int) tgbotapi.Chattable {
+	page := t.Pages[index]
+	text := pageText(t, index)
+	markup := keyboard(t, index)
+
+	if page.Media == nil {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyMarkup = markup
+		return msg
+	}
+
+	switch page.Media.Type {
+	case MediaPhoto:
+		c := tgbotapi.NewPhotoShare(chatID, page.Media.File)
+		c.Caption, c.ReplyMarkup = text, markup
+		return c
+	case MediaVideo:
+		c := tgbotapi.NewVideoShare(chatID, page.Media.File)
+		c.Caption, c.ReplyMarkup = text, markup
+		return c
+	case MediaAnimation:
+		c := tgbotapi.NewAnimationShare(chatID, page.Media.File)
+		c.Caption, c.ReplyMarkup = text, markup
+		return c
+	default:
+		c := tgbotapi.NewDocumentShare(chatID, page.Media.File)
+		c.Caption, c.ReplyMarkup = text, markup
+		return c
+	}
+}
+
+// pageText renders the title, body and position of a page.
+func pageText(t *Tutorial, index int) string {
+	return fmt.Sprintf("%s\n\n%s\n\n%d/%d", t.Title, strings.TrimSpace(t.Pages[index].Text), index+1, len(t.Pages))
+}
+
+// keyboard returns the link buttons of a page followed by the navigation row.
+func keyboard(t *Tutorial, index int) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, link := range t.Pages[index].Links {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(link.Label, link.URL)))
+	}
+
+	data := func(value string) string {
+		return callbackPrefix + "|" + t.Name + "|" + value
+	}
+	var nav []tgbotapi.InlineKeyboardButton
+	if index > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("« Back", data(strconv.Itoa(index-1))))
+	}
+	if index < len(t.Pages)-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("Next »", data(strconv.Itoa(index+1))))
+	} else {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("Done", data(done)))
+	}
+	rows = append(rows, nav)
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// hasMedia reports whether a message carries a file rather than plain text.
+func hasMedia(message *tgbotapi.Message) bool {
+	return message.Photo != nil || message.Video != nil || message.Animation != nil || message.Document != nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+//updates/updates.go
+
+package updates
+
+import (
+	"encoding/json"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Allowed lists the update types the bot asks Telegram for.
+// chat_member is only delivered when requested explicitly.
+var Allowed = []string{"message", "edited_message", "callback_query", "my_chat_member", "chat_member"}
+
+// Update is a Telegram update including the membership changes the
+// tgbotapi client does not decode.
+type Update struct {
+	tgbotapi.Update
+	MyChatMember *ChatMemberUpdated `json:"my_chat_member"` // The bot's own status in a chat changed
+	ChatMember   *ChatMemberUpdated `json:"chat_member"`    // A member's status in a chat changed
+}
+
+// ChatMemberUpdated describes a change of a chat member's status.
+type ChatMemberUpdated struct {
+	Chat          tgbotapi.Chat       `json:"chat"`
+	From          tgbotapi.User       `json:"from"` // Who made the change
+	Date          int64               `json:"date"`
+	OldChatMember tgbotapi.ChatMember `json:"old_chat_member"`
+	NewChatMember tgbotapi.ChatMember `json:"new_chat_member"`
+}
+
+// Time returns when the change happened.
+func (c *ChatMemberUpdated) Time() time.Time {
+	return time.Unix(c.Date, 0)
+}
+
+// Member statuses reported by Telegram.
+const (
+	StatusCreator       = "creator"
+	StatusAdministrator = "administrator"
+	StatusMember        = "member"
+	StatusRestricted    = "restricted"
+	StatusLeft          = "left"
+	StatusKicked        = "kicked"
+)
+
+// IsPresent reports whether a member with the given status is in the chat.
+func IsPresent(status string) bool {
+	return status != StatusLeft && status != StatusKicked && status != ""
+}
+
+// ChatID returns the chat an update belongs to, falling back to the sender for updates without a chat.
+func ChatID(update Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil && update.EditedMessage.Chat != nil:
+		return update.EditedMessage.Chat.ID
+	case update.ChannelPost != nil && update.ChannelPost.Chat != nil:
+		return update.ChannelPost.Chat.ID
+	case update.EditedChannelPost != nil && update.EditedChannelPost.Chat != nil:
+		return update.EditedChannelPost.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return int64(update.CallbackQuery.From.ID)
+	case update.InlineQuery != nil && update.InlineQuery.From != nil:
+		return int64(update.InlineQuery.From.ID)
+	case update.ChosenInlineResult != nil && update.ChosenInlineResult.From != nil:
+		return int64(update.ChosenInlineResult.From.ID)
+	case update.MyChatMember != nil:
+		return update.MyChatMember.Chat.ID
+	case update.ChatMember != nil:
+		return update.ChatMember.Chat.ID
+	}
+	return 0
+}
+
+// Poller fetches updates with getUpdates long polling, asking for every type in Allowed.
+type Poller struct {
+	bot     *tgbotapi.BotAPI
+	timeout int
+	updates chan Update
+	quit    chan struct{}
+	once    sync.Once
+}
+
+// Poll starts long polling in the background. Updates are delivered on a
+// channel buffered to buffer entries, which is closed after Stop.
+func Poll(bot *tgbotapi.BotAPI, timeout int, buffer int) *Poller {
+	p := &Poller{
+		bot:     bot,
+		timeout: timeout,
+		updates: make(chan Update, buffer),
+		quit:    make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Updates returns the channel the received updates are delivered on.
+func (p *Poller) Updates() <-chan Update {
+	return p.updates
+}
+
+// Stop ends polling once the current request returns.
+func (p *Poller) Stop() {
+	p.once.Do(func() { close(p.quit) })
+}
+
+func (p *Poller) run() {
+	defer close(p.updates)
+
+	allowed, _ := json.Marshal(Allowed)
+	offset := 0
+	for {
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+
+		v := url.Values{}
+		v.Set("offset", strconv.Itoa(offset))
+		v.Set("timeout", strconv.Itoa(p.timeout))
+		v.Set("allowed_updates", string(allowed))
+
+		resp, err := p.bot.MakeRequest("getUpdates", v)
+		var batch []Update
+		if err == nil {
+			err = json.Unmarshal(resp.Result, &batch)
+		}
+		if err != nil {
+			log.Printf("Failed to get updates, retrying in 3 seconds: %v", err)
+			select {
+			case <-time.After(3 * time.Second):
+			case <-p.quit:
+				return
+			}
+			continue
+		}
+
+		for _, update := range batch {
+			if update.UpdateID >= offset {
+				offset = update.UpdateID + 1
+				select {
+				case p.updates <- update:
+				case <-p.quit:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+//validate/contact.go
+
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	db "tg/db"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Name length limits, in characters.
+const (
+	minName = 2
+	maxName = 64
+)
+
+// E.164 allows at most 15 digits; national numbers are at least 7 long.
+const (
+	minPhoneDigits = 7
+	maxPhoneDigits = 15
+)
+
+// days are the day abbreviations of a contact window, Monday first, and dayNames their full names.
+var (
+	days     = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+	dayNames = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+)
+
+// fillers are words around the days of a contact window that carry no meaning.
+var fillers = regexp.MustCompile(`\b(every day|any day|daily|from|between|on|at)\b`)
+
+// timeRange finds the first "<time> - <time>" of a contact window, with optional minutes and am/pm.
+var timeRange = regexp.MustCompile(`(?i)(\d{1,2}(?::\d{2})?\s*(?:am|pm)?)\s*(?:-|–|—|\bto\b|\buntil\b)\s*(\d{1,2}(?::\d{2})?\s*(?:am|pm)?)`)
+
+// Name checks a person's name and returns it with its spacing tidied. Names
+// are made of letters in any script, with spaces, hyphens, apostrophes and
+// periods between them.
+func Name(answer string) (string, error) {
+	name := strings.Join(strings.Fields(answer), " ")
+	if n := utf8.RuneCountInString(name); n < minName || n > maxName {
+		return "", fmt.Errorf("Please send a name of %d to %d characters.", minName, maxName)
+	}
+
+	letters := 0
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r) || unicode.Is(unicode.Mn, r):
+			letters++
+		case r == ' ' || r == '-' || r == '\'' || r == '’' || r == '.':
+		case unicode.IsDigit(r):
+			return "", fmt.Errorf("Names can't contain digits. Please send your name as you'd like us to address you.")
+		default:
+			return "", fmt.Errorf("Names can only contain letters, spaces, hyphens, apostrophes and periods; %q isn't allowed.", r)
+		}
+	}
+	if letters < minName {
+		return "", fmt.Errorf("Please send your name as you'd like us to address you.")
+	}
+	return name, nil
+}
+
+// Phone checks a phone number given in international form, such as
+// +44 20 7946 0958, and returns it in E.164 form, e.g. +442079460958.
+func Phone(answer string) (string, error) {
+	answer = strings.TrimSpace(answer)
+	if strings.HasPrefix(answer, "00") {
+		answer = "+" + answer[2:]
+	}
+	if !strings.HasPrefix(answer, "+") {
+		return "", fmt.Errorf("Please include your country code, e.g. +44 20 7946 0958.")
+	}
+
+	var digits strings.Builder
+	for _, r := range answer[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", fmt.Errorf("Phone numbers can only contain digits, spaces, dashes and brackets after the +.")
+		}
+	}
+	number := digits.String()
+	if len(number) < minPhoneDigits || len(number) > maxPhoneDigits || number[0] == '0' {
+		return "", fmt.Errorf("That doesn't look like a phone number. Send it with your country code, e.g. +44 20 7946 0958.")
+	}
+	return "+" + number, nil
+}
+
+// ContactTime parses when someone would like to be contacted, such as
+// "weekdays 9am-5pm Europe/Berlin" or "mon, wed 18:00 to 20:00 UTC+2",
+// into a window. The days are optional; the time zone is required.
+func ContactTime(answer string) (db.ContactWindow, error) {
+	answer = strings.TrimSpace(answer)
+	match := timeRange.FindStringSubmatchIndex(answer)
+	if match == nil {
+		return db.ContactWindow{}, fmt.Errorf("Please give a time range with your time zone, e.g. \"weekdays 9:00-17:00 Europe/Berlin\" or \"18:00-20:00 UTC+2\".")
+	}
+
+	var window db.ContactWindow
+	var err error
+	if window.Start, err = parseTime(answer[match[2]:match[3]]); err != nil {
+		return db.ContactWindow{}, err
+	}
+	end := answer[match[4]:match[5]]
+	if window.End, err = parseTime(end); err != nil {
+		return db.ContactWindow{}, err
+	}
+	// "9-5" means 9:00-17:00, while "22-6" spans midnight
+	if !strings.ContainsAny(strings.ToLower(end), "ap") && window.End < window.Start && window.End < 12*60 && window.End+12*60 > window.Start {
+		window.End += 12 * 60
+	}
+	if window.Start == window.End {
+		return db.ContactWindow{}, fmt.Errorf("The window starts and ends at the same time. Please give a range such as 9:00-17:00.")
+	}
+
+	if window.Days, err = parseDays(answer[:match[0]]); err != nil {
+		return db.ContactWindow{}, err
+	}
+
+	zone := strings.TrimSpace(answer[match[1]:])
+	zone = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(zone, "in "), "time zone"))
+	if zone == "" {
+		return db.ContactWindow{}, fmt.Errorf("Please add your time zone after the times, e.g. \"9:00-17:00 Europe/Berlin\" or \"9:00-17:00 UTC+2\".")
+	}
+	if _, err := Location(zone); err != nil {
+		return db.ContactWindow{}, err
+	}
+	window.Timezone = zone
+	return window, nil
+}
+
+// FormatWindow renders a window in the form ContactTime reads, e.g. "mon-fri 09:00-17:00 Europe/Berlin".
+func FormatWindow(window db.ContactWindow) string {
+	text := fmt.Sprintf("%02d:%02d-%02d:%02d %s", window.Start/60, window.Start%60, window.End/60, window.End%60, window.Timezone)
+	if len(window.Days) == 0 {
+		return text
+	}
+	return formatDays(window.Days) + " " + text
+}
+
+// parseTime parses a time of day such as 9, 9:30, 17:00 or 5pm into minutes after midnight.
+func parseTime(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	suffix := ""
+	if strings.HasSuffix(s, "am") || strings.HasSuffix(s, "pm") {
+		s, suffix = strings.TrimSpace(s[:len(s)-2]), s[len(s)-2:]
+	}
+
+	hours, minutes, _ := strings.Cut(s, ":")
+	h, err := strconv.Atoi(hours)
+	m := 0
+	if err == nil && minutes != "" {
+		m, err = strconv.Atoi(minutes)
+	}
+	if suffix != "" && (h < 1 || h > 12) {
+		err = fmt.Errorf("hour out of range")
+	}
+	if err != nil || h > 23 || m > 59 {
+		return 0, fmt.Errorf("%q is not a time of day. Use times like 9:00, 17:30 or 5pm.", s+suffix)
+	}
+
+	switch {
+	case suffix == "am" && h == 12:
+		h = 0
+	case suffix == "pm" && h != 12:
+		h += 12
+	}
+	return h*60 + m, nil
+}
+
+// parseDays parses the days before a time range: nothing, "daily",
+// "weekdays", "weekends", day names, and ranges such as "mon-fri". Days are
+// returned in week order.
+func parseDays(s string) ([]string, error) {
+	s = fillers.ReplaceAllString(strings.ToLower(s), " ")
+	s = strings.NewReplacer(" to ", "-", "–", "-", "—", "-", " and ", ",", "&", ",").Replace(s)
+
+	selected := make(map[string]bool)
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		part = strings.Trim(part, ".")
+		switch part {
+		case "":
+			continue
+		case "weekdays", "weekday":
+			for _, d := range days[:5] {
+				selected[d] = true
+			}
+			continue
+		case "weekends", "weekend":
+			selected["sat"], selected["sun"] = true, true
+			continue
+		}
+
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := day(from)
+		last := first
+		if isRange {
+			var lastOK bool
+			last, lastOK = day(to)
+			ok = ok && lastOK
+		}
+		if !ok {
+			return nil, fmt.Errorf("I don't understand %q. Give days like \"weekdays\" or \"mon-fri\" before the times, or leave them out for any day.", part)
+		}
+		for i := first; ; i = (i + 1) % len(days) {
+			selected[days[i]] = true
+			if i == last {
+				break
+			}
+		}
+	}
+
+	if len(selected) == len(days) {
+		return nil, nil
+	}
+	var result []string
+	for _, d := range days {
+		if selected[d] {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+
+// day returns the index of a day given by its name or an abbreviation of at least three letters.
+func day(s string) (int, bool) {
+	if len(s) < 3 {
+		return 0, false
+	}
+	for i, d := range days {
+		if strings.HasPrefix(s, d) && strings.HasPrefix(dayNames[i], s) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// formatDays renders days in week order, joining runs of three or more into ranges, e.g. "mon-fri,sun".
+func formatDays(selected []string) string {
+	index := make(map[string]bool, len(selected))
+	for _, d := range selected {
+		index[d] = true
+	}
+
+	var parts []string
+	for i := 0; i < len(days); i++ {
+		if !index[days[i]] {
+			continue
+		}
+		j := i
+		for j+1 < len(days) && index[days[j+1]] {
+			j++
+		}
+		switch {
+		case j-i >= 2:
+			parts = append(parts, days[i]+"-"+days[j])
+		case j > i:
+			parts = append(parts, days[i], days[j])
+		default:
+			parts = append(parts, days[i])
+		}
+		i = j
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,134 @@
+//validate/email.go
+
+package validate
+
+import (
+	"bufio"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// Limits of RFC 5321 on the parts of an address.
+const (
+	maxLocal   = 64
+	maxAddress = 254
+)
+
+// disposable are well-known throwaway mail providers, refused even without a list file.
+var disposable = []string{
+	"10minutemail.com", "burnermail.io", "discard.email", "dispostable.com", "emailfake.com",
+	"emailondeck.com", "fakeinbox.com", "getnada.com", "grr.la", "guerrillamail.com",
+	"guerrillamailblock.com", "mailcatch.com", "maildrop.cc", "mailinator.com", "mailnesia.com",
+	"mintemail.com", "moakt.com", "mohmal.com", "mytemp.email", "sharklasers.com",
+	"spamgourmet.com", "temp-mail.org", "tempail.com", "tempmail.com", "tempr.email",
+	"throwawaymail.com", "tmpmail.org", "trashmail.com", "yopmail.com",
+}
+
+// Domains is a set of mail domains. A domain matches when it or one of its
+// parent domains is in the set.
+type Domains map[string]bool
+
+// Disposable returns the built-in disposable domains, extended with the
+// domains of a list file when path is set. The file holds one domain per
+// line; blank lines and lines starting with # are skipped.
+func Disposable(path string) (Domains, error) {
+	domains := make(Domains, len(disposable))
+	for _, d := range disposable {
+		domains[d] = true
+	}
+	if path == "" {
+		return domains, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return domains, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line != "" && !strings.HasPrefix(line, "#") {
+			domains[strings.TrimSuffix(line, ".")] = true
+		}
+	}
+	return domains, scanner.Err()
+}
+
+// Has reports whether domain or one of its parent domains is in the set.
+func (d Domains) Has(domain string) bool {
+	for {
+		if d[domain] {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// Email checks an email address and returns it normalized: trimmed, without
+// a display name or mailto: prefix, and with the domain in lower case. The
+// local part is kept as typed, since mail servers may tell case apart there.
+// Addresses at domains in blocked are refused.
+func Email(answer string, blocked Domains) (string, error) {
+	answer = strings.TrimSpace(answer)
+	if len(answer) >= 7 && strings.EqualFold(answer[:7], "mailto:") {
+		answer = answer[7:]
+	}
+
+	parsed, err := mail.ParseAddress(answer)
+	if err != nil {
+		return "", fmt.Errorf("That doesn't look like an email address. Please send it as name@example.com.")
+	}
+	local, domain, _ := strings.Cut(parsed.Address, "@")
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	if local == "" || len(local) > maxLocal || strings.ContainsAny(local, " \"") {
+		return "", fmt.Errorf("The part before the @ isn't valid. Please check your email address.")
+	}
+	if !validDomain(domain) {
+		return "", fmt.Errorf("%s isn't a valid email domain. Please check your email address.", domain)
+	}
+	if blocked.Has(domain) {
+		return "", fmt.Errorf("Disposable addresses such as %s can't be used. Please send an address you'll keep.", domain)
+	}
+
+	address := local + "@" + domain
+	if len(address) > maxAddress {
+		return "", fmt.Errorf("That email address is too long.")
+	}
+	return address, nil
+}
+
+// validDomain reports whether domain is a DNS name with a top-level domain of
+// letters, e.g. example.co.uk. Internationalized domains must be given in punycode.
+func validDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 || len(domain) > 253 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	tld := labels[len(labels)-1]
+	if len(tld) < 2 {
+		return false
+	}
+	if strings.HasPrefix(tld, "xn--") {
+		return true
+	}
+	return strings.Trim(tld, "abcdefghijklmnopqrstuvwxyz") == ""
+}
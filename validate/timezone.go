@@ -0,0 +1,46 @@
+//validate/timezone.go
+
+package validate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Time zones are looked up by name without relying on the host
+)
+
+// Location resolves a time zone given as an IANA name such as Europe/Berlin,
+// or as an offset such as UTC+2, +05:30 or -7. An empty name is UTC.
+func Location(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc, nil
+	}
+
+	offset := strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(name), "UTC"), "GMT")
+	if offset == "" || (offset[0] != '+' && offset[0] != '-') {
+		return nil, fmt.Errorf("Unknown time zone %q. Use a name like Europe/Berlin or an offset like UTC+2.", name)
+	}
+	sign := 1
+	if offset[0] == '-' {
+		sign = -1
+	}
+
+	hours, minutes, _ := strings.Cut(offset[1:], ":")
+	if len(hours) == 4 && minutes == "" {
+		hours, minutes = hours[:2], hours[2:]
+	}
+	h, err := strconv.Atoi(hours)
+	m := 0
+	if err == nil && minutes != "" {
+		m, err = strconv.Atoi(minutes)
+	}
+	if err != nil || h > 14 || m >= 60 {
+		return nil, fmt.Errorf("Unknown time zone %q. Use a name like Europe/Berlin or an offset like UTC+2.", name)
+	}
+	return time.FixedZone(strings.ToUpper(name), sign*(h*3600+m*60)), nil
+}
@@ -0,0 +1,39 @@
+//validate/timezone_test.go
+
+package validate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocation(t *testing.T) {
+	at := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		offset int // Seconds east of UTC at noon on 15 January 2024
+		ok     bool
+	}{
+		{"", 0, true},
+		{"Europe/Berlin", 3600, true},
+		{"UTC+2", 2 * 3600, true},
+		{"+05:30", 5*3600 + 30*60, true},
+		{"-7", -7 * 3600, true},
+		{"GMT-0930", -(9*3600 + 30*60), true},
+		{"UTC+15", 0, false},
+		{"Mars/Olympus", 0, false},
+	}
+	for _, tt := range tests {
+		loc, err := Location(tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("Location(%q) error = %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if _, offset := at.In(loc).Zone(); offset != tt.offset {
+			t.Errorf("Location(%q) offset = %d, want %d", tt.name, offset, tt.offset)
+		}
+	}
+}
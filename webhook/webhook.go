@@ -0,0 +1,202 @@
+//webhook/webhook.go
+
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	config "tg/config"
+	updates "tg/updates"
+	"time"
+)
+
+// SecretHeader is the header Telegram uses to send the webhook secret token.
+const SecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxBodySize caps the size of an update payload.
+const maxBodySize = 1 << 20
+
+// Server receives updates pushed by Telegram and hands them to the update pipeline.
+type Server struct {
+	cfg     config.WebhookConfig
+	updates chan updates.Update
+	server  *http.Server
+
+	mu       sync.Mutex     // Guards closed
+	closed   bool           // Set by Shutdown; later requests are refused
+	done     chan struct{}  // Closed by Shutdown to release requests waiting on the pipeline
+	inflight sync.WaitGroup // Requests that may still deliver an update
+
+	closeOnce sync.Once // Closes updates
+}
+
+// New creates a webhook server. Updates are delivered on a channel buffered to buffer entries.
+func New(cfg config.WebhookConfig, buffer int) *Server {
+	s := &Server{
+		cfg:     cfg,
+		updates: make(chan updates.Update, buffer),
+		done:    make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, s)
+	s.server = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Updates returns the channel the received updates are delivered on.
+func (s *Server) Updates() <-chan updates.Update {
+	return s.updates
+}
+
+// ServeHTTP verifies the secret token and decodes a single update.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get(SecretHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Secret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var update updates.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&update); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	// The update channel is only closed once no request can send on it
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.inflight.Add(1)
+	s.mu.Unlock()
+	defer s.inflight.Done()
+
+	select {
+	case s.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-r.Context().Done():
+		// The pipeline is backed up; Telegram will deliver the update again.
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	case <-s.done:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}
+}
+
+// Start binds the listen address and serves the webhook in the background
+// until Shutdown is called. Failing to bind or to load the certificate is
+// reported here rather than after the bot has started. TLS is terminated
+// when a certificate is configured, and plain HTTP is served otherwise,
+// which is the setup to use behind a reverse proxy.
+func (s *Server) Start() error {
+	if s.cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		s.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	listener, err := net.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		var err error
+		if s.server.TLSConfig != nil {
+			err = s.server.ServeTLS(listener, "", "")
+		} else {
+			err = s.server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("Webhook server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting updates and waits for in-progress requests. The
+// update channel is closed once no request can send on it anymore, even when
+// ctx ends first: requests still waiting on the pipeline are refused instead.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	s.mu.Unlock()
+
+	s.inflight.Wait()
+	s.closeOnce.Do(func() { close(s.updates) })
+	return err
+}
+
+// Register points the bot's webhook at the configured public URL.
+// The certificate is uploaded when UploadCert is set, for self-signed setups.
+func Register(bot *tgbotapi.BotAPI, cfg config.WebhookConfig) error {
+	allowed, err := json.Marshal(updates.Allowed)
+	if err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"url":             cfg.PublicURL,
+		"secret_token":    cfg.Secret,
+		"allowed_updates": string(allowed),
+	}
+	if cfg.MaxConnections > 0 {
+		params["max_connections"] = strconv.Itoa(cfg.MaxConnections)
+	}
+
+	if cfg.UploadCert {
+		_, err := bot.UploadFile("setWebhook", params, "certificate", cfg.CertFile)
+		return err
+	}
+
+	values := url.Values{}
+	for k, v := range params {
+		values.Set(k, v)
+	}
+	_, err = bot.MakeRequest("setWebhook", values)
+	return err
+}
+
+// Serve starts the server in the background and then registers the webhook
+// if configured, so Telegram is only pointed at an address that is listening.
+func Serve(bot *tgbotapi.BotAPI, cfg config.WebhookConfig, buffer int) (*Server, error) {
+	s := New(cfg, buffer)
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	if cfg.Register {
+		if err := Register(bot, cfg); err != nil {
+			s.Shutdown(context.Background())
+			return nil, err
+		}
+	}
+	log.Printf("Listening for webhook updates on %s%s", cfg.Listen, cfg.Path)
+	return s, nil
+}
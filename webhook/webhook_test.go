@@ -0,0 +1,81 @@
+//webhook/webhook_test.go
+
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	config "tg/config"
+	"time"
+)
+
+func testConfig(listen string) config.WebhookConfig {
+	return config.WebhookConfig{Listen: listen, Path: "/hook", Secret: "s3cret"}
+}
+
+func post(s *Server, secret string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	req.Header.Set(SecretHeader, secret)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   int
+	}{
+		{"delivers update", "s3cret", `{"update_id": 1}`, http.StatusOK},
+		{"wrong secret", "nope", `{"update_id": 2}`, http.StatusForbidden},
+		{"bad json", "s3cret", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(testConfig(""), 1)
+			if rec := post(s, tt.secret, tt.body); rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestShutdownReleasesBlockedRequests(t *testing.T) {
+	s := New(testConfig(""), 0) // Unbuffered and never read: every request blocks
+
+	result := make(chan int)
+	go func() { result <- post(s, "s3cret", `{"update_id": 1}`).Code }()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	s.Shutdown(ctx)
+
+	if code := <-result; code != http.StatusServiceUnavailable {
+		t.Fatalf("blocked request got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if _, open := <-s.Updates(); open {
+		t.Fatal("updates channel still open after Shutdown")
+	}
+	if code := post(s, "s3cret", `{"update_id": 2}`).Code; code != http.StatusServiceUnavailable {
+		t.Fatalf("request after Shutdown got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestStartReportsBindFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	if err := New(testConfig(taken.Addr().String()), 1).Start(); err == nil {
+		t.Fatal("Start succeeded on an address in use")
+	}
+}
@@ -0,0 +1,455 @@
+//welcome/welcome.go
+
+package welcome
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	config "tg/config"
+	db "tg/db"
+	errors "tg/errors"
+	groups "tg/groups"
+	router "tg/router"
+	sender "tg/sender"
+	"time"
+	"unicode"
+)
+
+// maxNames caps the names listed in a batched welcome; the rest are counted.
+const maxNames = 10
+
+// maxButtons caps the link buttons under a welcome message.
+const maxButtons = 6
+
+// placeholders are the values a template can refer to.
+var placeholders = map[string]bool{"first_name": true, "group": true, "member_count": true}
+
+// placeholderPattern finds the placeholders in a template.
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// usage lists the /welcome subcommands.
+const usage = "[on|off|set <text>|reset|buttons <label> <url> ...|delete <minutes>|test]"
+
+// ChatAPI is the part of the Telegram client the welcomer needs. *tgbotapi.BotAPI implements it.
+type ChatAPI interface {
+	groups.MemberAPI
+	GetChatMembersCount(config tgbotapi.ChatConfig) (int, error)
+}
+
+// Welcomer greets new group members with their group's welcome message.
+//
+// The first join after a quiet period is welcomed right away. Joins that
+// follow within the batch window are collected and greeted together when
+// it ends, so a join flood produces one message per window.
+type Welcomer struct {
+	store    db.Store
+	api      ChatAPI
+	out      *sender.Sender
+	cfg      config.WelcomeConfig
+	commands *router.Router // Set by Register; bot admins may manage any group
+
+	mu        sync.Mutex
+	batches   map[int64]*batch         // Groups inside their batch window
+	deletions map[deletion]*time.Timer // Welcomes waiting to be deleted
+	stopped   bool                     // Set by Stop; no more deletions are scheduled
+}
+
+// deletion identifies a welcome message that is deleted after a while.
+type deletion struct {
+	chatID    int64
+	messageID int
+}
+
+// batch collects the members who joined a group during its batch window.
+type batch struct {
+	timer   *time.Timer
+	pending []tgbotapi.User
+}
+
+// New creates a welcomer sending through out.
+func New(store db.Store, api ChatAPI, out *sender.Sender, cfg config.WelcomeConfig) *Welcomer {
+	return &Welcomer{
+		store:     store,
+		api:       api,
+		out:       out,
+		cfg:       cfg,
+		batches:   make(map[int64]*batch),
+		deletions: make(map[deletion]*time.Timer),
+	}
+}
+
+// Register adds the /welcome command to the router.
+func (w *Welcomer) Register(r *router.Router) {
+	w.commands = r
+	r.MustRegister(router.Command{
+		Name:        "welcome",
+		Description: "Welcome the new users",
+		Usage:       usage,
+		MaxArgs:     -1,
+		ChatTypes:   []string{router.Group},
+		Handler:     w.handleCommand,
+	})
+}
+
+// HandleJoin welcomes the members a message reports as new.
+func (w *Welcomer) HandleJoin(message *tgbotapi.Message) error {
+	if message == nil || message.NewChatMembers == nil || message.Chat == nil {
+		return nil
+	}
+
+	var joined []tgbotapi.User
+	for _, member := range *message.NewChatMembers {
+		if !member.IsBot {
+			joined = append(joined, member)
+		}
+	}
+	if len(joined) == 0 {
+		return nil
+	}
+
+	group, err := w.store.GetGroup(message.Chat.ID)
+	if errors.IsGroupNotFound(err) {
+		return nil
+	}
+	if err != nil || !w.enabled(group.Welcome) {
+		return err
+	}
+
+	chatID := message.Chat.ID
+	if w.cfg.BatchWindow > 0 {
+		w.mu.Lock()
+		if b, ok := w.batches[chatID]; ok {
+			b.pending = append(b.pending, joined...)
+			w.mu.Unlock()
+			return nil
+		}
+		w.batches[chatID] = &batch{timer: time.AfterFunc(w.cfg.BatchWindow, func() { w.flush(chatID) })}
+		w.mu.Unlock()
+	}
+
+	return w.send(chatID, joined)
+}
+
+// Stop deletes the welcomes that were waiting to be deleted, as their timers
+// do not outlive the process, and greets the members still waiting in a batch
+// instead of dropping them. Welcomes sent while stopping are kept.
+func (w *Welcomer) Stop(ctx context.Context) error {
+	w.mu.Lock()
+	w.stopped = true
+	pending := make(map[int64][]tgbotapi.User)
+	for chatID, b := range w.batches {
+		b.timer.Stop()
+		if len(b.pending) > 0 {
+			pending[chatID] = b.pending
+		}
+	}
+	w.batches = make(map[int64]*batch)
+	deletions := w.deletions
+	for _, timer := range deletions {
+		timer.Stop()
+	}
+	w.deletions = make(map[deletion]*time.Timer)
+	w.mu.Unlock()
+
+	var errs []error
+	for d := range deletions {
+		if ctx.Err() != nil {
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+		errs = append(errs, w.deleteWelcome(d))
+	}
+	for chatID, members := range pending {
+		if ctx.Err() != nil {
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+		errs = append(errs, w.send(chatID, members))
+	}
+	return errors.Join(errs...)
+}
+
+// flush greets the members collected during a group's batch window.
+// The window starts again while members keep joining.
+func (w *Welcomer) flush(chatID int64) {
+	w.mu.Lock()
+	b, ok := w.batches[chatID]
+	if !ok {
+		w.mu.Unlock()
+		return
+	}
+	pending := b.pending
+	if len(pending) == 0 {
+		delete(w.batches, chatID)
+		w.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	b.timer = time.AfterFunc(w.cfg.BatchWindow, func() { w.flush(chatID) })
+	w.mu.Unlock()
+
+	if err := w.send(chatID, pending); err != nil {
+		log.Printf("Failed to welcome %d members of %d: %v", len(pending), chatID, errors.HandleError(err))
+	}
+}
+
+// send posts the group's welcome for the members and schedules its deletion.
+func (w *Welcomer) send(chatID int64, members []tgbotapi.User) error {
+	group, err := w.store.GetGroup(chatID)
+	if err != nil || !w.enabled(group.Welcome) {
+		return err
+	}
+
+	msg, err := w.out.Send(w.message(group, members))
+	if err != nil {
+		return err
+	}
+
+	if after := group.Welcome.DeleteAfter; after > 0 {
+		w.scheduleDeletion(deletion{chatID: chatID, messageID: msg.MessageID}, after)
+	}
+	return nil
+}
+
+// scheduleDeletion deletes a welcome once after has passed, or when the welcomer stops.
+func (w *Welcomer) scheduleDeletion(d deletion, after time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+
+	w.deletions[d] = time.AfterFunc(after, func() {
+		w.mu.Lock()
+		_, due := w.deletions[d]
+		delete(w.deletions, d)
+		w.mu.Unlock()
+
+		if due {
+			if err := w.deleteWelcome(d); err != nil {
+				log.Printf("Failed to delete welcome %d in %d: %v", d.messageID, d.chatID, errors.HandleError(err))
+			}
+		}
+	})
+}
+
+// deleteWelcome removes a welcome message from its group.
+func (w *Welcomer) deleteWelcome(d deletion) error {
+	_, err := w.out.Send(tgbotapi.NewDeleteMessage(d.chatID, d.messageID))
+	return err
+}
+
+// enabled reports whether a group's new members are welcomed. Until an
+// admin changes the group's settings, they are whenever there is a default
+// template to welcome them with.
+func (w *Welcomer) enabled(settings db.Welcome) bool {
+	if settings.UpdatedBy == 0 {
+		return settings.Enabled || w.cfg.Template != ""
+	}
+	return settings.Enabled
+}
+
+// message renders the welcome of a group for the members, with its buttons.
+func (w *Welcomer) message(group *db.Group, members []tgbotapi.User) tgbotapi.MessageConfig {
+	count := group.MemberCount
+	if count == 0 {
+		if n, err := w.api.GetChatMembersCount(tgbotapi.ChatConfig{ChatID: group.GroupID}); err == nil {
+			count = n
+		}
+	}
+
+	template := group.Welcome.Template
+	if template == "" {
+		template = w.cfg.Template
+	}
+
+	msg := tgbotapi.NewMessage(group.GroupID, Render(template, names(members), group.GroupName, count))
+	if len(group.Welcome.Buttons) > 0 {
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for _, button := range group.Welcome.Buttons {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(button.Label, button.URL)))
+		}
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	return msg
+}
+
+// handleCommand shows or changes the group's welcome settings. Only group admins may use it.
+func (w *Welcomer) handleCommand(ctx *router.Context) (tgbotapi.Chattable, error) {
+	chatID := ctx.Message.Chat.ID
+	userID := ctx.Message.From.ID
+
+	admin, err := groups.IsAdmin(w.api, w.commands, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return ctx.Reply("Only group admins can change the welcome message."), nil
+	}
+
+	group, err := w.store.GetGroup(chatID)
+	if errors.IsGroupNotFound(err) {
+		group, err = &db.Group{GroupID: chatID, GroupName: ctx.Message.Chat.Title, Type: ctx.Message.Chat.Type, IsActive: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	settings := &group.Welcome
+	settings.Enabled = w.enabled(*settings)
+
+	sub := ""
+	if len(ctx.Args) > 0 {
+		sub = strings.ToLower(ctx.Args[0])
+	}
+
+	var reply string
+	switch sub {
+	case "", "show":
+		return ctx.Reply(w.describe(group)), nil
+	case "test":
+		return w.message(group, []tgbotapi.User{*ctx.Message.From}), nil
+	case "on":
+		settings.Enabled = true
+		reply = "New members will be welcomed."
+	case "off":
+		settings.Enabled = false
+		reply = "New members will no longer be welcomed."
+	case "set":
+		text := ""
+		if i := strings.IndexFunc(ctx.RawArgs, unicode.IsSpace); i >= 0 {
+			text = strings.TrimSpace(ctx.RawArgs[i:])
+		}
+		if text == "" {
+			return ctx.Reply("Usage: /welcome set <text>, using {first_name}, {group} and {member_count}."), nil
+		}
+		if unknown := unknownPlaceholder(text); unknown != "" {
+			return ctx.Reply(fmt.Sprintf("Unknown placeholder {%s}; use {first_name}, {group} or {member_count}.", unknown)), nil
+		}
+		settings.Template = text
+		settings.Enabled = true
+		reply = "Welcome message saved."
+	case "reset":
+		settings.Template = ""
+		reply = "Welcome message reset to the default."
+	case "buttons":
+		buttons, problem := parseButtons(ctx.Args[1:])
+		if problem != "" {
+			return ctx.Reply(problem), nil
+		}
+		settings.Buttons = buttons
+		reply = "The welcome message has no buttons now."
+		if len(buttons) > 0 {
+			reply = fmt.Sprintf("The welcome message now has %d link buttons.", len(buttons))
+		}
+	case "delete":
+		minutes := -1
+		if len(ctx.Args) == 2 {
+			minutes, _ = strconv.Atoi(ctx.Args[1])
+		}
+		if minutes < 0 {
+			return ctx.Reply("Usage: /welcome delete <minutes>, 0 to keep welcome messages."), nil
+		}
+		settings.DeleteAfter = time.Duration(minutes) * time.Minute
+		reply = "Welcome messages will be kept."
+		if minutes > 0 {
+			reply = fmt.Sprintf("Welcome messages will be deleted after %d minutes.", minutes)
+		}
+	default:
+		return ctx.Reply("Usage: /welcome " + usage), nil
+	}
+
+	settings.UpdatedBy = int64(userID)
+	group.Updated = time.Now()
+	if err := w.store.SaveGroup(*group); err != nil {
+		return nil, err
+	}
+	return ctx.Reply(reply), nil
+}
+
+// describe summarizes the group's welcome settings for /welcome.
+func (w *Welcomer) describe(group *db.Group) string {
+	settings := group.Welcome
+
+	state := "off"
+	if settings.Enabled {
+		state = "on"
+	}
+	template := settings.Template
+	if template == "" {
+		template = w.cfg.Template + " (default)"
+	}
+	deletion := "kept"
+	if settings.DeleteAfter > 0 {
+		deletion = "deleted after " + settings.DeleteAfter.String()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Welcome messages are %s.\nTemplate: %s\nMessages are %s.\nButtons: %d", state, template, deletion, len(settings.Buttons))
+	for _, button := range settings.Buttons {
+		fmt.Fprintf(&b, "\n- %s: %s", button.Label, button.URL)
+	}
+	b.WriteString("\n\nUsage: /welcome " + usage)
+	return b.String()
+}
+
+// Render fills in the placeholders of a welcome template.
+func Render(template string, firstName string, group string, memberCount int) string {
+	return strings.NewReplacer(
+		"{first_name}", firstName,
+		"{group}", group,
+		"{member_count}", strconv.Itoa(memberCount),
+	).Replace(template)
+}
+
+// names joins the first names of the members, e.g. "Ann, Bob and Cy".
+func names(members []tgbotapi.User) string {
+	var list []string
+	for i, member := range members {
+		if i == maxNames {
+			list = append(list, fmt.Sprintf("%d others", len(members)-maxNames))
+			break
+		}
+		list = append(list, member.FirstName)
+	}
+
+	if len(list) == 1 {
+		return list[0]
+	}
+	return strings.Join(list[:len(list)-1], ", ") + " and " + list[len(list)-1]
+}
+
+// unknownPlaceholder returns the first placeholder Render does not fill in, if any.
+func unknownPlaceholder(template string) string {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template, -1) {
+		if !placeholders[match[1]] {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// parseButtons reads label and URL pairs; no arguments clears the buttons.
+// The second result explains what is wrong, if anything.
+func parseButtons(args []string) ([]db.WelcomeButton, string) {
+	if len(args)%2 != 0 {
+		return nil, `Usage: /welcome buttons "<label>" <url> ..., or no arguments to remove the buttons.`
+	}
+	if len(args)/2 > maxButtons {
+		return nil, fmt.Sprintf("A welcome message can have at most %d buttons.", maxButtons)
+	}
+
+	var buttons []db.WelcomeButton
+	for i := 0; i < len(args); i += 2 {
+		u, err := url.Parse(args[i+1])
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Sprintf("%q is not a valid http or https link.", args[i+1])
+		}
+		buttons = append(buttons, db.WelcomeButton{Label: args[i], URL: u.String()})
+	}
+	return buttons, ""
+}
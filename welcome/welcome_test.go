@@ -0,0 +1,109 @@
+//welcome/welcome_test.go
+
+package welcome
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"sync"
+	"testing"
+	config "tg/config"
+	db "tg/db"
+	sender "tg/sender"
+	"time"
+)
+
+// fakeAPI records what is sent and answers member lookups.
+type fakeAPI struct {
+	mu   sync.Mutex
+	sent []tgbotapi.Chattable
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, c)
+	return tgbotapi.Message{MessageID: len(f.sent)}, nil
+}
+
+func (f *fakeAPI) GetChatMember(config tgbotapi.ChatConfigWithUser) (tgbotapi.ChatMember, error) {
+	return tgbotapi.ChatMember{Status: "member"}, nil
+}
+
+func (f *fakeAPI) GetChatMembersCount(config tgbotapi.ChatConfig) (int, error) {
+	return 3, nil
+}
+
+func (f *fakeAPI) messages() []tgbotapi.Chattable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]tgbotapi.Chattable(nil), f.sent...)
+}
+
+func join(chatID int64, names ...string) *tgbotapi.Message {
+	var members []tgbotapi.User
+	for i, name := range names {
+		members = append(members, tgbotapi.User{ID: i + 1, FirstName: name})
+	}
+	return &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID, Type: "group"}, NewChatMembers: &members}
+}
+
+func TestHandleJoin(t *testing.T) {
+	const chatID = -100
+	tests := []struct {
+		name     string
+		welcome  db.Welcome
+		template string
+		want     string // Text of the welcome, empty for none
+	}{
+		{"unset uses the default", db.Welcome{}, "Hi {first_name} in {group}!", "Hi Ann in Club!"},
+		{"unset without a default", db.Welcome{}, "", ""},
+		{"turned off", db.Welcome{Enabled: false, UpdatedBy: 9}, "Hi {first_name}!", ""},
+		{"own template", db.Welcome{Enabled: true, Template: "Hello {first_name}", UpdatedBy: 9}, "Hi!", "Hello Ann"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := db.NewMemoryStore()
+			store.SaveGroup(db.Group{GroupID: chatID, GroupName: "Club", IsActive: true, Welcome: tt.welcome})
+			api := &fakeAPI{}
+			w := New(store, api, sender.New(api, store, nil, sender.Options{}), config.WelcomeConfig{Template: tt.template})
+
+			if err := w.HandleJoin(join(chatID, "Ann")); err != nil {
+				t.Fatalf("HandleJoin: %v", err)
+			}
+			sent := api.messages()
+			if tt.want == "" {
+				if len(sent) != 0 {
+					t.Errorf("sent %d messages, want none", len(sent))
+				}
+				return
+			}
+			if len(sent) != 1 || sent[0].(tgbotapi.MessageConfig).Text != tt.want {
+				t.Errorf("sent %v, want %q", sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestStopDeletesPendingWelcomes(t *testing.T) {
+	const chatID = -100
+	store := db.NewMemoryStore()
+	store.SaveGroup(db.Group{GroupID: chatID, GroupName: "Club", Welcome: db.Welcome{Enabled: true, DeleteAfter: time.Hour, UpdatedBy: 9}})
+	api := &fakeAPI{}
+	w := New(store, api, sender.New(api, store, nil, sender.Options{}), config.WelcomeConfig{Template: "Hi {first_name}!"})
+
+	if err := w.HandleJoin(join(chatID, "Ann")); err != nil {
+		t.Fatalf("HandleJoin: %v", err)
+	}
+	if err := w.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	sent := api.messages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want the welcome and its deletion", len(sent))
+	}
+	if del, ok := sent[1].(tgbotapi.DeleteMessageConfig); !ok || del.ChatID != chatID || del.MessageID != 1 {
+		t.Errorf("second message = %#v, want the deletion of the welcome", sent[1])
+	}
+}
@@ -0,0 +1,281 @@
+//wizard/wizard.go
+
+package wizard
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strings"
+	db "tg/db"
+	errors "tg/errors"
+	"time"
+)
+
+// Special transition targets returned by Step.Next.
+const (
+	Done    = "__done__"    // Finish the wizard and call its CompleteFunc
+	Restart = "__restart__" // Drop every answer and go back to the first step
+)
+
+// Store persists wizard states between updates and restarts.
+type Store interface {
+	LoadWizardState(wizard string, userID int64, chatID int64) (*db.WizardState, error)
+	SaveWizardState(state db.WizardState) error
+	DeleteWizardState(wizard string, userID int64, chatID int64) error
+}
+
+// Choice is an inline button offered by a step.
+type Choice struct {
+	Label string // Text shown on the button
+	Value string // Value stored as the answer
+}
+
+// Step is one question of a wizard.
+type Step struct {
+	Name     string                              // Unique step name, also the key of its answer
+	Prompt   func(state *db.WizardState) string  // Question shown to the user
+	Choices  []Choice                            // Buttons to answer with; free text is expected when empty
+	Validate func(answer string) (string, error) // Checks and normalizes a free-text answer
+	Next     func(state *db.WizardState) string  // Name of the next step; the following step when nil
+	Final    bool                                // Whether the step only shows its prompt and ends the wizard
+}
+
+// CompleteFunc is called with the full state once a wizard reaches Done.
+// It returns the closing message shown to the user.
+type CompleteFunc func(state *db.WizardState) (string, error)
+
+// Wizard runs a sequence of steps and keeps each user's progress in a Store.
+type Wizard struct {
+	name     string
+	store    Store
+	steps    []Step
+	index    map[string]int
+	complete CompleteFunc
+}
+
+// Text returns a prompt that always shows the same text.
+func Text(text string) func(*db.WizardState) string {
+	return func(*db.WizardState) string { return text }
+}
+
+// New creates a wizard from its steps. The first step is where every run starts.
+func New(name string, store Store, steps []Step, complete CompleteFunc) *Wizard {
+	w := &Wizard{
+		name:     name,
+		store:    store,
+		steps:    steps,
+		index:    make(map[string]int, len(steps)),
+		complete: complete,
+	}
+	for i, step := range steps {
+		if _, exists := w.index[step.Name]; exists {
+			panic(fmt.Sprintf("wizard %s: duplicate step %q", name, step.Name))
+		}
+		w.index[step.Name] = i
+	}
+	return w
+}
+
+// Name returns the name of the wizard.
+func (w *Wizard) Name() string {
+	return w.name
+}
+
+// Start begins a new run for the user in the given chat, replacing any earlier progress.
+func (w *Wizard) Start(userID int64, chatID int64, originChatID int64, username string) (tgbotapi.Chattable, error) {
+	now := time.Now()
+	state := db.WizardState{
+		Wizard:       w.name,
+		UserID:       userID,
+		ChatID:       chatID,
+		OriginChatID: originChatID,
+		Username:     username,
+		Step:         w.steps[0].Name,
+		Answers:      map[string]string{},
+		Started:      now,
+		Updated:      now,
+	}
+	if err := w.store.SaveWizardState(state); err != nil {
+		return nil, err
+	}
+	return w.render(nil, &state, w.steps[0], ""), nil
+}
+
+// Cancel drops the user's progress in the given chat.
+func (w *Wizard) Cancel(userID int64, chatID int64) error {
+	return w.store.DeleteWizardState(w.name, userID, chatID)
+}
+
+// Handle feeds a text message or button press to the user's running wizard.
+// The boolean result reports whether the update belonged to this wizard.
+func (w *Wizard) Handle(update *tgbotapi.Update) (tgbotapi.Chattable, bool, error) {
+	var (
+		userID, chatID int64
+		answer, step   string
+		pressed        bool
+	)
+
+	switch {
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		name, rest, ok := strings.Cut(update.CallbackQuery.Data, "|")
+		if !ok || name != w.name {
+			return nil, false, nil
+		}
+		step, answer, _ = strings.Cut(rest, "|")
+		userID = int64(update.CallbackQuery.From.ID)
+		chatID = update.CallbackQuery.Message.Chat.ID
+		pressed = true
+	case update.Message != nil && update.Message.From != nil:
+		userID = int64(update.Message.From.ID)
+		chatID = update.Message.Chat.ID
+		answer = strings.TrimSpace(update.Message.Text)
+	default:
+		return nil, false, nil
+	}
+
+	state, err := w.store.LoadWizardState(w.name, userID, chatID)
+	if errors.IsNotFound(err) {
+		if pressed {
+			return tgbotapi.NewMessage(chatID, fmt.Sprintf("This form has expired. Send /%s to start again.", w.name)), true, nil
+		}
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+
+	current, ok := w.step(state.Step)
+	if !ok {
+		// The wizard definition changed since the state was saved; start over.
+		state.Step, state.Answers = w.steps[0].Name, map[string]string{}
+		return w.advance(update, state, w.steps[0])
+	}
+
+	if pressed && step != current.Name {
+		return w.render(update, state, current, "That button belongs to an earlier question."), true, nil
+	}
+
+	if len(current.Choices) > 0 {
+		if !pressed || !hasChoice(current.Choices, answer) {
+			return w.render(update, state, current, "Please use the buttons below."), true, nil
+		}
+	} else {
+		if pressed || answer == "" {
+			return w.render(update, state, current, "Please type your answer."), true, nil
+		}
+		if current.Validate != nil {
+			normalized, err := current.Validate(answer)
+			if err != nil {
+				return w.render(update, state, current, err.Error()), true, nil
+			}
+			answer = normalized
+		}
+	}
+
+	state.Answers[current.Name] = answer
+	state.Updated = time.Now()
+
+	next := w.next(current, state)
+	switch next {
+	case Done:
+		text, err := w.complete(state)
+		if err != nil {
+			return nil, true, err
+		}
+		if err := w.Cancel(userID, chatID); err != nil {
+			return nil, true, err
+		}
+		return w.reply(update, chatID, text, nil), true, nil
+	case Restart:
+		state.Answers = map[string]string{}
+		next = w.steps[0].Name
+	}
+
+	nextStep, ok := w.step(next)
+	if !ok {
+		return nil, true, fmt.Errorf("wizard %s: unknown step %q after %q", w.name, next, current.Name)
+	}
+	state.Step = nextStep.Name
+	return w.advance(update, state, nextStep)
+}
+
+// advance saves the state at step and renders its prompt.
+// Final steps end the run instead of being saved.
+func (w *Wizard) advance(update *tgbotapi.Update, state *db.WizardState, step Step) (tgbotapi.Chattable, bool, error) {
+	var err error
+	if step.Final {
+		err = w.Cancel(state.UserID, state.ChatID)
+	} else {
+		err = w.store.SaveWizardState(*state)
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	return w.render(update, state, step, ""), true, nil
+}
+
+// next returns the name of the step following current.
+func (w *Wizard) next(current Step, state *db.WizardState) string {
+	if current.Next != nil {
+		return current.Next(state)
+	}
+	if i := w.index[current.Name] + 1; i < len(w.steps) {
+		return w.steps[i].Name
+	}
+	return Done
+}
+
+// step looks up a step by name.
+func (w *Wizard) step(name string) (Step, bool) {
+	i, ok := w.index[name]
+	if !ok {
+		return Step{}, false
+	}
+	return w.steps[i], true
+}
+
+// render builds the prompt of a step, prefixed with an optional notice.
+func (w *Wizard) render(update *tgbotapi.Update, state *db.WizardState, step Step, notice string) tgbotapi.Chattable {
+	text := step.Prompt(state)
+	if notice != "" {
+		text = notice + "\n\n" + text
+	}
+
+	var markup *tgbotapi.InlineKeyboardMarkup
+	if len(step.Choices) > 0 {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, choice := range step.Choices {
+			data := w.name + "|" + step.Name + "|" + choice.Value
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(choice.Label, data))
+		}
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+		markup = &keyboard
+	}
+
+	return w.reply(update, state.ChatID, text, markup)
+}
+
+// reply edits the message holding the pressed button, or sends a new message otherwise.
+func (w *Wizard) reply(update *tgbotapi.Update, chatID int64, text string, markup *tgbotapi.InlineKeyboardMarkup) tgbotapi.Chattable {
+	if update != nil && update.CallbackQuery != nil && update.CallbackQuery.Message != nil {
+		msg := tgbotapi.NewEditMessageText(chatID, update.CallbackQuery.Message.MessageID, text)
+		msg.ReplyMarkup = markup
+		return msg
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	return msg
+}
+
+// hasChoice reports whether value is one of the offered choices.
+func hasChoice(choices []Choice, value string) bool {
+	for _, choice := range choices {
+		if choice.Value == value {
+			return true
+		}
+	}
+	return false
+}